@@ -50,6 +50,8 @@ type App struct {
 	memproFile   string
 	httpprof     string
 	logDir       string
+	migrate      string
+	reindex      string
 }
 
 func NewApp(name, desc, ver, commit, buildDate, terminalHeader, terminalFooter string) *App {
@@ -92,6 +94,10 @@ func (app *App) Init(customFunc func()) {
 
 	flag.StringVar(&app.logDir, "log_path", "log", "the log path")
 
+	flag.StringVar(&app.migrate, "migrate", "", "run pending orm migrations at startup, options: up, dry_run")
+
+	flag.StringVar(&app.reindex, "reindex", "", "run the elasticsearch reindex of the names in config at startup, separated by comma")
+
 	flag.Parse()
 
 	defaultLog.SetOutput(logger.EmptyLogger{})
@@ -100,6 +106,10 @@ func (app *App) Init(customFunc func()) {
 
 	app.environment.IsDebug = app.isDebug
 
+	app.environment.Migrate = app.migrate
+
+	app.environment.Reindex = app.reindex
+
 	app.environment.SetConfigFile(app.configFile)
 
 	app.environment.Init()
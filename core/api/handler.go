@@ -22,6 +22,7 @@ import (
 	"github.com/jmoiron/jsonq"
 	"github.com/huminghe/infini-framework/core/api/router"
 	"github.com/huminghe/infini-framework/core/errors"
+	"github.com/huminghe/infini-framework/core/orm"
 	"github.com/huminghe/infini-framework/core/util"
 	"io/ioutil"
 	"net/http"
@@ -268,8 +269,12 @@ func (handler Handler) Error500(w http.ResponseWriter, msg string) {
 	handler.WriteJSON(w, map[string]interface{}{"error": msg}, http.StatusInternalServerError)
 }
 
-// Error output custom error
+// Error output custom error, the version conflict of orm is reported as 409
 func (handler Handler) Error(w http.ResponseWriter, err error) {
+	if orm.IsConflict(err) {
+		handler.WriteJSON(w, map[string]interface{}{"error": err.Error()}, http.StatusConflict)
+		return
+	}
 	handler.WriteJSON(w, map[string]interface{}{"error": err.Error()}, http.StatusInternalServerError)
 }
 
@@ -31,6 +31,18 @@ const (
 
 	//ADMIN
 	PERMISSION_ADMIN_MINIMAL string = "admin_minimal"
+
+	//KV
+	PERMISSION_KV_READ  string = "kv_read"
+	PERMISSION_KV_WRITE string = "kv_write"
+
+	//FILTER
+	PERMISSION_FILTER_READ  string = "filter_read"
+	PERMISSION_FILTER_WRITE string = "filter_write"
+
+	//ELASTIC
+	PERMISSION_ELASTIC_READ  string = "elastic_read"
+	PERMISSION_ELASTIC_WRITE string = "elastic_write"
 )
 
 func GetPermissionsByRole(role string) (*hashset.Set, error) {
@@ -0,0 +1,77 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elastic
+
+import "encoding/json"
+
+// Aggregation is a aggregation of search request, eg:
+//
+//	NewAggregation("terms", "level").Set("size", 10).SubAggregation("avg_age", NewAggregation("avg", "age"))
+type Aggregation struct {
+	Type         string
+	Params       map[string]interface{}
+	Aggregations map[string]*Aggregation
+}
+
+// NewAggregation create the aggregation of the type on the field, the field is not set if it is empty,
+// eg: the filter aggregation
+func NewAggregation(aggType string, field string) *Aggregation {
+	agg := &Aggregation{Type: aggType, Params: map[string]interface{}{}}
+	if field != "" {
+		agg.Params["field"] = field
+	}
+	return agg
+}
+
+// NewFilterAggregation create the single bucket aggregation of the documents which match the query
+func NewFilterAggregation(query interface{}) *Aggregation {
+	return &Aggregation{Type: "filter", Params: map[string]interface{}{"filter": query}}
+}
+
+// NewNestedAggregation create the single bucket aggregation of the nested objects of the path
+func NewNestedAggregation(path string) *Aggregation {
+	return NewAggregation("nested", "").Set("path", path)
+}
+
+// Set set the parameter of aggregation, eg: size, interval, order
+func (agg *Aggregation) Set(key string, value interface{}) *Aggregation {
+	agg.Params[key] = value
+	return agg
+}
+
+// SubAggregation add the aggregation which is computed in every bucket of the aggregation
+func (agg *Aggregation) SubAggregation(name string, sub *Aggregation) *Aggregation {
+	if agg.Aggregations == nil {
+		agg.Aggregations = map[string]*Aggregation{}
+	}
+	agg.Aggregations[name] = sub
+	return agg
+}
+
+func (agg *Aggregation) MarshalJSON() ([]byte, error) {
+	v := map[string]interface{}{}
+	//the query of filter aggregation is not wrapped by the parameters
+	if filter, ok := agg.Params["filter"]; ok && agg.Type == "filter" {
+		v[agg.Type] = filter
+	} else {
+		v[agg.Type] = agg.Params
+	}
+	if len(agg.Aggregations) > 0 {
+		v["aggs"] = agg.Aggregations
+	}
+	return json.Marshal(v)
+}
@@ -0,0 +1,48 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elastic
+
+// AliasActionType is the type of alias action, add or remove
+type AliasActionType string
+
+const AliasAdd AliasActionType = "add"
+const AliasRemove AliasActionType = "remove"
+
+// AliasAction is one of the actions which are applied atomically by `_aliases`
+type AliasAction struct {
+	Type  AliasActionType
+	Index string
+	Alias string
+}
+
+// RolloverConditions is the conditions of rollover, the index is rolled over if any of them is met,
+// max_size is supported since elasticsearch 6.1
+type RolloverConditions struct {
+	MaxAge  string `json:"max_age,omitempty"`
+	MaxDocs int64  `json:"max_docs,omitempty"`
+	MaxSize string `json:"max_size,omitempty"`
+}
+
+// RolloverResponse is the response of `_rollover`
+type RolloverResponse struct {
+	Acknowledged bool            `json:"acknowledged"`
+	OldIndex     string          `json:"old_index"`
+	NewIndex     string          `json:"new_index"`
+	RolledOver   bool            `json:"rolled_over"`
+	DryRun       bool            `json:"dry_run"`
+	Conditions   map[string]bool `json:"conditions"`
+}
@@ -16,12 +16,19 @@ limitations under the License.
 
 package elastic
 
-import "bytes"
+import (
+	"bytes"
+	"github.com/huminghe/infini-framework/core/errors"
+)
+
+// ErrVersionConflict is returned when the document was changed by others
+var ErrVersionConflict = errors.New("version conflict")
 
 type API interface {
 	ScrollAPI
 	MappingAPI
 	TemplateAPI
+	AliasAPI
 
 	Init()
 
@@ -29,11 +36,34 @@ type API interface {
 
 	ClusterVersion() *ClusterVersion
 
+	// HasCapability return true if the feature is available in the version of cluster
+	HasCapability(capability Capability) bool
+
+	// NodeStats return the stats of the http endpoints which the requests are sent to
+	NodeStats() []NodeStats
+
 	CreateIndex(name string, settings map[string]interface{}) error
 
 	Index(indexName string, id interface{}, data interface{}) (*InsertResponse, error)
 
-	Bulk(data *bytes.Buffer)
+	// IndexIfMatch index the document only if it is not changed since it was read by Get, or return ErrVersionConflict
+	IndexIfMatch(indexName string, id interface{}, data interface{}, stored *GetResponse) (*InsertResponse, error)
+
+	// Bulk send the encoded actions in a bulk request, the buffer is reset if the request is sent
+	Bulk(data *bytes.Buffer) (*BulkResponse, error)
+
+	// BulkRequest send the actions in a bulk request, the failures of actions are in the items of response
+	BulkRequest(actions []BulkAction) (*BulkResponse, error)
+
+	// Update merge the partial document into the stored document, the upsert is indexed if the document is not exist,
+	// the update is retried by elasticsearch if the document is changed by others
+	Update(indexName string, id interface{}, partialDoc interface{}, upsert interface{}) (*UpdateResponse, error)
+
+	// UpdateByQuery update the matched documents, and wait for the task to be completed
+	UpdateByQuery(indexName string, request *ByQueryRequest) (*ByQueryResponse, error)
+
+	// DeleteByQuery delete the matched documents, and wait for the task to be completed
+	DeleteByQuery(indexName string, request *ByQueryRequest) (*ByQueryResponse, error)
 
 	Get(indexName, id string) (*GetResponse, error)
 	Delete(indexName, id string) (*DeleteResponse, error)
@@ -55,6 +85,19 @@ type TemplateAPI interface {
 	PutTemplate(templateName string, template []byte) ([]byte, error)
 }
 
+type AliasAPI interface {
+	// GetAliases return the aliases of the matched indices, the indices without alias are returned with empty aliases
+	GetAliases(indexNames string) (map[string][]string, error)
+	AddAlias(indexName, alias string) error
+	RemoveAlias(indexName, alias string) error
+	// SwapAlias move the alias from the old index to the new index atomically
+	SwapAlias(alias, oldIndex, newIndex string) error
+	UpdateAliases(actions []AliasAction) error
+	// Rollover roll the alias over to a new index if any of the conditions is met, the new index is named by
+	// elasticsearch if it is empty
+	Rollover(alias, newIndex string, conditions *RolloverConditions) (*RolloverResponse, error)
+}
+
 type MappingAPI interface {
 	GetMapping(copyAllIndexes bool, indexNames string) (string, int, *Indexes, error)
 	UpdateMapping(indexName string, mappings []byte) ([]byte, error)
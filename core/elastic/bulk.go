@@ -0,0 +1,121 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elastic
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type BulkActionType string
+
+const BulkIndex BulkActionType = "index"
+const BulkCreate BulkActionType = "create"
+const BulkUpdate BulkActionType = "update"
+const BulkDelete BulkActionType = "delete"
+
+// BulkAction is a action of bulk request, the Doc is the source of index and create, or the body of update
+type BulkAction struct {
+	Type  BulkActionType
+	Index string
+	ID    string
+	Doc   interface{}
+}
+
+// BulkResponse is the response of bulk request, every item has a single key of the action type
+type BulkResponse struct {
+	Took   int                                   `json:"took"`
+	Errors bool                                  `json:"errors"`
+	Items  []map[BulkActionType]BulkItemResponse `json:"items"`
+}
+
+// GetItems return the responses of the actions in order
+func (response *BulkResponse) GetItems() []BulkItemResponse {
+	items := make([]BulkItemResponse, 0, len(response.Items))
+	for _, item := range response.Items {
+		for _, v := range item {
+			items = append(items, v)
+			break
+		}
+	}
+	return items
+}
+
+type BulkItemResponse struct {
+	Index   string         `json:"_index"`
+	Type    string         `json:"_type,omitempty"`
+	ID      string         `json:"_id"`
+	Version int64          `json:"_version,omitempty"`
+	Result  string         `json:"result,omitempty"`
+	Status  int            `json:"status"`
+	Error   *BulkItemError `json:"error,omitempty"`
+}
+
+// BulkItemError is the error of a failed action, which is a object since 5.0, and a string before
+type BulkItemError struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+func (err *BulkItemError) Error() string {
+	if err.Type == "" {
+		return err.Reason
+	}
+	return fmt.Sprintf("%s: %s", err.Type, err.Reason)
+}
+
+func (err *BulkItemError) UnmarshalJSON(data []byte) error {
+	var reason string
+	if json.Unmarshal(data, &reason) == nil {
+		err.Reason = reason
+		return nil
+	}
+	type itemError BulkItemError
+	return json.Unmarshal(data, (*itemError)(err))
+}
+
+// EncodeBulkActions encode the actions to the body of bulk request, the type name is omitted if it is empty
+func EncodeBulkActions(actions []BulkAction, indexPrefix, typeName string) ([]byte, error) {
+	type metadata struct {
+		Index string `json:"_index"`
+		Type  string `json:"_type,omitempty"`
+		ID    string `json:"_id,omitempty"`
+	}
+
+	var body []byte
+	for _, action := range actions {
+		meta, err := json.Marshal(map[BulkActionType]metadata{
+			action.Type: {Index: indexPrefix + action.Index, Type: typeName, ID: action.ID},
+		})
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, meta...)
+		body = append(body, '\n')
+
+		if action.Type == BulkDelete {
+			continue
+		}
+		doc, err := json.Marshal(action.Doc)
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, doc...)
+		body = append(body, '\n')
+	}
+	return body, nil
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elastic
+
+// Capability is a feature which is not available in all the versions of elasticsearch,
+// the adapters have the tables of their capabilities
+type Capability string
+
+// CapabilitySlicedScroll the scroll is split into slices which are consumed in parallel, since 5.0
+const CapabilitySlicedScroll Capability = "sliced_scroll"
+
+// CapabilityRollover the alias is rolled over to a new index by conditions, since 5.0
+const CapabilityRollover Capability = "rollover"
+
+// CapabilityMappingTypes the mappings and documents have types, which are removed since 7.0
+const CapabilityMappingTypes Capability = "mapping_types"
+
+// CapabilityDocEndpoint the documents are accessed by `/<index>/_doc/<id>` without types, since 7.0
+const CapabilityDocEndpoint Capability = "doc_endpoint"
+
+// CapabilityHitsTotalObject the total of hits is a object of value and relation, since 7.0
+const CapabilityHitsTotalObject Capability = "hits_total_object"
+
+// CapabilityIndexTemplate the composable templates are put by `_index_template`, which are used since 8.0
+const CapabilityIndexTemplate Capability = "index_template"
+
+// CapabilityIDSort the documents are sorted by `_id`, which is disallowed by default since 8.0
+const CapabilityIDSort Capability = "id_sort"
+
+// CapabilityFixedInterval the fixed intervals of date_histogram are set by `fixed_interval`, since 7.2,
+// and the `interval` is removed since 8.0
+const CapabilityFixedInterval Capability = "fixed_interval"
@@ -18,6 +18,7 @@ package elastic
 
 import (
 	"fmt"
+	"strings"
 )
 
 var apis = map[string]API{}
@@ -47,6 +48,32 @@ type ElasticsearchConfig struct {
 		Username string `config:"username"`
 		Password string `config:"password"`
 	} `config:"basic_auth"`
+	//APIKey the base64 encoded `id:api_key` of the api key, which is used instead of the basic auth if it is set
+	APIKey string `config:"api_key"`
+	//Hosts the endpoints of nodes, the requests are balanced between the healthy ones, it is the Endpoint if not set
+	Hosts []string `config:"hosts"`
+	//Sniff discover the http endpoints of all the nodes in the cluster by `_nodes/http`
+	Sniff                        bool `config:"sniff"`
+	SniffIntervalInSeconds       int  `config:"sniff_interval_in_seconds"`
+	HealthCheckIntervalInSeconds int  `config:"health_check_interval_in_seconds"`
+	//MaxRetries the times to retry the idempotent requests on other nodes if the node is failed, every node is tried once if not set
+	MaxRetries int `config:"max_retries"`
+	//RetryOnConflict the times to retry the partial update if the document is changed by others, 3 if not set
+	RetryOnConflict int `config:"retry_on_conflict"`
+}
+
+// GetHosts return the endpoints of nodes without the trailing slash
+func (config *ElasticsearchConfig) GetHosts() []string {
+	hosts := []string{}
+	for _, host := range config.Hosts {
+		if host = strings.TrimRight(strings.TrimSpace(host), "/"); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	if len(hosts) == 0 && config.Endpoint != "" {
+		hosts = append(hosts, strings.TrimRight(config.Endpoint, "/"))
+	}
+	return hosts
 }
 
 func GetConfig(k string) ElasticsearchConfig {
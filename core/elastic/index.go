@@ -1,6 +1,7 @@
 package elastic
 
 import (
+	"encoding/json"
 	"github.com/huminghe/infini-framework/core/util"
 	"strings"
 )
@@ -110,6 +111,19 @@ func (c *ClusterVersion) GetMajorVersion() int {
 	return n
 }
 
+// GetMinorVersion return the minor version, which is 0 if the version is unknown
+func (c *ClusterVersion) GetMinorVersion() int {
+	vs := strings.Split(c.Version.Number, ".")
+	if len(vs) < 2 {
+		return 0
+	}
+	n, err := util.ToInt(vs[1])
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
 type ClusterHealth struct {
 	Name   string `json:"cluster_name"`
 	Status string `json:"status"`
@@ -123,15 +137,186 @@ type IndexDocument struct {
 	Routing   string                   `json:"_routing,omitempty"`
 	Source    map[string]interface{}   `json:"_source,omitempty"`
 	Highlight map[string][]interface{} `json:"highlight,omitempty"`
+	Sort      []interface{}            `json:"sort,omitempty"`
 }
 
+// Bucket is a bucket of the bucket aggregations, the Key is string, number or the object of composite aggregation, the From and To are set for
+// the range aggregations, the Score and BgCount are set for the significant_terms aggregation, and the sub aggregations are decoded into Aggregations
 type Bucket struct {
-	Key      string `json:"key,omitempty"`
-	DocCount int    `json:"doc_count,omitempty"`
+	Key          interface{}                    `json:"key,omitempty"`
+	KeyAsString  string                         `json:"key_as_string,omitempty"`
+	DocCount     int                            `json:"doc_count,omitempty"`
+	From         *float64                       `json:"from,omitempty"`
+	To           *float64                       `json:"to,omitempty"`
+	Score        float64                        `json:"score,omitempty"`
+	BgCount      int                            `json:"bg_count,omitempty"`
+	Aggregations map[string]AggregationResponse `json:"-"`
+}
+
+func (bucket *Bucket) UnmarshalJSON(data []byte) error {
+	fields := map[string]json.RawMessage{}
+	err := json.Unmarshal(data, &fields)
+	if err != nil {
+		return err
+	}
+
+	for k, v := range fields {
+		//the sub aggregations may be named as the fields of bucket, the key of composite aggregation is a object
+		if k != "key" && isObject(v) {
+			bucket.Aggregations, err = decodeSubAggregation(bucket.Aggregations, k, v)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch k {
+		case "key":
+			err = json.Unmarshal(v, &bucket.Key)
+		case "key_as_string":
+			err = json.Unmarshal(v, &bucket.KeyAsString)
+		case "doc_count":
+			err = json.Unmarshal(v, &bucket.DocCount)
+		case "from":
+			err = json.Unmarshal(v, &bucket.From)
+		case "to":
+			err = json.Unmarshal(v, &bucket.To)
+		case "score":
+			err = json.Unmarshal(v, &bucket.Score)
+		case "bg_count":
+			err = json.Unmarshal(v, &bucket.BgCount)
+		default:
+			bucket.Aggregations, err = decodeSubAggregation(bucket.Aggregations, k, v)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
+// AggregationResponse is the result of a aggregation. The Buckets are set for the multi bucket aggregations,
+// which are set to KeyedBuckets if they are keyed, eg: the filters aggregation. The DocCount and Aggregations
+// are set for the single bucket aggregations, eg: filter and nested. The Value is set for the single value
+// metric aggregations, which is null if no documents are aggregated.
 type AggregationResponse struct {
-	Buckets []Bucket `json:"buckets,omitempty"`
+	Buckets      []Bucket          `json:"buckets,omitempty"`
+	KeyedBuckets map[string]Bucket `json:"-"`
+	//SumOtherDocCount and DocCountErrorUpperBound are set for the terms aggregation
+	SumOtherDocCount        int `json:"sum_other_doc_count,omitempty"`
+	DocCountErrorUpperBound int `json:"doc_count_error_upper_bound,omitempty"`
+	//BgCount is the size of background set of the significant_terms aggregation
+	BgCount int `json:"bg_count,omitempty"`
+
+	DocCount     int                            `json:"doc_count,omitempty"`
+	Aggregations map[string]AggregationResponse `json:"-"`
+
+	Value         interface{} `json:"value,omitempty"`
+	ValueAsString string      `json:"value_as_string,omitempty"`
+	//Count, Min, Max, Avg and Sum are set for the stats aggregation
+	Count int      `json:"count,omitempty"`
+	Min   *float64 `json:"min,omitempty"`
+	Max   *float64 `json:"max,omitempty"`
+	Avg   *float64 `json:"avg,omitempty"`
+	Sum   *float64 `json:"sum,omitempty"`
+	//Values are the percentiles by the percents, eg: 50.0
+	Values map[string]*float64 `json:"values,omitempty"`
+	//Hits are set for the top_hits aggregation
+	Hits *SearchHits `json:"hits,omitempty"`
+}
+
+// GetValue return the value of single value metric aggregation, or false if it is null
+func (agg *AggregationResponse) GetValue() (float64, bool) {
+	v, ok := agg.Value.(float64)
+	return v, ok
+}
+
+func (agg *AggregationResponse) UnmarshalJSON(data []byte) error {
+	fields := map[string]json.RawMessage{}
+	err := json.Unmarshal(data, &fields)
+	if err != nil {
+		return err
+	}
+
+	//the object fields of single bucket aggregation are all sub aggregations, the significant_terms aggregation
+	//has the doc_count of the foreground set besides the buckets
+	_, hasDocCount := fields["doc_count"]
+	_, hasBuckets := fields["buckets"]
+	singleBucket := hasDocCount && !hasBuckets
+	for k, v := range fields {
+		if singleBucket && k != "doc_count" {
+			agg.Aggregations, err = decodeSubAggregation(agg.Aggregations, k, v)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch k {
+		case "buckets":
+			if isObject(v) {
+				err = json.Unmarshal(v, &agg.KeyedBuckets)
+			} else {
+				err = json.Unmarshal(v, &agg.Buckets)
+			}
+		case "sum_other_doc_count":
+			err = json.Unmarshal(v, &agg.SumOtherDocCount)
+		case "doc_count_error_upper_bound":
+			err = json.Unmarshal(v, &agg.DocCountErrorUpperBound)
+		case "bg_count":
+			err = json.Unmarshal(v, &agg.BgCount)
+		case "doc_count":
+			err = json.Unmarshal(v, &agg.DocCount)
+		case "value":
+			err = json.Unmarshal(v, &agg.Value)
+		case "value_as_string":
+			err = json.Unmarshal(v, &agg.ValueAsString)
+		case "count":
+			err = json.Unmarshal(v, &agg.Count)
+		case "min":
+			err = json.Unmarshal(v, &agg.Min)
+		case "max":
+			err = json.Unmarshal(v, &agg.Max)
+		case "avg":
+			err = json.Unmarshal(v, &agg.Avg)
+		case "sum":
+			err = json.Unmarshal(v, &agg.Sum)
+		case "values":
+			err = json.Unmarshal(v, &agg.Values)
+		case "hits":
+			err = json.Unmarshal(v, &agg.Hits)
+		default:
+			agg.Aggregations, err = decodeSubAggregation(agg.Aggregations, k, v)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isObject(v json.RawMessage) bool {
+	return len(v) > 0 && v[0] == '{'
+}
+
+// nonAggregationFields are the object fields of aggregation results which are not sub aggregations
+var nonAggregationFields = map[string]bool{"meta": true, "std_deviation_bounds": true, "bounds": true, "location": true}
+
+// decodeSubAggregation decode the field to the sub aggregations if it is a object, other fields are skipped
+func decodeSubAggregation(aggs map[string]AggregationResponse, k string, v json.RawMessage) (map[string]AggregationResponse, error) {
+	if !isObject(v) || nonAggregationFields[k] {
+		return aggs, nil
+	}
+	agg := AggregationResponse{}
+	err := json.Unmarshal(v, &agg)
+	if err != nil {
+		return aggs, err
+	}
+	if aggs == nil {
+		aggs = map[string]AggregationResponse{}
+	}
+	aggs[k] = agg
+	return aggs, nil
 }
 
 // InsertResponse is a index response object
@@ -151,6 +336,10 @@ type GetResponse struct {
 	ID      string                 `json:"_id"`
 	Version int                    `json:"_version"`
 	Source  map[string]interface{} `json:"_source"`
+
+	//SeqNo and PrimaryTerm are returned since elasticsearch 6.7, used for optimistic concurrency control
+	SeqNo       *int64 `json:"_seq_no,omitempty"`
+	PrimaryTerm *int64 `json:"_primary_term,omitempty"`
 }
 
 // DeleteResponse is a delete response object
@@ -169,16 +358,20 @@ type CountResponse struct {
 
 // SearchResponse is a count response object
 type SearchResponse struct {
-	Took     int  `json:"took"`
-	TimedOut bool `json:"timed_out"`
-	Hits     struct {
-		Total    interface{}     `json:"total"`
-		MaxScore float32         `json:"max_score"`
-		Hits     []IndexDocument `json:"hits,omitempty"`
-	} `json:"hits"`
+	Took         int                            `json:"took"`
+	TimedOut     bool                           `json:"timed_out"`
+	Hits         SearchHits                     `json:"hits"`
 	Aggregations map[string]AggregationResponse `json:"aggregations,omitempty"`
 }
 
+// SearchHits is the hits of search response and top_hits aggregation, the Total is a number before elasticsearch 7.0,
+// or a object of value and relation
+type SearchHits struct {
+	Total    interface{}     `json:"total"`
+	MaxScore float32         `json:"max_score"`
+	Hits     []IndexDocument `json:"hits,omitempty"`
+}
+
 func (response *SearchResponse) GetTotal() int {
 
 	if response.Hits.Total != nil {
@@ -198,38 +391,81 @@ type RangeQuery struct {
 	Range map[string]map[string]interface{} `json:"range,omitempty"`
 }
 
-func (query *RangeQuery) Gt(field string, value interface{}) {
-	query.Range = map[string]map[string]interface{}{}
-	v := map[string]interface{}{}
-	v["gt"] = value
-	query.Range[field] = v
+func (query *RangeQuery) Gt(field string, value interface{}) *RangeQuery {
+	return query.set(field, "gt", value)
 }
 
-func (query *RangeQuery) Gte(field string, value interface{}) {
-	query.Range = map[string]map[string]interface{}{}
-	v := map[string]interface{}{}
-	v["gte"] = value
-	query.Range[field] = v
+func (query *RangeQuery) Gte(field string, value interface{}) *RangeQuery {
+	return query.set(field, "gte", value)
 }
 
-func (query *RangeQuery) Lt(field string, value interface{}) {
-	query.Range = map[string]map[string]interface{}{}
-	v := map[string]interface{}{}
-	v["lt"] = value
-	query.Range[field] = v
+func (query *RangeQuery) Lt(field string, value interface{}) *RangeQuery {
+	return query.set(field, "lt", value)
 }
 
-func (query *RangeQuery) Lte(field string, value interface{}) {
-	query.Range = map[string]map[string]interface{}{}
-	v := map[string]interface{}{}
-	v["lte"] = value
-	query.Range[field] = v
+func (query *RangeQuery) Lte(field string, value interface{}) *RangeQuery {
+	return query.set(field, "lte", value)
+}
+
+// set add the bound of field, the bounds of other fields are removed
+func (query *RangeQuery) set(field, op string, value interface{}) *RangeQuery {
+	if query.Range[field] == nil {
+		query.Range = map[string]map[string]interface{}{field: {}}
+	}
+	query.Range[field][op] = value
+	return query
 }
 
 type MatchQuery struct {
 	Match map[string]interface{} `json:"match,omitempty"`
 }
 
+// TermsQuery is used to find documents which field contains any of the exact values
+type TermsQuery struct {
+	Terms map[string][]interface{} `json:"terms,omitempty"`
+}
+
+// Set init terms query's condition
+func (query *TermsQuery) Set(field string, v []interface{}) {
+	query.Terms = map[string][]interface{}{}
+	query.Terms[field] = v
+}
+
+// PrefixQuery is used to find documents which field starts with the prefix
+type PrefixQuery struct {
+	Prefix map[string]interface{} `json:"prefix,omitempty"`
+}
+
+// Set init prefix query's condition
+func (query *PrefixQuery) Set(field string, v interface{}) {
+	query.Prefix = map[string]interface{}{}
+	query.Prefix[field] = v
+}
+
+// ExistsQuery is used to find documents which field has a non-null value
+type ExistsQuery struct {
+	Exists map[string]interface{} `json:"exists,omitempty"`
+}
+
+// Set init exists query's condition
+func (query *ExistsQuery) Set(field string) {
+	query.Exists = map[string]interface{}{}
+	query.Exists["field"] = field
+}
+
+// MultiMatchQuery is used to find documents which fields match the text, the fields are lenient to ignore the format errors
+type MultiMatchQuery struct {
+	MultiMatch map[string]interface{} `json:"multi_match,omitempty"`
+}
+
+// Set init multi match query's condition
+func (query *MultiMatchQuery) Set(text string, fields []string) {
+	query.MultiMatch = map[string]interface{}{}
+	query.MultiMatch["query"] = text
+	query.MultiMatch["fields"] = fields
+	query.MultiMatch["lenient"] = true
+}
+
 type QueryStringQuery struct {
 	Query map[string]interface{} `json:"query_string,omitempty"`
 }
@@ -280,8 +516,11 @@ func (match *MatchQuery) Set(field string, v interface{}) {
 // BoolQuery wrapper queries
 type BoolQuery struct {
 	Must    []interface{} `json:"must,omitempty"`
+	Filter  []interface{} `json:"filter,omitempty"`
 	MustNot []interface{} `json:"must_not,omitempty"`
 	Should  []interface{} `json:"should,omitempty"`
+
+	MinimumShouldMatch int `json:"minimum_should_match,omitempty"`
 }
 
 // Query is the root query object
@@ -291,18 +530,20 @@ type Query struct {
 
 // SearchRequest is the root search query object
 type SearchRequest struct {
-	Query              *Query         `json:"query,omitempty"`
-	From               int            `json:"from"`
-	Size               int            `json:"size"`
-	Sort               *[]interface{} `json:"sort,omitempty"`
-	AggregationRequest `json:"aggs,omitempty"`
-}
-
-type AggregationRequest struct {
-	Aggregations map[string]Aggregation `json:"aggregations,omitempty"`
-}
-
-type Aggregation struct {
+	Query        *Query                  `json:"query,omitempty"`
+	From         int                     `json:"from"`
+	Size         int                     `json:"size"`
+	Sort         *[]interface{}          `json:"sort,omitempty"`
+	SearchAfter  []interface{}           `json:"search_after,omitempty"`
+	Aggregations map[string]*Aggregation `json:"aggs,omitempty"`
+}
+
+// AddAggregation add a aggregation to SearchRequest, the results are in the Aggregations of SearchResponse by the name
+func (request *SearchRequest) AddAggregation(name string, agg *Aggregation) {
+	if request.Aggregations == nil {
+		request.Aggregations = map[string]*Aggregation{}
+	}
+	request.Aggregations[name] = agg
 }
 
 // AddSort add sort conditions to SearchRequest
@@ -0,0 +1,39 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elastic
+
+import "time"
+
+// NodeStats is the stats of a http endpoint of the cluster
+type NodeStats struct {
+	Endpoint  string    `json:"endpoint"`
+	Healthy   bool      `json:"healthy"`
+	Requests  int64     `json:"requests"`
+	Failures  int64     `json:"failures"`
+	LastError string    `json:"last_error,omitempty"`
+	DeadSince time.Time `json:"dead_since,omitempty"`
+}
+
+// NodesHttpResponse is the response of `_nodes/http`
+type NodesHttpResponse struct {
+	Nodes map[string]struct {
+		Name string `json:"name"`
+		Http struct {
+			PublishAddress string `json:"publish_address"`
+		} `json:"http"`
+	} `json:"nodes"`
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elastic
+
+// The builders of query DSL, the queries are composed by the bool query, eg:
+//
+//	query := NewBoolQuery().
+//		Must(NewMatchQuery("title", "elasticsearch")).
+//		Filter(NewTermQuery("status", "published"), NewRangeQuery().Gte("views", 100)).
+//		MustNot(NewExistsQuery("deleted"))
+//
+// The filter clause of bool query and exists query are supported since elasticsearch 2.0.
+
+// NewBoolQuery create a empty bool query, which matches all the documents
+func NewBoolQuery() *Query {
+	return &Query{BoolQuery: &BoolQuery{}}
+}
+
+// boolQuery return the bool query, it is created if the query was not built by NewBoolQuery
+func (query *Query) boolQuery() *BoolQuery {
+	if query.BoolQuery == nil {
+		query.BoolQuery = &BoolQuery{}
+	}
+	return query.BoolQuery
+}
+
+// Must add the clauses which must match and contribute to the score
+func (query *Query) Must(queries ...interface{}) *Query {
+	b := query.boolQuery()
+	b.Must = append(b.Must, queries...)
+	return query
+}
+
+// Filter add the clauses which must match but do not contribute to the score
+func (query *Query) Filter(queries ...interface{}) *Query {
+	b := query.boolQuery()
+	b.Filter = append(b.Filter, queries...)
+	return query
+}
+
+// MustNot add the clauses which must not match
+func (query *Query) MustNot(queries ...interface{}) *Query {
+	b := query.boolQuery()
+	b.MustNot = append(b.MustNot, queries...)
+	return query
+}
+
+// Should add the clauses which should match, at least MinimumShouldMatch of them are required
+func (query *Query) Should(queries ...interface{}) *Query {
+	b := query.boolQuery()
+	b.Should = append(b.Should, queries...)
+	return query
+}
+
+func (query *Query) MinimumShouldMatch(n int) *Query {
+	query.boolQuery().MinimumShouldMatch = n
+	return query
+}
+
+// TermQuery is used to find documents which field contains the exact value
+type TermQuery struct {
+	Term map[string]interface{} `json:"term,omitempty"`
+}
+
+func NewTermQuery(field string, v interface{}) *TermQuery {
+	return &TermQuery{Term: map[string]interface{}{field: v}}
+}
+
+func NewTermsQuery(field string, v ...interface{}) *TermsQuery {
+	query := &TermsQuery{}
+	query.Set(field, v)
+	return query
+}
+
+func NewMatchQuery(field string, v interface{}) *MatchQuery {
+	query := &MatchQuery{}
+	query.Set(field, v)
+	return query
+}
+
+// NewRangeQuery create a empty range query, the bounds of field are set by Gt, Gte, Lt and Lte, eg:
+// NewRangeQuery().Gte("age", 18).Lt("age", 60)
+func NewRangeQuery() *RangeQuery {
+	return &RangeQuery{}
+}
+
+func NewExistsQuery(field string) *ExistsQuery {
+	query := &ExistsQuery{}
+	query.Set(field)
+	return query
+}
+
+func NewPrefixQuery(field string, v interface{}) *PrefixQuery {
+	query := &PrefixQuery{}
+	query.Set(field, v)
+	return query
+}
+
+// WildcardQuery is used to find documents which field matches the pattern, `*` matches any characters
+// and `?` matches any single character
+type WildcardQuery struct {
+	Wildcard map[string]interface{} `json:"wildcard,omitempty"`
+}
+
+func NewWildcardQuery(field string, pattern string) *WildcardQuery {
+	return &WildcardQuery{Wildcard: map[string]interface{}{field: pattern}}
+}
+
+// NestedQuery is used to find documents which nested objects of the path match the query,
+// the fields of query are the full paths, eg: `comments.author`
+type NestedQuery struct {
+	Nested struct {
+		Path      string      `json:"path"`
+		Query     interface{} `json:"query"`
+		ScoreMode string      `json:"score_mode,omitempty"`
+	} `json:"nested"`
+}
+
+func NewNestedQuery(path string, query interface{}) *NestedQuery {
+	nested := &NestedQuery{}
+	nested.Nested.Path = path
+	nested.Nested.Query = query
+	return nested
+}
+
+// ScoreMode set how the scores of matched nested objects are used, avg, max, min, sum or none
+func (query *NestedQuery) ScoreMode(mode string) *NestedQuery {
+	query.Nested.ScoreMode = mode
+	return query
+}
+
+// GeoDistanceQuery is used to find documents which geo point is within the distance of the point
+type GeoDistanceQuery struct {
+	GeoDistance map[string]interface{} `json:"geo_distance,omitempty"`
+}
+
+// NewGeoDistanceQuery create the query of geo point field, the distance is with unit, eg: 10km
+func NewGeoDistanceQuery(field string, lat, lon float64, distance string) *GeoDistanceQuery {
+	return &GeoDistanceQuery{GeoDistance: map[string]interface{}{
+		"distance": distance,
+		field:      map[string]float64{"lat": lat, "lon": lon},
+	}}
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elastic
+
+import (
+	"github.com/huminghe/infini-framework/core/util"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestQueryBuilder(t *testing.T) {
+	query := NewBoolQuery().
+		Must(NewMatchQuery("title", "elasticsearch")).
+		Filter(NewTermQuery("status", "published"), NewTermsQuery("tag", "go", "search"), NewRangeQuery().Gte("views", 100).Lt("views", 200)).
+		MustNot(NewExistsQuery("deleted"), NewWildcardQuery("author", "bot*")).
+		Should(NewPrefixQuery("title", "elastic"), NewNestedQuery("comments", NewBoolQuery().Must(NewTermQuery("comments.author", "bob"))).ScoreMode("max")).
+		MinimumShouldMatch(1)
+
+	assert.JSONEq(t, `{"bool":{
+		"must":[{"match":{"title":"elasticsearch"}}],
+		"filter":[{"term":{"status":"published"}},{"terms":{"tag":["go","search"]}},{"range":{"views":{"gte":100,"lt":200}}}],
+		"must_not":[{"exists":{"field":"deleted"}},{"wildcard":{"author":"bot*"}}],
+		"should":[{"prefix":{"title":"elastic"}},{"nested":{"path":"comments","score_mode":"max","query":{"bool":{"must":[{"term":{"comments.author":"bob"}}]}}}}],
+		"minimum_should_match":1}}`, util.ToJson(query, false))
+
+	assert.JSONEq(t, `{"geo_distance":{"distance":"10km","location":{"lat":40.7,"lon":-74}}}`, util.ToJson(NewGeoDistanceQuery("location", 40.7, -74, "10km"), false))
+
+	//the bounds of other fields are removed
+	assert.JSONEq(t, `{"range":{"age":{"lt":10}}}`, util.ToJson(NewRangeQuery().Gt("views", 1).Lt("age", 10), false))
+
+	//the bool query is created on demand
+	assert.JSONEq(t, `{"bool":{"filter":[{"term":{"status":"published"}}],"minimum_should_match":1}}`,
+		util.ToJson((&Query{}).Filter(NewTermQuery("status", "published")).MinimumShouldMatch(1), false))
+	assert.JSONEq(t, `{"bool":{"must_not":[{"exists":{"field":"deleted"}}]}}`, util.ToJson((&Query{}).MustNot(NewExistsQuery("deleted")), false))
+}
+
+func TestAggregationBuilder(t *testing.T) {
+	request := SearchRequest{Query: NewBoolQuery().Filter(NewTermQuery("level", "error"))}
+	assert.JSONEq(t, `{"query":{"bool":{"filter":[{"term":{"level":"error"}}]}},"from":0,"size":0}`, util.ToJson(request, false))
+
+	request.AddAggregation("by_host", NewAggregation("terms", "host").Set("size", 5).
+		SubAggregation("latency", NewAggregation("percentiles", "latency").Set("percents", []float64{50, 99})))
+	request.AddAggregation("slow", NewFilterAggregation(NewRangeQuery().Gt("latency", 1000)).
+		SubAggregation("hosts", NewAggregation("cardinality", "host")))
+	request.AddAggregation("comments", NewNestedAggregation("comments"))
+	assert.JSONEq(t, `{"query":{"bool":{"filter":[{"term":{"level":"error"}}]}},"from":0,"size":0,"aggs":{
+		"by_host":{"terms":{"field":"host","size":5},"aggs":{"latency":{"percentiles":{"field":"latency","percents":[50,99]}}}},
+		"slow":{"filter":{"range":{"latency":{"gt":1000}}},"aggs":{"hosts":{"cardinality":{"field":"host"}}}},
+		"comments":{"nested":{"path":"comments"}}}}`, util.ToJson(request, false))
+}
+
+// TestAggregationResponse decode the responses of the versions, which are different in hits total and percentile keys
+func TestAggregationResponse(t *testing.T) {
+	responses := map[string]string{
+		"2.4": `{"hits":{"total":3,"max_score":0,"hits":[]},"aggregations":{
+			"by_host":{"doc_count_error_upper_bound":0,"sum_other_doc_count":1,"buckets":[{"key":"a","doc_count":2,"latency":{"values":{"50.0":12.5,"99.0":40}}}]},
+			"slow":{"doc_count":1,"hosts":{"value":1}},
+			"empty":{"value":null},
+			"stats":{"count":3,"min":1,"max":3,"avg":2,"sum":6},
+			"ranges":{"buckets":[{"key":"*-10.0","to":10,"doc_count":2},{"key":"10.0-*","from":10,"doc_count":1}]},
+			"by_type":{"buckets":{"errors":{"doc_count":2},"warnings":{"doc_count":1}}},
+			"significant":{"doc_count":3,"bg_count":6,"buckets":[{"key":"a","doc_count":3,"score":1,"bg_count":3}]},
+			"top":{"hits":{"total":3,"max_score":1,"hits":[{"_index":"logs","_type":"log","_id":"1","_source":{"host":"a"}}]}}}}`,
+		"7.10": `{"hits":{"total":{"value":3,"relation":"eq"},"max_score":null,"hits":[]},"aggregations":{
+			"by_host":{"doc_count_error_upper_bound":0,"sum_other_doc_count":1,"buckets":[{"key":"a","doc_count":2,"latency":{"values":{"50.0":12.5,"99.0":40}}}]},
+			"slow":{"doc_count":1,"hosts":{"value":1}},
+			"empty":{"value":null},
+			"stats":{"count":3,"min":1,"max":3,"avg":2,"sum":6},
+			"ranges":{"buckets":[{"key":"*-10.0","to":10,"doc_count":2},{"key":"10.0-*","from":10,"doc_count":1}]},
+			"by_type":{"buckets":{"errors":{"doc_count":2},"warnings":{"doc_count":1}}},
+			"significant":{"doc_count":3,"bg_count":6,"buckets":[{"key":"a","doc_count":3,"score":1,"bg_count":3}]},
+			"top":{"hits":{"total":{"value":3,"relation":"eq"},"max_score":1,"hits":[{"_index":"logs","_id":"1","_source":{"host":"a"}}]}}}}`,
+	}
+
+	for version, v := range responses {
+		response := SearchResponse{}
+		assert.Nil(t, util.FromJson(v, &response), version)
+		assert.Equal(t, 3, response.GetTotal(), version)
+
+		byHost := response.Aggregations["by_host"]
+		assert.Equal(t, 1, byHost.SumOtherDocCount, version)
+		assert.Equal(t, "a", byHost.Buckets[0].Key, version)
+		assert.Equal(t, 2, byHost.Buckets[0].DocCount, version)
+		assert.Equal(t, 12.5, *byHost.Buckets[0].Aggregations["latency"].Values["50.0"], version)
+
+		slow := response.Aggregations["slow"]
+		assert.Equal(t, 1, slow.DocCount, version)
+		hosts := slow.Aggregations["hosts"]
+		value, ok := hosts.GetValue()
+		assert.Equal(t, true, ok, version)
+		assert.Equal(t, 1.0, value, version)
+
+		empty := response.Aggregations["empty"]
+		_, ok = empty.GetValue()
+		assert.Equal(t, false, ok, version)
+
+		stats := response.Aggregations["stats"]
+		assert.Equal(t, 3, stats.Count, version)
+		assert.Equal(t, 2.0, *stats.Avg, version)
+		assert.Equal(t, 6.0, *stats.Sum, version)
+
+		ranges := response.Aggregations["ranges"].Buckets
+		assert.Nil(t, ranges[0].From, version)
+		assert.Equal(t, 10.0, *ranges[0].To, version)
+		assert.Equal(t, 10.0, *ranges[1].From, version)
+
+		assert.Equal(t, 2, response.Aggregations["by_type"].KeyedBuckets["errors"].DocCount, version)
+
+		significant := response.Aggregations["significant"]
+		assert.Equal(t, 3, significant.DocCount, version)
+		assert.Equal(t, 6, significant.BgCount, version)
+		assert.Equal(t, "a", significant.Buckets[0].Key, version)
+		assert.Equal(t, 3, significant.Buckets[0].BgCount, version)
+
+		top := response.Aggregations["top"].Hits
+		assert.Equal(t, "a", top.Hits[0].Source["host"], version)
+	}
+}
+
+func TestSubAggregationNames(t *testing.T) {
+	response := SearchResponse{}
+	err := util.FromJson(`{"aggregations":{"f":{"doc_count":2,"avg":{"value":1.5},"count":{"value":2}},
+		"t":{"buckets":[{"key":{"host":"a"},"doc_count":2,"sum":{"value":3}}]}}}`, &response)
+	assert.Nil(t, err)
+
+	f := response.Aggregations["f"]
+	assert.Equal(t, 0, f.Count)
+	assert.Equal(t, 1.5, f.Aggregations["avg"].Value)
+	assert.Equal(t, 2.0, f.Aggregations["count"].Value)
+
+	bucket := response.Aggregations["t"].Buckets[0]
+	assert.Equal(t, map[string]interface{}{"host": "a"}, bucket.Key)
+	assert.Equal(t, 3.0, bucket.Aggregations["sum"].Value)
+}
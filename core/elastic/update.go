@@ -0,0 +1,71 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elastic
+
+import "time"
+
+// UpdateResponse is the response of partial update, the Result is `noop` if the document is not changed
+type UpdateResponse struct {
+	Result  string `json:"result"`
+	Index   string `json:"_index"`
+	Type    string `json:"_type"`
+	ID      string `json:"_id"`
+	Version int    `json:"_version"`
+}
+
+// Script is the script of update, the Source is sent as `inline` before elasticsearch 5.6
+type Script struct {
+	Source string                 `json:"source"`
+	Lang   string                 `json:"lang,omitempty"`
+	Params map[string]interface{} `json:"params,omitempty"`
+}
+
+// ByQueryRequest is the request of update by query and delete by query, all the documents are matched if the Query is nil,
+// the documents are updated by the Script, or just reindexed if it is nil
+type ByQueryRequest struct {
+	Query  *Query  `json:"query,omitempty"`
+	Script *Script `json:"script,omitempty"`
+
+	//ProceedOnConflicts count the version conflicts instead of aborting the task
+	ProceedOnConflicts bool `json:"-"`
+	//Slices split the task into the sub tasks by sliced scroll
+	Slices int `json:"-"`
+	//Timeout cancels the task if it is not completed in time, the default timeout of adapter is used if it is not set
+	Timeout time.Duration `json:"-"`
+}
+
+// ByQueryResponse is the result of the task of update by query or delete by query
+type ByQueryResponse struct {
+	Took             int              `json:"took"`
+	TimedOut         bool             `json:"timed_out"`
+	Total            int              `json:"total"`
+	Updated          int              `json:"updated"`
+	Deleted          int              `json:"deleted"`
+	Batches          int              `json:"batches"`
+	VersionConflicts int              `json:"version_conflicts"`
+	Noops            int              `json:"noops"`
+	Failures         []ByQueryFailure `json:"failures"`
+}
+
+// ByQueryFailure is a failed document of the task
+type ByQueryFailure struct {
+	Index  string         `json:"index"`
+	Type   string         `json:"type,omitempty"`
+	ID     string         `json:"id"`
+	Status int            `json:"status"`
+	Cause  *BulkItemError `json:"cause"`
+}
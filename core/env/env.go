@@ -53,6 +53,12 @@ type Env struct {
 	IsDebug      bool
 	IsDaemonMode bool
 
+	// Migrate is the mode of running orm migrations at startup, options: up, dry_run
+	Migrate string
+
+	// Reindex is the names of elasticsearch reindex in config to run at startup, separated by `,`
+	Reindex string
+
 	LoggingLevel string
 
 	init bool
@@ -19,6 +19,7 @@ package filter
 import (
 	log "github.com/cihub/seelog"
 	"github.com/huminghe/infini-framework/core/errors"
+	"sort"
 )
 
 // Filter is used to check if the object is in the filter or not
@@ -34,7 +35,30 @@ type Filter interface {
 	Close() error
 }
 
+// Clearable is implemented by the filters which are able to remove all the keys of a bucket
+type Clearable interface {
+	Clear(bucket string) error
+}
+
+// ErrDeleteNotSupported is returned by the filters which are not able to remove a key, such as the bloom filter
+var ErrDeleteNotSupported = errors.New("delete is not supported")
+
+// Stats is the common stats of a bucket, reported by all kinds of filters
+type Stats struct {
+	Filter                     string  `json:"filter"`
+	Bucket                     string  `json:"bucket"`
+	Items                      uint64  `json:"items"`
+	SizeInBytes                uint64  `json:"size_in_bytes"`
+	EstimatedFalsePositiveRate float64 `json:"estimated_false_positive_rate"`
+}
+
+// StatsProvider is implemented by the filters which are able to report the stats of a bucket
+type StatsProvider interface {
+	GetStats(bucket string) (Stats, error)
+}
+
 var handler Filter
+var active string
 
 func getHandler() Filter {
 	if handler == nil {
@@ -65,6 +89,30 @@ func CheckThenAdd(bucket string, key []byte) (bool, error) {
 
 var filters map[string]Filter
 
+// GetFilter return the registered filter by name
+func GetFilter(name string) (Filter, error) {
+	h, ok := filters[name]
+	if !ok {
+		return nil, errors.Errorf("filter: %v is not registered", name)
+	}
+	return h, nil
+}
+
+// GetActiveName return the name of the filter which handles the package level calls
+func GetActiveName() string {
+	return active
+}
+
+// ListFilters return the names of all the registered filters
+func ListFilters() []string {
+	names := []string{}
+	for k := range filters {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func Register(name string, h Filter) {
 	if filters == nil {
 		filters = map[string]Filter{}
@@ -77,6 +125,7 @@ func Register(name string, h Filter) {
 	filters[name] = h
 
 	handler = h
+	active = name
 
 	log.Debug("register filter: ", name)
 
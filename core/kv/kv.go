@@ -21,6 +21,9 @@ import (
 	"github.com/huminghe/infini-framework/core/errors"
 )
 
+// ErrBucketNotFound is returned when the bucket to delete from doesn't exist
+var ErrBucketNotFound = errors.New("bucket is not found")
+
 type KVStore interface {
 	Open() error
 
@@ -37,6 +40,13 @@ type KVStore interface {
 	DeleteKey(bucket string, key []byte) error
 
 	DeleteBucket(bucket string) error
+
+	ListBuckets() ([]string, error)
+
+	// Scan walks through the keys of the bucket in byte order, begins at the key `from`,
+	// only keys with the prefix will be visited, stop after `size` keys if size great than 0,
+	// or the walk function return false, the key and value are only valid inside the walk function
+	Scan(bucket string, prefix []byte, from []byte, size int, walkFunc func(key, value []byte) bool) error
 }
 
 var handler KVStore
@@ -73,6 +83,14 @@ func DeleteBucket(bucket string) error {
 	return getKVHandler().DeleteBucket(bucket)
 }
 
+func ListBuckets() ([]string, error) {
+	return getKVHandler().ListBuckets()
+}
+
+func Scan(bucket string, prefix []byte, from []byte, size int, walkFunc func(key, value []byte) bool) error {
+	return getKVHandler().Scan(bucket, prefix, from, size, walkFunc)
+}
+
 var stores map[string]KVStore
 
 func Register(name string, h KVStore) {
@@ -0,0 +1,165 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package orm
+
+import (
+	"github.com/huminghe/infini-framework/core/errors"
+	"time"
+)
+
+type AggregationType string
+
+// Bucket aggregations, split the documents into buckets and can have sub aggregations
+const TermsAggregation AggregationType = "terms"
+const HistogramAggregation AggregationType = "histogram"
+const DateHistogramAggregation AggregationType = "date_histogram"
+
+// Metric aggregations, compute a single value over the documents
+const MinAggregation AggregationType = "min"
+const MaxAggregation AggregationType = "max"
+const AvgAggregation AggregationType = "avg"
+const SumAggregation AggregationType = "sum"
+const CardinalityAggregation AggregationType = "cardinality"
+
+// Aggregation describe a aggregation on a field, the result is keyed by the Name
+type Aggregation struct {
+	Name  string
+	Type  AggregationType
+	Field string
+
+	//Size is the max number of buckets of terms aggregation, default is 10
+	Size int
+
+	//Interval is the bucket width of histogram aggregation
+	Interval float64
+
+	//DateInterval is the bucket width of date histogram aggregation, at least one second
+	DateInterval time.Duration
+
+	//Aggs are the sub aggregations computed for each bucket
+	Aggs []*Aggregation
+}
+
+// AggregationResult is the value of a metric aggregation or the buckets of a bucket aggregation,
+// the Value is nil when there is no document to compute
+type AggregationResult struct {
+	Value   *float64 `json:"value,omitempty"`
+	Buckets []Bucket `json:"buckets,omitempty"`
+}
+
+// Bucket is a bucket of a bucket aggregation,
+// the Key is string or float64 for terms, float64 for histogram and time.Time for date histogram
+type Bucket struct {
+	Key          interface{}                   `json:"key"`
+	DocCount     int                           `json:"doc_count"`
+	Aggregations map[string]*AggregationResult `json:"aggregations,omitempty"`
+}
+
+// Terms splits the documents by the distinct values of the field, the most frequent values come first
+func Terms(name, field string, size int) *Aggregation {
+	return &Aggregation{Name: name, Type: TermsAggregation, Field: field, Size: size}
+}
+
+// Histogram splits the documents by the numeric field into buckets of fixed interval
+func Histogram(name, field string, interval float64) *Aggregation {
+	return &Aggregation{Name: name, Type: HistogramAggregation, Field: field, Interval: interval}
+}
+
+// DateHistogram splits the documents by the date field into buckets of fixed interval
+func DateHistogram(name, field string, interval time.Duration) *Aggregation {
+	return &Aggregation{Name: name, Type: DateHistogramAggregation, Field: field, DateInterval: interval}
+}
+
+func Min(name, field string) *Aggregation {
+	return &Aggregation{Name: name, Type: MinAggregation, Field: field}
+}
+
+func Max(name, field string) *Aggregation {
+	return &Aggregation{Name: name, Type: MaxAggregation, Field: field}
+}
+
+func Avg(name, field string) *Aggregation {
+	return &Aggregation{Name: name, Type: AvgAggregation, Field: field}
+}
+
+func Sum(name, field string) *Aggregation {
+	return &Aggregation{Name: name, Type: SumAggregation, Field: field}
+}
+
+// Cardinality counts the distinct values of the field, it is approximate in elasticsearch
+func Cardinality(name, field string) *Aggregation {
+	return &Aggregation{Name: name, Type: CardinalityAggregation, Field: field}
+}
+
+// SubAggregation add sub aggregations to the bucket aggregation
+func (agg *Aggregation) SubAggregation(aggs ...*Aggregation) *Aggregation {
+	agg.Aggs = append(agg.Aggs, aggs...)
+	return agg
+}
+
+// IsBucket return true if the aggregation produces buckets
+func (agg *Aggregation) IsBucket() bool {
+	switch agg.Type {
+	case TermsAggregation, HistogramAggregation, DateHistogramAggregation:
+		return true
+	}
+	return false
+}
+
+// GetSize return the max number of buckets of terms aggregation
+func (agg *Aggregation) GetSize() int {
+	if agg.Size <= 0 {
+		return 10
+	}
+	return agg.Size
+}
+
+// ValidateAggregations check the aggregations and their sub aggregations
+func ValidateAggregations(aggs []*Aggregation) error {
+	names := map[string]bool{}
+	for _, agg := range aggs {
+		if agg.Name == "" || agg.Field == "" {
+			return errors.Errorf("invalid aggregation, name and field are required: %v", agg)
+		}
+		if names[agg.Name] {
+			return errors.Errorf("duplicated aggregation: %s", agg.Name)
+		}
+		names[agg.Name] = true
+
+		switch agg.Type {
+		case TermsAggregation, MinAggregation, MaxAggregation, AvgAggregation, SumAggregation, CardinalityAggregation:
+		case HistogramAggregation:
+			if agg.Interval <= 0 {
+				return errors.Errorf("invalid interval of aggregation %s: %v", agg.Name, agg.Interval)
+			}
+		case DateHistogramAggregation:
+			if agg.DateInterval < time.Second {
+				return errors.Errorf("invalid interval of aggregation %s: %v", agg.Name, agg.DateInterval)
+			}
+		default:
+			return errors.Errorf("invalid aggregation type: %v", agg.Type)
+		}
+
+		if !agg.IsBucket() && len(agg.Aggs) > 0 {
+			return errors.Errorf("metric aggregation %s can't have sub aggregations", agg.Name)
+		}
+		if err := ValidateAggregations(agg.Aggs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package orm
+
+// DefaultBulkChunkSize is the number of objects sent in a request or committed in a transaction by the bulk operations
+const DefaultBulkChunkSize = 1000
+
+// BulkResult is the result of a object in bulk operation, the Error is nil if it succeeded
+type BulkResult struct {
+	ID    string
+	Error error
+}
+
+// Bulk is the operations on a batch of objects, the objects are split into chunks by the chunk size,
+// and the default chunk size is used if it is not positive, the results are in the same order of the objects,
+// the error is returned when a chunk failed as a whole, the handler stops there and the remaining objects are not written
+type Bulk interface {
+	BulkSave(objects []interface{}, chunkSize int) ([]BulkResult, error)
+
+	BulkUpdate(objects []interface{}, chunkSize int) ([]BulkResult, error)
+
+	BulkDelete(objects []interface{}, chunkSize int) ([]BulkResult, error)
+}
+
+// BulkSave save the objects in batch, the hooks are called and the change events are published for every object
+func BulkSave(objects []interface{}, chunkSize int) ([]BulkResult, error) {
+	return bulkChange(OpSave, objects, chunkSize, getHandler().BulkSave)
+}
+
+// BulkUpdate update the objects in batch, the objects with version field fail with ConflictError if they are changed
+func BulkUpdate(objects []interface{}, chunkSize int) ([]BulkResult, error) {
+	return bulkChange(OpUpdate, objects, chunkSize, getHandler().BulkUpdate)
+}
+
+// BulkDelete delete the objects in batch
+func BulkDelete(objects []interface{}, chunkSize int) ([]BulkResult, error) {
+	return bulkChange(OpDelete, objects, chunkSize, getHandler().BulkDelete)
+}
+
+// bulkChange run the before hooks for every object, the vetoed objects are not sent to the handler,
+// if the handler failed, the objects without result fail with the error of the handler
+func bulkChange(op Operation, objects []interface{}, chunkSize int, fn func(objects []interface{}, chunkSize int) ([]BulkResult, error)) ([]BulkResult, error) {
+	results := make([]BulkResult, len(objects))
+	accepted := []interface{}{}
+	index := []int{}
+	for i, o := range objects {
+		results[i].ID = GetID(o)
+		if err := before(op, o); err != nil {
+			results[i].Error = err
+			continue
+		}
+		accepted = append(accepted, o)
+		index = append(index, i)
+	}
+
+	if len(accepted) == 0 {
+		return results, nil
+	}
+
+	r, err := fn(accepted, chunkSize)
+	for j, v := range r {
+		results[index[j]] = v
+		if v.Error == nil {
+			after(op, accepted[j], publishChangeEvent)
+		}
+	}
+
+	//the objects of the failed chunk and the following chunks were not written
+	if err != nil {
+		for j := len(r); j < len(accepted); j++ {
+			results[index[j]].Error = err
+		}
+	}
+	return results, err
+}
+
+// GetBulkChunks split the objects into chunks, the default chunk size is used if it is not positive
+func GetBulkChunks(objects []interface{}, chunkSize int) [][]interface{} {
+	if chunkSize <= 0 {
+		chunkSize = DefaultBulkChunkSize
+	}
+	chunks := [][]interface{}{}
+	for i := 0; i < len(objects); i += chunkSize {
+		end := i + chunkSize
+		if end > len(objects) {
+			end = len(objects)
+		}
+		chunks = append(chunks, objects[i:end])
+	}
+	return chunks
+}
+
+// RunBulk run fn for the chunks of objects, and collect the results, it stops at the first failed chunk
+func RunBulk(objects []interface{}, chunkSize int, fn func(chunk []interface{}) ([]BulkResult, error)) ([]BulkResult, error) {
+	results := make([]BulkResult, 0, len(objects))
+	for _, chunk := range GetBulkChunks(objects, chunkSize) {
+		r, err := fn(chunk)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, r...)
+	}
+	return results, nil
+}
+
+// RunEach run fn for every object of the chunk, the error of fn is the result of the object
+func RunEach(chunk []interface{}, fn func(o interface{}) error) []BulkResult {
+	results := make([]BulkResult, len(chunk))
+	for i, o := range chunk {
+		err := fn(o)
+		results[i] = BulkResult{ID: GetID(o), Error: err}
+	}
+	return results
+}
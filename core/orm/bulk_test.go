@@ -0,0 +1,83 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package orm
+
+import (
+	"github.com/huminghe/infini-framework/core/errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func (handler recordingORM) BulkSave(objects []interface{}, chunkSize int) ([]BulkResult, error) {
+	return RunBulk(objects, chunkSize, func(chunk []interface{}) ([]BulkResult, error) {
+		*handler.changes = append(*handler.changes, "chunk")
+		return RunEach(chunk, func(o interface{}) error {
+			if GetID(o) == "fail" {
+				return errors.New("failed")
+			}
+			return handler.Save(o)
+		}), nil
+	})
+}
+
+func (handler recordingORM) BulkDelete(objects []interface{}, chunkSize int) ([]BulkResult, error) {
+	return nil, errors.New("unavailable")
+}
+
+func TestGetBulkChunks(t *testing.T) {
+	objects := []interface{}{1, 2, 3, 4, 5}
+	assert.Equal(t, [][]interface{}{{1, 2}, {3, 4}, {5}}, GetBulkChunks(objects, 2))
+	assert.Equal(t, [][]interface{}{{1, 2, 3, 4, 5}}, GetBulkChunks(objects, 0))
+	assert.Equal(t, [][]interface{}{}, GetBulkChunks(nil, 2))
+}
+
+func TestBulk(t *testing.T) {
+	changes, events, cleanup := setupHooks(t)
+	defer cleanup()
+
+	RegisterBeforeHook(&hookDoc{}, func(op Operation, o interface{}) error {
+		if o.(*hookDoc).Name == "" {
+			return errors.New("name is required")
+		}
+		return nil
+	})
+
+	results, err := BulkSave([]interface{}{
+		&hookDoc{ID: "a", Name: "alice"}, &hookDoc{ID: "b"}, &hookDoc{ID: "fail", Name: "x"}, &hookDoc{ID: "c", Name: "carol"},
+	}, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(results))
+	assert.Equal(t, BulkResult{ID: "a"}, results[0])
+	assert.Equal(t, "name is required", results[1].Error.Error())
+	assert.Equal(t, "failed", results[2].Error.Error())
+	assert.Equal(t, BulkResult{ID: "c"}, results[3])
+
+	//the vetoed object is not sent
+	assert.Equal(t, []string{"chunk", "save a", "chunk", "save c"}, *changes)
+	assert.Equal(t, 2, len(*events))
+	assert.Equal(t, "c", (*events)[1].ID)
+
+	//the objects not written fail with the error of the chunk
+	results, err = BulkDelete([]interface{}{&hookDoc{ID: "a", Name: "alice"}, &hookDoc{ID: "b"}, &hookDoc{ID: "c", Name: "carol"}}, 0)
+	assert.Equal(t, "unavailable", err.Error())
+	assert.Equal(t, 3, len(results))
+	assert.Equal(t, "a", results[0].ID)
+	assert.Equal(t, "unavailable", results[0].Error.Error())
+	assert.Equal(t, "name is required", results[1].Error.Error())
+	assert.Equal(t, "unavailable", results[2].Error.Error())
+	assert.Equal(t, 2, len(*events))
+}
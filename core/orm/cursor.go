@@ -0,0 +1,129 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package orm
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/huminghe/infini-framework/core/errors"
+	"reflect"
+)
+
+// The cursor is the opaque encoding of the sort values of the last document in a page,
+// the backends sort by the id of documents after the Query.Sort, so that the sort values are unique,
+// and the next page starts after the sort values, which is search_after of elasticsearch and keyset pagination of SQL
+
+// EncodeCursor encode the sort values of the last document
+func EncodeCursor(values []interface{}) (string, error) {
+	b, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// DecodeCursor decode the sort values, which are kept raw so that the backends can decode them to the types of fields
+func DecodeCursor(cursor string) ([]json.RawMessage, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errors.Errorf("invalid cursor: %s", cursor)
+	}
+	values := []json.RawMessage{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err = d.Decode(&values); err != nil {
+		return nil, errors.Errorf("invalid cursor: %s", cursor)
+	}
+	return values, nil
+}
+
+// Iterator walks through all the documents matched by the query page by page with cursor
+type Iterator struct {
+	handler ORM
+	t       interface{}
+	q       Query
+	result  Result
+	done    bool
+	err     error
+}
+
+// Iterate return a iterator of the documents matched by the query, the Size of query is the page size
+func Iterate(t interface{}, q *Query) *Iterator {
+	return NewIterator(getHandler(), t, q)
+}
+
+// NewIterator return a iterator over the handler
+func NewIterator(handler ORM, t interface{}, q *Query) *Iterator {
+	it := &Iterator{handler: handler, t: t, q: *q}
+	if it.q.Size <= 0 {
+		it.q.Size = 100
+	}
+	it.q.From = 0
+	return it
+}
+
+// Next fetch the next page into to, which is same as the parameter of Search,
+// it returns false when there is no more documents or there is error
+func (it *Iterator) Next(to interface{}) bool {
+	if it.done {
+		return false
+	}
+
+	err, result := it.handler.Search(it.t, to, &it.q)
+	if err != nil {
+		it.err = err
+		it.done = true
+		return false
+	}
+
+	it.result = result
+	if result.Cursor == "" {
+		it.done = true
+	}
+	it.q.Cursor = result.Cursor
+
+	return getLength(result.Result) > 0
+}
+
+// Result return the result of current page
+func (it *Iterator) Result() Result {
+	return it.result
+}
+
+// Err return the error stopped the iteration
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// getLength return the length of the search result, which is a slice or a pointer to slice
+func getLength(o interface{}) int {
+	if o == nil {
+		return 0
+	}
+	v := reflect.ValueOf(o)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return 0
+		}
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+		return v.Len()
+	}
+	return 0
+}
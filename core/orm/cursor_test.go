@@ -0,0 +1,109 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package orm
+
+import (
+	"encoding/json"
+	"github.com/huminghe/infini-framework/core/errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCursor(t *testing.T) {
+	created := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	cursor, err := EncodeCursor([]interface{}{"a", 12345678901234, created})
+	assert.Nil(t, err)
+
+	values, err := DecodeCursor(cursor)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(values))
+
+	var s string
+	var n int64
+	var c time.Time
+	assert.Nil(t, json.Unmarshal(values[0], &s))
+	assert.Nil(t, json.Unmarshal(values[1], &n))
+	assert.Nil(t, json.Unmarshal(values[2], &c))
+	assert.Equal(t, "a", s)
+	assert.Equal(t, int64(12345678901234), n)
+	assert.True(t, created.Equal(c))
+
+	_, err = DecodeCursor("not a cursor")
+	assert.NotNil(t, err)
+}
+
+// pagedORM returns the items page by page, the cursor is the offset of next page
+type pagedORM struct {
+	ORM
+	items []string
+	err   error
+}
+
+func (handler pagedORM) Search(t interface{}, to interface{}, q *Query) (error, Result) {
+	if handler.err != nil {
+		return handler.err, Result{}
+	}
+	from := 0
+	if q.Cursor != "" {
+		values, err := DecodeCursor(q.Cursor)
+		if err != nil {
+			return err, Result{}
+		}
+		json.Unmarshal(values[0], &from)
+	}
+	end := from + q.Size
+	if end > len(handler.items) {
+		end = len(handler.items)
+	}
+	page := handler.items[from:end]
+	*(to.(*[]string)) = page
+
+	result := Result{Total: len(handler.items), Result: to}
+	if len(page) == q.Size {
+		result.Cursor, _ = EncodeCursor([]interface{}{end})
+	}
+	return nil, result
+}
+
+func TestIterator(t *testing.T) {
+	walk := func(it *Iterator) []string {
+		items := []string{}
+		page := []string{}
+		for it.Next(&page) {
+			items = append(items, page...)
+		}
+		return items
+	}
+
+	handler := pagedORM{items: []string{"a", "b", "c", "d", "e"}}
+	it := NewIterator(handler, nil, &Query{Size: 2})
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, walk(it))
+	assert.Nil(t, it.Err())
+	assert.Equal(t, 5, it.Result().Total)
+
+	//the last page is full, the next page is empty
+	it = NewIterator(handler, nil, &Query{Size: 5})
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, walk(it))
+
+	it = NewIterator(pagedORM{}, nil, &Query{Size: 5})
+	assert.Equal(t, []string{}, walk(it))
+
+	it = NewIterator(pagedORM{err: errors.New("failed")}, nil, &Query{Size: 2})
+	assert.Equal(t, []string{}, walk(it))
+	assert.Equal(t, "failed", it.Err().Error())
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package orm
+
+import (
+	"encoding/json"
+	log "github.com/cihub/seelog"
+	"github.com/huminghe/infini-framework/core/env"
+	"github.com/huminghe/infini-framework/core/queue"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChangeEvent is published to the queue when a object is changed,
+// the Value is the object after the change, or the deleted object
+type ChangeEvent struct {
+	Type      string      `json:"type"`
+	ID        string      `json:"id"`
+	Operation Operation   `json:"operation"`
+	Value     interface{} `json:"value,omitempty"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+func newChangeEvent(op Operation, o interface{}) *ChangeEvent {
+	return &ChangeEvent{
+		Type:      strings.ToLower(getStructType(o).Name()),
+		ID:        GetID(o),
+		Operation: op,
+		Value:     o,
+		Timestamp: time.Now(),
+	}
+}
+
+// ChangeEventConfig is the config of change events under `orm.change_events`, the events are pushed to the queue if enabled
+type ChangeEventConfig struct {
+	Enabled bool   `config:"enabled"`
+	Queue   string `config:"queue"`
+}
+
+type Config struct {
+	ChangeEvents ChangeEventConfig `config:"change_events"`
+}
+
+var eventLock sync.Mutex
+var eventConfig *ChangeEventConfig
+
+// pushEvent is replaced by tests
+var pushEvent = queue.Push
+
+// getChangeEventConfig load the config at the first change
+func getChangeEventConfig() ChangeEventConfig {
+	eventLock.Lock()
+	defer eventLock.Unlock()
+	if eventConfig == nil {
+		cfg := Config{}
+		exist, err := env.ParseConfig("orm", &cfg)
+		if err != nil && exist {
+			log.Error("invalid orm config: ", err)
+		}
+		eventConfig = &cfg.ChangeEvents
+	}
+	return *eventConfig
+}
+
+// SetChangeEventQueue publish the change events to the queue instead of the configured one, the events are disabled if queue is empty
+func SetChangeEventQueue(queue string) {
+	eventLock.Lock()
+	defer eventLock.Unlock()
+	eventConfig = &ChangeEventConfig{Enabled: queue != "", Queue: queue}
+}
+
+// publishChangeEvent push the event to the queue if the change events are enabled,
+// the failure is logged, as the change is already made
+func publishChangeEvent(event *ChangeEvent) {
+	cfg := getChangeEventConfig()
+	if !cfg.Enabled || cfg.Queue == "" {
+		return
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Errorf("failed to encode change event of %s %v: %v", event.Type, event.ID, err)
+		return
+	}
+	if err := pushEvent(cfg.Queue, data); err != nil {
+		log.Errorf("failed to publish change event of %s %v: %v", event.Type, event.ID, err)
+	}
+}
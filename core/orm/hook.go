@@ -0,0 +1,179 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package orm
+
+import (
+	log "github.com/cihub/seelog"
+	"github.com/huminghe/infini-framework/core/errors"
+	"reflect"
+	"sync"
+)
+
+// Operation is the change made to a object
+type Operation string
+
+const OpSave Operation = "save"
+const OpUpdate Operation = "update"
+const OpDelete Operation = "delete"
+
+// HookFunc is called before or after the change of object, the object is the parameter of Save, Update or Delete,
+// the operation is vetoed if a before hook returns error
+type HookFunc func(op Operation, o interface{}) error
+
+type hooks struct {
+	before []HookFunc
+	after  []HookFunc
+}
+
+var hookLock sync.RWMutex
+var globalHooks = hooks{}
+var typeHooks = map[reflect.Type]*hooks{}
+
+func getHooks(t interface{}) *hooks {
+	if t == nil {
+		return &globalHooks
+	}
+	rt := getStructType(t)
+	h, ok := typeHooks[rt]
+	if !ok {
+		h = &hooks{}
+		typeHooks[rt] = h
+	}
+	return h
+}
+
+func isNil(o interface{}) bool {
+	if o == nil {
+		return true
+	}
+	v := reflect.ValueOf(o)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
+
+func getStructType(t interface{}) reflect.Type {
+	rt := reflect.TypeOf(t)
+	for rt.Kind() == reflect.Ptr || rt.Kind() == reflect.Slice {
+		rt = rt.Elem()
+	}
+	return rt
+}
+
+// RegisterBeforeHook register the hook called before the objects of type t are changed, the hook is global if t is nil
+func RegisterBeforeHook(t interface{}, fn HookFunc) {
+	hookLock.Lock()
+	defer hookLock.Unlock()
+	h := getHooks(t)
+	h.before = append(h.before, fn)
+}
+
+// RegisterAfterHook register the hook called after the objects of type t are changed, the hook is global if t is nil,
+// as the change is already made, the error of after hook is only logged
+func RegisterAfterHook(t interface{}, fn HookFunc) {
+	hookLock.Lock()
+	defer hookLock.Unlock()
+	h := getHooks(t)
+	h.after = append(h.after, fn)
+}
+
+// ClearHooks remove all the hooks
+func ClearHooks() {
+	hookLock.Lock()
+	defer hookLock.Unlock()
+	globalHooks = hooks{}
+	typeHooks = map[reflect.Type]*hooks{}
+}
+
+// getHookFuncs return the global hooks and then the hooks of the type
+func getHookFuncs(o interface{}, before bool) []HookFunc {
+	hookLock.RLock()
+	defer hookLock.RUnlock()
+
+	fns := []HookFunc{}
+	list := []*hooks{&globalHooks}
+	if h, ok := typeHooks[getStructType(o)]; ok {
+		list = append(list, h)
+	}
+	for _, h := range list {
+		if before {
+			fns = append(fns, h.before...)
+		} else {
+			fns = append(fns, h.after...)
+		}
+	}
+	return fns
+}
+
+// change run the operation with hooks, the change event is passed to emit if the operation succeeded
+func change(op Operation, o interface{}, fn func(o interface{}) error, emit func(event *ChangeEvent)) error {
+	if err := before(op, o); err != nil {
+		return err
+	}
+
+	if err := fn(o); err != nil {
+		return err
+	}
+
+	after(op, o, emit)
+	return nil
+}
+
+// ErrNilObject is returned by the changes of a nil object, before the hooks are called
+var ErrNilObject = errors.New("object is nil")
+
+func before(op Operation, o interface{}) error {
+	if isNil(o) {
+		return ErrNilObject
+	}
+	for _, hook := range getHookFuncs(o, true) {
+		if err := hook(op, o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func after(op Operation, o interface{}, emit func(event *ChangeEvent)) {
+	for _, hook := range getHookFuncs(o, false) {
+		if err := hook(op, o); err != nil {
+			log.Errorf("failed to run hook after %s %v: %v", op, GetID(o), err)
+		}
+	}
+
+	emit(newChangeEvent(op, o))
+}
+
+// hookTx run the operations of transaction with hooks, the change events are published after the transaction is committed
+type hookTx struct {
+	Tx
+	events []*ChangeEvent
+}
+
+func (tx *hookTx) emit(event *ChangeEvent) {
+	tx.events = append(tx.events, event)
+}
+
+func (tx *hookTx) Save(o interface{}) error {
+	return change(OpSave, o, tx.Tx.Save, tx.emit)
+}
+
+func (tx *hookTx) Update(o interface{}) error {
+	return change(OpUpdate, o, tx.Tx.Update, tx.emit)
+}
+
+func (tx *hookTx) Delete(o interface{}) error {
+	return change(OpDelete, o, tx.Tx.Delete, tx.emit)
+}
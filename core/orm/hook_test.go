@@ -0,0 +1,174 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package orm
+
+import (
+	"encoding/json"
+	"github.com/huminghe/infini-framework/core/errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type hookDoc struct {
+	ID   string `json:"id" elastic_meta:"_id"`
+	Name string `json:"name"`
+}
+
+type otherDoc struct {
+	Key  int `gorm:"primary_key"`
+	Name string
+}
+
+// recordingORM records the changes, and the changes in transaction are recorded when it is committed
+type recordingORM struct {
+	ORM
+	changes *[]string
+}
+
+func (handler recordingORM) Save(o interface{}) error {
+	*handler.changes = append(*handler.changes, "save "+GetID(o))
+	return nil
+}
+
+func (handler recordingORM) Update(o interface{}) error {
+	*handler.changes = append(*handler.changes, "update "+GetID(o))
+	return nil
+}
+
+func (handler recordingORM) Delete(o interface{}) error {
+	*handler.changes = append(*handler.changes, "delete "+GetID(o))
+	return nil
+}
+
+func (handler recordingORM) Transaction(fn func(tx Tx) error) error {
+	changes := []string{}
+	if err := fn(recordingORM{changes: &changes}); err != nil {
+		return err
+	}
+	*handler.changes = append(*handler.changes, changes...)
+	return nil
+}
+
+func setupHooks(t *testing.T) (*[]string, *[]ChangeEvent, func()) {
+	changes := []string{}
+	events := []ChangeEvent{}
+
+	h, push := handler, pushEvent
+	handler = recordingORM{changes: &changes}
+	pushEvent = func(queue string, data []byte) error {
+		assert.Equal(t, "changes", queue)
+		event := ChangeEvent{}
+		assert.Nil(t, json.Unmarshal(data, &event))
+		events = append(events, event)
+		return nil
+	}
+	SetChangeEventQueue("changes")
+
+	return &changes, &events, func() {
+		handler, pushEvent = h, push
+		SetChangeEventQueue("")
+		ClearHooks()
+	}
+}
+
+func TestHooks(t *testing.T) {
+	changes, _, cleanup := setupHooks(t)
+	defer cleanup()
+
+	calls := []string{}
+	RegisterBeforeHook(nil, func(op Operation, o interface{}) error {
+		calls = append(calls, "before "+string(op)+" "+GetID(o))
+		return nil
+	})
+	RegisterAfterHook(nil, func(op Operation, o interface{}) error {
+		calls = append(calls, "after "+string(op)+" "+GetID(o))
+		return errors.New("ignored")
+	})
+	RegisterBeforeHook(&hookDoc{}, func(op Operation, o interface{}) error {
+		doc := o.(*hookDoc)
+		if doc.Name == "" {
+			return errors.New("name is required")
+		}
+		calls = append(calls, "before hookDoc "+string(op))
+		return nil
+	})
+
+	assert.Nil(t, Save(&hookDoc{ID: "a", Name: "alice"}))
+	assert.Nil(t, Delete(&otherDoc{Key: 1}))
+	assert.Equal(t, "name is required", Update(&hookDoc{ID: "b"}).Error())
+
+	//nil objects are rejected before the hooks
+	assert.Equal(t, ErrNilObject, Save(nil))
+	assert.Equal(t, ErrNilObject, Update((*hookDoc)(nil)))
+	assert.Equal(t, ErrNilObject, Delete(nil))
+	results, err := BulkSave([]interface{}{nil}, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, ErrNilObject, results[0].Error)
+
+	assert.Equal(t, []string{"save a", "delete 1"}, *changes)
+	assert.Equal(t, []string{
+		"before save a", "before hookDoc save", "after save a",
+		"before delete 1", "after delete 1",
+		"before update b",
+	}, calls)
+}
+
+func TestChangeEvents(t *testing.T) {
+	changes, events, cleanup := setupHooks(t)
+	defer cleanup()
+
+	assert.Nil(t, Save(&hookDoc{ID: "a", Name: "alice"}))
+	assert.Nil(t, Update(&otherDoc{Key: 1, Name: "bob"}))
+	assert.Equal(t, 2, len(*events))
+	assert.Equal(t, "hookdoc", (*events)[0].Type)
+	assert.Equal(t, "a", (*events)[0].ID)
+	assert.Equal(t, OpSave, (*events)[0].Operation)
+	assert.Equal(t, map[string]interface{}{"id": "a", "name": "alice"}, (*events)[0].Value)
+	assert.Equal(t, "otherdoc", (*events)[1].Type)
+	assert.Equal(t, OpUpdate, (*events)[1].Operation)
+
+	//published after committed
+	err := Transaction(func(tx Tx) error {
+		assert.Nil(t, tx.Save(&hookDoc{ID: "b", Name: "bob"}))
+		assert.Nil(t, tx.Delete(&hookDoc{ID: "a"}))
+		assert.Equal(t, 2, len(*events))
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"save a", "update 1", "save b", "delete a"}, *changes)
+	assert.Equal(t, 4, len(*events))
+	assert.Equal(t, OpDelete, (*events)[3].Operation)
+
+	//vetoed in transaction
+	RegisterBeforeHook(&hookDoc{}, func(op Operation, o interface{}) error {
+		return errors.New("read only")
+	})
+	err = Transaction(func(tx Tx) error {
+		if err := tx.Update(&otherDoc{Key: 2}); err != nil {
+			return err
+		}
+		return tx.Save(&hookDoc{ID: "c"})
+	})
+	assert.Equal(t, "read only", err.Error())
+	assert.Equal(t, 4, len(*changes))
+	assert.Equal(t, 4, len(*events))
+
+	//disabled
+	SetChangeEventQueue("")
+	assert.Nil(t, Update(&otherDoc{Key: 1}))
+	assert.Equal(t, 4, len(*events))
+}
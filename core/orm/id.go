@@ -0,0 +1,62 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package orm
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// GetIDField return the id field of the struct type, which is tagged by `storm:"id"`, `gorm:"primary_key"` or `elastic_meta:"_id"`,
+// or named ID
+func GetIDField(t reflect.Type) (reflect.StructField, bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return reflect.StructField{}, false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if strings.Split(f.Tag.Get("storm"), ",")[0] == "id" ||
+			strings.Contains(f.Tag.Get("gorm"), "primary_key") ||
+			f.Tag.Get("elastic_meta") == "_id" {
+			return f, true
+		}
+	}
+	return t.FieldByName("ID")
+}
+
+// GetID return the id of the object, it is empty if there is no id field
+func GetID(o interface{}) string {
+	v := reflect.ValueOf(o)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return ""
+	}
+	f, ok := GetIDField(v.Type())
+	if !ok {
+		return ""
+	}
+	return fmt.Sprint(v.FieldByIndex(f.Index).Interface())
+}
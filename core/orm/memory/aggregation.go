@@ -0,0 +1,203 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memory
+
+import (
+	"fmt"
+	"github.com/huminghe/infini-framework/core/orm"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+)
+
+func (s *store) aggregate(t interface{}, q *orm.Query, aggs []*orm.Aggregation) (map[string]*orm.AggregationResult, error) {
+	if err := orm.ValidateAggregations(aggs); err != nil {
+		return nil, err
+	}
+
+	rt, err := getType(t)
+	if err != nil {
+		return nil, err
+	}
+	var conds []*orm.Cond
+	if q != nil {
+		conds = q.Conds
+	}
+	docs, err := s.find(rt, conds)
+	if err != nil {
+		return nil, err
+	}
+	return getAggregationResults(rt, docs, aggs)
+}
+
+func getAggregationResults(t reflect.Type, docs []reflect.Value, aggs []*orm.Aggregation) (map[string]*orm.AggregationResult, error) {
+	results := map[string]*orm.AggregationResult{}
+	for _, agg := range aggs {
+		f, err := getField(t, agg.Field)
+		if err != nil {
+			return nil, err
+		}
+		values := make([]interface{}, len(docs))
+		for i, doc := range docs {
+			values[i] = normalize(f.get(doc))
+		}
+
+		if !agg.IsBucket() {
+			results[agg.Name] = getMetricResult(agg, values)
+			continue
+		}
+
+		buckets, err := getBuckets(agg, docs, values)
+		if err != nil {
+			return nil, err
+		}
+		result := &orm.AggregationResult{Buckets: []orm.Bucket{}}
+		for _, b := range buckets {
+			bucket := orm.Bucket{Key: b.key, DocCount: len(b.docs)}
+			if len(agg.Aggs) > 0 {
+				bucket.Aggregations, err = getAggregationResults(t, b.docs, agg.Aggs)
+				if err != nil {
+					return nil, err
+				}
+			}
+			result.Buckets = append(result.Buckets, bucket)
+		}
+		results[agg.Name] = result
+	}
+	return results, nil
+}
+
+type bucket struct {
+	key  interface{}
+	docs []reflect.Value
+}
+
+// getBuckets split the documents by the bucket key, the documents without value are skipped,
+// the terms buckets are ordered by count, and the histogram buckets are ordered by key
+func getBuckets(agg *orm.Aggregation, docs []reflect.Value, values []interface{}) ([]*bucket, error) {
+	buckets := []*bucket{}
+	index := map[string]*bucket{}
+	for i, v := range values {
+		key := getBucketKey(agg, v)
+		if key == nil {
+			continue
+		}
+		id := fmt.Sprintf("%T:%v", key, key)
+		b, ok := index[id]
+		if !ok {
+			b = &bucket{key: key}
+			index[id] = b
+			buckets = append(buckets, b)
+		}
+		b.docs = append(b.docs, docs[i])
+	}
+
+	sort.Slice(buckets, func(i, j int) bool {
+		if agg.Type == orm.TermsAggregation && len(buckets[i].docs) != len(buckets[j].docs) {
+			return len(buckets[i].docs) > len(buckets[j].docs)
+		}
+		r, _ := compare(buckets[i].key, buckets[j].key)
+		return r < 0
+	})
+
+	if agg.Type == orm.TermsAggregation && len(buckets) > agg.GetSize() {
+		buckets = buckets[:agg.GetSize()]
+	}
+	return buckets, nil
+}
+
+func getBucketKey(agg *orm.Aggregation, v interface{}) interface{} {
+	switch agg.Type {
+	case orm.TermsAggregation:
+		return v
+	case orm.HistogramAggregation:
+		if f, ok := v.(float64); ok {
+			return math.Floor(f/agg.Interval) * agg.Interval
+		}
+	case orm.DateHistogramAggregation:
+		if t, ok := getTime(v); ok {
+			interval := int64(agg.DateInterval)
+			n := t.UnixNano()
+			key := n - n%interval
+			if n%interval < 0 {
+				key -= interval
+			}
+			return time.Unix(0, key).UTC()
+		}
+	}
+	return nil
+}
+
+func getTime(v interface{}) (time.Time, bool) {
+	switch x := v.(type) {
+	case time.Time:
+		return x, true
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, x)
+		return t, err == nil
+	}
+	return time.Time{}, false
+}
+
+// getMetricResult compute the metric over the values, the time is converted to milliseconds like elasticsearch,
+// the value is nil if there is no value, except that the sum is 0
+func getMetricResult(agg *orm.Aggregation, values []interface{}) *orm.AggregationResult {
+	numbers := []float64{}
+	distinct := map[string]bool{}
+	for _, v := range values {
+		if v == nil {
+			continue
+		}
+		distinct[fmt.Sprintf("%T:%v", v, v)] = true
+		switch x := v.(type) {
+		case float64:
+			numbers = append(numbers, x)
+		case time.Time:
+			numbers = append(numbers, float64(x.UnixNano()/int64(time.Millisecond)))
+		}
+	}
+
+	var value float64
+	switch agg.Type {
+	case orm.CardinalityAggregation:
+		value = float64(len(distinct))
+	case orm.SumAggregation:
+		for _, n := range numbers {
+			value += n
+		}
+	default:
+		if len(numbers) == 0 {
+			return &orm.AggregationResult{}
+		}
+		value = numbers[0]
+		sum := 0.0
+		for _, n := range numbers {
+			switch agg.Type {
+			case orm.MinAggregation:
+				value = math.Min(value, n)
+			case orm.MaxAggregation:
+				value = math.Max(value, n)
+			}
+			sum += n
+		}
+		if agg.Type == orm.AvgAggregation {
+			value = sum / float64(len(numbers))
+		}
+	}
+	return &orm.AggregationResult{Value: &value}
+}
@@ -0,0 +1,454 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package memory is a ORM backend which keeps the objects in memory,
+// it is used by the unit tests and the embedded applications which don't need persistence.
+//
+// The objects are copied by JSON encoding, so only the fields visible to encoding/json are kept,
+// the id of a object is the field tagged by `storm:"id"`, `gorm:"primary_key"` or `elastic_meta:"_id"`, or the field named ID.
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/huminghe/infini-framework/core/errors"
+	"github.com/huminghe/infini-framework/core/orm"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+// ErrNotFound is returned when the object with the id doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// MemoryORM is the in-memory ORM handler, it is safe for concurrent use
+type MemoryORM struct {
+	lock  sync.RWMutex
+	store *store
+}
+
+// New return a empty in-memory ORM handler
+func New() *MemoryORM {
+	return &MemoryORM{store: newStore()}
+}
+
+var defaultORM = New()
+var registerOnce sync.Once
+
+// Register register the shared in-memory handler as the ORM handler, and reset it,
+// so that a test can start with a empty store by calling it at the beginning
+func Register() *MemoryORM {
+	registerOnce.Do(func() {
+		orm.Register("memory", defaultORM)
+	})
+	defaultORM.Reset()
+	return defaultORM
+}
+
+// Reset remove all the objects
+func (handler *MemoryORM) Reset() {
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+	handler.store = newStore()
+}
+
+func (handler *MemoryORM) RegisterSchema(t interface{}) error {
+	_, err := getType(t)
+	return err
+}
+
+func (handler *MemoryORM) Save(o interface{}) error {
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+	return handler.store.save(o)
+}
+
+// Update save the object which must exist, if it has a version field, the stored version is checked
+func (handler *MemoryORM) Update(o interface{}) error {
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+	return handler.store.update(o)
+}
+
+func (handler *MemoryORM) Delete(o interface{}) error {
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+	return handler.store.delete(o)
+}
+
+func (handler *MemoryORM) Get(o interface{}) error {
+	handler.lock.RLock()
+	defer handler.lock.RUnlock()
+	return handler.store.get(o)
+}
+
+// GetBy find the objects which field equals to the value, to is a pointer to a struct which is filled by the first one,
+// or a pointer to a slice which is filled by all of them
+func (handler *MemoryORM) GetBy(field string, value interface{}, t interface{}, to interface{}) (error, orm.Result) {
+	handler.lock.RLock()
+	defer handler.lock.RUnlock()
+	return handler.store.getBy(field, value, t, to)
+}
+
+func (handler *MemoryORM) Count(o interface{}) (int, error) {
+	handler.lock.RLock()
+	defer handler.lock.RUnlock()
+	return handler.store.count(o)
+}
+
+func (handler *MemoryORM) Search(t interface{}, to interface{}, q *orm.Query) (error, orm.Result) {
+	handler.lock.RLock()
+	defer handler.lock.RUnlock()
+	return handler.store.search(t, to, q)
+}
+
+func (handler *MemoryORM) GroupBy(o interface{}, selectField, groupField string, haveQuery string, haveValue interface{}) (error, map[string]interface{}) {
+	handler.lock.RLock()
+	defer handler.lock.RUnlock()
+	return handler.store.groupBy(o, selectField, groupField, haveQuery, haveValue)
+}
+
+func (handler *MemoryORM) Aggregate(t interface{}, q *orm.Query, aggs ...*orm.Aggregation) (map[string]*orm.AggregationResult, error) {
+	handler.lock.RLock()
+	defer handler.lock.RUnlock()
+	return handler.store.aggregate(t, q, aggs)
+}
+
+// Transaction run fn on a copy of the store, which replaces the store when it is committed,
+// the other operations are blocked until the transaction is finished
+func (handler *MemoryORM) Transaction(fn func(tx orm.Tx) error) error {
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+
+	tx := memoryTx{store: handler.store.clone()}
+	return orm.RunInTransaction(tx, fn, func() error {
+		handler.store = tx.store
+		return nil
+	}, func() error {
+		return nil
+	})
+}
+
+func (handler *MemoryORM) BulkSave(objects []interface{}, chunkSize int) ([]orm.BulkResult, error) {
+	return handler.bulk(objects, chunkSize, (*store).save)
+}
+
+func (handler *MemoryORM) BulkUpdate(objects []interface{}, chunkSize int) ([]orm.BulkResult, error) {
+	return handler.bulk(objects, chunkSize, (*store).update)
+}
+
+func (handler *MemoryORM) BulkDelete(objects []interface{}, chunkSize int) ([]orm.BulkResult, error) {
+	return handler.bulk(objects, chunkSize, (*store).delete)
+}
+
+func (handler *MemoryORM) bulk(objects []interface{}, chunkSize int, fn func(s *store, o interface{}) error) ([]orm.BulkResult, error) {
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+	return orm.RunBulk(objects, chunkSize, func(chunk []interface{}) ([]orm.BulkResult, error) {
+		return orm.RunEach(chunk, func(o interface{}) error {
+			return fn(handler.store, o)
+		}), nil
+	})
+}
+
+// memoryTx is the operations on the copy of store in a transaction
+type memoryTx struct {
+	store *store
+}
+
+func (tx memoryTx) Save(o interface{}) error {
+	return tx.store.save(o)
+}
+
+func (tx memoryTx) Update(o interface{}) error {
+	return tx.store.update(o)
+}
+
+func (tx memoryTx) Delete(o interface{}) error {
+	return tx.store.delete(o)
+}
+
+func (tx memoryTx) Get(o interface{}) error {
+	return tx.store.get(o)
+}
+
+func (tx memoryTx) Search(t interface{}, to interface{}, q *orm.Query) (error, orm.Result) {
+	return tx.store.search(t, to, q)
+}
+
+// store is the objects grouped by type, the operations are not locked
+type store struct {
+	tables map[reflect.Type]*table
+}
+
+// table is the JSON encoded objects of a type, keyed by the id
+type table struct {
+	docs map[string][]byte
+	seq  int64
+}
+
+func newStore() *store {
+	return &store{tables: map[reflect.Type]*table{}}
+}
+
+func (s *store) clone() *store {
+	c := newStore()
+	for t, v := range s.tables {
+		docs := make(map[string][]byte, len(v.docs))
+		for id, doc := range v.docs {
+			docs[id] = doc
+		}
+		c.tables[t] = &table{docs: docs, seq: v.seq}
+	}
+	return c
+}
+
+func (s *store) getTable(t reflect.Type) *table {
+	v, ok := s.tables[t]
+	if !ok {
+		v = &table{docs: map[string][]byte{}}
+		s.tables[t] = v
+	}
+	return v
+}
+
+// save the object, a new id is generated if the id of the object is zero value
+func (s *store) save(o interface{}) error {
+	v, err := getStruct(o)
+	if err != nil {
+		return err
+	}
+	idField, err := getIDField(v.Type())
+	if err != nil {
+		return err
+	}
+
+	table := s.getTable(v.Type())
+	id := v.FieldByIndex(idField.Index)
+	if isZero(id) {
+		if !id.CanSet() {
+			return errors.Errorf("id of %v is empty", v.Type())
+		}
+		for {
+			table.seq++
+			if _, ok := table.docs[strconv.FormatInt(table.seq, 10)]; !ok {
+				break
+			}
+		}
+		switch id.Kind() {
+		case reflect.String:
+			id.SetString(strconv.FormatInt(table.seq, 10))
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			id.SetInt(table.seq)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			id.SetUint(uint64(table.seq))
+		default:
+			return errors.Errorf("id of %v is empty", v.Type())
+		}
+	}
+
+	orm.InitVersion(o)
+	doc, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+	table.docs[fmt.Sprint(id.Interface())] = doc
+	return nil
+}
+
+func (s *store) update(o interface{}) error {
+	v, err := getStruct(o)
+	if err != nil {
+		return err
+	}
+	id, err := getID(v)
+	if err != nil {
+		return err
+	}
+	doc, ok := s.getTable(v.Type()).docs[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	_, version, ok := orm.GetVersion(o)
+	if !ok {
+		return s.save(o)
+	}
+
+	stored := reflect.New(v.Type()).Interface()
+	if err := json.Unmarshal(doc, stored); err != nil {
+		return err
+	}
+	_, storedVersion, _ := orm.GetVersion(stored)
+	if storedVersion != version {
+		return &orm.ConflictError{ID: id, Version: version}
+	}
+
+	orm.SetVersion(o, version+1)
+	err = s.save(o)
+	if err != nil {
+		orm.SetVersion(o, version)
+	}
+	return err
+}
+
+func (s *store) delete(o interface{}) error {
+	v, err := getStruct(o)
+	if err != nil {
+		return err
+	}
+	id, err := getID(v)
+	if err != nil {
+		return err
+	}
+	delete(s.getTable(v.Type()).docs, id)
+	return nil
+}
+
+func (s *store) get(o interface{}) error {
+	v, err := getStruct(o)
+	if err != nil {
+		return err
+	}
+	id, err := getID(v)
+	if err != nil {
+		return err
+	}
+	doc, ok := s.getTable(v.Type()).docs[id]
+	if !ok {
+		return ErrNotFound
+	}
+	return json.Unmarshal(doc, o)
+}
+
+func (s *store) count(o interface{}) (int, error) {
+	t, err := getType(o)
+	if err != nil {
+		return 0, err
+	}
+	return len(s.getTable(t).docs), nil
+}
+
+func (s *store) getBy(field string, value interface{}, t interface{}, to interface{}) (error, orm.Result) {
+	rt, err := getType(t)
+	if err != nil {
+		return err, orm.Result{}
+	}
+	docs, err := s.find(rt, orm.And(orm.Eq(field, value)))
+	if err != nil {
+		return err, orm.Result{}
+	}
+
+	if v, err := getStruct(to); err == nil {
+		if len(docs) == 0 {
+			return ErrNotFound, orm.Result{}
+		}
+		if v.Type() != rt {
+			return errors.Errorf("invalid type: %v", v.Type()), orm.Result{}
+		}
+		v.Set(docs[0].Elem())
+		return nil, orm.Result{Total: len(docs), Result: to}
+	}
+
+	if err := setResult(to, docs); err != nil {
+		return err, orm.Result{}
+	}
+	return nil, orm.Result{Total: len(docs), Result: to}
+}
+
+// find return the matched objects of type t, which are pointers to the decoded objects
+func (s *store) find(t reflect.Type, conds []*orm.Cond) ([]reflect.Value, error) {
+	docs := []reflect.Value{}
+	for _, doc := range s.getTable(t).docs {
+		v := reflect.New(t)
+		if err := json.Unmarshal(doc, v.Interface()); err != nil {
+			return nil, err
+		}
+		ok, err := matchConds(v.Elem(), conds)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			docs = append(docs, v)
+		}
+	}
+	return docs, nil
+}
+
+// setResult fill the pointer to slice with the objects, the element of slice is either struct or pointer to struct
+func setResult(to interface{}, docs []reflect.Value) error {
+	v := reflect.ValueOf(to)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice {
+		return errors.Errorf("invalid result type: %v, pointer to slice is required", v.Type())
+	}
+	slice := reflect.MakeSlice(v.Elem().Type(), 0, len(docs))
+	elemType := v.Elem().Type().Elem()
+	for _, doc := range docs {
+		switch {
+		case doc.Type().AssignableTo(elemType):
+			slice = reflect.Append(slice, doc)
+		case doc.Elem().Type().AssignableTo(elemType):
+			slice = reflect.Append(slice, doc.Elem())
+		default:
+			return errors.Errorf("invalid result type: %v", v.Type())
+		}
+	}
+	v.Elem().Set(slice)
+	return nil
+}
+
+// getType return the struct type of object, pointer or slice
+func getType(o interface{}) (reflect.Type, error) {
+	if o == nil {
+		return nil, errors.New("invalid type: nil")
+	}
+	t := reflect.TypeOf(o)
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, errors.Errorf("invalid type: %v", t)
+	}
+	return t, nil
+}
+
+func getStruct(o interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(o)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, errors.Errorf("invalid object: %v, pointer to struct is required", v.Type())
+	}
+	return v.Elem(), nil
+}
+
+func getIDField(t reflect.Type) (reflect.StructField, error) {
+	f, ok := orm.GetIDField(t)
+	if !ok {
+		return reflect.StructField{}, errors.Errorf("id field not found in %v", t)
+	}
+	return f, nil
+}
+
+func getID(v reflect.Value) (string, error) {
+	f, err := getIDField(v.Type())
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint(v.FieldByIndex(f.Index).Interface()), nil
+}
+
+func isZero(v reflect.Value) bool {
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
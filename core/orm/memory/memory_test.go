@@ -0,0 +1,144 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memory
+
+import (
+	"github.com/huminghe/infini-framework/core/orm"
+	"github.com/huminghe/infini-framework/core/orm/ormtest"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestConformance(t *testing.T) {
+	handler := New()
+	ormtest.Suite{Save: handler.Save, Search: handler.Search, Aggregate: handler.Aggregate, Cursor: true,
+		Transaction: handler.Transaction, Update: handler.Update, Get: handler.Get,
+		BulkSave: handler.BulkSave, BulkUpdate: handler.BulkUpdate, BulkDelete: handler.BulkDelete}.Run(t)
+}
+
+type user struct {
+	UserID int    `json:"user_id" gorm:"primary_key"`
+	Name   string `json:"name"`
+	Level  int    `json:"level"`
+}
+
+func TestRegister(t *testing.T) {
+	handler := Register()
+	assert.Nil(t, orm.Save(&user{UserID: 1, Name: "alice"}))
+
+	//the id is generated if it is empty
+	u := user{Name: "bob"}
+	assert.Nil(t, orm.Save(&u))
+	assert.Equal(t, 2, u.UserID)
+
+	stored := user{UserID: 2}
+	assert.Nil(t, orm.Get(&stored))
+	assert.Equal(t, "bob", stored.Name)
+
+	count, err := orm.Count(&user{})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+
+	//reset by the next registration
+	assert.Equal(t, handler, Register())
+	count, err = orm.Count(&user{})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+	assert.Equal(t, ErrNotFound, orm.Get(&user{UserID: 1}))
+}
+
+func TestGetByAndGroupBy(t *testing.T) {
+	handler := New()
+	for _, u := range []user{{1, "alice", 1}, {2, "bob", 2}, {3, "carol", 1}, {4, "dave", 3}, {5, "eve", 1}} {
+		u := u
+		assert.Nil(t, handler.Save(&u))
+	}
+
+	u := user{}
+	err, result := handler.GetBy("name", "carol", &user{}, &u)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, result.Total)
+	assert.Equal(t, 3, u.UserID)
+
+	users := []*user{}
+	err, result = handler.GetBy("level", 1, &user{}, &users)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(users))
+
+	err, _ = handler.GetBy("name", "zoe", &user{}, &u)
+	assert.Equal(t, ErrNotFound, err)
+
+	err, groups := handler.GroupBy(&user{}, "level", "level", "", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"1": 3, "2": 1, "3": 1}, groups)
+
+	err, groups = handler.GroupBy(&user{}, "level", "level", "count(*) > ?", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"1": 3}, groups)
+
+	err, _ = handler.GroupBy(&user{}, "level", "level", "sum(level) > ?", 1)
+	assert.NotNil(t, err)
+
+	assert.Equal(t, ErrNotFound, handler.Update(&user{UserID: 6}))
+	assert.Nil(t, handler.Delete(&user{UserID: 1}))
+	count, _ := handler.Count(&user{})
+	assert.Equal(t, 4, count)
+}
+
+type article struct {
+	ID    string `json:"id"`
+	Title string `json:"title" orm:"searchable"`
+	Body  string `json:"body" orm:"searchable"`
+	Level int    `json:"level"`
+}
+
+func TestFullText(t *testing.T) {
+	handler := New()
+	for _, a := range []article{
+		{"a", "Quick brown fox", "jumps over the lazy dog", 1},
+		{"b", "Lazy afternoon", "the dog sleeps, the dog dreams", 2},
+		{"c", "Fox news", "nothing here", 3},
+		{"d", "Cats", "no match", 4},
+	} {
+		a := a
+		assert.Nil(t, handler.Save(&a))
+	}
+
+	search := func(q *orm.Query) []string {
+		articles := []article{}
+		err, _ := handler.Search(&article{}, &articles, q)
+		assert.Nil(t, err)
+		ids := []string{}
+		for _, a := range articles {
+			ids = append(ids, a.ID)
+		}
+		return ids
+	}
+
+	//ranked by the occurrences of words
+	assert.Equal(t, []string{"b", "a"}, search(&orm.Query{Size: 10, Conds: orm.And(orm.FullText("DOG"))}))
+	assert.Equal(t, []string{"a", "b", "c"}, search(&orm.Query{Size: 10, Conds: orm.And(orm.FullText("fox lazy"))}))
+	assert.Equal(t, []string{"a", "c"}, search(&orm.Query{Size: 10, Conds: orm.And(orm.FullText("fox dog", "title"), orm.Lt("level", 4)),
+		Sort: &[]orm.Sort{{Field: "level", SortType: orm.ASC}}}))
+	assert.Equal(t, []string{"b"}, search(&orm.Query{Size: 10, Conds: orm.And(orm.FullText("fox lazy"), orm.Not(orm.FullText("fox")))}))
+
+	err, _ := handler.Search(&article{}, &[]article{}, &orm.Query{Size: 10, Cursor: "x", Conds: orm.And(orm.FullText("dog"))})
+	assert.NotNil(t, err)
+	assert.Nil(t, handler.Save(&user{Name: "dog"}))
+	err, _ = handler.Search(&user{}, &[]user{}, &orm.Query{Size: 10, Conds: orm.And(orm.FullText("dog"))})
+	assert.NotNil(t, err)
+}
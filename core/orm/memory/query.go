@@ -0,0 +1,564 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/huminghe/infini-framework/core/errors"
+	"github.com/huminghe/infini-framework/core/orm"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// field is a resolved field of the struct, the name of a condition is matched with the field name, json tag or snake case name,
+// and the fields of nested structs are separated by dot
+type field struct {
+	name  string
+	index [][]int
+	typ   reflect.Type
+}
+
+func getField(t reflect.Type, name string) (*field, error) {
+	f := field{name: name, typ: t}
+	for _, part := range strings.Split(name, ".") {
+		for f.typ.Kind() == reflect.Ptr {
+			f.typ = f.typ.Elem()
+		}
+		if f.typ.Kind() != reflect.Struct {
+			return nil, errors.Errorf("field %s not found in %v", name, t)
+		}
+		sf, ok := getStructField(f.typ, part)
+		if !ok {
+			return nil, errors.Errorf("field %s not found in %v", name, t)
+		}
+		f.index = append(f.index, sf.Index)
+		f.typ = sf.Type
+	}
+	return &f, nil
+}
+
+func getStructField(t reflect.Type, name string) (reflect.StructField, bool) {
+	if f, ok := t.FieldByName(name); ok {
+		return f, true
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if tag == name || strings.EqualFold(strings.Replace(name, "_", "", -1), f.Name) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// get return the value of the field, it is nil if the field or a struct on the path is nil
+func (f *field) get(v reflect.Value) interface{} {
+	for _, index := range f.index {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return nil
+			}
+			v = v.Elem()
+		}
+		v = v.FieldByIndex(index)
+	}
+	return v.Interface()
+}
+
+// normalize convert the numbers to float64, and dereference the pointers, nil is returned for the nil values
+func normalize(o interface{}) interface{} {
+	if o == nil {
+		return nil
+	}
+	if n, ok := o.(json.Number); ok {
+		f, err := n.Float64()
+		if err != nil {
+			return n.String()
+		}
+		return f
+	}
+
+	v := reflect.ValueOf(o)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.String:
+		return v.String()
+	case reflect.Slice, reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+	}
+	return v.Interface()
+}
+
+// compare the normalized values, ok is false if they are not comparable,
+// the time is comparable with the string in RFC3339 format
+func compare(a, b interface{}) (int, bool) {
+	switch x := a.(type) {
+	case float64:
+		if y, ok := b.(float64); ok {
+			return compareFloat(x, y), true
+		}
+	case string:
+		switch y := b.(type) {
+		case string:
+			return strings.Compare(x, y), true
+		case time.Time:
+			if t, err := time.Parse(time.RFC3339Nano, x); err == nil {
+				return compareTime(t, y), true
+			}
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			if x == y {
+				return 0, true
+			}
+			if !x {
+				return -1, true
+			}
+			return 1, true
+		}
+	case time.Time:
+		switch y := b.(type) {
+		case time.Time:
+			return compareTime(x, y), true
+		case string:
+			if t, err := time.Parse(time.RFC3339Nano, y); err == nil {
+				return compareTime(x, t), true
+			}
+		}
+	}
+	if a != nil && b != nil && reflect.DeepEqual(a, b) {
+		return 0, true
+	}
+	return 0, false
+}
+
+func compareFloat(x, y float64) int {
+	if x < y {
+		return -1
+	}
+	if x > y {
+		return 1
+	}
+	return 0
+}
+
+func compareTime(x, y time.Time) int {
+	if x.Before(y) {
+		return -1
+	}
+	if x.After(y) {
+		return 1
+	}
+	return 0
+}
+
+// matchConds evaluate the query tree on the object, see the semantic of tree in package orm
+func matchConds(v reflect.Value, conds []*orm.Cond) (bool, error) {
+	matched := true
+	hasShould := false
+	shouldMatched := false
+	for _, c := range conds {
+		ok, err := matchCond(v, c)
+		if err != nil {
+			return false, err
+		}
+		switch c.BoolType {
+		case orm.MustNot:
+			if ok {
+				matched = false
+			}
+		case orm.Should:
+			hasShould = true
+			if ok {
+				shouldMatched = true
+			}
+		default:
+			if !ok {
+				matched = false
+			}
+		}
+	}
+	return matched && (!hasShould || shouldMatched), nil
+}
+
+func matchCond(v reflect.Value, c *orm.Cond) (bool, error) {
+	if c.QueryType == orm.Group {
+		return matchConds(v, c.Conds)
+	}
+	if c.QueryType == orm.FullTextMatch {
+		score, err := getFullTextScore(v, c)
+		return score > 0, err
+	}
+
+	f, err := getField(v.Type(), c.Field)
+	if err != nil {
+		return false, err
+	}
+	value := normalize(f.get(v))
+
+	switch c.QueryType {
+	case orm.Match:
+		r, ok := compare(value, normalize(c.Value))
+		return ok && r == 0, nil
+	case orm.RangeGt:
+		r, ok := compare(value, normalize(c.Value))
+		return ok && r > 0, nil
+	case orm.RangeGte:
+		r, ok := compare(value, normalize(c.Value))
+		return ok && r >= 0, nil
+	case orm.RangeLt:
+		r, ok := compare(value, normalize(c.Value))
+		return ok && r < 0, nil
+	case orm.RangeLte:
+		r, ok := compare(value, normalize(c.Value))
+		return ok && r <= 0, nil
+	case orm.TermsMatch:
+		values := reflect.ValueOf(c.Value)
+		if values.Kind() != reflect.Slice && values.Kind() != reflect.Array {
+			return false, errors.Errorf("invalid values of terms query: %v", c.Value)
+		}
+		for i := 0; i < values.Len(); i++ {
+			if r, ok := compare(value, normalize(values.Index(i).Interface())); ok && r == 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	case orm.PrefixMatch:
+		prefix, ok := c.Value.(string)
+		if !ok {
+			return false, errors.Errorf("invalid prefix: %v", c.Value)
+		}
+		s, ok := value.(string)
+		return ok && strings.HasPrefix(s, prefix), nil
+	case orm.FieldExists:
+		return value != nil, nil
+	}
+	return false, errors.Errorf("invalid query: %v", c.QueryType)
+}
+
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// getFullTextScore return the number of occurrences of the words of text in the fields
+func getFullTextScore(v reflect.Value, c *orm.Cond) (int, error) {
+	text, ok := c.Value.(string)
+	if !ok {
+		return 0, errors.Errorf("invalid text: %v", c.Value)
+	}
+
+	names := c.Fields
+	if len(names) == 0 {
+		for _, f := range orm.GetSearchableFields(v.Interface()) {
+			names = append(names, f.Name)
+		}
+		if len(names) == 0 {
+			return 0, errors.Errorf("no searchable field in %v", v.Type())
+		}
+	}
+
+	words := map[string]bool{}
+	for _, w := range tokenize(text) {
+		words[w] = true
+	}
+
+	score := 0
+	for _, name := range names {
+		f, err := getField(v.Type(), name)
+		if err != nil {
+			return 0, err
+		}
+		value, ok := normalize(f.get(v)).(string)
+		if !ok {
+			continue
+		}
+		for _, w := range tokenize(value) {
+			if words[w] {
+				score++
+			}
+		}
+	}
+	return score, nil
+}
+
+type sortKey struct {
+	field *field
+	desc  bool
+}
+
+// getSortKeys return the fields to sort by, the id is always the last one to make the order unique
+func getSortKeys(t reflect.Type, sorts *[]orm.Sort) ([]sortKey, error) {
+	keys := []sortKey{}
+	if sorts != nil {
+		for _, s := range *sorts {
+			f, err := getField(t, s.Field)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, sortKey{field: f, desc: strings.ToLower(string(s.SortType)) == string(orm.DESC)})
+		}
+	}
+
+	id, err := getIDField(t)
+	if err != nil {
+		return nil, err
+	}
+	keys = append(keys, sortKey{field: &field{name: id.Name, index: [][]int{id.Index}, typ: id.Type}})
+	return keys, nil
+}
+
+// compareKeys compare the sort values, the nil values come first in ascending order
+func compareKeys(keys []sortKey, a, b []interface{}) int {
+	for i, k := range keys {
+		x, y := normalize(a[i]), normalize(b[i])
+		r := 0
+		switch {
+		case x == nil && y == nil:
+		case x == nil:
+			r = -1
+		case y == nil:
+			r = 1
+		default:
+			r, _ = compare(x, y)
+		}
+		if k.desc {
+			r = -r
+		}
+		if r != 0 {
+			return r
+		}
+	}
+	return 0
+}
+
+// search return a page of the matched objects ordered by the sort fields and id,
+// the cursor is the sort values of the last object of the page
+func (s *store) search(t interface{}, to interface{}, q *orm.Query) (error, orm.Result) {
+	rt, err := getType(t)
+	if err != nil {
+		return err, orm.Result{}
+	}
+	docs, err := s.find(rt, q.Conds)
+	if err != nil {
+		return err, orm.Result{}
+	}
+	keys, err := getSortKeys(rt, q.Sort)
+	if err != nil {
+		return err, orm.Result{}
+	}
+
+	//the ranked results are ordered by the score first
+	ranking := orm.GetRankingConds(q)
+	if len(ranking) > 0 && q.Cursor != "" {
+		return errors.New("cursor is not supported by the ranked full text search"), orm.Result{}
+	}
+
+	values := make([][]interface{}, len(docs))
+	for i, doc := range docs {
+		values[i] = make([]interface{}, len(keys))
+		for j, k := range keys {
+			values[i][j] = k.field.get(doc)
+		}
+		if len(ranking) > 0 {
+			score := 0
+			for _, c := range ranking {
+				s, err := getFullTextScore(doc.Elem(), c)
+				if err != nil {
+					return err, orm.Result{}
+				}
+				score += s
+			}
+			values[i] = append([]interface{}{score}, values[i]...)
+		}
+	}
+	if len(ranking) > 0 {
+		keys = append([]sortKey{{field: &field{name: "_score"}, desc: true}}, keys...)
+	}
+	sort.Sort(sortedDocs{keys: keys, docs: docs, values: values})
+
+	from := q.From
+	if from < 0 {
+		from = 0
+	}
+	size := q.Size
+	if size < 0 {
+		size = 10
+	}
+
+	if q.Cursor != "" {
+		after, err := decodeCursor(keys, q.Cursor)
+		if err != nil {
+			return err, orm.Result{}
+		}
+		from = sort.Search(len(docs), func(i int) bool {
+			return compareKeys(keys, values[i], after) > 0
+		})
+	}
+
+	end := from + size
+	if from > len(docs) {
+		from = len(docs)
+	}
+	if end > len(docs) {
+		end = len(docs)
+	}
+
+	if err := setResult(to, docs[from:end]); err != nil {
+		return err, orm.Result{}
+	}
+
+	result := orm.Result{Total: len(docs), Result: to}
+	if size > 0 && end-from == size && len(ranking) == 0 {
+		result.Cursor, err = orm.EncodeCursor(values[end-1])
+	}
+	return err, result
+}
+
+func decodeCursor(keys []sortKey, cursor string) ([]interface{}, error) {
+	values, err := orm.DecodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != len(keys) {
+		return nil, errors.Errorf("cursor doesn't match the sort fields: %s", cursor)
+	}
+	after := make([]interface{}, len(keys))
+	for i, k := range keys {
+		v := reflect.New(k.field.typ)
+		if err := json.Unmarshal(values[i], v.Interface()); err != nil {
+			return nil, errors.Errorf("invalid cursor value of %s: %s", k.field.name, values[i])
+		}
+		after[i] = v.Elem().Interface()
+	}
+	return after, nil
+}
+
+type sortedDocs struct {
+	keys   []sortKey
+	docs   []reflect.Value
+	values [][]interface{}
+}
+
+func (s sortedDocs) Len() int {
+	return len(s.docs)
+}
+
+func (s sortedDocs) Less(i, j int) bool {
+	return compareKeys(s.keys, s.values[i], s.values[j]) < 0
+}
+
+func (s sortedDocs) Swap(i, j int) {
+	s.docs[i], s.docs[j] = s.docs[j], s.docs[i]
+	s.values[i], s.values[j] = s.values[j], s.values[i]
+}
+
+var havingPattern = regexp.MustCompile(`(?i)^\s*count\(\*\)\s*(=|!=|<>|>=|<=|>|<)\s*\?\s*$`)
+
+// groupBy count the objects by the group field, the result is keyed by the value of select field,
+// the having condition only supports the form of `count(*) > ?`
+func (s *store) groupBy(o interface{}, selectField, groupField string, haveQuery string, haveValue interface{}) (error, map[string]interface{}) {
+	result := map[string]interface{}{}
+
+	rt, err := getType(o)
+	if err != nil {
+		return err, result
+	}
+	selected, err := getField(rt, selectField)
+	if err != nil {
+		return err, result
+	}
+	grouped, err := getField(rt, groupField)
+	if err != nil {
+		return err, result
+	}
+
+	op := ""
+	if haveQuery != "" {
+		m := havingPattern.FindStringSubmatch(haveQuery)
+		if m == nil {
+			return errors.Errorf("unsupported having condition: %s", haveQuery), result
+		}
+		op = m[1]
+	}
+
+	docs, err := s.find(rt, nil)
+	if err != nil {
+		return err, result
+	}
+
+	counts := map[string]int{}
+	keys := map[string]string{}
+	for _, doc := range docs {
+		group := fmt.Sprint(normalize(grouped.get(doc)))
+		counts[group]++
+		if _, ok := keys[group]; !ok {
+			keys[group] = fmt.Sprint(normalize(selected.get(doc)))
+		}
+	}
+
+	for group, count := range counts {
+		if op != "" {
+			r, ok := compare(normalize(count), normalize(haveValue))
+			if !ok || !matchOperator(op, r) {
+				continue
+			}
+		}
+		result[keys[group]] = count
+	}
+	return nil, result
+}
+
+func matchOperator(op string, r int) bool {
+	switch op {
+	case "=":
+		return r == 0
+	case "!=", "<>":
+		return r != 0
+	case ">":
+		return r > 0
+	case ">=":
+		return r >= 0
+	case "<":
+		return r < 0
+	case "<=":
+		return r <= 0
+	}
+	return false
+}
@@ -0,0 +1,230 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package orm
+
+import (
+	log "github.com/cihub/seelog"
+	"github.com/huminghe/infini-framework/core/errors"
+	"github.com/huminghe/infini-framework/core/global"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MigrationFunc run a step of migration with the connection of the backend,
+// which is *gorm.DB in a transaction for the database backend and elastic.API for the elastic backend
+type MigrationFunc func(conn interface{}) error
+
+// Migration is a numbered step to change the schema or data of a backend, the migrations are applied in the order of version
+type Migration struct {
+	Version     int64
+	Description string
+	Up          MigrationFunc
+	Down        MigrationFunc
+}
+
+// MigrationRecord is the history of a applied migration
+type MigrationRecord struct {
+	Version     int64     `json:"version"`
+	Description string    `json:"description"`
+	Applied     time.Time `json:"applied"`
+}
+
+// Migrator is implemented by the backends which support migrations
+type Migrator interface {
+	// GetMigrationRecords return the applied migrations
+	GetMigrationRecords() ([]MigrationRecord, error)
+
+	// ApplyMigration run the up or down step of the migration, and add or remove the history record
+	ApplyMigration(m *Migration, up bool) error
+}
+
+const MigrateUp = "up"
+const MigrateDryRun = "dry_run"
+
+var migrationLock sync.RWMutex
+var migrations = map[string]map[int64]*Migration{}
+
+// RegisterMigration register a migration of the backend, the backend is the name of the registered ORM handler, eg: db, elastic
+func RegisterMigration(backend string, m *Migration) {
+	migrationLock.Lock()
+	defer migrationLock.Unlock()
+
+	if m.Version <= 0 || m.Up == nil {
+		panic(errors.Errorf("invalid migration, version and up step are required: %v", m.Version))
+	}
+
+	if migrations[backend] == nil {
+		migrations[backend] = map[int64]*Migration{}
+	}
+	if _, ok := migrations[backend][m.Version]; ok {
+		panic(errors.Errorf("migration %v of %s already exists", m.Version, backend))
+	}
+	migrations[backend][m.Version] = m
+}
+
+// GetMigrations return the registered migrations of the backend in the order of version
+func GetMigrations(backend string) []*Migration {
+	migrationLock.RLock()
+	defer migrationLock.RUnlock()
+
+	result := []*Migration{}
+	for _, m := range migrations[backend] {
+		result = append(result, m)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+	return result
+}
+
+func getMigrator(backend string) (Migrator, error) {
+	h, ok := adapters[backend]
+	if !ok {
+		return nil, errors.Errorf("ORM handler %s is not registered", backend)
+	}
+	m, ok := h.(Migrator)
+	if !ok {
+		return nil, errors.Errorf("ORM handler %s doesn't support migration", backend)
+	}
+	return m, nil
+}
+
+// GetPendingMigrations return the migrations of the backend which are not applied yet
+func GetPendingMigrations(backend string) ([]*Migration, error) {
+	migrator, err := getMigrator(backend)
+	if err != nil {
+		return nil, err
+	}
+	return getPendingMigrations(migrator, GetMigrations(backend))
+}
+
+// Migrate apply the pending migrations of the backend in order, it stops at the first failed step,
+// the applied migrations are returned, or the pending migrations if it is a dry run
+func Migrate(backend string, dryRun bool) ([]*Migration, error) {
+	migrator, err := getMigrator(backend)
+	if err != nil {
+		return nil, err
+	}
+	return migrate(backend, migrator, GetMigrations(backend), dryRun)
+}
+
+// Rollback run the down steps of the last n applied migrations of the backend in reverse order,
+// the rolled back migrations are returned, or the migrations to roll back if it is a dry run
+func Rollback(backend string, n int, dryRun bool) ([]*Migration, error) {
+	migrator, err := getMigrator(backend)
+	if err != nil {
+		return nil, err
+	}
+	return rollback(backend, migrator, GetMigrations(backend), n, dryRun)
+}
+
+// MigrateOnStartup apply the pending migrations of the backend if the app is started with the migrate flag
+func MigrateOnStartup(backend string) error {
+	mode := global.Env().Migrate
+	if mode == "" {
+		return nil
+	}
+	if mode != MigrateUp && mode != MigrateDryRun {
+		return errors.Errorf("invalid migrate mode: %s", mode)
+	}
+	_, err := Migrate(backend, mode == MigrateDryRun)
+	return err
+}
+
+func getPendingMigrations(migrator Migrator, list []*Migration) ([]*Migration, error) {
+	records, err := migrator.GetMigrationRecords()
+	if err != nil {
+		return nil, err
+	}
+	applied := map[int64]bool{}
+	for _, r := range records {
+		applied[r.Version] = true
+	}
+
+	result := []*Migration{}
+	for _, m := range list {
+		if !applied[m.Version] {
+			result = append(result, m)
+		}
+	}
+	return result, nil
+}
+
+func migrate(backend string, migrator Migrator, list []*Migration, dryRun bool) ([]*Migration, error) {
+	pending, err := getPendingMigrations(migrator, list)
+	if err != nil {
+		return nil, err
+	}
+
+	if dryRun {
+		for _, m := range pending {
+			log.Infof("[dry-run] migration %v of %s is pending: %s", m.Version, backend, m.Description)
+		}
+		return pending, nil
+	}
+
+	result := []*Migration{}
+	for _, m := range pending {
+		log.Infof("applying migration %v of %s: %s", m.Version, backend, m.Description)
+		if err := migrator.ApplyMigration(m, true); err != nil {
+			return result, errors.Errorf("failed to apply migration %v of %s: %v", m.Version, backend, err)
+		}
+		result = append(result, m)
+	}
+	return result, nil
+}
+
+func rollback(backend string, migrator Migrator, list []*Migration, n int, dryRun bool) ([]*Migration, error) {
+	records, err := migrator.GetMigrationRecords()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Version > records[j].Version })
+	if n < len(records) {
+		records = records[:n]
+	}
+
+	registered := map[int64]*Migration{}
+	for _, m := range list {
+		registered[m.Version] = m
+	}
+
+	steps := []*Migration{}
+	for _, r := range records {
+		m, ok := registered[r.Version]
+		if !ok || m.Down == nil {
+			return nil, errors.Errorf("migration %v of %s can't be rolled back, down step is missing", r.Version, backend)
+		}
+		steps = append(steps, m)
+	}
+
+	if dryRun {
+		for _, m := range steps {
+			log.Infof("[dry-run] migration %v of %s will be rolled back: %s", m.Version, backend, m.Description)
+		}
+		return steps, nil
+	}
+
+	result := []*Migration{}
+	for _, m := range steps {
+		log.Infof("rolling back migration %v of %s: %s", m.Version, backend, m.Description)
+		if err := migrator.ApplyMigration(m, false); err != nil {
+			return result, errors.Errorf("failed to roll back migration %v of %s: %v", m.Version, backend, err)
+		}
+		result = append(result, m)
+	}
+	return result, nil
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package orm
+
+import (
+	"github.com/huminghe/infini-framework/core/errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type memoryMigrator struct {
+	records []MigrationRecord
+}
+
+func (m *memoryMigrator) GetMigrationRecords() ([]MigrationRecord, error) {
+	return m.records, nil
+}
+
+func (m *memoryMigrator) ApplyMigration(migration *Migration, up bool) error {
+	if up {
+		if err := migration.Up(nil); err != nil {
+			return err
+		}
+		m.records = append(m.records, MigrationRecord{Version: migration.Version})
+		return nil
+	}
+	if err := migration.Down(nil); err != nil {
+		return err
+	}
+	for i, r := range m.records {
+		if r.Version == migration.Version {
+			m.records = append(m.records[:i], m.records[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func TestMigrate(t *testing.T) {
+	steps := []int64{}
+	step := func(v int64, err error) MigrationFunc {
+		return func(conn interface{}) error {
+			if err == nil {
+				steps = append(steps, v)
+			}
+			return err
+		}
+	}
+
+	list := []*Migration{
+		{Version: 1, Up: step(1, nil), Down: step(-1, nil)},
+		{Version: 2, Up: step(2, nil), Down: step(-2, nil)},
+		{Version: 3, Up: step(3, errors.New("failed")), Down: step(-3, nil)},
+	}
+
+	migrator := &memoryMigrator{}
+
+	pending, err := migrate("test", migrator, list, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(pending))
+	assert.Equal(t, 0, len(steps))
+
+	applied, err := migrate("test", migrator, list, false)
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, len(applied))
+	assert.Equal(t, []int64{1, 2}, steps)
+
+	list[2].Up = step(3, nil)
+	applied, err = migrate("test", migrator, list, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(applied))
+	assert.Equal(t, []int64{1, 2, 3}, steps)
+
+	pending, err = getPendingMigrations(migrator, list)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(pending))
+
+	rolled, err := rollback("test", migrator, list, 2, true)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), rolled[0].Version)
+	assert.Equal(t, int64(2), rolled[1].Version)
+	assert.Equal(t, 3, len(migrator.records))
+
+	rolled, err = rollback("test", migrator, list, 2, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(rolled))
+	assert.Equal(t, []int64{1, 2, 3, -3, -2}, steps)
+	assert.Equal(t, []MigrationRecord{{Version: 1}}, migrator.records)
+
+	list[0].Down = nil
+	_, err = rollback("test", migrator, list, 1, false)
+	assert.NotNil(t, err)
+}
+
+func TestRegisterMigration(t *testing.T) {
+	RegisterMigration("test_register", &Migration{Version: 2, Up: func(conn interface{}) error { return nil }})
+	RegisterMigration("test_register", &Migration{Version: 1, Up: func(conn interface{}) error { return nil }})
+
+	list := GetMigrations("test_register")
+	assert.Equal(t, int64(1), list[0].Version)
+	assert.Equal(t, int64(2), list[1].Version)
+
+	assert.Panics(t, func() {
+		RegisterMigration("test_register", &Migration{Version: 1, Up: func(conn interface{}) error { return nil }})
+	})
+	assert.Panics(t, func() {
+		RegisterMigration("test_register", &Migration{Version: 3})
+	})
+}
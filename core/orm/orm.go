@@ -39,6 +39,12 @@ type ORM interface {
 	Count(o interface{}) (int, error)
 
 	GroupBy(o interface{}, selectField, groupField string, haveQuery string, haveValue interface{}) (error, map[string]interface{})
+
+	Aggregate(t interface{}, q *Query, aggs ...*Aggregation) (map[string]*AggregationResult, error)
+
+	Transactional
+
+	Bulk
 }
 
 type Sort struct {
@@ -57,14 +63,23 @@ type Query struct {
 	Size     int
 	Conds    []*Cond
 	RawQuery string
+
+	//Cursor is the Result.Cursor of previous page, From is ignored if it is set
+	Cursor string
 }
 
+// Cond is a node of the query tree, it is either a condition on a field,
+// or a group of conditions when the QueryType is Group, see query.go for the semantic of the tree
 type Cond struct {
 	Field       string
 	SQLOperator string
 	QueryType   QueryType
 	BoolType    BoolType
 	Value       interface{}
+	Conds       []*Cond
+
+	//Fields are the fields of full text condition, which are all the searchable fields if it is empty
+	Fields []string
 }
 
 type BoolType string
@@ -79,6 +94,11 @@ const RangeGt QueryType = "gt"
 const RangeGte QueryType = "gte"
 const RangeLt QueryType = "lt"
 const RangeLte QueryType = "lte"
+const TermsMatch QueryType = "terms"
+const PrefixMatch QueryType = "prefix"
+const FieldExists QueryType = "exists"
+const Group QueryType = "group"
+const FullTextMatch QueryType = "full_text"
 
 func Eq(field string, value interface{}) *Cond {
 	c := Cond{}
@@ -161,6 +181,10 @@ func And(conds ...*Cond) []*Cond {
 type Result struct {
 	Total  int
 	Result interface{}
+
+	//Cursor is used to fetch the next page, it is empty if there is no more results,
+	//or the backend doesn't support cursor
+	Cursor string
 }
 
 func GetBy(field string, value interface{}, t interface{}, to interface{}) (error, Result) {
@@ -172,17 +196,17 @@ func Get(o interface{}) error {
 	return getHandler().Get(o)
 }
 
+// Save the object, the hooks are called around it and the change event is published, see hook.go
 func Save(o interface{}) error {
-
-	return getHandler().Save(o)
+	return change(OpSave, o, getHandler().Save, publishChangeEvent)
 }
 
 func Update(o interface{}) error {
-	return getHandler().Update(o)
+	return change(OpUpdate, o, getHandler().Update, publishChangeEvent)
 }
 
 func Delete(o interface{}) error {
-	return getHandler().Delete(o)
+	return change(OpDelete, o, getHandler().Delete, publishChangeEvent)
 }
 
 func Count(o interface{}) (int, error) {
@@ -197,6 +221,11 @@ func GroupBy(o interface{}, selectField, groupField, haveQuery string, haveValue
 	return getHandler().GroupBy(o, selectField, groupField, haveQuery, haveValue)
 }
 
+// Aggregate compute the aggregations over the documents of type t matched by the query, the query is optional
+func Aggregate(t interface{}, q *Query, aggs ...*Aggregation) (map[string]*AggregationResult, error) {
+	return getHandler().Aggregate(t, q, aggs...)
+}
+
 func RegisterSchema(t interface{}) error {
 	return getHandler().RegisterSchema(t)
 }
@@ -0,0 +1,469 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ormtest provides the conformance suite shared by the ORM backends,
+// every backend should return the same documents for the same query tree.
+package ormtest
+
+import (
+	"encoding/json"
+	"github.com/huminghe/infini-framework/core/errors"
+	"github.com/huminghe/infini-framework/core/orm"
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Doc is the document used by the suite, the column name, json name and struct field of each field resolve to each other
+type Doc struct {
+	ID      string    `json:"id" storm:"id" gorm:"primary_key" elastic_meta:"_id"`
+	Name    string    `json:"name"`
+	Age     int       `json:"age"`
+	Level   int       `json:"level"`
+	Tag     *string   `json:"tag,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// VersionedDoc is the document used by the optimistic concurrency cases
+type VersionedDoc struct {
+	ID      string `json:"id" storm:"id" gorm:"primary_key" elastic_meta:"_id"`
+	Name    string `json:"name"`
+	Version int64  `json:"version" orm:"version"`
+}
+
+// Suite runs the query cases against a backend
+type Suite struct {
+	Save   func(o interface{}) error
+	Search func(t interface{}, to interface{}, q *orm.Query) (error, orm.Result)
+
+	// Update and Get are optional, the optimistic concurrency cases are skipped if they are not set
+	Update func(o interface{}) error
+	Get    func(o interface{}) error
+
+	// Transaction is optional, the transaction cases are skipped if it is not set
+	Transaction func(fn func(tx orm.Tx) error) error
+
+	// Aggregate is optional, the aggregation cases are skipped if it is not set
+	Aggregate func(t interface{}, q *orm.Query, aggs ...*orm.Aggregation) (map[string]*orm.AggregationResult, error)
+
+	// Refresh make the saved documents searchable, optional
+	Refresh func() error
+
+	// BulkSave, BulkUpdate and BulkDelete are optional, the bulk cases are skipped if they are not set
+	BulkSave   func(objects []interface{}, chunkSize int) ([]orm.BulkResult, error)
+	BulkUpdate func(objects []interface{}, chunkSize int) ([]orm.BulkResult, error)
+	BulkDelete func(objects []interface{}, chunkSize int) ([]orm.BulkResult, error)
+
+	// Cursor is set if the backend supports cursor pagination
+	Cursor bool
+}
+
+func tag(v string) *string {
+	return &v
+}
+
+// Docs are saved by the suite before running the cases
+var Docs = []Doc{
+	{ID: "a", Name: "alice", Age: 20, Level: 1, Tag: tag("x"), Created: day(1, 10)},
+	{ID: "b", Name: "bob", Age: 30, Level: 2, Created: day(1, 20)},
+	{ID: "c", Name: "carol", Age: 40, Level: 1, Tag: tag("y"), Created: day(2, 10)},
+	{ID: "d", Name: "dave", Age: 50, Level: 2, Created: day(3, 0)},
+	{ID: "e", Name: "eve", Age: 25, Level: 1, Tag: tag("x"), Created: day(3, 23)},
+}
+
+func day(d, hour int) time.Time {
+	return time.Date(2020, 1, d, hour, 0, 0, 0, time.UTC)
+}
+
+type testCase struct {
+	name   string
+	conds  []*orm.Cond
+	expect []string
+}
+
+var cases = []testCase{
+	{"all", nil, []string{"a", "b", "c", "d", "e"}},
+	{"eq", orm.And(orm.Eq("name", "bob")), []string{"b"}},
+	{"not_eq", orm.And(orm.NotEq("name", "bob")), []string{"a", "c", "d", "e"}},
+	{"gt", orm.And(orm.Gt("age", 30)), []string{"c", "d"}},
+	{"ge", orm.And(orm.Ge("age", 30)), []string{"b", "c", "d"}},
+	{"lt", orm.And(orm.Lt("age", 25)), []string{"a"}},
+	{"le", orm.And(orm.Le("age", 25)), []string{"a", "e"}},
+	{"between", orm.And(orm.Between("age", 25, 40)), []string{"b", "c", "e"}},
+	{"and", orm.And(orm.Gt("age", 20), orm.Lt("age", 50)), []string{"b", "c", "e"}},
+	{"in", orm.And(orm.In("name", "alice", "dave", "zoe")), []string{"a", "d"}},
+	{"in_empty", orm.And(orm.In("name")), []string{}},
+	{"prefix", orm.And(orm.Prefix("name", "ca")), []string{"c"}},
+	{"exists", orm.And(orm.Exists("tag")), []string{"a", "c", "e"}},
+	{"not_exists", orm.And(orm.Not(orm.Exists("tag"))), []string{"b", "d"}},
+	{"or", orm.And(orm.Or(orm.Eq("name", "alice"), orm.Gt("age", 45))), []string{"a", "d"}},
+	{"not_or", orm.And(orm.Not(orm.Or(orm.Eq("name", "alice"), orm.Eq("name", "bob")))), []string{"c", "d", "e"}},
+	{"not_and", orm.And(orm.Not(orm.Ge("age", 30), orm.Exists("tag"))), []string{"a", "b", "d", "e"}},
+	{"or_and", orm.And(orm.Or(orm.Lt("age", 25), orm.Gt("age", 45)), orm.NotEq("name", "dave")), []string{"a"}},
+	{"or_not", orm.And(orm.Or(orm.NotEq("name", "alice"), orm.Eq("name", "alice"))), []string{"a", "b", "c", "d", "e"}},
+	{"or_all", orm.And(orm.Or(orm.All(orm.Ge("age", 30), orm.NotEq("name", "bob")), orm.Eq("name", "alice"))), []string{"a", "c", "d"}},
+	{"nested", orm.And(orm.Or(orm.All(orm.Exists("tag"), orm.Or(orm.Lt("age", 21), orm.Gt("age", 39))), orm.Prefix("name", "bo"))), []string{"a", "b", "c"}},
+}
+
+// Run saves the documents and checks every query case
+func (s Suite) Run(t *testing.T) {
+	for i := range Docs {
+		doc := Docs[i]
+		if err := s.Save(&doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if s.Refresh != nil {
+		if err := s.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			docs := []Doc{}
+			q := orm.Query{From: 0, Size: 100, Conds: c.conds}
+			err, result := s.Search(&Doc{}, &docs, &q)
+			assert.Nil(t, err)
+			ids := getIDs(t, result.Result)
+			assert.Equal(t, c.expect, ids)
+			assert.Equal(t, len(c.expect), result.Total)
+		})
+	}
+
+	if s.Cursor {
+		t.Run("cursor", s.testCursor)
+	}
+
+	if s.Aggregate != nil {
+		t.Run("aggregation", s.testAggregation)
+	}
+
+	if s.Transaction != nil {
+		t.Run("transaction", s.testTransaction)
+	}
+
+	if s.Update != nil && s.Get != nil {
+		t.Run("version", s.testVersion)
+	}
+
+	if s.Transaction != nil && s.Update != nil && s.Get != nil {
+		t.Run("transaction_version", s.testTransactionVersion)
+	}
+
+	if s.BulkSave != nil && s.BulkUpdate != nil && s.BulkDelete != nil {
+		t.Run("bulk", s.testBulk)
+	}
+}
+
+func (s Suite) testBulk(t *testing.T) {
+	search := func() []string {
+		if s.Refresh != nil {
+			assert.Nil(t, s.Refresh())
+		}
+		docs := []Doc{}
+		err, result := s.Search(&Doc{}, &docs, &orm.Query{Size: 10, Conds: orm.And(orm.Prefix("name", "bulk"))})
+		assert.Nil(t, err)
+		return getIDs(t, result.Result)
+	}
+	assertResults := func(ids []string, results []orm.BulkResult, err error) {
+		assert.Nil(t, err)
+		assert.Equal(t, len(ids), len(results))
+		for i, r := range results {
+			assert.Equal(t, ids[i], r.ID)
+			assert.Nil(t, r.Error, ids[i])
+		}
+	}
+
+	docs := []interface{}{
+		&Doc{ID: "x1", Name: "bulk1", Age: 1}, &Doc{ID: "x2", Name: "bulk2", Age: 2}, &Doc{ID: "x3", Name: "bulk3", Age: 3},
+	}
+	results, err := s.BulkSave(docs, 2)
+	assertResults([]string{"x1", "x2", "x3"}, results, err)
+	assert.Equal(t, []string{"x1", "x2", "x3"}, search())
+
+	docs[1].(*Doc).Name = "renamed"
+	results, err = s.BulkUpdate(docs[1:], 0)
+	assertResults([]string{"x2", "x3"}, results, err)
+	assert.Equal(t, []string{"x1", "x3"}, search())
+
+	//the conflict fails the item only
+	versioned := []interface{}{&VersionedDoc{ID: "y1", Name: "first"}, &VersionedDoc{ID: "y2", Name: "first"}}
+	results, err = s.BulkSave(versioned, 0)
+	assertResults([]string{"y1", "y2"}, results, err)
+	assert.Equal(t, int64(1), versioned[0].(*VersionedDoc).Version)
+	stale := &VersionedDoc{ID: "y1", Name: "stale", Version: 0}
+	results, err = s.BulkUpdate([]interface{}{stale, versioned[1]}, 0)
+	assert.Nil(t, err)
+	assert.True(t, orm.IsConflict(results[0].Error))
+	assert.Nil(t, results[1].Error)
+	assert.Equal(t, int64(2), versioned[1].(*VersionedDoc).Version)
+
+	results, err = s.BulkDelete(docs, 2)
+	assertResults([]string{"x1", "x2", "x3"}, results, err)
+	assert.Equal(t, []string{}, search())
+}
+
+func (s Suite) testCursor(t *testing.T) {
+	walk := func(q orm.Query) []string {
+		ids := []string{}
+		for i := 0; i < 10; i++ {
+			docs := []Doc{}
+			err, result := s.Search(&Doc{}, &docs, &q)
+			assert.Nil(t, err)
+			assert.Equal(t, 5, result.Total)
+			ids = append(ids, getOrderedIDs(t, result.Result)...)
+			if result.Cursor == "" {
+				return ids
+			}
+			q.Cursor = result.Cursor
+		}
+		t.Fatal("too many pages")
+		return nil
+	}
+
+	//ties are ordered by id
+	assert.Equal(t, []string{"b", "d", "a", "c", "e"}, walk(orm.Query{Size: 2, Sort: &[]orm.Sort{{Field: "level", SortType: orm.DESC}}}))
+	assert.Equal(t, []string{"a", "e", "b", "c", "d"}, walk(orm.Query{Size: 2, Sort: &[]orm.Sort{{Field: "age", SortType: orm.ASC}}}))
+	assert.Equal(t, []string{"e", "d", "c", "b", "a"}, walk(orm.Query{Size: 3, Sort: &[]orm.Sort{{Field: "created", SortType: orm.DESC}}}))
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, walk(orm.Query{Size: 5}))
+
+	//the offset only applies to the first page
+	assert.Equal(t, []string{"a", "c", "e"}, walk(orm.Query{From: 2, Size: 2, Sort: &[]orm.Sort{{Field: "level", SortType: orm.DESC}}}))
+
+	//the page boundary falls between the ties, the next page continues from the id of the last tie
+	docs := []Doc{}
+	q := orm.Query{Size: 3, Sort: &[]orm.Sort{{Field: "level", SortType: orm.ASC}}}
+	err, result := s.Search(&Doc{}, &docs, &q)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "c", "e"}, getOrderedIDs(t, result.Result))
+	assert.NotEqual(t, "", result.Cursor)
+	q.Cursor = result.Cursor
+	docs = []Doc{}
+	err, result = s.Search(&Doc{}, &docs, &q)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b", "d"}, getOrderedIDs(t, result.Result))
+}
+
+func (s Suite) testVersion(t *testing.T) {
+	doc := VersionedDoc{ID: "v", Name: "first"}
+	assert.Nil(t, s.Save(&doc))
+	assert.Equal(t, int64(1), doc.Version)
+
+	stale := doc
+
+	doc.Name = "second"
+	assert.Nil(t, s.Update(&doc))
+	assert.Equal(t, int64(2), doc.Version)
+
+	stale.Name = "stale"
+	err := s.Update(&stale)
+	assert.True(t, orm.IsConflict(err))
+	assert.Equal(t, int64(1), stale.Version)
+
+	stored := VersionedDoc{ID: "v"}
+	assert.Nil(t, s.Get(&stored))
+	assert.Equal(t, "second", stored.Name)
+	assert.Equal(t, int64(2), stored.Version)
+}
+
+func (s Suite) testTransaction(t *testing.T) {
+	search := func(id string) []string {
+		docs := []Doc{}
+		err, result := s.Search(&Doc{}, &docs, &orm.Query{Size: 10, Conds: orm.And(orm.Eq("id", id))})
+		assert.Nil(t, err)
+		return getIDs(t, result.Result)
+	}
+
+	//committed
+	err := s.Transaction(func(tx orm.Tx) error {
+		if err := tx.Save(&Doc{ID: "f", Name: "frank", Age: 60, Level: 3}); err != nil {
+			return err
+		}
+		doc := Doc{ID: "f"}
+		if err := tx.Get(&doc); err != nil {
+			return err
+		}
+		assert.Equal(t, "frank", doc.Name)
+		return tx.Save(&Doc{ID: "g", Name: "grace", Age: 61, Level: 3})
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"f"}, search("f"))
+	assert.Equal(t, []string{"g"}, search("g"))
+
+	//rolled back by error
+	err = s.Transaction(func(tx orm.Tx) error {
+		if err := tx.Save(&Doc{ID: "h", Name: "heidi", Age: 62, Level: 3}); err != nil {
+			return err
+		}
+		if err := tx.Delete(&Doc{ID: "f"}); err != nil {
+			return err
+		}
+		return errors.New("failed")
+	})
+	assert.Equal(t, "failed", err.Error())
+	assert.Equal(t, []string{}, search("h"))
+	assert.Equal(t, []string{"f"}, search("f"))
+
+	//rolled back by panic
+	assert.Panics(t, func() {
+		s.Transaction(func(tx orm.Tx) error {
+			tx.Save(&Doc{ID: "i", Name: "ivan", Age: 63, Level: 3})
+			panic("oops")
+		})
+	})
+	assert.Equal(t, []string{}, search("i"))
+}
+
+func (s Suite) testTransactionVersion(t *testing.T) {
+	doc := VersionedDoc{ID: "tv", Name: "first"}
+	assert.Nil(t, s.Save(&doc))
+
+	//the versioned update joins the transaction
+	err := s.Transaction(func(tx orm.Tx) error {
+		doc.Name = "second"
+		return tx.Update(&doc)
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), doc.Version)
+
+	//the conflict rolls back the transaction
+	stale := VersionedDoc{ID: "tv", Name: "stale", Version: 1}
+	err = s.Transaction(func(tx orm.Tx) error {
+		if err := tx.Save(&Doc{ID: "j", Name: "judy", Age: 64, Level: 3}); err != nil {
+			return err
+		}
+		return tx.Update(&stale)
+	})
+	assert.True(t, orm.IsConflict(err))
+
+	stored := VersionedDoc{ID: "tv"}
+	assert.Nil(t, s.Get(&stored))
+	assert.Equal(t, "second", stored.Name)
+	assert.Equal(t, int64(2), stored.Version)
+
+	docs := []Doc{}
+	err, result := s.Search(&Doc{}, &docs, &orm.Query{Size: 10, Conds: orm.And(orm.Eq("id", "j"))})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{}, getIDs(t, result.Result))
+}
+
+func (s Suite) testAggregation(t *testing.T) {
+	result, err := s.Aggregate(&Doc{}, nil,
+		orm.Min("min_age", "age"), orm.Max("max_age", "age"), orm.Avg("avg_age", "age"),
+		orm.Sum("sum_age", "age"), orm.Cardinality("levels", "level"),
+		orm.Terms("by_level", "level", 10).SubAggregation(orm.Avg("avg_age", "age"), orm.Histogram("by_age", "age", 20)),
+		orm.Histogram("by_age", "age", 20),
+		orm.DateHistogram("by_day", "created", 24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertValue(t, 20, result["min_age"])
+	assertValue(t, 50, result["max_age"])
+	assertValue(t, 33, result["avg_age"])
+	assertValue(t, 165, result["sum_age"])
+	assertValue(t, 2, result["levels"])
+
+	byLevel := result["by_level"]
+	assert.Equal(t, []interface{}{1.0, 2.0}, getKeys(byLevel))
+	assert.Equal(t, []int{3, 2}, getCounts(byLevel))
+	assertValue(t, 85.0/3, byLevel.Buckets[0].Aggregations["avg_age"])
+	assertValue(t, 40, byLevel.Buckets[1].Aggregations["avg_age"])
+	assert.Equal(t, []interface{}{20.0, 40.0}, getKeys(byLevel.Buckets[0].Aggregations["by_age"]))
+	assert.Equal(t, []int{2, 1}, getCounts(byLevel.Buckets[0].Aggregations["by_age"]))
+	assert.Equal(t, []interface{}{20.0, 40.0}, getKeys(byLevel.Buckets[1].Aggregations["by_age"]))
+	assert.Equal(t, []int{1, 1}, getCounts(byLevel.Buckets[1].Aggregations["by_age"]))
+
+	assert.Equal(t, []interface{}{20.0, 40.0}, getKeys(result["by_age"]))
+	assert.Equal(t, []int{3, 2}, getCounts(result["by_age"]))
+
+	assert.Equal(t, []interface{}{day(1, 0), day(2, 0), day(3, 0)}, getKeys(result["by_day"]))
+	assert.Equal(t, []int{2, 1, 2}, getCounts(result["by_day"]))
+
+	//filtered by query, ties are ordered by key
+	result, err = s.Aggregate(&Doc{}, &orm.Query{Conds: orm.And(orm.Gt("age", 20))},
+		orm.Terms("by_level", "level", 10), orm.Avg("avg_age", "age"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []interface{}{1.0, 2.0}, getKeys(result["by_level"]))
+	assert.Equal(t, []int{2, 2}, getCounts(result["by_level"]))
+	assertValue(t, 36.25, result["avg_age"])
+
+	//no document matched
+	result, err = s.Aggregate(&Doc{}, &orm.Query{Conds: orm.And(orm.Gt("age", 100))},
+		orm.Terms("by_level", "level", 10), orm.Avg("avg_age", "age"), orm.Sum("sum_age", "age"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, len(result["by_level"].Buckets))
+	assert.Nil(t, result["avg_age"].Value)
+	assertValue(t, 0, result["sum_age"])
+}
+
+func assertValue(t *testing.T, expect float64, result *orm.AggregationResult) {
+	if assert.NotNil(t, result) && assert.NotNil(t, result.Value) {
+		assert.InDelta(t, expect, *result.Value, 0.0001)
+	}
+}
+
+func getKeys(result *orm.AggregationResult) []interface{} {
+	keys := []interface{}{}
+	if result != nil {
+		for _, b := range result.Buckets {
+			keys = append(keys, b.Key)
+		}
+	}
+	return keys
+}
+
+func getCounts(result *orm.AggregationResult) []int {
+	counts := []int{}
+	if result != nil {
+		for _, b := range result.Buckets {
+			counts = append(counts, b.DocCount)
+		}
+	}
+	return counts
+}
+
+// getIDs extract the sorted document ids from the search result, which is either the typed slice or a list of raw documents
+func getIDs(t *testing.T, result interface{}) []string {
+	ids := getOrderedIDs(t, result)
+	sort.Strings(ids)
+	return ids
+}
+
+// getOrderedIDs return the ids in the order of the search result
+func getOrderedIDs(t *testing.T, result interface{}) []string {
+	data, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := []Doc{}
+	if err := json.Unmarshal(data, &docs); err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, doc := range docs {
+		ids = append(ids, doc.ID)
+	}
+	return ids
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package orm
+
+// The conditions of a query are organized as a tree, a list of conditions, either the Query.Conds or the children of a group, is evaluated as:
+//   all the conditions with BoolType Must are matched,
+//   none of the conditions with BoolType MustNot is matched,
+//   and at least one of the conditions with BoolType Should is matched, if there is any.
+// The leaf conditions are evaluated by the QueryType:
+//   Match: the field equals to the value
+//   RangeGt, RangeGte, RangeLt, RangeLte: the field compares with the value
+//   TermsMatch: the field equals to one of the values
+//   PrefixMatch: the string field starts with the value
+//   FieldExists: the field is not null
+//   FullTextMatch: any word of the text is found in the fields, the fields are tagged by `orm:"searchable"`,
+//     and the results are ordered by relevance if the query has no sort, see FullText
+
+// In matches the documents which field equals to any of the values
+func In(field string, values ...interface{}) *Cond {
+	c := Cond{}
+	c.Field = field
+	c.Value = values
+	c.QueryType = TermsMatch
+	c.BoolType = Must
+	return &c
+}
+
+// Prefix matches the documents which string field starts with the prefix
+func Prefix(field string, prefix string) *Cond {
+	c := Cond{}
+	c.Field = field
+	c.Value = prefix
+	c.QueryType = PrefixMatch
+	c.BoolType = Must
+	return &c
+}
+
+// Exists matches the documents which field is not null
+func Exists(field string) *Cond {
+	c := Cond{}
+	c.Field = field
+	c.QueryType = FieldExists
+	c.BoolType = Must
+	return &c
+}
+
+// FullText matches the documents which contain any word of the text in the fields, or in any searchable field if no field is specified,
+// the results of a query with full text conditions and without sort are ranked by relevance,
+// only the elastic backend supports cursor pagination for the ranked results
+func FullText(text string, fields ...string) *Cond {
+	c := Cond{}
+	c.Fields = fields
+	c.Value = text
+	c.QueryType = FullTextMatch
+	c.BoolType = Must
+	return &c
+}
+
+// GetRankingConds return the full text conditions which rank the results, they are the top level conditions not excluded by MustNot,
+// the results are ranked only if the query has no sort
+func GetRankingConds(q *Query) []*Cond {
+	conds := []*Cond{}
+	if q.Sort != nil && len(*q.Sort) > 0 {
+		return conds
+	}
+	for _, c := range q.Conds {
+		if c.QueryType == FullTextMatch && c.BoolType != MustNot {
+			conds = append(conds, c)
+		}
+	}
+	return conds
+}
+
+// Between matches the documents which field is in the range of [from, to]
+func Between(field string, from, to interface{}) *Cond {
+	return All(Ge(field, from), Le(field, to))
+}
+
+// All is a nested group which matches when all of the conditions matched,
+// the conditions keep their own BoolType, so All(Eq(a), NotEq(b)) means a and not b
+func All(conds ...*Cond) *Cond {
+	c := Cond{}
+	c.QueryType = Group
+	c.BoolType = Must
+	c.Conds = conds
+	return &c
+}
+
+// Or is a nested group which matches when any of the conditions matched
+func Or(conds ...*Cond) *Cond {
+	c := Cond{}
+	c.QueryType = Group
+	c.BoolType = Must
+	for _, v := range conds {
+		c.Conds = append(c.Conds, occur(v, Should))
+	}
+	return &c
+}
+
+// Not matches when the conditions are not all matched, Not(a) is the negation of a, and Not(a, b) means not (a and b)
+func Not(conds ...*Cond) *Cond {
+	if len(conds) == 1 {
+		return occur(conds[0], MustNot)
+	}
+	return occur(All(conds...), MustNot)
+}
+
+// occur return a condition with the required BoolType and the same meaning,
+// a condition is wrapped into a group if its BoolType can't be replaced directly
+func occur(c *Cond, t BoolType) *Cond {
+	if c.BoolType == t {
+		return c
+	}
+	if c.BoolType == Must || c.BoolType == "" {
+		v := *c
+		v.BoolType = t
+		return &v
+	}
+	g := Cond{}
+	g.QueryType = Group
+	g.BoolType = t
+	g.Conds = []*Cond{c}
+	return &g
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package orm
+
+import (
+	"reflect"
+	"strings"
+)
+
+// HasTagOption return true if the option is in the `orm` tag of the field, the options are separated by comma, e.g. `orm:"searchable"`
+func HasTagOption(f reflect.StructField, option string) bool {
+	for _, v := range strings.Split(f.Tag.Get("orm"), ",") {
+		if strings.TrimSpace(v) == option {
+			return true
+		}
+	}
+	return false
+}
+
+// GetSearchableFields return the fields tagged by `orm:"searchable"`, which are used by the full text conditions
+func GetSearchableFields(t interface{}) []reflect.StructField {
+	rt := reflect.TypeOf(t)
+	for rt.Kind() == reflect.Ptr || rt.Kind() == reflect.Slice {
+		rt = rt.Elem()
+	}
+	fields := []reflect.StructField{}
+	if rt.Kind() != reflect.Struct {
+		return fields
+	}
+	for i := 0; i < rt.NumField(); i++ {
+		if HasTagOption(rt.Field(i), "searchable") {
+			fields = append(fields, rt.Field(i))
+		}
+	}
+	return fields
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package orm
+
+import (
+	log "github.com/cihub/seelog"
+	"github.com/huminghe/infini-framework/core/errors"
+)
+
+// Tx is the operations which are committed or rolled back together
+type Tx interface {
+	Save(o interface{}) error
+
+	Update(o interface{}) error
+
+	Delete(o interface{}) error
+
+	Get(o interface{}) error
+
+	Search(t interface{}, to interface{}, q *Query) (error, Result)
+}
+
+// Transactional is implemented by the backends which support transaction
+type Transactional interface {
+	Transaction(fn func(tx Tx) error) error
+}
+
+// ErrTransactionNotSupported is returned by the backends which can't run operations in a transaction
+var ErrTransactionNotSupported = errors.New("transaction is not supported by the ORM handler")
+
+// Transaction run fn in a transaction of the registered handler,
+// it is committed if fn returns nil, and rolled back if fn returns error or panics,
+// the hooks are called around the changes in transaction, and the change events are published after it is committed
+func Transaction(fn func(tx Tx) error) error {
+	var events []*ChangeEvent
+	err := getHandler().Transaction(func(tx Tx) error {
+		h := &hookTx{Tx: tx}
+		err := fn(h)
+		events = h.events
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		publishChangeEvent(event)
+	}
+	return nil
+}
+
+// RunInTransaction is the helper for backends to commit or roll back the transaction by the result of fn,
+// the panic of fn is propagated after the rollback
+func RunInTransaction(tx Tx, fn func(tx Tx) error, commit func() error, rollback func() error) error {
+	done := false
+	defer func() {
+		if !done {
+			if err := rollback(); err != nil {
+				log.Error("failed to rollback transaction: ", err)
+			}
+		}
+	}()
+
+	err := fn(tx)
+	done = true
+	if err != nil {
+		if e := rollback(); e != nil {
+			log.Error("failed to rollback transaction: ", e)
+		}
+		return err
+	}
+	return commit()
+}
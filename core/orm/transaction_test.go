@@ -0,0 +1,52 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package orm
+
+import (
+	"github.com/huminghe/infini-framework/core/errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRunInTransaction(t *testing.T) {
+	committed := 0
+	rolledBack := 0
+	commit := func() error {
+		committed++
+		return nil
+	}
+	rollback := func() error {
+		rolledBack++
+		return nil
+	}
+
+	err := RunInTransaction(nil, func(tx Tx) error { return nil }, commit, rollback)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, committed)
+	assert.Equal(t, 0, rolledBack)
+
+	err = RunInTransaction(nil, func(tx Tx) error { return errors.New("failed") }, commit, rollback)
+	assert.Equal(t, "failed", err.Error())
+	assert.Equal(t, 1, committed)
+	assert.Equal(t, 1, rolledBack)
+
+	assert.PanicsWithValue(t, "oops", func() {
+		RunInTransaction(nil, func(tx Tx) error { panic("oops") }, commit, rollback)
+	})
+	assert.Equal(t, 1, committed)
+	assert.Equal(t, 2, rolledBack)
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package orm
+
+import (
+	"fmt"
+	"github.com/huminghe/infini-framework/core/errors"
+	"reflect"
+)
+
+// The optional version field of a object is tagged by `orm:"version"`, it is a integer field,
+// which is increased by every Update, and the Update fails with ConflictError if the version is changed by others
+
+// ConflictError is returned by Update when the stored version doesn't match the version of the object
+type ConflictError struct {
+	ID      string
+	Version int64
+}
+
+func (err *ConflictError) Error() string {
+	return fmt.Sprintf("version conflict, %v was changed since version %v", err.ID, err.Version)
+}
+
+// IsConflict return true if the error is caused by version conflict, the error may be wrapped
+func IsConflict(err error) bool {
+	_, ok := errors.Cause(err).(*ConflictError)
+	return ok
+}
+
+func getVersionField(o interface{}) (reflect.Value, string, bool) {
+	v := reflect.ValueOf(o)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}, "", false
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}, "", false
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if !HasTagOption(t.Field(i), "version") {
+			continue
+		}
+		switch t.Field(i).Type.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return v.Field(i), t.Field(i).Name, true
+		}
+	}
+	return reflect.Value{}, "", false
+}
+
+// GetVersion return the name and value of the version field, ok is false if there is no version field
+func GetVersion(o interface{}) (field string, version int64, ok bool) {
+	f, name, ok := getVersionField(o)
+	if !ok {
+		return "", 0, false
+	}
+	return name, f.Int(), true
+}
+
+// SetVersion set the version field of the object, o must be a pointer
+func SetVersion(o interface{}, version int64) bool {
+	f, _, ok := getVersionField(o)
+	if !ok || !f.CanSet() {
+		return false
+	}
+	f.SetInt(version)
+	return true
+}
+
+// InitVersion set the version to 1 if the object has a unset version field
+func InitVersion(o interface{}) {
+	if _, v, ok := GetVersion(o); ok && v == 0 {
+		SetVersion(o, 1)
+	}
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package orm
+
+import (
+	"github.com/huminghe/infini-framework/core/errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type versioned struct {
+	ID      string
+	Version int `orm:"version"`
+}
+
+func TestVersion(t *testing.T) {
+	o := versioned{ID: "1"}
+	field, v, ok := GetVersion(&o)
+	assert.True(t, ok)
+	assert.Equal(t, "Version", field)
+	assert.Equal(t, int64(0), v)
+
+	InitVersion(&o)
+	assert.Equal(t, 1, o.Version)
+	InitVersion(&o)
+	assert.Equal(t, 1, o.Version)
+
+	assert.True(t, SetVersion(&o, 5))
+	assert.Equal(t, 5, o.Version)
+
+	//not addressable
+	assert.False(t, SetVersion(o, 6))
+
+	_, _, ok = GetVersion(&Sort{})
+	assert.False(t, ok)
+
+	assert.True(t, IsConflict(&ConflictError{ID: "1", Version: 5}))
+	assert.True(t, IsConflict(errors.Wrap(&ConflictError{ID: "1", Version: 5}, "update user")))
+	assert.False(t, IsConflict(errors.New("not found")))
+	assert.False(t, IsConflict(nil))
+}
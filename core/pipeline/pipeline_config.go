@@ -40,6 +40,7 @@ type PipelineConfig struct {
 	Created        time.Time          `json:"created,omitempty"`
 	Updated        time.Time          `json:"updated,omitempty"`
 	Tags           []string           `json:"tags,omitempty" config:"tags"`
+	Version        int64              `json:"version,omitempty" orm:"version"`
 }
 
 var m map[string]PipelineConfig
@@ -129,6 +129,33 @@ func FilePutContentWithByte(file string, content []byte) (int, error) {
 	return fs.Write(content)
 }
 
+// FilePutContentAtomic write bytes to a temp file in the same folder, sync it and then rename it to the target file,
+// the target file is either the previous one or the new one, never a partial one,
+// the temp file has a unique name, so concurrent writers never truncate each other's temp file
+func FilePutContentAtomic(file string, content []byte) (int, error) {
+	fs, e := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*.tmp")
+	if e != nil {
+		return 0, e
+	}
+	tmp := fs.Name()
+
+	n, e := fs.Write(content)
+	if e == nil {
+		e = fs.Sync()
+	}
+	if e1 := fs.Close(); e == nil {
+		e = e1
+	}
+	if e == nil {
+		e = os.Rename(tmp, file)
+	}
+	if e != nil {
+		os.Remove(tmp)
+		return 0, e
+	}
+	return n, nil
+}
+
 // FileAppendContentWithByte append bytes to the end of the file
 func FileAppendContentWithByte(file string, content []byte) (int, error) {
 
@@ -17,9 +17,12 @@ limitations under the License.
 package util
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
 	"path"
+	"sync"
 	"testing"
 )
 
@@ -40,3 +43,41 @@ func TestJoinPath2(t *testing.T) {
 	fmt.Println(str)
 	assert.Equal(t, "wwww.baidu.com/blog/comments/1.html", str)
 }
+
+func TestFilePutContentAtomic(t *testing.T) {
+	dir := t.TempDir()
+	file := path.Join(dir, "fsutils_atomic_test")
+
+	n, err := FilePutContentAtomic(file, []byte("hello"))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+
+	n, err = FilePutContentAtomic(file, []byte("world!"))
+	assert.Nil(t, err)
+	assert.Equal(t, 6, n)
+
+	b, _ := FileGetContent(file)
+	assert.Equal(t, "world!", string(b))
+
+	//concurrent writers never corrupt each other, the file is one of the complete contents
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := FilePutContentAtomic(file, bytes.Repeat([]byte{byte('a' + i)}, 4096))
+			assert.Nil(t, err)
+		}(i)
+	}
+	wg.Wait()
+	b, _ = FileGetContent(file)
+	assert.Equal(t, 4096, len(b))
+	assert.Equal(t, bytes.Repeat(b[:1], 4096), b)
+
+	//no temp file is left
+	files, _ := ioutil.ReadDir(dir)
+	assert.Equal(t, 1, len(files))
+
+	_, err = FilePutContentAtomic(path.Join(dir, "not_exists_folder", "fsutils_atomic_test"), []byte("hello"))
+	assert.NotNil(t, err)
+}
@@ -17,16 +17,18 @@ limitations under the License.
 package boltdb
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/asdine/storm"
+	"github.com/asdine/storm/codec"
 	"github.com/asdine/storm/codec/protobuf"
 	"github.com/asdine/storm/q"
 	lz4 "github.com/bkaradzic/go-lz4"
 	"github.com/boltdb/bolt"
 	log "github.com/cihub/seelog"
-	"github.com/emirpasic/gods/sets/hashset"
 	"github.com/huminghe/infini-framework/core/api"
 	"github.com/huminghe/infini-framework/core/global"
+	"github.com/huminghe/infini-framework/core/kv"
 	"github.com/huminghe/infini-framework/core/orm"
 	core "github.com/huminghe/infini-framework/core/ui"
 	"github.com/huminghe/infini-framework/core/util"
@@ -41,36 +43,34 @@ import (
 
 type BoltdbStore struct {
 	FileName string
+	//Codec used to encode the objects, default is protobuf
+	Codec codec.MarshalUnmarshaler
 	api.Handler
 }
 
 var db *storm.DB
 
-var buckets hashset.Set
+// buckets are the buckets known to exist, which are created on the first write
+var buckets = map[string]bool{}
 var initLocker sync.Mutex
 
 func initBucket(name string) error {
-	if buckets.Contains(name) {
-		return nil
-	}
-
 	initLocker.Lock()
 	defer initLocker.Unlock()
 
-	//double check after lock in
-	if buckets.Contains(name) {
+	if buckets[name] {
 		return nil
 	}
 
-	db.Bolt.Update(func(tx *bolt.Tx) error {
+	err := db.Bolt.Update(func(tx *bolt.Tx) error {
 		_, err := tx.CreateBucketIfNotExists([]byte(name))
-		if err != nil {
-			log.Error("create bucket: ", err, ",", name)
-			panic(err)
-		}
-		return nil
+		return err
 	})
-
+	if err != nil {
+		log.Error("create bucket: ", err, ",", name)
+		return err
+	}
+	buckets[name] = true
 	return nil
 }
 
@@ -81,8 +81,17 @@ func (store BoltdbStore) Open() error {
 		log.Debug("found boltdb file, start reload,", store.FileName)
 	}
 
+	c := store.Codec
+	if c == nil {
+		c = protobuf.Codec
+	}
+
+	initLocker.Lock()
+	buckets = map[string]bool{}
+	initLocker.Unlock()
+
 	var err error
-	db, err = storm.Open(store.FileName, storm.BoltOptions(0600, &bolt.Options{Timeout: 5 * time.Second}), storm.Codec(protobuf.Codec))
+	db, err = storm.Open(store.FileName, storm.BoltOptions(0600, &bolt.Options{Timeout: 5 * time.Second}), storm.Codec(c))
 
 	if err != nil {
 		log.Errorf("error open boltdb: %s, %s", store.FileName, err)
@@ -121,20 +130,22 @@ func (store BoltdbStore) GetCompressedValue(bucket string, key []byte) ([]byte,
 	return data, nil
 }
 
+// GetValue return the value of key, or nil if the key or the bucket doesn't exist, the missing bucket is not created
 func (store BoltdbStore) GetValue(bucket string, key []byte) ([]byte, error) {
-
-	initBucket(bucket)
-
-	var ret []byte = nil
-	db.Bolt.View(func(tx *bolt.Tx) error {
+	var ret []byte
+	err := db.Bolt.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(bucket))
-		v := b.Get(key)
-		if v != nil {
-			ret = v
+		if b == nil {
+			return nil
+		}
+		//the value is only valid inside the transaction, make a copy
+		if v := b.Get(key); v != nil {
+			ret = make([]byte, len(v))
+			copy(ret, v)
 		}
 		return nil
 	})
-	return ret, nil
+	return ret, err
 }
 
 func (store BoltdbStore) AddValueCompress(bucket string, key []byte, value []byte) error {
@@ -147,32 +158,86 @@ func (store BoltdbStore) AddValueCompress(bucket string, key []byte, value []byt
 }
 
 func (store BoltdbStore) AddValue(bucket string, key []byte, value []byte) error {
+	err := initBucket(bucket)
+	if err != nil {
+		return err
+	}
 
-	initBucket(bucket)
-
-	db.Bolt.Update(func(tx *bolt.Tx) error {
+	return db.Bolt.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(bucket))
-		err := b.Put(key, value)
-		return err
+		if b == nil {
+			return kv.ErrBucketNotFound
+		}
+		return b.Put(key, value)
 	})
-	return nil
 }
 
+// DeleteKey delete the key, kv.ErrBucketNotFound is returned if the bucket doesn't exist
 func (store BoltdbStore) DeleteKey(bucket string, key []byte) error {
-	db.Bolt.Update(func(tx *bolt.Tx) error {
+	return db.Bolt.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(bucket))
-		err := b.Delete(key)
-		return err
+		if b == nil {
+			return kv.ErrBucketNotFound
+		}
+		return b.Delete(key)
 	})
-	return nil
 }
 
+// DeleteBucket delete the bucket and all its keys, kv.ErrBucketNotFound is returned if the bucket doesn't exist
 func (store BoltdbStore) DeleteBucket(bucket string) error {
-	db.Bolt.Update(func(tx *bolt.Tx) error {
-		err := tx.DeleteBucket([]byte(bucket))
-		return err
+	initLocker.Lock()
+	defer initLocker.Unlock()
+
+	delete(buckets, bucket)
+	err := db.Bolt.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte(bucket))
+	})
+	if err == bolt.ErrBucketNotFound {
+		return kv.ErrBucketNotFound
+	}
+	return err
+}
+
+func (store BoltdbStore) ListBuckets() ([]string, error) {
+	result := []string{}
+	err := db.Bolt.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			result = append(result, string(name))
+			return nil
+		})
+	})
+	return result, err
+}
+
+func (store BoltdbStore) Scan(bucket string, prefix []byte, from []byte, size int, walkFunc func(key, value []byte) bool) error {
+	return db.Bolt.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+
+		start := prefix
+		if bytes.Compare(from, prefix) > 0 {
+			start = from
+		}
+
+		c := b.Cursor()
+		count := 0
+		for k, v := c.Seek(start); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			//skip nested buckets
+			if v == nil {
+				continue
+			}
+			if size > 0 && count >= size {
+				break
+			}
+			count++
+			if !walkFunc(k, v) {
+				break
+			}
+		}
+		return nil
 	})
-	return nil
 }
 
 func (store BoltdbStore) boltDBStatusAction(w http.ResponseWriter, r *http.Request) {
@@ -217,11 +282,27 @@ func (store BoltdbStore) Get(key string, value interface{}, to interface{}) erro
 }
 
 func (store BoltdbStore) Save(o interface{}) error {
+	orm.InitVersion(o)
 	return db.Save(o)
 }
 
+// Update the object, the objects with version field are checked and saved in a bolt transaction
 func (store BoltdbStore) Update(o interface{}) error {
-	return db.Update(o)
+	if _, _, ok := orm.GetVersion(o); !ok {
+		return db.Update(o)
+	}
+
+	node, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer node.Rollback()
+
+	err = update(node, o)
+	if err != nil {
+		return err
+	}
+	return node.Commit()
 }
 
 func (store BoltdbStore) Delete(o interface{}) error {
@@ -233,13 +314,16 @@ func (store BoltdbStore) Count(o interface{}) (int, error) {
 }
 
 func (s BoltdbStore) Search(t1, t2 interface{}, q1 *orm.Query) (error, orm.Result) {
+	return search(db, t1, t2, q1)
+}
+
+// selector is the storm db or a node in transaction
+type selector interface {
+	Select(matchers ...q.Matcher) storm.Query
+}
+
+func search(node selector, t1, t2 interface{}, q1 *orm.Query) (error, orm.Result) {
 	result := orm.Result{}
-	total, err := s.Count(t1)
-	if err != nil {
-		log.Debug(err)
-		total = -1
-	}
-	result.Total = total
 	result.Result = t2
 
 	if q1.From < 0 {
@@ -249,15 +333,23 @@ func (s BoltdbStore) Search(t1, t2 interface{}, q1 *orm.Query) (error, orm.Resul
 		q1.Size = 10
 	}
 
-	var q2 storm.Query
-	if q1.Conds != nil {
-
-		//TODO
-		//q2 = db.Select(q.Eq(q1.Conds.Field, q1.Filter.Value)) //can't limit here, bug .Limit(q1.Size).Skip(q1.From)
+	var matcher q.Matcher = q.True()
+	if len(q1.Conds) > 0 {
+		var err error
+		matcher, err = getMatcher(t1, q1.Conds)
+		if err != nil {
+			return err, result
+		}
+	}
 
-	} else {
-		q2 = db.Select(q.True()).Limit(q1.Size).Skip(q1.From)
+	total, err := node.Select(matcher).Count(t1)
+	if err != nil {
+		log.Debug(err)
+		total = -1
 	}
+	result.Total = total
+
+	q2 := node.Select(matcher).Limit(q1.Size).Skip(q1.From)
 
 	if q1.Sort != nil && len(*q1.Sort) > 0 {
 		for _, i := range *q1.Sort {
@@ -268,6 +360,9 @@ func (s BoltdbStore) Search(t1, t2 interface{}, q1 *orm.Query) (error, orm.Resul
 	//t, _ := time.Parse(layout, skipDate)
 	//query := db.Select(q.Gt("CreateTime", t)).Limit(size).Skip(from).Reverse().OrderBy("CreateTime")
 	err = q2.Find(t2)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
 	if err != nil {
 		log.Trace(err)
 	}
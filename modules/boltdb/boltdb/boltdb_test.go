@@ -0,0 +1,68 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package boltdb
+
+import (
+	"github.com/huminghe/infini-framework/core/env"
+	"github.com/huminghe/infini-framework/core/global"
+	"github.com/huminghe/infini-framework/core/util"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	global.RegisterEnv(env.EmptyEnv())
+
+	file := path.Join(os.TempDir(), "bolt_"+util.PickRandomName()+".db")
+	defer os.RemoveAll(file)
+
+	store := BoltdbStore{FileName: file}
+	assert.Nil(t, store.Open())
+	defer store.Close()
+
+	bucket := "scan"
+	for _, k := range []string{"a1", "a2", "a3", "b1"} {
+		store.AddValue(bucket, []byte(k), []byte(k))
+	}
+
+	buckets, err := store.ListBuckets()
+	assert.Nil(t, err)
+	assert.Contains(t, buckets, bucket)
+
+	keys := []string{}
+	walk := func(key, value []byte) bool {
+		keys = append(keys, string(key))
+		return true
+	}
+
+	store.Scan(bucket, []byte("a"), nil, 0, walk)
+	assert.Equal(t, []string{"a1", "a2", "a3"}, keys)
+
+	keys = []string{}
+	store.Scan(bucket, []byte("a"), []byte("a2"), 0, walk)
+	assert.Equal(t, []string{"a2", "a3"}, keys)
+
+	keys = []string{}
+	store.Scan(bucket, nil, nil, 2, walk)
+	assert.Equal(t, []string{"a1", "a2"}, keys)
+
+	keys = []string{}
+	store.Scan("not_exists", nil, nil, 0, walk)
+	assert.Equal(t, 0, len(keys))
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package boltdb
+
+import (
+	"github.com/asdine/storm/q"
+	"github.com/huminghe/infini-framework/core/errors"
+	"github.com/huminghe/infini-framework/core/orm"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// getMatcher translate the query tree to storm matchers, t is the type of the documents,
+// the field of conditions is resolved to the struct field by name, json tag or snake case name
+func getMatcher(t interface{}, conds []*orm.Cond) (q.Matcher, error) {
+	rt := reflect.TypeOf(t)
+	for rt.Kind() == reflect.Ptr || rt.Kind() == reflect.Slice {
+		rt = rt.Elem()
+	}
+	if rt.Kind() != reflect.Struct {
+		return nil, errors.Errorf("invalid type: %v", rt)
+	}
+	return getBoolMatcher(rt, conds)
+}
+
+func getBoolMatcher(t reflect.Type, conds []*orm.Cond) (q.Matcher, error) {
+	must := []q.Matcher{}
+	should := []q.Matcher{}
+	for _, c := range conds {
+		m, err := getCondMatcher(t, c)
+		if err != nil {
+			return nil, err
+		}
+		switch c.BoolType {
+		case orm.MustNot:
+			must = append(must, q.Not(m))
+		case orm.Should:
+			should = append(should, m)
+		default:
+			must = append(must, m)
+		}
+	}
+	if len(should) > 0 {
+		must = append(must, q.Or(should...))
+	}
+	if len(must) == 0 {
+		return q.True(), nil
+	}
+	return q.And(must...), nil
+}
+
+func getCondMatcher(t reflect.Type, c *orm.Cond) (q.Matcher, error) {
+	if c.QueryType == orm.Group {
+		return getBoolMatcher(t, c.Conds)
+	}
+
+	field, err := getFieldName(t, c.Field)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.QueryType {
+	case orm.Match:
+		return q.Eq(field, c.Value), nil
+	case orm.RangeGt:
+		return q.Gt(field, c.Value), nil
+	case orm.RangeGte:
+		return q.Gte(field, c.Value), nil
+	case orm.RangeLt:
+		return q.Lt(field, c.Value), nil
+	case orm.RangeLte:
+		return q.Lte(field, c.Value), nil
+	case orm.TermsMatch:
+		return q.In(field, c.Value), nil
+	case orm.PrefixMatch:
+		prefix, ok := c.Value.(string)
+		if !ok {
+			return nil, errors.Errorf("invalid prefix: %v", c.Value)
+		}
+		return q.Re(field, "^"+regexp.QuoteMeta(prefix)), nil
+	case orm.FieldExists:
+		return q.NewFieldMatcher(field, existsMatcher{}), nil
+	}
+	return nil, errors.Errorf("invalid query: %v", c.QueryType)
+}
+
+// existsMatcher matches the fields which are not nil
+type existsMatcher struct{}
+
+func (existsMatcher) MatchField(v interface{}) (bool, error) {
+	if v == nil {
+		return false, nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+		return !rv.IsNil(), nil
+	}
+	return true, nil
+}
+
+func getFieldName(t reflect.Type, name string) (string, error) {
+	if _, ok := t.FieldByName(name); ok {
+		return name, nil
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if tag == name || strings.EqualFold(strings.Replace(name, "_", "", -1), f.Name) {
+			return f.Name, nil
+		}
+	}
+	return "", errors.Errorf("field %s not found in %v", name, t)
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package boltdb
+
+import (
+	"github.com/asdine/storm/codec/json"
+	"github.com/huminghe/infini-framework/core/env"
+	"github.com/huminghe/infini-framework/core/global"
+	"github.com/huminghe/infini-framework/core/orm/ormtest"
+	"github.com/huminghe/infini-framework/core/util"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestStormConformance(t *testing.T) {
+	global.RegisterEnv(env.EmptyEnv())
+
+	file := path.Join(os.TempDir(), "bolt_"+util.PickRandomName()+".db")
+	defer os.RemoveAll(file)
+
+	store := BoltdbStore{FileName: file, Codec: json.Codec}
+	assert.Nil(t, store.Open())
+	defer store.Close()
+
+	get := func(o interface{}) error {
+		field, id, err := getID(o)
+		if err != nil {
+			return err
+		}
+		return db.One(field, id, o)
+	}
+	ormtest.Suite{Save: store.Save, Search: store.Search, Transaction: store.Transaction, Update: store.Update, Get: get,
+		BulkSave: store.BulkSave, BulkUpdate: store.BulkUpdate, BulkDelete: store.BulkDelete}.Run(t)
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package boltdb
+
+import (
+	"fmt"
+	"github.com/asdine/storm"
+	"github.com/huminghe/infini-framework/core/errors"
+	"github.com/huminghe/infini-framework/core/orm"
+	"reflect"
+	"strings"
+)
+
+// stormTx is the operations in a bolt transaction
+type stormTx struct {
+	node storm.Node
+}
+
+func (tx stormTx) Save(o interface{}) error {
+	orm.InitVersion(o)
+	return tx.node.Save(o)
+}
+
+func (tx stormTx) Update(o interface{}) error {
+	return update(tx.node, o)
+}
+
+func (tx stormTx) Delete(o interface{}) error {
+	return tx.node.DeleteStruct(o)
+}
+
+// Get load the object by the value of its id field
+func (tx stormTx) Get(o interface{}) error {
+	field, value, err := getID(o)
+	if err != nil {
+		return err
+	}
+	return tx.node.One(field, value, o)
+}
+
+func (tx stormTx) Search(t1, t2 interface{}, q1 *orm.Query) (error, orm.Result) {
+	return search(tx.node, t1, t2, q1)
+}
+
+// Transaction run fn in a writable bolt transaction
+func (store BoltdbStore) Transaction(fn func(tx orm.Tx) error) error {
+	node, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	return orm.RunInTransaction(stormTx{node: node}, fn, node.Commit, node.Rollback)
+}
+
+// update check the stored version before saving the object with version field,
+// the whole object is saved, as storm's update skips the zero value fields
+func update(node storm.Node, o interface{}) error {
+	_, version, ok := orm.GetVersion(o)
+	if !ok {
+		return node.Update(o)
+	}
+
+	field, id, err := getID(o)
+	if err != nil {
+		return err
+	}
+
+	stored := reflect.New(reflect.Indirect(reflect.ValueOf(o)).Type()).Interface()
+	err = node.One(field, id, stored)
+	if err != nil {
+		return err
+	}
+
+	_, storedVersion, _ := orm.GetVersion(stored)
+	if storedVersion != version {
+		return &orm.ConflictError{ID: fmt.Sprint(id), Version: version}
+	}
+
+	orm.SetVersion(o, version+1)
+	err = node.Save(o)
+	if err != nil {
+		orm.SetVersion(o, version)
+	}
+	return err
+}
+
+// getID return the name and value of the id field, which is tagged by `storm:"id"` or named ID
+func getID(o interface{}) (string, interface{}, error) {
+	v := reflect.Indirect(reflect.ValueOf(o))
+	if v.Kind() != reflect.Struct {
+		return "", nil, errors.Errorf("invalid type: %v", v.Type())
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if strings.Split(t.Field(i).Tag.Get("storm"), ",")[0] == "id" {
+			return t.Field(i).Name, v.Field(i).Interface(), nil
+		}
+	}
+	if f := v.FieldByName("ID"); f.IsValid() {
+		return "ID", f.Interface(), nil
+	}
+	return "", nil, errors.Errorf("id field not found in %v", t)
+}
+
+func (store BoltdbStore) BulkSave(objects []interface{}, chunkSize int) ([]orm.BulkResult, error) {
+	return bulk(objects, chunkSize, stormTx.Save)
+}
+
+func (store BoltdbStore) BulkUpdate(objects []interface{}, chunkSize int) ([]orm.BulkResult, error) {
+	return bulk(objects, chunkSize, stormTx.Update)
+}
+
+func (store BoltdbStore) BulkDelete(objects []interface{}, chunkSize int) ([]orm.BulkResult, error) {
+	return bulk(objects, chunkSize, stormTx.Delete)
+}
+
+// bulk run the operations of every chunk in a bolt transaction
+func bulk(objects []interface{}, chunkSize int, fn func(tx stormTx, o interface{}) error) ([]orm.BulkResult, error) {
+	return orm.RunBulk(objects, chunkSize, func(chunk []interface{}) ([]orm.BulkResult, error) {
+		node, err := db.Begin(true)
+		if err != nil {
+			return nil, err
+		}
+		defer node.Rollback()
+
+		tx := stormTx{node: node}
+		results := orm.RunEach(chunk, func(o interface{}) error {
+			return fn(tx, o)
+		})
+		if err := node.Commit(); err != nil {
+			return nil, err
+		}
+		return results, nil
+	})
+}
@@ -0,0 +1,365 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"fmt"
+	"github.com/huminghe/infini-framework/core/elastic"
+	"github.com/huminghe/infini-framework/core/env"
+	"github.com/huminghe/infini-framework/core/global"
+	"github.com/huminghe/infini-framework/core/util"
+	"github.com/huminghe/infini-framework/modules/elastic/elastictest"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"sort"
+	"testing"
+	"time"
+)
+
+func newTestAPI(t *testing.T, server *elastictest.Server) elastic.API {
+	config := elastic.ElasticsearchConfig{Name: "fake", Endpoint: server.URL, IndexPrefix: "test_"}
+	version, err := ClusterVersion(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, server.Version, version.Version.Number)
+
+	switch version.GetMajorVersion() {
+	case 8:
+		return &ESAPIV8{ESAPIV7{ESAPIV6{ESAPIV5{ESAPIV0{Config: config, Version: version}}}}}
+	case 7:
+		return &ESAPIV7{ESAPIV6{ESAPIV5{ESAPIV0{Config: config, Version: version}}}}
+	case 6:
+		return &ESAPIV6{ESAPIV5{ESAPIV0{Config: config, Version: version}}}
+	}
+	return &ESAPIV5{ESAPIV0{Config: config, Version: version}}
+}
+
+// TestAdapterMatrix runs the adapter of every major version against the fake elasticsearch of the same version
+func TestAdapterMatrix(t *testing.T) {
+	global.RegisterEnv(env.EmptyEnv())
+
+	for _, version := range elastictest.Versions {
+		version := version
+		t.Run(version, func(t *testing.T) {
+			server := elastictest.NewServer(version)
+			defer server.Close()
+			api := newTestAPI(t, server)
+
+			exist, err := api.TemplateExists("app")
+			assert.Nil(t, err)
+			assert.False(t, exist)
+			_, err = api.PutTemplate("app", []byte(`{"index_patterns":["test_*"]}`))
+			assert.Nil(t, err)
+			exist, err = api.TemplateExists("app")
+			assert.Nil(t, err)
+			assert.True(t, exist)
+
+			resp, err := api.Index("docs", "1", map[string]interface{}{"name": "alice", "age": 20})
+			assert.Nil(t, err)
+			assert.Equal(t, "created", resp.Result)
+			_, err = api.Index("docs", "2", map[string]interface{}{"name": "bob", "age": 30})
+			assert.Nil(t, err)
+
+			stored, err := api.Get("docs", "1")
+			assert.Nil(t, err)
+			assert.Equal(t, "alice", stored.Source["name"])
+			assert.Equal(t, version >= "6", stored.SeqNo != nil)
+
+			_, err = api.IndexIfMatch("docs", "1", map[string]interface{}{"name": "alice", "age": 21}, stored)
+			assert.Nil(t, err)
+			_, err = api.IndexIfMatch("docs", "1", map[string]interface{}{"name": "alice", "age": 22}, stored)
+			assert.Equal(t, elastic.ErrVersionConflict, err)
+
+			bulk, err := api.BulkRequest([]elastic.BulkAction{
+				{Type: elastic.BulkIndex, Index: "docs", ID: "3", Doc: map[string]interface{}{"name": "carol", "age": 40}},
+				{Type: elastic.BulkDelete, Index: "docs", ID: "2"},
+				{Type: elastic.BulkCreate, Index: "docs", ID: "1", Doc: map[string]interface{}{"name": "alice"}},
+			})
+			assert.Nil(t, err)
+			items := bulk.GetItems()
+			assert.Nil(t, items[0].Error)
+			assert.Nil(t, items[1].Error)
+			assert.Equal(t, 409, items[2].Status)
+
+			assert.Nil(t, api.Refresh("docs"))
+			count, err := api.Count("docs")
+			assert.Nil(t, err)
+			assert.Equal(t, 2, count.Count)
+
+			query := elastic.NewBoolQuery().Must(elastic.NewMatchQuery("name", "carol")).Filter(elastic.NewRangeQuery().Gte("age", 30))
+			search, err := api.Search("docs", &elastic.SearchRequest{Query: query})
+			assert.Nil(t, err)
+			assert.Equal(t, 1, search.GetTotal())
+			assert.Equal(t, "3", search.Hits.Hits[0].ID)
+
+			ids := []string{}
+			scroll, err := api.NewScroll("test_docs", "1m", 1, "", 0, 1, "")
+			assert.Nil(t, err)
+			for i := 0; i < 3; i++ {
+				response := scroll.(elastic.ScrollResponseAPI)
+				assert.Equal(t, 2, response.GetHitsTotal())
+				for _, doc := range response.GetDocs() {
+					ids = append(ids, doc.(map[string]interface{})["_id"].(string))
+				}
+				scroll, err = api.NextScroll("1m", response.GetScrollId())
+				assert.Nil(t, err)
+			}
+			sort.Strings(ids)
+			assert.Equal(t, []string{"1", "3"}, ids)
+
+			_, _, mappings, err := api.GetMapping(false, "test_docs")
+			assert.Nil(t, err)
+			assert.Contains(t, util.ToJson(mappings, false), `"name":{"fields"`)
+
+			settings, err := api.GetIndexSettings("test_docs")
+			assert.Nil(t, err)
+			assert.Contains(t, util.ToJson(settings, false), `"number_of_shards":"1"`)
+
+			deleted, err := api.Delete("docs", "1")
+			assert.Nil(t, err)
+			assert.Equal(t, "deleted", deleted.Result)
+			assert.Nil(t, api.DeleteIndex("docs"))
+			exist, err = api.IndexExists("docs")
+			assert.Nil(t, err)
+			assert.False(t, exist)
+		})
+	}
+}
+
+// TestAggregationMatrix decode the aggregations computed by the fake elasticsearch of every version
+func TestAggregationMatrix(t *testing.T) {
+	global.RegisterEnv(env.EmptyEnv())
+
+	for _, version := range elastictest.Versions {
+		version := version
+		t.Run(version, func(t *testing.T) {
+			server := elastictest.NewServer(version)
+			defer server.Close()
+			api := newTestAPI(t, server)
+
+			logs := []map[string]interface{}{
+				{"host": "a", "latency": 10, "level": "error"},
+				{"host": "a", "latency": 15, "level": "error"},
+				{"host": "a", "latency": 40, "level": "error"},
+				{"host": "b", "latency": 1200, "level": "info"},
+				{"host": "b", "latency": 5, "level": "info"},
+				{"host": "c", "latency": 20, "level": "info"},
+			}
+			for i, doc := range logs {
+				_, err := api.Index("logs", fmt.Sprint(i+1), doc)
+				assert.Nil(t, err)
+			}
+			assert.Nil(t, api.Refresh("logs"))
+
+			request := &elastic.SearchRequest{}
+			request.AddAggregation("by_host", elastic.NewAggregation("terms", "host").Set("size", 2).
+				SubAggregation("latency", elastic.NewAggregation("percentiles", "latency").Set("percents", []float64{50, 99})))
+			request.AddAggregation("slow", elastic.NewFilterAggregation(elastic.NewRangeQuery().Gt("latency", 1000)).
+				SubAggregation("hosts", elastic.NewAggregation("cardinality", "host")))
+			request.AddAggregation("empty", elastic.NewAggregation("avg", "missing"))
+			request.AddAggregation("stats", elastic.NewAggregation("stats", "latency"))
+			request.AddAggregation("ranges", elastic.NewAggregation("range", "latency").
+				Set("ranges", []map[string]interface{}{{"to": 10}, {"from": 10}}))
+			request.AddAggregation("by_type", elastic.NewAggregation("filters", "").Set("filters", map[string]interface{}{
+				"errors": elastic.NewTermQuery("level", "error"), "infos": elastic.NewTermQuery("level", "info")}))
+			request.AddAggregation("top", elastic.NewAggregation("top_hits", "").Set("size", 1))
+			response, err := api.Search("logs", request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, 6, response.GetTotal())
+
+			byHost := response.Aggregations["by_host"]
+			assert.Equal(t, 1, byHost.SumOtherDocCount)
+			assert.Equal(t, 2, len(byHost.Buckets))
+			assert.Equal(t, "a", byHost.Buckets[0].Key)
+			assert.Equal(t, 3, byHost.Buckets[0].DocCount)
+			assert.Equal(t, 15.0, *byHost.Buckets[0].Aggregations["latency"].Values["50.0"])
+
+			slow := response.Aggregations["slow"]
+			assert.Equal(t, 1, slow.DocCount)
+			hosts := slow.Aggregations["hosts"]
+			value, ok := hosts.GetValue()
+			assert.True(t, ok)
+			assert.Equal(t, 1.0, value)
+
+			empty := response.Aggregations["empty"]
+			_, ok = empty.GetValue()
+			assert.False(t, ok)
+
+			stats := response.Aggregations["stats"]
+			assert.Equal(t, 6, stats.Count)
+			assert.Equal(t, 5.0, *stats.Min)
+			assert.Equal(t, 1290.0, *stats.Sum)
+
+			ranges := response.Aggregations["ranges"].Buckets
+			assert.Equal(t, "*-10.0", ranges[0].Key)
+			assert.Nil(t, ranges[0].From)
+			assert.Equal(t, 1, ranges[0].DocCount)
+			assert.Equal(t, 10.0, *ranges[1].From)
+			assert.Equal(t, 5, ranges[1].DocCount)
+
+			assert.Equal(t, 3, response.Aggregations["by_type"].KeyedBuckets["errors"].DocCount)
+			assert.Equal(t, 3, response.Aggregations["by_type"].KeyedBuckets["infos"].DocCount)
+
+			top := response.Aggregations["top"].Hits
+			assert.Equal(t, 1, len(top.Hits))
+			assert.Equal(t, "a", top.Hits[0].Source["host"])
+
+			//the significant_terms has the doc_count of foreground besides the buckets
+			request = &elastic.SearchRequest{Query: elastic.NewBoolQuery().Filter(elastic.NewTermQuery("level", "error"))}
+			request.AddAggregation("significant", elastic.NewAggregation("significant_terms", "host").Set("min_doc_count", 1))
+			response, err = api.Search("logs", request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			significant := response.Aggregations["significant"]
+			assert.Equal(t, 3, significant.DocCount)
+			assert.Equal(t, 6, significant.BgCount)
+			assert.Equal(t, 1, len(significant.Buckets))
+			assert.Equal(t, "a", significant.Buckets[0].Key)
+			assert.Equal(t, 3, significant.Buckets[0].DocCount)
+			assert.Equal(t, 3, significant.Buckets[0].BgCount)
+			assert.True(t, significant.Buckets[0].Score > 0)
+			assert.Nil(t, significant.Aggregations)
+		})
+	}
+}
+
+func TestUpdateMatrix(t *testing.T) {
+	global.RegisterEnv(env.EmptyEnv())
+	defer func(interval time.Duration) {
+		taskPollInterval = interval
+	}(taskPollInterval)
+	taskPollInterval = time.Millisecond
+
+	for _, version := range elastictest.Versions {
+		version := version
+		t.Run(version, func(t *testing.T) {
+			server := elastictest.NewServer(version)
+			defer server.Close()
+			api := newTestAPI(t, server)
+
+			_, err := api.Update("counters", "a", map[string]interface{}{"name": "a"}, nil)
+			assert.NotNil(t, err)
+
+			resp, err := api.Update("counters", "a", map[string]interface{}{"name": "a"}, map[string]interface{}{"name": "a", "count": 1})
+			assert.Nil(t, err)
+			assert.Equal(t, "created", resp.Result)
+			resp, err = api.Update("counters", "a", map[string]interface{}{"tag": "x"}, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, "updated", resp.Result)
+			assert.Equal(t, 2, resp.Version)
+			resp, err = api.Update("counters", "a", map[string]interface{}{"tag": "x"}, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, "noop", resp.Result)
+
+			stored, err := api.Get("counters", "a")
+			assert.Nil(t, err)
+			assert.Equal(t, map[string]interface{}{"name": "a", "count": 1.0, "tag": "x"}, stored.Source)
+
+			_, err = api.Index("counters", "b", map[string]interface{}{"name": "b", "count": 5})
+			assert.Nil(t, err)
+			_, err = api.Index("counters", "c", map[string]interface{}{"name": "c", "count": 10})
+			assert.Nil(t, err)
+			assert.Nil(t, api.Refresh("counters"))
+
+			result, err := api.UpdateByQuery("counters", &elastic.ByQueryRequest{
+				Query:  elastic.NewBoolQuery().Filter(elastic.NewRangeQuery().Lt("count", 10)),
+				Script: &elastic.Script{Source: "ctx._source.count += params.step", Params: map[string]interface{}{"step": 2}},
+			})
+			assert.Nil(t, err)
+			assert.Equal(t, 2, result.Total)
+			assert.Equal(t, 2, result.Updated)
+			stored, err = api.Get("counters", "b")
+			assert.Nil(t, err)
+			assert.Equal(t, 7.0, stored.Source["count"])
+
+			result, err = api.DeleteByQuery("counters", &elastic.ByQueryRequest{
+				Query: elastic.NewBoolQuery().Filter(elastic.NewRangeQuery().Gte("count", 7)),
+			})
+			assert.Nil(t, err)
+			assert.Equal(t, 2, result.Deleted)
+			count, err := api.Count("counters")
+			assert.Nil(t, err)
+			assert.Equal(t, 1, count.Count)
+
+			_, err = api.DeleteByQuery("counters", &elastic.ByQueryRequest{Script: &elastic.Script{Source: "ctx._source.count = 0"}})
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+// TestUpdateByQueryTimeout cancels the task which is not completed in time
+func TestUpdateByQueryTimeout(t *testing.T) {
+	global.RegisterEnv(env.EmptyEnv())
+	defer func(interval time.Duration) {
+		taskPollInterval = interval
+	}(taskPollInterval)
+	taskPollInterval = time.Millisecond
+
+	for _, version := range elastictest.Versions {
+		version := version
+		t.Run(version, func(t *testing.T) {
+			server := elastictest.NewServer(version)
+			defer server.Close()
+			server.TaskPolls = math.MaxInt32
+			api := newTestAPI(t, server)
+
+			_, err := api.Index("counters", "a", map[string]interface{}{"count": 1})
+			assert.Nil(t, err)
+			assert.Nil(t, api.Refresh("counters"))
+
+			_, err = api.UpdateByQuery("counters", &elastic.ByQueryRequest{Timeout: 20 * time.Millisecond})
+			if assert.NotNil(t, err) {
+				assert.Contains(t, err.Error(), "was cancelled")
+			}
+		})
+	}
+}
+
+// TestInlineScript sends the source of script as `inline` before 5.6
+func TestInlineScript(t *testing.T) {
+	global.RegisterEnv(env.EmptyEnv())
+	defer func(interval time.Duration) {
+		taskPollInterval = interval
+	}(taskPollInterval)
+	taskPollInterval = time.Millisecond
+
+	for _, version := range []string{"5.5.3", "5.6.16"} {
+		version := version
+		t.Run(version, func(t *testing.T) {
+			server := elastictest.NewServer(version)
+			defer server.Close()
+			api := newTestAPI(t, server)
+
+			_, err := api.Index("counters", "a", map[string]interface{}{"count": 1})
+			assert.Nil(t, err)
+			assert.Nil(t, api.Refresh("counters"))
+
+			result, err := api.UpdateByQuery("counters", &elastic.ByQueryRequest{
+				Script: &elastic.Script{Source: "ctx._source.count += params.step", Params: map[string]interface{}{"step": 2}},
+			})
+			assert.Nil(t, err)
+			assert.Equal(t, 1, result.Updated)
+			stored, err := api.Get("counters", "a")
+			assert.Nil(t, err)
+			assert.Equal(t, 3.0, stored.Source["count"])
+		})
+	}
+}
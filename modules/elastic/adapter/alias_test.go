@@ -0,0 +1,64 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"github.com/huminghe/infini-framework/core/elastic"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAliases(t *testing.T) {
+	var path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		path, body = r.URL.Path, string(data)
+		switch r.URL.Path {
+		case "/test_logs-*/_alias":
+			w.Write([]byte(`{"test_logs-000001":{"aliases":{}},"test_logs-000002":{"aliases":{"test_logs":{}}}}`))
+		case "/test_logs/_rollover":
+			w.Write([]byte(`{"old_index":"test_logs-000002","new_index":"test_logs-000003","rolled_over":true,"conditions":{"[max_docs: 10]":true}}`))
+		default:
+			w.Write([]byte(`{"acknowledged":true}`))
+		}
+	}))
+	defer server.Close()
+
+	api := new(ESAPIV5)
+	api.Config = elastic.ElasticsearchConfig{Endpoint: server.URL, IndexPrefix: "test_"}
+
+	aliases, err := api.GetAliases("logs-*")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]string{"logs-000001": {}, "logs-000002": {"logs"}}, aliases)
+
+	assert.Nil(t, api.SwapAlias("logs", "logs-000001", "logs-000002"))
+	assert.Equal(t, "/_aliases", path)
+	assert.JSONEq(t, `{"actions":[{"remove":{"index":"test_logs-000001","alias":"test_logs"}},{"add":{"index":"test_logs-000002","alias":"test_logs"}}]}`, body)
+
+	resp, err := api.Rollover("logs", "", &elastic.RolloverConditions{MaxDocs: 10})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"conditions":{"max_docs":10}}`, body)
+	assert.Equal(t, true, resp.RolledOver)
+	assert.Equal(t, "logs-000002", resp.OldIndex)
+	assert.Equal(t, "logs-000003", resp.NewIndex)
+
+	_, err = api.ESAPIV0.Rollover("logs", "", nil)
+	assert.NotNil(t, err)
+}
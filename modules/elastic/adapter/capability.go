@@ -0,0 +1,74 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import "github.com/huminghe/infini-framework/core/elastic"
+
+var capabilitiesV0 = map[elastic.Capability]bool{
+	elastic.CapabilityMappingTypes: true,
+	elastic.CapabilityIDSort:       true,
+}
+
+var capabilitiesV5 = map[elastic.Capability]bool{
+	elastic.CapabilitySlicedScroll: true,
+	elastic.CapabilityRollover:     true,
+	elastic.CapabilityMappingTypes: true,
+	elastic.CapabilityIDSort:       true,
+}
+
+var capabilitiesV6 = capabilitiesV5
+
+var capabilitiesV7 = map[elastic.Capability]bool{
+	elastic.CapabilitySlicedScroll:    true,
+	elastic.CapabilityRollover:        true,
+	elastic.CapabilityDocEndpoint:     true,
+	elastic.CapabilityHitsTotalObject: true,
+	elastic.CapabilityIDSort:          true,
+}
+
+var capabilitiesV8 = map[elastic.Capability]bool{
+	elastic.CapabilitySlicedScroll:    true,
+	elastic.CapabilityRollover:        true,
+	elastic.CapabilityDocEndpoint:     true,
+	elastic.CapabilityHitsTotalObject: true,
+	elastic.CapabilityIndexTemplate:   true,
+	elastic.CapabilityFixedInterval:   true,
+}
+
+func (c *ESAPIV0) HasCapability(capability elastic.Capability) bool {
+	return capabilitiesV0[capability]
+}
+
+func (c *ESAPIV5) HasCapability(capability elastic.Capability) bool {
+	return capabilitiesV5[capability]
+}
+
+func (c *ESAPIV6) HasCapability(capability elastic.Capability) bool {
+	return capabilitiesV6[capability]
+}
+
+func (c *ESAPIV7) HasCapability(capability elastic.Capability) bool {
+	//the fixed_interval of date_histogram is added in a minor version
+	if capability == elastic.CapabilityFixedInterval {
+		return c.Version.GetMinorVersion() >= 2
+	}
+	return capabilitiesV7[capability]
+}
+
+func (c *ESAPIV8) HasCapability(capability elastic.Capability) bool {
+	return capabilitiesV8[capability]
+}
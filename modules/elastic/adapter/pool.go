@@ -0,0 +1,347 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/cihub/seelog"
+	"github.com/huminghe/infini-framework/core/elastic"
+	"github.com/huminghe/infini-framework/core/stats"
+	"github.com/huminghe/infini-framework/core/util"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultHealthCheckInterval = 10 * time.Second
+const defaultSniffInterval = 5 * time.Minute
+const deadTimeout = time.Second
+const maxDeadTimeout = 5 * time.Minute
+
+type node struct {
+	stats   elastic.NodeStats
+	retryAt time.Time
+	//failures the continuous failures, which is used to compute the backoff of a dead node
+	failures int
+}
+
+// NodePool balance the requests between the healthy nodes by round robin, a node is marked dead if the request
+// is failed, and it is retried after a backoff or brought back by the health check
+type NodePool struct {
+	config elastic.ElasticsearchConfig
+	do     func(method, url string, body []byte) (*util.Result, error)
+
+	lock  sync.Mutex
+	nodes []*node
+	next  int
+	quit  chan bool
+}
+
+// NewNodePool create the pool of the hosts in config
+func NewNodePool(config elastic.ElasticsearchConfig) *NodePool {
+	pool := &NodePool{config: config}
+	pool.do = func(method, url string, body []byte) (*util.Result, error) {
+		return execute(&pool.config, method, url, body)
+	}
+	pool.setNodes(config.GetHosts())
+	return pool
+}
+
+func (pool *NodePool) setNodes(endpoints []string) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	existing := map[string]*node{}
+	for _, n := range pool.nodes {
+		existing[n.stats.Endpoint] = n
+	}
+	nodes := []*node{}
+	for _, endpoint := range endpoints {
+		n, ok := existing[endpoint]
+		if !ok {
+			n = &node{stats: elastic.NodeStats{Endpoint: endpoint, Healthy: true}}
+		}
+		nodes = append(nodes, n)
+	}
+	pool.nodes = nodes
+}
+
+// Start run the health check and sniffing in background
+func (pool *NodePool) Start() {
+	pool.quit = make(chan bool)
+
+	if pool.config.Sniff {
+		pool.Sniff()
+	}
+
+	healthCheckInterval := defaultHealthCheckInterval
+	if pool.config.HealthCheckIntervalInSeconds > 0 {
+		healthCheckInterval = time.Duration(pool.config.HealthCheckIntervalInSeconds) * time.Second
+	}
+	sniffInterval := defaultSniffInterval
+	if pool.config.SniffIntervalInSeconds > 0 {
+		sniffInterval = time.Duration(pool.config.SniffIntervalInSeconds) * time.Second
+	}
+
+	go func() {
+		healthCheck := time.NewTicker(healthCheckInterval)
+		defer healthCheck.Stop()
+		sniff := time.NewTicker(sniffInterval)
+		defer sniff.Stop()
+
+		for {
+			select {
+			case <-pool.quit:
+				return
+			case <-healthCheck.C:
+				pool.HealthCheck()
+			case <-sniff.C:
+				if pool.config.Sniff {
+					pool.Sniff()
+				}
+			}
+		}
+	}()
+}
+
+func (pool *NodePool) Stop() {
+	if pool.quit != nil {
+		close(pool.quit)
+		pool.quit = nil
+	}
+}
+
+// NodeStats return the stats of nodes
+func (pool *NodePool) NodeStats() []elastic.NodeStats {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	result := make([]elastic.NodeStats, 0, len(pool.nodes))
+	for _, n := range pool.nodes {
+		result = append(result, n.stats)
+	}
+	return result
+}
+
+// pick return the next healthy node which is not tried, or the dead node which is going to be retried first
+func (pool *NodePool) pick(tried map[*node]bool) *node {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	now := time.Now()
+	var dead *node
+	for i := 0; i < len(pool.nodes); i++ {
+		n := pool.nodes[(pool.next+i)%len(pool.nodes)]
+		if tried[n] {
+			continue
+		}
+		if n.stats.Healthy || !now.Before(n.retryAt) {
+			pool.next = (pool.next + i + 1) % len(pool.nodes)
+			return n
+		}
+		if dead == nil || n.retryAt.Before(dead.retryAt) {
+			dead = n
+		}
+	}
+	return dead
+}
+
+func (pool *NodePool) markHealthy(n *node) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	if !n.stats.Healthy {
+		log.Infof("elasticsearch node %s is back", n.stats.Endpoint)
+	}
+	n.stats.Healthy = true
+	n.stats.DeadSince = time.Time{}
+	n.failures = 0
+}
+
+// markDead mark the node dead, the node is retried after the timeout, which is doubled after every failure
+func (pool *NodePool) markDead(n *node, err error) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	if n.stats.Healthy {
+		log.Warnf("elasticsearch node %s is dead, %v", n.stats.Endpoint, err)
+		n.stats.DeadSince = time.Now()
+	}
+	n.stats.Healthy = false
+	n.stats.LastError = err.Error()
+	n.failures++
+
+	timeout := deadTimeout
+	for i := 1; i < n.failures && timeout < maxDeadTimeout; i++ {
+		timeout *= 2
+	}
+	if timeout > maxDeadTimeout {
+		timeout = maxDeadTimeout
+	}
+	n.retryAt = time.Now().Add(timeout)
+}
+
+func (pool *NodePool) count(n *node, failed bool) {
+	pool.lock.Lock()
+	n.stats.Requests++
+	if failed {
+		n.stats.Failures++
+	}
+	pool.lock.Unlock()
+
+	category := "elastic." + pool.config.ID
+	stats.Increment(category, n.stats.Endpoint+".requests")
+	if failed {
+		stats.Increment(category, n.stats.Endpoint+".failures")
+	}
+}
+
+// isIdempotent return true if the request could be sent again safely, the POST requests are not retried
+// unless they are read only
+func isIdempotent(method, path string) bool {
+	if method != util.Verb_POST {
+		return true
+	}
+	p := path
+	if i := strings.Index(p, "?"); i >= 0 {
+		p = p[:i]
+	}
+	for _, api := range []string{"/_search", "/_count", "/_mget", "/_msearch", "/_refresh"} {
+		if strings.HasSuffix(p, api) || strings.Contains(p, api+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// isUnavailable return true if the node is not able to handle the request for now
+func isUnavailable(status int) bool {
+	return status == 502 || status == 503 || status == 504
+}
+
+// Request send the request of path to a node, the idempotent requests are retried on the other nodes
+// if the node is failed or unavailable
+func (pool *NodePool) Request(method, path string, body []byte) (*util.Result, error) {
+	tried := map[*node]bool{}
+	for retry := 0; ; retry++ {
+		n := pool.pick(tried)
+		if n == nil {
+			return nil, fmt.Errorf("no elasticsearch node is available")
+		}
+		tried[n] = true
+
+		resp, err := pool.do(method, n.stats.Endpoint+path, body)
+		if err != nil {
+			pool.markDead(n, err)
+		} else if !isUnavailable(resp.StatusCode) {
+			pool.count(n, false)
+			pool.markHealthy(n)
+			return resp, nil
+		}
+		pool.count(n, true)
+
+		if (pool.config.MaxRetries > 0 && retry >= pool.config.MaxRetries) || !isIdempotent(method, path) || len(tried) >= pool.size() {
+			return resp, err
+		}
+		log.Debugf("retry %s %s on other node, %v", method, path, err)
+	}
+}
+
+func (pool *NodePool) size() int {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	return len(pool.nodes)
+}
+
+// HealthCheck check all the nodes by requesting the root path
+func (pool *NodePool) HealthCheck() {
+	pool.lock.Lock()
+	nodes := append([]*node{}, pool.nodes...)
+	pool.lock.Unlock()
+
+	for _, n := range nodes {
+		resp, err := pool.do(util.Verb_GET, n.stats.Endpoint+"/", nil)
+		if err == nil && resp.StatusCode != 200 {
+			err = fmt.Errorf("status: %v", resp.StatusCode)
+		}
+		if err != nil {
+			pool.markDead(n, err)
+			continue
+		}
+		pool.markHealthy(n)
+	}
+}
+
+// Sniff replace the nodes with the http endpoints of the nodes in cluster, the nodes are not changed if it is failed
+func (pool *NodePool) Sniff() {
+	resp, err := pool.Request(util.Verb_GET, "/_nodes/http", nil)
+	if err == nil && resp.StatusCode != 200 {
+		err = fmt.Errorf("status: %v, %s", resp.StatusCode, string(resp.Body))
+	}
+	if err != nil {
+		log.Warn("failed to sniff nodes, ", err)
+		return
+	}
+
+	nodes := elastic.NodesHttpResponse{}
+	err = json.Unmarshal(resp.Body, &nodes)
+	if err != nil {
+		log.Warn("failed to sniff nodes, ", err)
+		return
+	}
+
+	scheme := "http"
+	if hosts := pool.config.GetHosts(); len(hosts) > 0 {
+		if u, err := url.Parse(hosts[0]); err == nil && u.Scheme != "" {
+			scheme = u.Scheme
+		}
+	}
+
+	endpoints := []string{}
+	for _, v := range nodes.Nodes {
+		address := getPublishAddress(v.Http.PublishAddress)
+		if address != "" {
+			endpoints = append(endpoints, scheme+"://"+address)
+		}
+	}
+	if len(endpoints) == 0 {
+		log.Warn("no http endpoint was sniffed")
+		return
+	}
+	log.Debug("sniffed elasticsearch nodes: ", endpoints)
+	sort.Strings(endpoints)
+	pool.setNodes(endpoints)
+}
+
+// getPublishAddress return the address of `ip:port`, or `hostname/ip:port` if the hostname is set
+func getPublishAddress(address string) string {
+	if i := strings.Index(address, "/"); i >= 0 {
+		if i == 0 {
+			return address[1:]
+		}
+		//use the hostname, as the certificate may be issued to it
+		hostname := address[:i]
+		if j := strings.LastIndex(address, ":"); j > i {
+			return hostname + address[j:]
+		}
+		return hostname
+	}
+	return address
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"fmt"
+	"github.com/huminghe/infini-framework/core/elastic"
+	"github.com/huminghe/infini-framework/core/util"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+type testNode struct {
+	server   *httptest.Server
+	requests int32
+	status   int32
+}
+
+func newTestNode() *testNode {
+	n := &testNode{status: 200}
+	n.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&n.requests, 1)
+		w.WriteHeader(int(atomic.LoadInt32(&n.status)))
+		w.Write([]byte(`{}`))
+	}))
+	return n
+}
+
+func TestNodePoolRoundRobin(t *testing.T) {
+	n1, n2 := newTestNode(), newTestNode()
+	defer n1.server.Close()
+	defer n2.server.Close()
+
+	pool := NewNodePool(elastic.ElasticsearchConfig{Hosts: []string{n1.server.URL, n2.server.URL + "/"}})
+	for i := 0; i < 4; i++ {
+		resp, err := pool.Request(util.Verb_GET, "/_cluster/health", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+	}
+	assert.Equal(t, int32(2), n1.requests)
+	assert.Equal(t, int32(2), n2.requests)
+}
+
+func TestNodePoolFailover(t *testing.T) {
+	n1, n2 := newTestNode(), newTestNode()
+	defer n2.server.Close()
+	down := n1.server.URL
+	n1.server.Close()
+
+	pool := NewNodePool(elastic.ElasticsearchConfig{Hosts: []string{down, n2.server.URL}})
+
+	//the idempotent request is retried on the other node, and the dead node is not used until the timeout
+	for i := 0; i < 3; i++ {
+		resp, err := pool.Request(util.Verb_GET, "/index/doc/1", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+	}
+	assert.Equal(t, int32(3), n2.requests)
+
+	stats := pool.NodeStats()
+	assert.False(t, stats[0].Healthy)
+	assert.Equal(t, int64(1), stats[0].Failures)
+	assert.True(t, stats[1].Healthy)
+	assert.Equal(t, int64(3), stats[1].Requests)
+
+	//the node is back after the health check
+	atomic.StoreInt32(&n2.status, 503)
+	pool.HealthCheck()
+	stats = pool.NodeStats()
+	assert.False(t, stats[0].Healthy)
+	assert.False(t, stats[1].Healthy)
+	atomic.StoreInt32(&n2.status, 200)
+	pool.HealthCheck()
+	assert.True(t, pool.NodeStats()[1].Healthy)
+}
+
+func TestNodePoolNoRetry(t *testing.T) {
+	n1, n2 := newTestNode(), newTestNode()
+	defer n1.server.Close()
+	defer n2.server.Close()
+	atomic.StoreInt32(&n1.status, 503)
+	atomic.StoreInt32(&n2.status, 503)
+
+	pool := NewNodePool(elastic.ElasticsearchConfig{Hosts: []string{n1.server.URL, n2.server.URL}})
+	resp, err := pool.Request(util.Verb_POST, "/index/doc", []byte(`{}`))
+	assert.Nil(t, err)
+	assert.Equal(t, 503, resp.StatusCode)
+	assert.Equal(t, int32(1), n1.requests+n2.requests)
+
+	resp, err = pool.Request(util.Verb_POST, "/index/_search?size=1", []byte(`{}`))
+	assert.Nil(t, err)
+	assert.Equal(t, 503, resp.StatusCode)
+	assert.Equal(t, int32(3), n1.requests+n2.requests)
+}
+
+func TestNodePoolSniff(t *testing.T) {
+	var address string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/_nodes/http") {
+			fmt.Fprintf(w, `{"nodes":{"a":{"name":"a","http":{"publish_address":"%s"}},"b":{"name":"b","http":{}}}}`, address)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	address = "localhost/" + strings.TrimPrefix(server.URL, "http://")
+
+	pool := NewNodePool(elastic.ElasticsearchConfig{Hosts: []string{server.URL}})
+	pool.Sniff()
+	stats := pool.NodeStats()
+	assert.Equal(t, 1, len(stats))
+	assert.Equal(t, "http://localhost:"+server.URL[strings.LastIndex(server.URL, ":")+1:], stats[0].Endpoint)
+
+	assert.Equal(t, "10.0.0.1:9200", getPublishAddress("10.0.0.1:9200"))
+	assert.Equal(t, "10.0.0.1:9200", getPublishAddress("/10.0.0.1:9200"))
+	assert.Equal(t, "es1:9200", getPublishAddress("es1/10.0.0.1:9200"))
+}
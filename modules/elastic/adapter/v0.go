@@ -32,16 +32,39 @@ import (
 type ESAPIV0 struct {
 	Version elastic.ClusterVersion
 	Config  elastic.ElasticsearchConfig
+	Pool    *NodePool
 }
 
 const TypeName6 = "doc"
 
+const defaultRetryOnConflict = 3
+
+// Request send the request, the requests of the endpoint are balanced between the nodes of pool if it is set
 func (c *ESAPIV0) Request(method, url string, body []byte) (result *util.Result, err error) {
 
 	if global.Env().IsDebug {
 		log.Trace(method, ",", url, ",", string(body))
 	}
 
+	if c.Pool != nil && strings.HasPrefix(url, c.Config.Endpoint) {
+		return c.Pool.Request(method, strings.TrimPrefix(url, c.Config.Endpoint), body)
+	}
+	return execute(&c.Config, method, url, body)
+}
+
+func execute(config *elastic.ElasticsearchConfig, method, url string, body []byte) (result *util.Result, err error) {
+	//the transport errors are panicked by util, which are returned to failover
+	defer func() {
+		if r := recover(); r != nil {
+			result = nil
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = fmt.Errorf("%v", r)
+			}
+		}
+	}()
+
 	var req *util.Request
 
 	switch method {
@@ -57,28 +80,23 @@ func (c *ESAPIV0) Request(method, url string, body []byte) (result *util.Result,
 	case util.Verb_DELETE:
 		req = util.NewDeleteRequest(url, body)
 		break
+	default:
+		return nil, fmt.Errorf("invalid method: %s", method)
 	}
 
 	req.SetContentType(util.ContentTypeJson)
 
-	if c.Config.BasicAuth != nil {
-		req.SetBasicAuth(c.Config.BasicAuth.Username, c.Config.BasicAuth.Password)
+	if config.APIKey != "" {
+		req.AddHeader("Authorization", "ApiKey "+config.APIKey)
+	} else if config.BasicAuth != nil {
+		req.SetBasicAuth(config.BasicAuth.Username, config.BasicAuth.Password)
 	}
 
-	if c.Config.HttpProxy != "" {
-		req.SetProxy(c.Config.HttpProxy)
+	if config.HttpProxy != "" {
+		req.SetProxy(config.HttpProxy)
 	}
 
-	resp, err := util.ExecuteRequest(req)
-
-	if err != nil {
-		panic(err)
-		return nil, err
-	}
-
-	responseHandle(resp)
-
-	return resp, err
+	return util.ExecuteRequest(req)
 }
 
 func (c *ESAPIV0) Init() {
@@ -193,6 +211,110 @@ func (c *ESAPIV0) Index(indexName string, id interface{}, data interface{}) (*el
 	return esResp, nil
 }
 
+func (c *ESAPIV0) IndexIfMatch(indexName string, id interface{}, data interface{}, stored *elastic.GetResponse) (*elastic.InsertResponse, error) {
+	if c.Config.IndexPrefix != "" {
+		indexName = c.Config.IndexPrefix + indexName
+	}
+	url := fmt.Sprintf("%s/%s/%s/%s", c.Config.Endpoint, indexName, TypeName6, id)
+	return c.indexIfMatch(url, data, stored)
+}
+
+// indexIfMatch use if_seq_no and if_primary_term if they are returned by Get, or fallback to the internal version
+func (c *ESAPIV0) indexIfMatch(url string, data interface{}, stored *elastic.GetResponse) (*elastic.InsertResponse, error) {
+	if stored.SeqNo != nil && stored.PrimaryTerm != nil {
+		url = fmt.Sprintf("%s?if_seq_no=%d&if_primary_term=%d", url, *stored.SeqNo, *stored.PrimaryTerm)
+	} else {
+		url = fmt.Sprintf("%s?version=%d", url, stored.Version)
+	}
+
+	js, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if global.Env().IsDebug {
+		log.Trace("indexing doc: ", url, ",", string(js))
+	}
+
+	resp, err := c.Request(util.Verb_PUT, url, js)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == 409 {
+		return nil, elastic.ErrVersionConflict
+	}
+	responseHandle(resp)
+
+	esResp := &elastic.InsertResponse{}
+	err = json.Unmarshal(resp.Body, esResp)
+	if err != nil {
+		return &elastic.InsertResponse{}, err
+	}
+	if !(esResp.Result == "created" || esResp.Result == "updated") {
+		return nil, errors.New(string(resp.Body))
+	}
+
+	return esResp, nil
+}
+
+// Update merge the partial document into the stored one by the update api
+func (c *ESAPIV0) Update(indexName string, id interface{}, partialDoc interface{}, upsert interface{}) (*elastic.UpdateResponse, error) {
+	if c.Config.IndexPrefix != "" {
+		indexName = c.Config.IndexPrefix + indexName
+	}
+	url := fmt.Sprintf("%s/%s/%s/%v/_update", c.Config.Endpoint, indexName, TypeName6, id)
+	return c.update(url, partialDoc, upsert)
+}
+
+// update send the partial document with retry_on_conflict, so that the update is retried by elasticsearch on the version conflict,
+// ErrVersionConflict is returned if all the retries are failed
+func (c *ESAPIV0) update(url string, partialDoc interface{}, upsert interface{}) (*elastic.UpdateResponse, error) {
+	retries := c.Config.RetryOnConflict
+	if retries <= 0 {
+		retries = defaultRetryOnConflict
+	}
+	url = fmt.Sprintf("%s?retry_on_conflict=%d", url, retries)
+
+	body := map[string]interface{}{"doc": partialDoc}
+	if upsert != nil {
+		body["upsert"] = upsert
+	}
+	js, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if global.Env().IsDebug {
+		log.Trace("updating doc: ", url, ",", string(js))
+	}
+
+	resp, err := c.Request(util.Verb_POST, url, js)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == 409 {
+		return nil, elastic.ErrVersionConflict
+	}
+	if resp.StatusCode != 200 && resp.StatusCode != 201 {
+		return nil, fmt.Errorf("update failed, %v: %s", resp.StatusCode, string(resp.Body))
+	}
+
+	esResp := &elastic.UpdateResponse{}
+	err = json.Unmarshal(resp.Body, esResp)
+	if err != nil {
+		return nil, err
+	}
+	return esResp, nil
+}
+
+func (c *ESAPIV0) UpdateByQuery(indexName string, request *elastic.ByQueryRequest) (*elastic.ByQueryResponse, error) {
+	return nil, errors.New("update by query is supported since elasticsearch 5.0")
+}
+
+func (c *ESAPIV0) DeleteByQuery(indexName string, request *elastic.ByQueryRequest) (*elastic.ByQueryResponse, error) {
+	return nil, errors.New("delete by query is supported since elasticsearch 5.0")
+}
+
 // Get fetch document by id
 func (c *ESAPIV0) Get(indexName, id string) (*elastic.GetResponse, error) {
 	if c.Config.IndexPrefix != "" {
@@ -368,6 +490,13 @@ func (c *ESAPIV0) ClusterVersion() *elastic.ClusterVersion {
 	return &c.Version
 }
 
+func (c *ESAPIV0) NodeStats() []elastic.NodeStats {
+	if c.Pool == nil {
+		return []elastic.NodeStats{}
+	}
+	return c.Pool.NodeStats()
+}
+
 func (c *ESAPIV0) ClusterHealth() *elastic.ClusterHealth {
 
 	url := fmt.Sprintf("%s/_cluster/health", c.Config.Endpoint)
@@ -391,25 +520,58 @@ func (c *ESAPIV0) ClusterHealth() *elastic.ClusterHealth {
 	return health
 }
 
-func (c *ESAPIV0) Bulk(data *bytes.Buffer) {
+func (c *ESAPIV0) Bulk(data *bytes.Buffer) (*elastic.BulkResponse, error) {
 	if data == nil || data.Len() == 0 {
-		return
+		return &elastic.BulkResponse{}, nil
 	}
 
 	data.WriteRune('\n')
 
-	url := fmt.Sprintf("%s/_bulk", c.Config.Endpoint)
+	esResp, err := c.doBulk(data.Bytes())
+	if err != nil {
+		return nil, err
+	}
 
-	resp, err := c.Request(util.Verb_POST, url, data.Bytes())
+	data.Reset()
+	return esResp, nil
+}
+
+func (c *ESAPIV0) BulkRequest(actions []elastic.BulkAction) (*elastic.BulkResponse, error) {
+	return c.bulkRequest(actions, TypeName6)
+}
 
+func (c *ESAPIV0) bulkRequest(actions []elastic.BulkAction, typeName string) (*elastic.BulkResponse, error) {
+	body, err := elastic.EncodeBulkActions(actions, c.Config.IndexPrefix, typeName)
 	if err != nil {
-		panic(err)
-		return
+		return nil, err
 	}
 
-	responseHandle(resp)
+	esResp, err := c.doBulk(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(esResp.Items) != len(actions) {
+		return nil, fmt.Errorf("invalid bulk response, %v items for %v actions", len(esResp.Items), len(actions))
+	}
+	return esResp, nil
+}
 
-	data.Reset()
+func (c *ESAPIV0) doBulk(body []byte) (*elastic.BulkResponse, error) {
+	url := fmt.Sprintf("%s/_bulk", c.Config.Endpoint)
+	resp, err := c.Request(util.Verb_POST, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("bulk request failed, %v: %s", resp.StatusCode, string(resp.Body))
+	}
+
+	esResp := &elastic.BulkResponse{}
+	err = json.Unmarshal(resp.Body, esResp)
+	if err != nil {
+		return nil, err
+	}
+	return esResp, nil
 }
 
 func (c *ESAPIV0) GetIndexSettings(indexNames string) (*elastic.Indexes, error) {
@@ -642,6 +804,93 @@ func (c *ESAPIV0) CreateIndex(indexName string, settings map[string]interface{})
 	return err
 }
 
+func (c *ESAPIV0) GetAliases(indexNames string) (map[string][]string, error) {
+	url := fmt.Sprintf("%s/%s/_alias", c.Config.Endpoint, c.Config.IndexPrefix+indexNames)
+
+	resp, err := c.Request(util.Verb_GET, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	aliases := map[string][]string{}
+	if resp.StatusCode == 404 {
+		return aliases, nil
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("get aliases failed, %v: %s", resp.StatusCode, string(resp.Body))
+	}
+
+	indices := map[string]struct {
+		Aliases map[string]interface{} `json:"aliases"`
+	}{}
+	err = json.Unmarshal(resp.Body, &indices)
+	if err != nil {
+		return nil, err
+	}
+
+	for index, v := range indices {
+		names := []string{}
+		for alias := range v.Aliases {
+			names = append(names, strings.TrimPrefix(alias, c.Config.IndexPrefix))
+		}
+		aliases[strings.TrimPrefix(index, c.Config.IndexPrefix)] = names
+	}
+	return aliases, nil
+}
+
+func (c *ESAPIV0) AddAlias(indexName, alias string) error {
+	return c.UpdateAliases([]elastic.AliasAction{{Type: elastic.AliasAdd, Index: indexName, Alias: alias}})
+}
+
+func (c *ESAPIV0) RemoveAlias(indexName, alias string) error {
+	return c.UpdateAliases([]elastic.AliasAction{{Type: elastic.AliasRemove, Index: indexName, Alias: alias}})
+}
+
+func (c *ESAPIV0) SwapAlias(alias, oldIndex, newIndex string) error {
+	return c.UpdateAliases([]elastic.AliasAction{
+		{Type: elastic.AliasRemove, Index: oldIndex, Alias: alias},
+		{Type: elastic.AliasAdd, Index: newIndex, Alias: alias},
+	})
+}
+
+func (c *ESAPIV0) UpdateAliases(actions []elastic.AliasAction) error {
+	body := []map[string]interface{}{}
+	for _, action := range actions {
+		if action.Type != elastic.AliasAdd && action.Type != elastic.AliasRemove {
+			return fmt.Errorf("unknown alias action: %s", action.Type)
+		}
+		body = append(body, map[string]interface{}{
+			string(action.Type): map[string]string{
+				"index": c.Config.IndexPrefix + action.Index,
+				"alias": c.Config.IndexPrefix + action.Alias,
+			},
+		})
+	}
+
+	data, err := json.Marshal(map[string]interface{}{"actions": body})
+	if err != nil {
+		return err
+	}
+
+	if global.Env().IsDebug {
+		log.Trace("update aliases: ", string(data))
+	}
+
+	url := fmt.Sprintf("%s/_aliases", c.Config.Endpoint)
+	resp, err := c.Request(util.Verb_POST, url, data)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("update aliases failed, %v: %s", resp.StatusCode, string(resp.Body))
+	}
+	return nil
+}
+
+func (c *ESAPIV0) Rollover(alias, newIndex string, conditions *elastic.RolloverConditions) (*elastic.RolloverResponse, error) {
+	return nil, errors.New("rollover is supported since elasticsearch 5.0")
+}
+
 func (s *ESAPIV0) Refresh(name string) (err error) {
 	if s.Config.IndexPrefix != "" {
 		name = s.Config.IndexPrefix + name
@@ -24,6 +24,7 @@ import (
 	"github.com/huminghe/infini-framework/core/elastic"
 	"github.com/huminghe/infini-framework/core/util"
 	"strings"
+	"time"
 )
 
 type ESAPIV5 struct {
@@ -38,8 +39,8 @@ func (s *ESAPIV5) ClusterHealth() *elastic.ClusterHealth {
 	return s.ESAPIV0.ClusterHealth()
 }
 
-func (s *ESAPIV5) Bulk(data *bytes.Buffer) {
-	s.ESAPIV0.Bulk(data)
+func (s *ESAPIV5) Bulk(data *bytes.Buffer) (*elastic.BulkResponse, error) {
+	return s.ESAPIV0.Bulk(data)
 }
 
 func (s *ESAPIV5) GetIndexSettings(indexNames string) (*elastic.Indexes, error) {
@@ -73,37 +74,9 @@ func (s *ESAPIV5) Refresh(name string) (err error) {
 func (s *ESAPIV5) NewScroll(indexNames string, scrollTime string, docBufferCount int, query string, slicedId, maxSlicedCount int, fields string) (scroll interface{}, err error) {
 	url := fmt.Sprintf("%s/%s/_search?scroll=%s&size=%d", s.Config.Endpoint, indexNames, scrollTime, docBufferCount)
 
-	var jsonBody []byte
-	if len(query) > 0 || maxSlicedCount > 0 || len(fields) > 0 {
-		queryBody := map[string]interface{}{}
-
-		if len(fields) > 0 {
-			if !strings.Contains(fields, ",") {
-				return nil, errors.New("")
-			} else {
-				queryBody["_source"] = strings.Split(fields, ",")
-			}
-		}
-
-		if len(query) > 0 {
-			queryBody["query"] = map[string]interface{}{}
-			queryBody["query"].(map[string]interface{})["query_string"] = map[string]interface{}{}
-			queryBody["query"].(map[string]interface{})["query_string"].(map[string]interface{})["query"] = query
-		}
-
-		if maxSlicedCount > 1 {
-			//log.Tracef("sliced scroll, %d of %d",slicedId,maxSlicedCount)
-			queryBody["slice"] = map[string]interface{}{}
-			queryBody["slice"].(map[string]interface{})["id"] = slicedId
-			queryBody["slice"].(map[string]interface{})["max"] = maxSlicedCount
-		}
-
-		jsonArray, err := json.Marshal(queryBody)
-		if err != nil {
-			panic(err)
-		} else {
-			jsonBody = jsonArray
-		}
+	jsonBody, err := getScrollBody(query, slicedId, maxSlicedCount, fields)
+	if err != nil {
+		return nil, err
 	}
 
 	resp, err := s.Request(util.Verb_POST, url, jsonBody)
@@ -132,6 +105,30 @@ func (s *ESAPIV5) NewScroll(indexNames string, scrollTime string, docBufferCount
 	return scroll, err
 }
 
+// getScrollBody return the body of sliced scroll with the query string and source fields, the fields are separated by `,`
+func getScrollBody(query string, slicedId, maxSlicedCount int, fields string) ([]byte, error) {
+	if len(query) == 0 && maxSlicedCount <= 1 && len(fields) == 0 {
+		return nil, nil
+	}
+
+	queryBody := map[string]interface{}{}
+	if len(fields) > 0 {
+		queryBody["_source"] = strings.Split(fields, ",")
+	}
+
+	if len(query) > 0 {
+		queryBody["query"] = map[string]interface{}{
+			"query_string": map[string]interface{}{"query": query},
+		}
+	}
+
+	if maxSlicedCount > 1 {
+		queryBody["slice"] = map[string]interface{}{"id": slicedId, "max": maxSlicedCount}
+	}
+
+	return json.Marshal(queryBody)
+}
+
 func (s *ESAPIV5) NextScroll(scrollTime string, scrollId string) (interface{}, error) {
 	id := bytes.NewBufferString(scrollId)
 
@@ -163,3 +160,172 @@ func (s *ESAPIV5) TemplateExists(templateName string) (bool, error) {
 func (s *ESAPIV5) PutTemplate(templateName string, template []byte) ([]byte, error) {
 	return s.ESAPIV0.PutTemplate(templateName, template)
 }
+
+func (s *ESAPIV5) Rollover(alias, newIndex string, conditions *elastic.RolloverConditions) (*elastic.RolloverResponse, error) {
+	url := fmt.Sprintf("%s/%s/_rollover", s.Config.Endpoint, s.Config.IndexPrefix+alias)
+	if newIndex != "" {
+		url = url + "/" + s.Config.IndexPrefix + newIndex
+	}
+
+	var body []byte
+	if conditions != nil {
+		data, err := json.Marshal(map[string]interface{}{"conditions": conditions})
+		if err != nil {
+			return nil, err
+		}
+		body = data
+	}
+
+	resp, err := s.Request(util.Verb_POST, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("rollover failed, %v: %s", resp.StatusCode, string(resp.Body))
+	}
+
+	rollover := &elastic.RolloverResponse{}
+	err = json.Unmarshal(resp.Body, rollover)
+	if err != nil {
+		return nil, err
+	}
+	rollover.OldIndex = strings.TrimPrefix(rollover.OldIndex, s.Config.IndexPrefix)
+	rollover.NewIndex = strings.TrimPrefix(rollover.NewIndex, s.Config.IndexPrefix)
+	return rollover, nil
+}
+
+// taskPollInterval is the interval to check whether the task is completed
+var taskPollInterval = time.Second
+
+// defaultTaskTimeout is the time to wait for the task, which is cancelled if it is not completed in time
+const defaultTaskTimeout = time.Hour
+
+// inlineScript is the script before elasticsearch 5.6, the source of which is named as `inline`
+type inlineScript struct {
+	Inline string                 `json:"inline"`
+	Lang   string                 `json:"lang,omitempty"`
+	Params map[string]interface{} `json:"params,omitempty"`
+}
+
+// UpdateByQuery start the task of update by query, and wait for it to be completed
+func (s *ESAPIV5) UpdateByQuery(indexName string, request *elastic.ByQueryRequest) (*elastic.ByQueryResponse, error) {
+	return s.byQuery("_update_by_query", indexName, request)
+}
+
+// DeleteByQuery start the task of delete by query, and wait for it to be completed
+func (s *ESAPIV5) DeleteByQuery(indexName string, request *elastic.ByQueryRequest) (*elastic.ByQueryResponse, error) {
+	if request != nil && request.Script != nil {
+		return nil, errors.New("the script is not supported by delete by query")
+	}
+	return s.byQuery("_delete_by_query", indexName, request)
+}
+
+// byQuery start the task without waiting for completion, as it may take longer than the timeout of request,
+// and poll the task until it is completed
+func (s *ESAPIV5) byQuery(api, indexName string, request *elastic.ByQueryRequest) (*elastic.ByQueryResponse, error) {
+	if request == nil {
+		request = &elastic.ByQueryRequest{}
+	}
+
+	url := fmt.Sprintf("%s/%s/%s?wait_for_completion=false", s.Config.Endpoint, s.Config.IndexPrefix+indexName, api)
+	if request.ProceedOnConflicts {
+		url = url + "&conflicts=proceed"
+	}
+	if request.Slices > 1 {
+		url = fmt.Sprintf("%s&slices=%d", url, request.Slices)
+	}
+
+	body, err := json.Marshal(request)
+	if request.Script != nil && s.Version.GetMajorVersion() == 5 && s.Version.GetMinorVersion() < 6 {
+		v := map[string]interface{}{"script": inlineScript{Inline: request.Script.Source, Lang: request.Script.Lang, Params: request.Script.Params}}
+		if request.Query != nil {
+			v["query"] = request.Query
+		}
+		body, err = json.Marshal(v)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.Request(util.Verb_POST, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("%s failed, %v: %s", api, resp.StatusCode, string(resp.Body))
+	}
+
+	task := struct {
+		Task string `json:"task"`
+	}{}
+	err = json.Unmarshal(resp.Body, &task)
+	if err != nil {
+		return nil, err
+	}
+	if task.Task == "" {
+		return nil, fmt.Errorf("%s failed, no task was returned: %s", api, string(resp.Body))
+	}
+	timeout := request.Timeout
+	if timeout <= 0 {
+		timeout = defaultTaskTimeout
+	}
+	return s.waitForTask(task.Task, timeout)
+}
+
+// waitForTask poll the task until it is completed, the result is returned with error if any document is failed,
+// and the task is cancelled if it is not completed before the timeout
+func (s *ESAPIV5) waitForTask(taskID string, timeout time.Duration) (*elastic.ByQueryResponse, error) {
+	url := fmt.Sprintf("%s/_tasks/%s", s.Config.Endpoint, taskID)
+	deadline := time.Now().Add(timeout)
+	for {
+		resp, err := s.Request(util.Verb_GET, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != 200 {
+			return nil, fmt.Errorf("get task %s failed, %v: %s", taskID, resp.StatusCode, string(resp.Body))
+		}
+
+		task := struct {
+			Completed bool                     `json:"completed"`
+			Response  *elastic.ByQueryResponse `json:"response"`
+			Error     interface{}              `json:"error"`
+		}{}
+		err = json.Unmarshal(resp.Body, &task)
+		if err != nil {
+			return nil, err
+		}
+
+		if !task.Completed {
+			if time.Now().After(deadline) {
+				return nil, s.cancelTask(taskID, timeout)
+			}
+			time.Sleep(taskPollInterval)
+			continue
+		}
+		if task.Error != nil {
+			return nil, fmt.Errorf("task %s failed: %s", taskID, util.ToJson(task.Error, false))
+		}
+		if task.Response == nil {
+			return nil, fmt.Errorf("task %s is completed without response: %s", taskID, string(resp.Body))
+		}
+		if len(task.Response.Failures) > 0 {
+			failure := task.Response.Failures[0]
+			return task.Response, fmt.Errorf("task %s is completed with %v failures, the first is %s: %v", taskID, len(task.Response.Failures), failure.ID, failure.Cause)
+		}
+		return task.Response, nil
+	}
+}
+
+// cancelTask cancel the task which is not completed in time, and return the error of timeout
+func (s *ESAPIV5) cancelTask(taskID string, timeout time.Duration) error {
+	url := fmt.Sprintf("%s/_tasks/%s/_cancel", s.Config.Endpoint, taskID)
+	resp, err := s.Request(util.Verb_POST, url, nil)
+	if err != nil {
+		return fmt.Errorf("task %s is not completed in %v, and failed to cancel: %v", taskID, timeout, err)
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("task %s is not completed in %v, and failed to cancel, %v: %s", taskID, timeout, resp.StatusCode, string(resp.Body))
+	}
+	return fmt.Errorf("task %s is not completed in %v and was cancelled", taskID, timeout)
+}
@@ -25,7 +25,6 @@ import (
 	"github.com/huminghe/infini-framework/core/elastic"
 	"github.com/huminghe/infini-framework/core/global"
 	"github.com/huminghe/infini-framework/core/util"
-	"strings"
 )
 
 type ESAPIV7 struct {
@@ -184,6 +183,23 @@ func (c *ESAPIV7) Index(indexName string, id interface{}, data interface{}) (*el
 	return esResp, nil
 }
 
+func (c *ESAPIV7) IndexIfMatch(indexName string, id interface{}, data interface{}, stored *elastic.GetResponse) (*elastic.InsertResponse, error) {
+	if c.Config.IndexPrefix != "" {
+		indexName = c.Config.IndexPrefix + indexName
+	}
+	url := fmt.Sprintf("%s/%s/%s/%s", c.Config.Endpoint, indexName, TypeName7, id)
+	return c.indexIfMatch(url, data, stored)
+}
+
+// Update merge the partial document into the stored one by the typeless update api
+func (c *ESAPIV7) Update(indexName string, id interface{}, partialDoc interface{}, upsert interface{}) (*elastic.UpdateResponse, error) {
+	if c.Config.IndexPrefix != "" {
+		indexName = c.Config.IndexPrefix + indexName
+	}
+	url := fmt.Sprintf("%s/%s/_update/%v", c.Config.Endpoint, indexName, id)
+	return c.update(url, partialDoc, upsert)
+}
+
 func (c *ESAPIV7) UpdateMapping(indexName string, mappings []byte) ([]byte, error) {
 	if c.Config.IndexPrefix != "" {
 		indexName = c.Config.IndexPrefix + indexName
@@ -197,62 +213,30 @@ func (c *ESAPIV7) UpdateMapping(indexName string, mappings []byte) ([]byte, erro
 	if err != nil {
 		panic(err)
 	}
+	responseHandle(resp)
 
 	return resp.Body, err
 }
 
 func (c *ESAPIV7) NewScroll(indexNames string, scrollTime string, docBufferCount int, query string, slicedId, maxSlicedCount int, fields string) (scroll interface{}, err error) {
 	url := fmt.Sprintf("%s/%s/_search?scroll=%s&size=%d", c.Config.Endpoint, indexNames, scrollTime, docBufferCount)
-	var jsonBody []byte
-	if len(query) > 0 || maxSlicedCount > 0 || len(fields) > 0 {
-		queryBody := map[string]interface{}{}
-
-		if len(fields) > 0 {
-			if !strings.Contains(fields, ",") {
-				log.Error("The fields shoud be seraprated by ,")
-				return nil, errors.New("")
-			} else {
-				queryBody["_source"] = strings.Split(fields, ",")
-			}
-		}
-
-		if len(query) > 0 {
-			queryBody["query"] = map[string]interface{}{}
-			queryBody["query"].(map[string]interface{})["query_string"] = map[string]interface{}{}
-			queryBody["query"].(map[string]interface{})["query_string"].(map[string]interface{})["query"] = query
-		}
-
-		if maxSlicedCount > 1 {
-			log.Tracef("sliced scroll, %d of %d", slicedId, maxSlicedCount)
-			queryBody["slice"] = map[string]interface{}{}
-			queryBody["slice"].(map[string]interface{})["id"] = slicedId
-			queryBody["slice"].(map[string]interface{})["max"] = maxSlicedCount
-		}
 
-		jsonArray, err := json.Marshal(queryBody)
-		if err != nil {
-			log.Error(err)
-
-		} else {
-			jsonBody = jsonArray
-		}
-	}
-
-	if jsonBody == nil {
-		panic("scroll request is nil")
+	jsonBody, err := getScrollBody(query, slicedId, maxSlicedCount, fields)
+	if err != nil {
+		return nil, err
 	}
 
 	resp, err := c.Request(util.Verb_POST, url, jsonBody)
 
-	if resp.StatusCode != 200 {
-		return nil, errors.New(string(resp.Body))
-	}
-
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
 
+	if resp.StatusCode != 200 {
+		return nil, errors.New(string(resp.Body))
+	}
+
 	scroll = &elastic.ScrollResponseV7{}
 	err = json.Unmarshal(resp.Body, scroll)
 	if err != nil {
@@ -287,3 +271,8 @@ func (c *ESAPIV7) NextScroll(scrollTime string, scrollId string) (interface{}, e
 
 	return scroll, nil
 }
+
+// BulkRequest send the actions without type name, which is removed since 7.0
+func (c *ESAPIV7) BulkRequest(actions []elastic.BulkAction) (*elastic.BulkResponse, error) {
+	return c.bulkRequest(actions, "")
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/cihub/seelog"
+	"github.com/huminghe/infini-framework/core/elastic"
+	"github.com/huminghe/infini-framework/core/global"
+	"github.com/huminghe/infini-framework/core/util"
+)
+
+// ESAPIV8 is the adapter of elasticsearch 8.x, the types are removed completely,
+// and the legacy templates are replaced by the composable index templates
+type ESAPIV8 struct {
+	ESAPIV7
+}
+
+func (c *ESAPIV8) Init() {
+	c.initTemplate(c.Config.IndexPrefix)
+}
+
+func (c *ESAPIV8) getDefaultTemplate(indexPrefix string) string {
+	template := `
+{
+"index_patterns": ["%s*"],
+"template": {
+  "settings": {
+    "number_of_shards": %v,
+    "index.max_result_window":10000000
+  },
+  "mappings": {
+      "dynamic_templates": [
+        {
+          "strings": {
+            "match_mapping_type": "string",
+            "mapping": {
+              "type": "keyword",
+              "ignore_above": 256
+            }
+          }
+        }
+      ]
+  }
+}
+}
+`
+	return fmt.Sprintf(template, indexPrefix, 1)
+}
+
+func (c *ESAPIV8) initTemplate(indexPrefix string) {
+	if global.Env().IsDebug {
+		log.Trace("init elasticsearch template")
+	}
+	templateName := global.Env().GetAppLowercaseName()
+
+	if c.Config.TemplateName != "" {
+		templateName = c.Config.TemplateName
+	}
+
+	exist, err := c.TemplateExists(templateName)
+	if err != nil {
+		panic(err)
+	}
+	if !exist {
+		template := c.getDefaultTemplate(indexPrefix)
+		log.Trace("template: ", template)
+		res, err := c.PutTemplate(templateName, []byte(template))
+		if err != nil {
+			panic(err)
+		}
+		if global.Env().IsDebug {
+			log.Trace("put template response, %v", string(res))
+		}
+	}
+	log.Debugf("elasticsearch template successful initialized")
+
+}
+
+// TemplateExists check the composable index template
+func (c *ESAPIV8) TemplateExists(templateName string) (bool, error) {
+	url := fmt.Sprintf("%s/_index_template/%s", c.Config.Endpoint, templateName)
+	resp, err := c.Request(util.Verb_GET, url, nil)
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode == 404 {
+		return false, nil
+	}
+	if resp.StatusCode != 200 {
+		return false, fmt.Errorf("get template failed, %v: %s", resp.StatusCode, string(resp.Body))
+	}
+	return true, nil
+}
+
+// PutTemplate put the composable index template, the settings and mappings are in the `template` of it
+func (c *ESAPIV8) PutTemplate(templateName string, template []byte) ([]byte, error) {
+	url := fmt.Sprintf("%s/_index_template/%s", c.Config.Endpoint, templateName)
+	resp, err := c.Request(util.Verb_PUT, url, template)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("put template failed, %v: %s", resp.StatusCode, string(resp.Body))
+	}
+	return resp.Body, nil
+}
+
+// NextScroll send the scroll id in the body, which may be too long for the url
+func (c *ESAPIV8) NextScroll(scrollTime string, scrollId string) (interface{}, error) {
+	body, err := json.Marshal(map[string]string{"scroll": scrollTime, "scroll_id": scrollId})
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/_search/scroll", c.Config.Endpoint)
+	resp, err := c.Request(util.Verb_POST, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, errors.New(string(resp.Body))
+	}
+
+	scroll := &elastic.ScrollResponseV7{}
+	err = json.Unmarshal(resp.Body, scroll)
+	if err != nil {
+		return nil, err
+	}
+
+	return scroll, nil
+}
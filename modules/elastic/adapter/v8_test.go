@@ -0,0 +1,102 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"github.com/huminghe/infini-framework/core/elastic"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCapabilities(t *testing.T) {
+	var v0 elastic.API = new(ESAPIV0)
+	var v5 elastic.API = new(ESAPIV5)
+	var v7 elastic.API = new(ESAPIV7)
+	var v8 elastic.API = new(ESAPIV8)
+
+	assert.False(t, v0.HasCapability(elastic.CapabilitySlicedScroll))
+	assert.True(t, v5.HasCapability(elastic.CapabilitySlicedScroll))
+	assert.True(t, v5.HasCapability(elastic.CapabilityMappingTypes))
+	assert.False(t, v7.HasCapability(elastic.CapabilityMappingTypes))
+	assert.True(t, v7.HasCapability(elastic.CapabilityDocEndpoint))
+	assert.True(t, v7.HasCapability(elastic.CapabilityIDSort))
+	assert.False(t, v8.HasCapability(elastic.CapabilityIDSort))
+	assert.True(t, v8.HasCapability(elastic.CapabilityIndexTemplate))
+
+	//the fixed_interval is available since 7.2
+	assert.False(t, v5.HasCapability(elastic.CapabilityFixedInterval))
+	assert.False(t, v7.HasCapability(elastic.CapabilityFixedInterval))
+	v71, v72 := new(ESAPIV7), new(ESAPIV7)
+	v71.Version.Version.Number, v72.Version.Version.Number = "7.1.1", "7.2.0"
+	assert.False(t, v71.HasCapability(elastic.CapabilityFixedInterval))
+	assert.True(t, v72.HasCapability(elastic.CapabilityFixedInterval))
+	assert.True(t, v8.HasCapability(elastic.CapabilityFixedInterval))
+}
+
+func TestESAPIV8(t *testing.T) {
+	var method, path, body, auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		method, path, body, auth = r.Method, r.URL.Path, string(data), r.Header.Get("Authorization")
+		switch {
+		case r.URL.Path == "/_index_template/missing":
+			w.WriteHeader(404)
+			w.Write([]byte(`{"error":"not found"}`))
+		case r.URL.Path == "/_search/scroll":
+			w.Write([]byte(`{"_scroll_id":"next","hits":{"total":{"value":1,"relation":"eq"},"hits":[{"_index":"test","_id":"1","_source":{}}]}}`))
+		default:
+			w.Write([]byte(`{"acknowledged":true}`))
+		}
+	}))
+	defer server.Close()
+
+	api := new(ESAPIV8)
+	api.Config = elastic.ElasticsearchConfig{Endpoint: server.URL, APIKey: "a2V5"}
+
+	exist, err := api.TemplateExists("missing")
+	assert.Nil(t, err)
+	assert.False(t, exist)
+	assert.Equal(t, "ApiKey a2V5", auth)
+
+	_, err = api.PutTemplate("app", []byte(api.getDefaultTemplate("test_")))
+	assert.Nil(t, err)
+	assert.Equal(t, "/_index_template/app", path)
+	assert.True(t, strings.Contains(body, `"template"`))
+
+	scroll, err := api.NextScroll("1m", "current")
+	assert.Nil(t, err)
+	assert.Equal(t, "POST", method)
+	assert.JSONEq(t, `{"scroll":"1m","scroll_id":"current"}`, body)
+	assert.Equal(t, "next", scroll.(*elastic.ScrollResponseV7).GetScrollId())
+	assert.Equal(t, 1, scroll.(*elastic.ScrollResponseV7).GetHitsTotal())
+}
+
+func TestClusterVersionUnauthorized(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(401)
+		w.Write([]byte(`{"error":"missing authentication credentials"}`))
+	}))
+	defer server.Close()
+
+	_, err := ClusterVersion(&elastic.ElasticsearchConfig{Name: "es8", Endpoint: server.URL})
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "basic_auth or api_key"))
+}
@@ -23,29 +23,30 @@ import (
 	"github.com/huminghe/infini-framework/core/util"
 )
 
+// ClusterVersion return the version of cluster from the first available host
 func ClusterVersion(config *elastic.ElasticsearchConfig) (elastic.ClusterVersion, error) {
-
-	req := util.NewGetRequest(fmt.Sprintf("%s", config.Endpoint), nil)
-
-	req.SetContentType(util.ContentTypeJson)
-
-	if config.BasicAuth != nil {
-		req.SetBasicAuth(config.BasicAuth.Username, config.BasicAuth.Password)
-	}
-	if config.HttpProxy != "" {
-		req.SetProxy(config.HttpProxy)
-	}
-
-	response, err := util.ExecuteRequest(req)
-	if err != nil {
-		panic(err)
-	}
-
 	version := elastic.ClusterVersion{}
-	err = json.Unmarshal(response.Body, &version)
+	hosts := config.GetHosts()
+	if len(hosts) == 0 {
+		return version, fmt.Errorf("no host of elasticsearch %s", config.Name)
+	}
 
-	if err != nil {
-		panic(err)
+	var err error
+	for _, host := range hosts {
+		var response *util.Result
+		response, err = execute(config, util.Verb_GET, host, nil)
+		if err == nil && response.StatusCode == 401 {
+			//the security is enabled by default since 8.0
+			err = fmt.Errorf("failed to authenticate elasticsearch %s, the basic_auth or api_key is required, %s", config.Name, string(response.Body))
+		} else if err == nil && response.StatusCode != 200 {
+			err = fmt.Errorf("status: %v, %s", response.StatusCode, string(response.Body))
+		}
+		if err == nil {
+			err = json.Unmarshal(response.Body, &version)
+		}
+		if err == nil {
+			return version, nil
+		}
 	}
-	return version, nil
+	return version, err
 }
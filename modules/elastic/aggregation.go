@@ -0,0 +1,126 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elastic
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/huminghe/infini-framework/core/elastic"
+	"github.com/huminghe/infini-framework/core/errors"
+	api "github.com/huminghe/infini-framework/core/orm"
+	"time"
+)
+
+// Aggregate compute the aggregations with the native aggregations of elasticsearch
+func (handler ElasticORM) Aggregate(t interface{}, q *api.Query, aggs ...*api.Aggregation) (map[string]*api.AggregationResult, error) {
+	err := api.ValidateAggregations(aggs)
+	if err != nil {
+		return nil, err
+	}
+
+	request := map[string]interface{}{}
+	request["size"] = 0
+	request["aggs"] = getAggregations(aggs, handler.Client.HasCapability(elastic.CapabilityFixedInterval))
+
+	if q != nil && len(q.Conds) > 0 {
+		boolQuery, err := getBoolQuery(q.Conds)
+		if err != nil {
+			return nil, err
+		}
+		request["query"] = elastic.Query{BoolQuery: boolQuery}
+	}
+
+	dsl, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	searchResponse, err := handler.Client.SearchWithRawQueryDSL(getIndexName(t), dsl)
+	if err != nil {
+		return nil, err
+	}
+
+	return getAggregationResults(aggs, searchResponse.Aggregations)
+}
+
+// getAggregations return the native aggregations, the interval of date_histogram is set by `fixed_interval` if fixedInterval is true
+func getAggregations(aggs []*api.Aggregation, fixedInterval bool) map[string]*elastic.Aggregation {
+	result := map[string]*elastic.Aggregation{}
+	for _, agg := range aggs {
+		v := elastic.NewAggregation(string(agg.Type), agg.Field)
+		switch agg.Type {
+		case api.TermsAggregation:
+			v.Set("size", agg.GetSize())
+		case api.HistogramAggregation:
+			v.Set("interval", agg.Interval).Set("min_doc_count", 1)
+		case api.DateHistogramAggregation:
+			interval := "interval"
+			if fixedInterval {
+				interval = "fixed_interval"
+			}
+			v.Set(interval, fmt.Sprintf("%ds", int64(agg.DateInterval/time.Second))).Set("min_doc_count", 1)
+		}
+
+		for name, sub := range getAggregations(agg.Aggs, fixedInterval) {
+			v.SubAggregation(name, sub)
+		}
+		result[agg.Name] = v
+	}
+	return result
+}
+
+func getAggregationResults(aggs []*api.Aggregation, response map[string]elastic.AggregationResponse) (map[string]*api.AggregationResult, error) {
+	result := map[string]*api.AggregationResult{}
+	for _, agg := range aggs {
+		v, ok := response[agg.Name]
+		if !ok {
+			return nil, errors.Errorf("aggregation %s is missing in response", agg.Name)
+		}
+
+		if !agg.IsBucket() {
+			r := &api.AggregationResult{}
+			if f, ok := v.GetValue(); ok {
+				r.Value = &f
+			}
+			result[agg.Name] = r
+			continue
+		}
+
+		r := &api.AggregationResult{Buckets: []api.Bucket{}}
+		for _, b := range v.Buckets {
+			bucket := api.Bucket{DocCount: b.DocCount, Key: b.Key}
+			if agg.Type == api.DateHistogramAggregation {
+				f, ok := b.Key.(float64)
+				if !ok {
+					return nil, errors.Errorf("invalid key of aggregation %s: %v", agg.Name, b.Key)
+				}
+				bucket.Key = time.Unix(0, int64(f)*int64(time.Millisecond)).UTC()
+			}
+
+			if len(agg.Aggs) > 0 {
+				sub, err := getAggregationResults(agg.Aggs, b.Aggregations)
+				if err != nil {
+					return nil, err
+				}
+				bucket.Aggregations = sub
+			}
+			r.Buckets = append(r.Buckets, bucket)
+		}
+		result[agg.Name] = r
+	}
+	return result, nil
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elastic
+
+import (
+	"github.com/huminghe/infini-framework/core/api"
+	"github.com/huminghe/infini-framework/core/api/router"
+	"github.com/huminghe/infini-framework/core/errors"
+	"github.com/huminghe/infini-framework/core/ui"
+	"net/http"
+)
+
+// API namespace
+type API struct {
+	api.Handler
+}
+
+func (handler API) Init() {
+
+	//Elastic API, also registered to the ui server, so the admin ui is able to access
+	handle(api.GET, "/elastic/_reindex", api.PERMISSION_ELASTIC_READ, handler.listReindexAction)
+	handle(api.POST, "/elastic/_reindex", api.PERMISSION_ELASTIC_WRITE, handler.startReindexAction)
+	handle(api.GET, "/elastic/_reindex/:id", api.PERMISSION_ELASTIC_READ, handler.getReindexAction)
+	handle(api.POST, "/elastic/_reindex/:id/_resume", api.PERMISSION_ELASTIC_WRITE, handler.resumeReindexAction)
+	handle(api.POST, "/elastic/_reindex/:id/_cancel", api.PERMISSION_ELASTIC_WRITE, handler.cancelReindexAction)
+}
+
+func handle(method api.Method, pattern string, permission string, h httprouter.Handle) {
+	h = api.NeedPermission(permission, h)
+	api.HandleAPIMethod(method, pattern, h)
+	ui.HandleUIMethod(method, pattern, h)
+}
+
+func (handler API) listReindexAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	tasks, err := ListReindexTasks()
+	if err != nil {
+		handler.Error(w, err)
+		return
+	}
+	handler.WriteJSONListResult(w, len(tasks), tasks, http.StatusOK)
+}
+
+// startReindexAction start a reindex with the config in body, the task is returned with the id to check the progress,
+// 409 is returned if the unfinished task of the same name has a different config, it should be resumed by `_resume`
+func (handler API) startReindexAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	config := ReindexConfig{}
+	err := handler.DecodeJSON(req, &config)
+	if err != nil {
+		handler.WriteJSON(w, map[string]interface{}{"error": err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	task, err := RunReindex(config)
+	if errors.Cause(err) == errReindexConflict {
+		handler.WriteJSON(w, map[string]interface{}{"error": err.Error()}, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		handler.Error(w, err)
+		return
+	}
+	handler.WriteJSON(w, task.snapshot(), http.StatusCreated)
+}
+
+func (handler API) getReindexAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	task, err := GetReindexTask(ps.ByName("id"))
+	if err != nil {
+		handler.Error(w, err)
+		return
+	}
+	handler.WriteJSON(w, task, http.StatusOK)
+}
+
+func (handler API) resumeReindexAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	task, err := ResumeReindex(ps.ByName("id"))
+	if err != nil {
+		handler.Error(w, err)
+		return
+	}
+	handler.WriteJSON(w, task.snapshot(), http.StatusOK)
+}
+
+func (handler API) cancelReindexAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	err := CancelReindex(ps.ByName("id"))
+	if err != nil {
+		handler.Error(w, err)
+		return
+	}
+	handler.WriteAckJSON(w, true)
+}
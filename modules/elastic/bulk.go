@@ -0,0 +1,85 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elastic
+
+import (
+	"github.com/huminghe/infini-framework/core/elastic"
+	api "github.com/huminghe/infini-framework/core/orm"
+)
+
+func (handler ElasticORM) BulkSave(objects []interface{}, chunkSize int) ([]api.BulkResult, error) {
+	return handler.bulk(api.OpSave, objects, chunkSize)
+}
+
+// BulkUpdate index the objects in bulk, but the objects with version field are updated one by one,
+// as their stored versions are checked before indexing
+func (handler ElasticORM) BulkUpdate(objects []interface{}, chunkSize int) ([]api.BulkResult, error) {
+	return handler.bulk(api.OpUpdate, objects, chunkSize)
+}
+
+func (handler ElasticORM) BulkDelete(objects []interface{}, chunkSize int) ([]api.BulkResult, error) {
+	return handler.bulk(api.OpDelete, objects, chunkSize)
+}
+
+// bulk send a bulk request for every chunk, the deletion of missing document is not a failure, as it has no error
+func (handler ElasticORM) bulk(op api.Operation, objects []interface{}, chunkSize int) ([]api.BulkResult, error) {
+	return api.RunBulk(objects, chunkSize, func(chunk []interface{}) ([]api.BulkResult, error) {
+		results := make([]api.BulkResult, len(chunk))
+		actions := []elastic.BulkAction{}
+		index := []int{}
+
+		for i, o := range chunk {
+			results[i].ID = getIndexID(o)
+
+			action := elastic.BulkAction{Type: elastic.BulkIndex, Index: getIndexName(o), ID: results[i].ID, Doc: o}
+			switch op {
+			case api.OpSave:
+				api.InitVersion(o)
+			case api.OpUpdate:
+				if _, _, ok := api.GetVersion(o); ok {
+					results[i].Error = handler.Update(o)
+					continue
+				}
+			case api.OpDelete:
+				action.Type = elastic.BulkDelete
+				action.Doc = nil
+			}
+			actions = append(actions, action)
+			index = append(index, i)
+		}
+
+		if len(actions) == 0 {
+			return results, nil
+		}
+
+		response, err := handler.Client.BulkRequest(actions)
+		if err != nil {
+			return nil, err
+		}
+
+		for j, item := range response.GetItems() {
+			result := &results[index[j]]
+			if result.ID == "" {
+				result.ID = item.ID
+			}
+			if item.Error != nil {
+				result.Error = item.Error
+			}
+		}
+		return results, nil
+	})
+}
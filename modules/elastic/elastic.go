@@ -25,6 +25,7 @@ import (
 	"github.com/huminghe/infini-framework/core/global"
 	"github.com/huminghe/infini-framework/core/kv"
 	"github.com/huminghe/infini-framework/core/orm"
+	"github.com/huminghe/infini-framework/core/util"
 	"github.com/huminghe/infini-framework/modules/elastic/adapter"
 	"strings"
 )
@@ -36,6 +37,7 @@ func (module ElasticModule) Name() string {
 var (
 	defaultConfig = ModuleConfig{
 		Elasticsearch: "default",
+		APIEnabled:    true,
 	}
 )
 
@@ -48,9 +50,22 @@ type ModuleConfig struct {
 	StoreEnabled   bool   `config:"store_enabled"`
 	ORMEnabled     bool   `config:"orm_enabled"`
 	Elasticsearch  string `config:"elasticsearch"`
+	//Indexers the indexers of channels, there is one indexer of channel `index` if it is not set
+	Indexers []IndexerConfig `config:"indexers"`
+	//Reindex the reindex which could be run at startup by the names
+	Reindex    []ReindexConfig `config:"reindex"`
+	APIEnabled bool            `config:"api_enabled"`
+	//Lifecycles the time-based indices which are rolled over and deleted after the retention period
+	Lifecycles []LifecycleConfig `config:"lifecycles"`
 }
 
-var indexer *ElasticIndexer
+var indexers []*ElasticIndexer
+
+var pools []*adapter.NodePool
+
+var reindexConfigs []ReindexConfig
+
+var ormEnabled bool
 
 var m = map[string]elastic.ElasticsearchConfig{}
 
@@ -79,44 +94,81 @@ func loadElasticConfig() {
 	}
 }
 
+// newElasticClient detect the version of the cluster and create the client of that version
+func newElasticClient(esConfig elastic.ElasticsearchConfig) (elastic.API, error) {
+	var client elastic.API
+	hosts := esConfig.GetHosts()
+	if len(hosts) == 0 {
+		return nil, errors.Errorf("no host of elasticsearch %s", esConfig.Name)
+	}
+	if esConfig.Endpoint == "" {
+		esConfig.Endpoint = hosts[0]
+	}
+	esVersion, err := adapter.ClusterVersion(&esConfig)
+	if err != nil {
+		return nil, err
+	}
+	major, err := util.ToInt(strings.Split(esVersion.Version.Number, ".")[0])
+	if err != nil {
+		return nil, errors.Errorf("invalid version of elasticsearch %s: %s", esConfig.Name, esVersion.Version.Number)
+	}
+	pool := adapter.NewNodePool(esConfig)
+	pool.Start()
+	pools = append(pools, pool)
+	if global.Env().IsDebug {
+		log.Debug("elasticsearch version: ", esVersion.Version.Number)
+	}
+
+	//the newer versions are assumed to be compatible with the latest adapter
+	switch {
+	case major >= 8:
+		if major > 8 {
+			log.Warnf("elasticsearch %s is not supported, the adapter of 8.x is used", esVersion.Version.Number)
+		}
+		api := new(adapter.ESAPIV8)
+		api.Config = esConfig
+		api.Version = esVersion
+		api.Pool = pool
+		client = api
+	case major == 7:
+		api := new(adapter.ESAPIV7)
+		api.Config = esConfig
+		api.Version = esVersion
+		api.Pool = pool
+		client = api
+	case major == 6:
+		api := new(adapter.ESAPIV6)
+		api.Config = esConfig
+		api.Version = esVersion
+		api.Pool = pool
+		client = api
+	case major == 5:
+		api := new(adapter.ESAPIV5)
+		api.Config = esConfig
+		api.Version = esVersion
+		api.Pool = pool
+		client = api
+	default:
+		api := new(adapter.ESAPIV0)
+		api.Config = esConfig
+		api.Version = esVersion
+		api.Pool = pool
+		client = api
+	}
+	return client, nil
+}
+
 func initElasticInstances() {
 
 	for k, esConfig := range m {
 
-		var client elastic.API
 		if !esConfig.Enabled {
 			log.Warn("elasticsearch ", esConfig.Name, " is not enabled")
 			continue
 		}
-		esVersion, err := adapter.ClusterVersion(&esConfig)
+		client, err := newElasticClient(esConfig)
 		if err != nil {
 			panic(err)
-			return
-		}
-		if global.Env().IsDebug {
-			log.Debug("elasticsearch version: ", esVersion.Version.Number)
-		}
-
-		if strings.HasPrefix(esVersion.Version.Number, "7.") {
-			api := new(adapter.ESAPIV7)
-			api.Config = esConfig
-			api.Version = esVersion
-			client = api
-		} else if strings.HasPrefix(esVersion.Version.Number, "6.") {
-			api := new(adapter.ESAPIV6)
-			api.Config = esConfig
-			api.Version = esVersion
-			client = api
-		} else if strings.HasPrefix(esVersion.Version.Number, "5.") {
-			api := new(adapter.ESAPIV5)
-			api.Config = esConfig
-			api.Version = esVersion
-			client = api
-		} else {
-			api := new(adapter.ESAPIV0)
-			api.Config = esConfig
-			api.Version = esVersion
-			client = api
 		}
 		elastic.RegisterInstance(k, esConfig, client)
 	}
@@ -144,6 +196,7 @@ func (module ElasticModule) Setup(cfg *config.Config) {
 		handler := ElasticORM{Client: client}
 		handler.Client.Init()
 		orm.Register("elastic", handler)
+		ormEnabled = true
 	}
 
 	if moduleConfig.StoreEnabled {
@@ -151,27 +204,98 @@ func (module ElasticModule) Setup(cfg *config.Config) {
 		kv.Register("elastic", handler)
 	}
 
+	reindexConfigs = moduleConfig.Reindex
+
+	for _, v := range moduleConfig.Lifecycles {
+		if v.Index == "" {
+			panic(errors.Errorf("invalid lifecycle config, %v", v))
+		}
+		id := moduleConfig.Elasticsearch
+		if v.Elasticsearch != "" {
+			id = v.Elasticsearch
+		}
+		lifecycles[v.Index] = NewLifecycleManager(elastic.GetClient(id), elastic.GetConfig(id).IndexPrefix, v)
+	}
+
+	if moduleConfig.APIEnabled {
+		handler := API{}
+		handler.Init()
+	}
+
 	if moduleConfig.IndexerEnabled {
-		indexer = &ElasticIndexer{client: client, indexChannel: "index"}
+		configs := moduleConfig.Indexers
+		if len(configs) == 0 {
+			configs = []IndexerConfig{defaultIndexerConfig}
+		}
+		for _, v := range configs {
+			indexerClient := client
+			if v.Elasticsearch != "" {
+				indexerClient = elastic.GetClient(v.Elasticsearch)
+			}
+			indexers = append(indexers, NewElasticIndexer(indexerClient, v))
+		}
 	}
 
 }
 
 func (module ElasticModule) Stop() error {
-	if indexer != nil {
+	for _, indexer := range indexers {
 		indexer.Stop()
 	}
+	for _, manager := range lifecycles {
+		manager.Stop()
+	}
+	for _, pool := range pools {
+		pool.Stop()
+	}
 	return nil
 
 }
 
 func (module ElasticModule) Start() error {
-	if indexer != nil {
+	//run migrations after all the modules and plugins are setup, which may register migrations
+	if ormEnabled {
+		err := orm.MigrateOnStartup("elastic")
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	//the write indices are bootstrapped before the indexers start
+	for _, manager := range lifecycles {
+		manager.Start()
+	}
+
+	for _, indexer := range indexers {
 		indexer.Start()
 	}
+
+	if global.Env().Reindex != "" {
+		runReindexOnStartup(global.Env().Reindex)
+	}
 	return nil
 
 }
 
+// runReindexOnStartup run the reindex of the names in background
+func runReindexOnStartup(names string) {
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, config := range reindexConfigs {
+			if config.Name != name {
+				continue
+			}
+			found = true
+			if _, err := RunReindex(config); err != nil {
+				log.Errorf("failed to run reindex %s, %v", name, err)
+			}
+		}
+		if !found {
+			log.Errorf("reindex %s was not found", name)
+		}
+	}
+}
+
 type ElasticModule struct {
 }
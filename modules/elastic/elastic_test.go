@@ -0,0 +1,68 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elastic
+
+import (
+	"fmt"
+	"github.com/huminghe/infini-framework/core/elastic"
+	"github.com/huminghe/infini-framework/core/env"
+	"github.com/huminghe/infini-framework/core/global"
+	"github.com/huminghe/infini-framework/modules/elastic/adapter"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestNewElasticClient(t *testing.T) {
+	global.RegisterEnv(env.EmptyEnv())
+
+	versions := map[string]elastic.API{
+		"1.7.6":  &adapter.ESAPIV0{},
+		"5.6.16": &adapter.ESAPIV5{},
+		"6.8.23": &adapter.ESAPIV6{},
+		"7.17.9": &adapter.ESAPIV7{},
+		"8.6.2":  &adapter.ESAPIV8{},
+		"9.0.0":  &adapter.ESAPIV8{},
+	}
+	for version, expected := range versions {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(fmt.Sprintf(`{"version":{"number":"%s"}}`, version)))
+		}))
+
+		client, err := newElasticClient(elastic.ElasticsearchConfig{Name: "test", Endpoint: server.URL})
+		assert.Nil(t, err, version)
+		assert.Equal(t, reflect.TypeOf(expected), reflect.TypeOf(client), version)
+		assert.Equal(t, version, client.ClusterVersion().Version.Number)
+		server.Close()
+	}
+	for _, pool := range pools {
+		pool.Stop()
+	}
+	pools = nil
+}
+
+func TestGetIndexIDField(t *testing.T) {
+	assert.Equal(t, "host", getIndexIDField(&MyHost{}))
+	assert.Equal(t, "", getIndexIDField(struct {
+		ID string `json:"-" elastic_meta:"_id"`
+	}{}))
+	assert.Equal(t, "ID", getIndexIDField(struct {
+		ID string `elastic_meta:"_id"`
+	}{}))
+}
@@ -0,0 +1,546 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elastictest
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// aggregate compute the aggregations of the matched documents, the background of significant_terms is all the documents
+// of the searched indices. The bucket aggregations are terms, significant_terms, histogram, date_histogram, range, filter
+// and filters, the metric aggregations are min, max, avg, sum, value_count, cardinality, stats, percentiles and top_hits.
+func (s *Server) aggregate(aggs map[string]interface{}, docs, background []*document) (map[string]interface{}, *esError) {
+	result := map[string]interface{}{}
+	for name, v := range aggs {
+		spec, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, newError(400, "parsing_exception", "Expected [START_OBJECT] under [%s], but got a [%v]", name, v)
+		}
+		aggType, params, subAggs, err := parseAggregation(name, spec)
+		if err != nil {
+			return nil, err
+		}
+
+		var response map[string]interface{}
+		switch aggType {
+		case "terms":
+			response, err = s.terms(params, subAggs, docs, background)
+		case "significant_terms":
+			response, err = s.significantTerms(params, subAggs, docs, background)
+		case "histogram", "date_histogram":
+			response, err = s.histogram(aggType, params, subAggs, docs, background)
+		case "range":
+			response, err = s.rangeAggregation(params, subAggs, docs, background)
+		case "filter":
+			response, err = s.filter(params, subAggs, docs, background)
+		case "filters":
+			response, err = s.filters(params, subAggs, docs, background)
+		case "top_hits":
+			response = s.topHits(params, docs)
+		default:
+			response, err = metric(aggType, params, docs)
+		}
+		if err != nil {
+			return nil, err
+		}
+		result[name] = response
+	}
+	return result, nil
+}
+
+// parseAggregation return the type, parameters and sub aggregations of the aggregation
+func parseAggregation(name string, spec map[string]interface{}) (string, map[string]interface{}, map[string]interface{}, *esError) {
+	var aggType string
+	var params, subAggs map[string]interface{}
+	for k, v := range spec {
+		switch k {
+		case "aggs", "aggregations":
+			subAggs, _ = v.(map[string]interface{})
+		case "meta":
+		default:
+			if aggType != "" {
+				return "", nil, nil, newError(400, "parsing_exception", "Found two aggregation type definitions in [%s]: [%s] and [%s]", name, aggType, k)
+			}
+			aggType = k
+			params, _ = v.(map[string]interface{})
+		}
+	}
+	if aggType == "" {
+		return "", nil, nil, newError(400, "parsing_exception", "Missing definition for aggregation [%s]", name)
+	}
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	return aggType, params, subAggs, nil
+}
+
+// bucket return the bucket of the documents with the sub aggregations
+func (s *Server) bucket(key interface{}, docs []*document, subAggs map[string]interface{}, background []*document) (map[string]interface{}, *esError) {
+	bucket := map[string]interface{}{"key": key, "doc_count": len(docs)}
+	if len(subAggs) > 0 {
+		sub, err := s.aggregate(subAggs, docs, background)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range sub {
+			bucket[k] = v
+		}
+	}
+	return bucket, nil
+}
+
+// termBucket is the documents of a term
+type termBucket struct {
+	key  interface{}
+	docs []*document
+}
+
+// groupByTerm group the documents by the values of field, the document of multiple values is in every bucket
+func groupByTerm(docs []*document, field string) []*termBucket {
+	groups := map[string]*termBucket{}
+	buckets := []*termBucket{}
+	for _, doc := range docs {
+		seen := map[string]bool{}
+		for _, v := range fieldValues(doc, field) {
+			if _, ok := v.(map[string]interface{}); ok {
+				continue
+			}
+			id := fmt.Sprintf("%T:%v", v, v)
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			group, ok := groups[id]
+			if !ok {
+				group = &termBucket{key: v}
+				groups[id] = group
+				buckets = append(buckets, group)
+			}
+			group.docs = append(group.docs, doc)
+		}
+	}
+	return buckets
+}
+
+// termKey set the key of the term bucket, the booleans are keyed by 1 and 0 as elasticsearch does
+func termKey(bucket map[string]interface{}, key interface{}) {
+	if v, ok := key.(bool); ok {
+		bucket["key"] = 0
+		if v {
+			bucket["key"] = 1
+		}
+		bucket["key_as_string"] = strconv.FormatBool(v)
+		return
+	}
+	bucket["key"] = key
+}
+
+// compareKeys order the numbers before the strings
+func compareKeys(a, b interface{}) bool {
+	x, ok1 := a.(float64)
+	y, ok2 := b.(float64)
+	if ok1 && ok2 {
+		return x < y
+	}
+	if ok1 != ok2 {
+		return ok1
+	}
+	return fmt.Sprint(a) < fmt.Sprint(b)
+}
+
+// terms return the most frequent terms, which are ordered by the count and then the key
+func (s *Server) terms(params, subAggs map[string]interface{}, docs, background []*document) (map[string]interface{}, *esError) {
+	field, _ := params["field"].(string)
+	size := intParam(params, "size", 10)
+	minCount := intParam(params, "min_doc_count", 1)
+
+	groups := groupByTerm(docs, field)
+	sort.SliceStable(groups, func(i, j int) bool {
+		if len(groups[i].docs) != len(groups[j].docs) {
+			return len(groups[i].docs) > len(groups[j].docs)
+		}
+		return compareKeys(groups[i].key, groups[j].key)
+	})
+
+	buckets := []interface{}{}
+	others := 0
+	for _, group := range groups {
+		if len(group.docs) < minCount {
+			continue
+		}
+		if len(buckets) >= size {
+			others += len(group.docs)
+			continue
+		}
+		bucket, err := s.bucket(nil, group.docs, subAggs, background)
+		if err != nil {
+			return nil, err
+		}
+		termKey(bucket, group.key)
+		buckets = append(buckets, bucket)
+	}
+	return map[string]interface{}{"doc_count_error_upper_bound": 0, "sum_other_doc_count": others, "buckets": buckets}, nil
+}
+
+// significantTerms return the terms more frequent in the documents than in the background, which are scored by JLH
+func (s *Server) significantTerms(params, subAggs map[string]interface{}, docs, background []*document) (map[string]interface{}, *esError) {
+	field, _ := params["field"].(string)
+	size := intParam(params, "size", 10)
+	minCount := intParam(params, "min_doc_count", 3)
+
+	backgroundCounts := map[string]int{}
+	for _, group := range groupByTerm(background, field) {
+		backgroundCounts[fmt.Sprintf("%T:%v", group.key, group.key)] = len(group.docs)
+	}
+
+	type scored struct {
+		*termBucket
+		score   float64
+		bgCount int
+	}
+	candidates := []scored{}
+	for _, group := range groupByTerm(docs, field) {
+		if len(group.docs) < minCount {
+			continue
+		}
+		bgCount := backgroundCounts[fmt.Sprintf("%T:%v", group.key, group.key)]
+		fg := float64(len(group.docs)) / float64(len(docs))
+		bg := float64(bgCount) / float64(len(background))
+		if bgCount == 0 || fg <= bg {
+			continue
+		}
+		candidates = append(candidates, scored{termBucket: group, score: (fg - bg) * (fg / bg), bgCount: bgCount})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		return compareKeys(candidates[i].key, candidates[j].key)
+	})
+	if len(candidates) > size {
+		candidates = candidates[:size]
+	}
+
+	buckets := []interface{}{}
+	for _, candidate := range candidates {
+		bucket, err := s.bucket(nil, candidate.docs, subAggs, background)
+		if err != nil {
+			return nil, err
+		}
+		termKey(bucket, candidate.key)
+		bucket["score"] = candidate.score
+		bucket["bg_count"] = candidate.bgCount
+		buckets = append(buckets, bucket)
+	}
+	return map[string]interface{}{"doc_count": len(docs), "bg_count": len(background), "buckets": buckets}, nil
+}
+
+// histogram return the buckets of the fixed interval, the empty buckets between the keys are filled if min_doc_count is 0
+func (s *Server) histogram(aggType string, params, subAggs map[string]interface{}, docs, background []*document) (map[string]interface{}, *esError) {
+	field, _ := params["field"].(string)
+	minCount := intParam(params, "min_doc_count", 0)
+
+	var interval float64
+	if aggType == "date_histogram" {
+		var err *esError
+		if interval, err = s.dateInterval(params); err != nil {
+			return nil, err
+		}
+	} else {
+		interval, _ = toNumber(params["interval"])
+	}
+	if interval <= 0 {
+		return nil, newError(400, "illegal_argument_exception", "[interval] must be >0 for histogram aggregation [%s]", field)
+	}
+
+	groups := map[float64][]*document{}
+	keys := []float64{}
+	for _, doc := range docs {
+		seen := map[float64]bool{}
+		for _, v := range fieldValues(doc, field) {
+			f, ok := toNumber(v)
+			if !ok {
+				continue
+			}
+			key := math.Floor(f/interval) * interval
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			if _, ok := groups[key]; !ok {
+				keys = append(keys, key)
+			}
+			groups[key] = append(groups[key], doc)
+		}
+	}
+	sort.Float64s(keys)
+	if minCount == 0 && len(keys) > 1 {
+		filled := []float64{}
+		for key := keys[0]; key <= keys[len(keys)-1]; key += interval {
+			filled = append(filled, key)
+		}
+		keys = filled
+	}
+
+	buckets := []interface{}{}
+	for _, key := range keys {
+		if len(groups[key]) < minCount {
+			continue
+		}
+		bucket, err := s.bucket(key, groups[key], subAggs, background)
+		if err != nil {
+			return nil, err
+		}
+		if aggType == "date_histogram" {
+			bucket["key_as_string"] = time.Unix(0, int64(key)*int64(time.Millisecond)).UTC().Format("2006-01-02T15:04:05.000Z")
+		}
+		buckets = append(buckets, bucket)
+	}
+	return map[string]interface{}{"buckets": buckets}, nil
+}
+
+// dateInterval return the milliseconds of the fixed interval of date_histogram, the calendar intervals are not supported,
+// the `fixed_interval` is added in 7.2 and the `interval` is removed in 8.0
+func (s *Server) dateInterval(params map[string]interface{}) (float64, *esError) {
+	v, ok := params["interval"]
+	if ok && s.major >= 8 {
+		return 0, newError(400, "x_content_parse_exception", "[date_histogram] unknown field [interval]")
+	}
+	if !ok {
+		v, ok = params["fixed_interval"]
+		if ok && (s.major < 7 || s.major == 7 && s.minor < 2) {
+			return 0, newError(400, "parsing_exception", "[date_histogram] unknown field [fixed_interval]")
+		}
+	}
+	text, _ := v.(string)
+	units := map[string]float64{"ms": 1, "s": 1000, "m": 60 * 1000, "h": 60 * 60 * 1000, "d": 24 * 60 * 60 * 1000}
+	for _, unit := range []string{"ms", "s", "m", "h", "d"} {
+		if n, err := strconv.Atoi(strings.TrimSuffix(text, unit)); err == nil && strings.HasSuffix(text, unit) {
+			return float64(n) * units[unit], nil
+		}
+	}
+	return 0, newError(400, "illegal_argument_exception", "the interval [%v] of date_histogram is not supported by the fake elasticsearch", v)
+}
+
+// rangeAggregation return the buckets of the ranges, which include the from and exclude the to
+func (s *Server) rangeAggregation(params, subAggs map[string]interface{}, docs, background []*document) (map[string]interface{}, *esError) {
+	field, _ := params["field"].(string)
+	ranges, _ := params["ranges"].([]interface{})
+
+	buckets := []interface{}{}
+	for _, v := range ranges {
+		r, _ := v.(map[string]interface{})
+		from, hasFrom := toNumber(r["from"])
+		to, hasTo := toNumber(r["to"])
+		matched := []*document{}
+		for _, doc := range docs {
+			if anyValue(fieldValues(doc, field), func(x interface{}) bool {
+				f, ok := toNumber(x)
+				return ok && (!hasFrom || f >= from) && (!hasTo || f < to)
+			}) {
+				matched = append(matched, doc)
+			}
+		}
+
+		key, ok := r["key"].(string)
+		if !ok {
+			key = rangeKey(from, hasFrom) + "-" + rangeKey(to, hasTo)
+		}
+		bucket, err := s.bucket(key, matched, subAggs, background)
+		if err != nil {
+			return nil, err
+		}
+		if hasFrom {
+			bucket["from"] = from
+		}
+		if hasTo {
+			bucket["to"] = to
+		}
+		buckets = append(buckets, bucket)
+	}
+	return map[string]interface{}{"buckets": buckets}, nil
+}
+
+func rangeKey(v float64, ok bool) string {
+	if !ok {
+		return "*"
+	}
+	return formatDouble(v)
+}
+
+// formatDouble format the number as java does, eg: `10.0` and `99.9`
+func formatDouble(v float64) string {
+	text := strconv.FormatFloat(v, 'f', -1, 64)
+	if !strings.Contains(text, ".") {
+		text += ".0"
+	}
+	return text
+}
+
+// filter return the single bucket of the documents matching the query
+func (s *Server) filter(query, subAggs map[string]interface{}, docs, background []*document) (map[string]interface{}, *esError) {
+	filtered, err := filterDocs(docs, query)
+	if err != nil {
+		return nil, err
+	}
+	bucket, err := s.bucket(nil, filtered, subAggs, background)
+	if err != nil {
+		return nil, err
+	}
+	delete(bucket, "key")
+	return bucket, nil
+}
+
+// filters return the bucket of every filter, the buckets are keyed if the filters are named
+func (s *Server) filters(params, subAggs map[string]interface{}, docs, background []*document) (map[string]interface{}, *esError) {
+	switch filters := params["filters"].(type) {
+	case map[string]interface{}:
+		buckets := map[string]interface{}{}
+		for name, v := range filters {
+			query, _ := v.(map[string]interface{})
+			bucket, err := s.filter(query, subAggs, docs, background)
+			if err != nil {
+				return nil, err
+			}
+			buckets[name] = bucket
+		}
+		return map[string]interface{}{"buckets": buckets}, nil
+	case []interface{}:
+		buckets := []interface{}{}
+		for _, v := range filters {
+			query, _ := v.(map[string]interface{})
+			bucket, err := s.filter(query, subAggs, docs, background)
+			if err != nil {
+				return nil, err
+			}
+			buckets = append(buckets, bucket)
+		}
+		return map[string]interface{}{"buckets": buckets}, nil
+	}
+	return nil, newError(400, "parsing_exception", "[filters] must be an object or an array")
+}
+
+func filterDocs(docs []*document, query map[string]interface{}) ([]*document, *esError) {
+	filtered := []*document{}
+	for _, doc := range docs {
+		matched, err := matches(doc, query)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			filtered = append(filtered, doc)
+		}
+	}
+	return filtered, nil
+}
+
+// topHits return the first documents in the order they are created
+func (s *Server) topHits(params map[string]interface{}, docs []*document) map[string]interface{} {
+	size := intParam(params, "size", 3)
+	hits := []*hit{}
+	for _, doc := range docs {
+		if len(hits) >= size {
+			break
+		}
+		hits = append(hits, &hit{doc: doc})
+	}
+	response := s.searchResponse(hits, len(docs), params["_source"], nil)
+	return map[string]interface{}{"hits": response["hits"]}
+}
+
+// metric compute the metric aggregation of the numeric values of field, the value is null if no value is aggregated
+func metric(aggType string, params map[string]interface{}, docs []*document) (map[string]interface{}, *esError) {
+	field, _ := params["field"].(string)
+	values := []interface{}{}
+	numbers := []float64{}
+	for _, doc := range docs {
+		for _, v := range fieldValues(doc, field) {
+			values = append(values, v)
+			if f, ok := toNumber(v); ok {
+				numbers = append(numbers, f)
+			}
+		}
+	}
+	sort.Float64s(numbers)
+
+	sum := 0.0
+	for _, f := range numbers {
+		sum += f
+	}
+	var min, max, avg interface{}
+	if len(numbers) > 0 {
+		min, max, avg = numbers[0], numbers[len(numbers)-1], sum/float64(len(numbers))
+	}
+
+	switch aggType {
+	case "min":
+		return map[string]interface{}{"value": min}, nil
+	case "max":
+		return map[string]interface{}{"value": max}, nil
+	case "avg":
+		return map[string]interface{}{"value": avg}, nil
+	case "sum":
+		return map[string]interface{}{"value": sum}, nil
+	case "value_count":
+		return map[string]interface{}{"value": len(values)}, nil
+	case "cardinality":
+		distinct := map[string]bool{}
+		for _, v := range values {
+			distinct[fmt.Sprintf("%T:%v", v, v)] = true
+		}
+		return map[string]interface{}{"value": len(distinct)}, nil
+	case "stats":
+		return map[string]interface{}{"count": len(numbers), "min": min, "max": max, "avg": avg, "sum": sum}, nil
+	case "percentiles":
+		percents := []interface{}{1.0, 5.0, 25.0, 50.0, 75.0, 95.0, 99.0}
+		if v, ok := params["percents"].([]interface{}); ok {
+			percents = v
+		}
+		result := map[string]interface{}{}
+		for _, v := range percents {
+			p, _ := toNumber(v)
+			result[formatDouble(p)] = percentile(numbers, p)
+		}
+		return map[string]interface{}{"values": result}, nil
+	}
+	return nil, newError(400, "parsing_exception", "Unknown aggregation type [%s]", aggType)
+}
+
+// percentile interpolate the percentile of the sorted numbers, which is null if there is no number
+func percentile(numbers []float64, p float64) interface{} {
+	if len(numbers) == 0 {
+		return nil
+	}
+	rank := p / 100 * float64(len(numbers)-1)
+	lower := int(math.Floor(rank))
+	if lower+1 >= len(numbers) {
+		return numbers[len(numbers)-1]
+	}
+	return numbers[lower] + (rank-float64(lower))*(numbers[lower+1]-numbers[lower])
+}
+
+func intParam(params map[string]interface{}, name string, defaultValue int) int {
+	if f, ok := toNumber(params[name]); ok {
+		return int(f)
+	}
+	return defaultValue
+}
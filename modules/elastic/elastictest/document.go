@@ -0,0 +1,321 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elastictest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// writeOptions are the conditions of a write, the internal version is only supported before 7.0,
+// and the seq_no and primary_term are supported since 6.0
+type writeOptions struct {
+	create        bool
+	version       string
+	ifSeqNo       string
+	ifPrimaryTerm string
+}
+
+func (s *Server) document(req *request, name, typeName, id string, create bool) (int, interface{}) {
+	switch req.method {
+	case http.MethodGet, http.MethodHead:
+		idx, err := s.getIndex(name, false)
+		if err != nil {
+			return errorResponse(err)
+		}
+		doc, ok := idx.docs[id]
+		if !ok {
+			return 404, s.metadata(idx, typeName, id, map[string]interface{}{"found": false})
+		}
+		return 200, s.getResult(doc)
+	case http.MethodPut, http.MethodPost:
+		if id == "" {
+			if req.method == http.MethodPut {
+				return unsupported(req)
+			}
+			s.lastID++
+			id = fmt.Sprintf("fake%012d", s.lastID)
+		}
+		source := map[string]interface{}{}
+		if err := json.Unmarshal(req.body, &source); err != nil {
+			return parseError(err)
+		}
+		options := writeOptions{
+			create:        create,
+			version:       req.param("version"),
+			ifSeqNo:       req.param("if_seq_no"),
+			ifPrimaryTerm: req.param("if_primary_term"),
+		}
+		status, result, err := s.indexDocument(name, typeName, id, source, options)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return status, result
+	case http.MethodDelete:
+		status, result, err := s.deleteDocument(name, typeName, id)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return status, result
+	}
+	return unsupported(req)
+}
+
+// indexDocument create or replace the document, the index is created if it is not exist
+func (s *Server) indexDocument(name, typeName, id string, source map[string]interface{}, options writeOptions) (int, map[string]interface{}, *esError) {
+	idx, err := s.getIndex(name, true)
+	if err != nil {
+		return 0, nil, err
+	}
+	if typeName, err = s.checkType(idx, typeName); err != nil {
+		return 0, nil, err
+	}
+
+	doc, exists := idx.docs[id]
+	switch {
+	case options.create && exists:
+		return 0, nil, newError(409, "version_conflict_engine_exception",
+			"[%s][%s]: version conflict, document already exists (current version [%d])", typeName, id, doc.version)
+	case options.version != "":
+		if s.major >= 7 {
+			return 0, nil, newError(400, "action_request_validation_exception",
+				"Validation Failed: 1: internal versioning can not be used for optimistic concurrency control. "+
+					"Please use `if_seq_no` and `if_primary_term` instead;")
+		}
+		var current int64
+		if exists {
+			current = doc.version
+		}
+		if strconv.FormatInt(current, 10) != options.version {
+			return 0, nil, newError(409, "version_conflict_engine_exception",
+				"[%s][%s]: version conflict, current version [%d] is different than the one provided [%s]", typeName, id, current, options.version)
+		}
+	case options.ifSeqNo != "" || options.ifPrimaryTerm != "":
+		if s.major < 6 {
+			return 0, nil, newError(400, "illegal_argument_exception", "request does not support [if_seq_no]")
+		}
+		if !exists {
+			return 0, nil, newError(409, "version_conflict_engine_exception",
+				"[%s]: version conflict, required seqNo [%s], primary term [%s]. but no document was found", id, options.ifSeqNo, options.ifPrimaryTerm)
+		}
+		if strconv.FormatInt(doc.seqNo, 10) != options.ifSeqNo || strconv.FormatInt(doc.primaryTerm, 10) != options.ifPrimaryTerm {
+			return 0, nil, newError(409, "version_conflict_engine_exception",
+				"[%s]: version conflict, required seqNo [%s], primary term [%s]. current document has seqNo [%d] and primary term [%d]",
+				id, options.ifSeqNo, options.ifPrimaryTerm, doc.seqNo, doc.primaryTerm)
+		}
+	}
+
+	status, result := 200, "updated"
+	if !exists {
+		status, result = 201, "created"
+		doc = &document{index: idx, id: id, primaryTerm: 1, order: idx.seqNo}
+		idx.docs[id] = doc
+	}
+	doc.typeName = typeName
+	doc.source = source
+	doc.version++
+	doc.seqNo = idx.seqNo
+	idx.seqNo++
+
+	properties, ok := idx.mappings["properties"].(map[string]interface{})
+	if !ok {
+		properties = map[string]interface{}{}
+		idx.mappings["properties"] = properties
+	}
+	addDynamicMapping(properties, source)
+
+	return status, s.writeResult(doc, result), nil
+}
+
+func (s *Server) deleteDocument(name, typeName, id string) (int, map[string]interface{}, *esError) {
+	idx, err := s.getIndex(name, false)
+	if err != nil {
+		return 0, nil, err
+	}
+	if typeName, err = s.checkType(idx, typeName); err != nil {
+		return 0, nil, err
+	}
+
+	doc, ok := idx.docs[id]
+	if !ok {
+		result := s.metadata(idx, typeName, id, map[string]interface{}{"_version": 1, "result": "not_found", "_shards": shards(1)})
+		return 404, result, nil
+	}
+	delete(idx.docs, id)
+	doc.version++
+	doc.seqNo = idx.seqNo
+	idx.seqNo++
+	return 200, s.writeResult(doc, "deleted"), nil
+}
+
+func (s *Server) bulk(req *request, defaultIndex string) (int, interface{}) {
+	if len(req.body) == 0 || req.body[len(req.body)-1] != '\n' {
+		return errorResponse(newError(400, "illegal_argument_exception", "The bulk request must be terminated by a newline [\\n]"))
+	}
+
+	lines := bytes.Split(req.body, []byte("\n"))
+	items := []interface{}{}
+	hasErrors := false
+	for i := 0; i < len(lines); i++ {
+		if len(bytes.TrimSpace(lines[i])) == 0 {
+			continue
+		}
+
+		action := map[string]map[string]interface{}{}
+		if err := json.Unmarshal(lines[i], &action); err != nil || len(action) != 1 {
+			return errorResponse(newError(400, "illegal_argument_exception", "Malformed action/metadata line [%d], expected a simple object", i+1))
+		}
+		for op, meta := range action {
+			if _, ok := meta["_type"]; ok && s.major >= 8 {
+				return errorResponse(newError(400, "illegal_argument_exception", "Action/metadata line [%d] contains an unknown parameter [_type]", i+1))
+			}
+			name, typeName, id := defaultIndex, "", ""
+			if v, ok := meta["_index"].(string); ok {
+				name = v
+			}
+			if v, ok := meta["_type"].(string); ok {
+				typeName = v
+			}
+			if v, ok := meta["_id"]; ok && v != nil {
+				id = fmt.Sprint(v)
+			}
+
+			source := map[string]interface{}{}
+			if op != "delete" {
+				i++
+				if i >= len(lines) || json.Unmarshal(lines[i], &source) != nil {
+					return errorResponse(newError(400, "illegal_argument_exception", "The source of action/metadata line [%d] is missing or malformed", i))
+				}
+			}
+
+			var status int
+			var result map[string]interface{}
+			var err *esError
+			switch op {
+			case "index", "create":
+				if id == "" {
+					s.lastID++
+					id = fmt.Sprintf("fake%012d", s.lastID)
+				}
+				options := writeOptions{create: op == "create"}
+				if v, ok := meta["version"]; ok {
+					options.version = fmt.Sprint(v)
+				}
+				if v, ok := meta["if_seq_no"]; ok {
+					options.ifSeqNo = fmt.Sprint(v)
+				}
+				if v, ok := meta["if_primary_term"]; ok {
+					options.ifPrimaryTerm = fmt.Sprint(v)
+				}
+				status, result, err = s.indexDocument(name, typeName, id, source, options)
+			case "update":
+				status, result, err = s.updateDocument(name, typeName, id, source)
+			case "delete":
+				status, result, err = s.deleteDocument(name, typeName, id)
+			default:
+				return errorResponse(newError(400, "illegal_argument_exception",
+					"Malformed action/metadata line [%d], expected one of [create, delete, index, update] but found [%s]", i+1, op))
+			}
+
+			if err != nil {
+				hasErrors = true
+				status = err.status
+				result = map[string]interface{}{"_index": name, "_id": id, "error": err.body()}
+				if s.major < 8 {
+					result["_type"] = typeName
+				}
+			}
+			result["status"] = status
+			items = append(items, map[string]interface{}{op: result})
+		}
+	}
+	return 200, map[string]interface{}{"took": 1, "errors": hasErrors, "items": items}
+}
+
+// metadata return the fields with the metadata of document, the `_type` is removed since 8.0
+func (s *Server) metadata(idx *index, typeName, id string, fields map[string]interface{}) map[string]interface{} {
+	fields["_index"] = idx.name
+	fields["_id"] = id
+	if s.major < 8 {
+		if typeName == "" {
+			typeName = idx.typeName
+		}
+		fields["_type"] = typeName
+	}
+	return fields
+}
+
+func (s *Server) writeResult(doc *document, result string) map[string]interface{} {
+	fields := map[string]interface{}{"_version": doc.version, "result": result, "_shards": shards(1)}
+	if s.major >= 6 {
+		fields["_seq_no"] = doc.seqNo
+		fields["_primary_term"] = doc.primaryTerm
+	}
+	return s.metadata(doc.index, doc.typeName, doc.id, fields)
+}
+
+func (s *Server) getResult(doc *document) map[string]interface{} {
+	fields := map[string]interface{}{"_version": doc.version, "found": true, "_source": doc.source}
+	if s.major >= 6 {
+		fields["_seq_no"] = doc.seqNo
+		fields["_primary_term"] = doc.primaryTerm
+	}
+	return s.metadata(doc.index, doc.typeName, doc.id, fields)
+}
+
+// esError is a error of elasticsearch, which is encoded as the error response
+type esError struct {
+	status int
+	Type   string
+	Reason string
+}
+
+func newError(status int, errType, format string, args ...interface{}) *esError {
+	return &esError{status: status, Type: errType, Reason: fmt.Sprintf(format, args...)}
+}
+
+func (err *esError) Error() string {
+	return fmt.Sprintf("%s: %s", err.Type, err.Reason)
+}
+
+func (err *esError) body() map[string]interface{} {
+	return map[string]interface{}{"type": err.Type, "reason": err.Reason}
+}
+
+func errorResponse(err *esError) (int, interface{}) {
+	return err.status, map[string]interface{}{
+		"error":  map[string]interface{}{"root_cause": []interface{}{err.body()}, "type": err.Type, "reason": err.Reason},
+		"status": err.status,
+	}
+}
+
+func indexNotFound(name string) *esError {
+	return newError(404, "index_not_found_exception", "no such index [%s]", name)
+}
+
+func parseError(err error) (int, interface{}) {
+	return errorResponse(newError(400, "parse_exception", "failed to parse the request body, %v", err))
+}
+
+func unsupported(req *request) (int, interface{}) {
+	return errorResponse(newError(400, "illegal_argument_exception", "request [%s /%s] is not supported by the fake elasticsearch",
+		req.method, strings.Join(req.path, "/")))
+}
@@ -0,0 +1,445 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elastictest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// matches evaluate the query on the document, the text of match queries and the string values are split into lowercase terms,
+// and the other queries compare the values directly, the numbers, numeric strings and dates are compared by their numeric values
+func matches(doc *document, query map[string]interface{}) (bool, *esError) {
+	if len(query) == 0 {
+		return true, nil
+	}
+	if len(query) != 1 {
+		return false, newError(400, "parsing_exception", "[query] malformed query, expected a single query but found %d", len(query))
+	}
+
+	for name, v := range query {
+		params, ok := v.(map[string]interface{})
+		if !ok {
+			return false, newError(400, "parsing_exception", "[%s] query malformed, no start_object after query name", name)
+		}
+
+		switch name {
+		case "match_all":
+			return true, nil
+		case "match_none":
+			return false, nil
+		case "bool":
+			return matchBool(doc, params)
+		case "ids":
+			values, _ := params["values"].([]interface{})
+			for _, v := range values {
+				if fmt.Sprint(v) == doc.id {
+					return true, nil
+				}
+			}
+			return false, nil
+		case "exists":
+			field, _ := params["field"].(string)
+			return len(fieldValues(doc, field)) > 0, nil
+		case "query_string":
+			text, _ := params["query"].(string)
+			text = strings.TrimSpace(text)
+			if text == "" || text == "*" {
+				return true, nil
+			}
+			if i := strings.Index(text, ":"); i > 0 {
+				return matchText(fieldValues(doc, text[:i]), text[i+1:], "or"), nil
+			}
+			return matchText(allValues(doc.source), text, "or"), nil
+		case "multi_match":
+			operator, _ := params["operator"].(string)
+			fields, _ := params["fields"].([]interface{})
+			values := []interface{}{}
+			for _, field := range fields {
+				field := strings.Split(fmt.Sprint(field), "^")[0]
+				if field == "*" {
+					values = allValues(doc.source)
+					break
+				}
+				values = append(values, fieldValues(doc, field)...)
+			}
+			if len(fields) == 0 {
+				values = allValues(doc.source)
+			}
+			return matchText(values, params["query"], operator), nil
+		}
+
+		//the queries of a single field
+		if len(params) != 1 {
+			return false, newError(400, "parsing_exception", "[%s] query doesn't support multiple fields, found %v", name, params)
+		}
+		for field, v := range params {
+			values := fieldValues(doc, field)
+			value, options := v, map[string]interface{}{}
+			if m, ok := v.(map[string]interface{}); ok {
+				options = m
+				value = m["value"]
+				if q, ok := m["query"]; ok {
+					value = q
+				}
+			}
+
+			switch name {
+			case "term":
+				return anyValue(values, func(x interface{}) bool { return termEquals(x, value) }), nil
+			case "terms":
+				list, ok := v.([]interface{})
+				if !ok {
+					return false, newError(400, "parsing_exception", "[terms] query does not support [%s]", field)
+				}
+				return anyValue(values, func(x interface{}) bool {
+					for _, value := range list {
+						if termEquals(x, value) {
+							return true
+						}
+					}
+					return false
+				}), nil
+			case "match", "match_phrase":
+				operator, _ := options["operator"].(string)
+				return matchText(values, value, operator), nil
+			case "prefix":
+				prefix := fmt.Sprint(value)
+				return anyTerm(values, func(term string) bool { return strings.HasPrefix(term, prefix) }), nil
+			case "wildcard":
+				pattern := fmt.Sprint(value)
+				return anyTerm(values, func(term string) bool { return wildcardMatch(pattern, term) }), nil
+			case "range":
+				return anyValue(values, func(x interface{}) bool { return inRange(x, options) }), nil
+			}
+		}
+		return false, newError(400, "parsing_exception", "no [query] registered for [%s]", name)
+	}
+	return false, nil
+}
+
+// matchBool evaluate the bool query, at least one should clause is required if there is no must or filter clause,
+// unless the minimum_should_match is set
+func matchBool(doc *document, params map[string]interface{}) (bool, *esError) {
+	clauses := func(key string) []map[string]interface{} {
+		switch v := params[key].(type) {
+		case map[string]interface{}:
+			return []map[string]interface{}{v}
+		case []interface{}:
+			result := []map[string]interface{}{}
+			for _, item := range v {
+				if m, ok := item.(map[string]interface{}); ok {
+					result = append(result, m)
+				}
+			}
+			return result
+		}
+		return nil
+	}
+
+	required := append(clauses("must"), clauses("filter")...)
+	for _, q := range required {
+		if matched, err := matches(doc, q); err != nil || !matched {
+			return false, err
+		}
+	}
+	for _, q := range clauses("must_not") {
+		if matched, err := matches(doc, q); err != nil || matched {
+			return false, err
+		}
+	}
+
+	should := clauses("should")
+	if len(should) == 0 {
+		return true, nil
+	}
+	minimum := 0
+	if len(required) == 0 {
+		minimum = 1
+	}
+	if v, ok := params["minimum_should_match"]; ok {
+		n, err := strconv.Atoi(fmt.Sprint(v))
+		if err != nil {
+			return false, newError(400, "parsing_exception", "unsupported minimum_should_match [%v]", v)
+		}
+		minimum = n
+	}
+	matched := 0
+	for _, q := range should {
+		ok, err := matches(doc, q)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			matched++
+		}
+	}
+	return matched >= minimum, nil
+}
+
+// matchText match the terms of text with the terms of values, any term is required by default, or all of them if the operator is `and`
+func matchText(values []interface{}, text interface{}, operator string) bool {
+	str, ok := text.(string)
+	if !ok {
+		return anyValue(values, func(x interface{}) bool { return termEquals(x, text) })
+	}
+
+	queryTerms := analyze(str)
+	if len(queryTerms) == 0 {
+		return false
+	}
+	terms := map[string]bool{}
+	for _, x := range values {
+		if s, ok := x.(string); ok {
+			for _, t := range analyze(s) {
+				terms[t] = true
+			}
+		} else {
+			terms[strings.ToLower(fmt.Sprint(x))] = true
+		}
+	}
+
+	all := strings.ToLower(operator) == "and"
+	for _, t := range queryTerms {
+		if terms[t] != all {
+			return !all
+		}
+	}
+	return all
+}
+
+func termEquals(x, value interface{}) bool {
+	if fmt.Sprint(x) == fmt.Sprint(value) {
+		return true
+	}
+	if a, ok := toNumber(x); ok {
+		if b, ok := toNumber(value); ok && a == b {
+			return true
+		}
+	}
+	if s, ok := x.(string); ok {
+		for _, t := range analyze(s) {
+			if t == fmt.Sprint(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func inRange(x interface{}, options map[string]interface{}) bool {
+	for op, bound := range options {
+		if bound == nil {
+			continue
+		}
+		c, ok := compare(x, bound)
+		switch op {
+		case "gt":
+			ok = ok && c > 0
+		case "gte":
+			ok = ok && c >= 0
+		case "lt":
+			ok = ok && c < 0
+		case "lte":
+			ok = ok && c <= 0
+		case "from":
+			ok = ok && (c > 0 || c == 0 && options["include_lower"] != false)
+		case "to":
+			ok = ok && (c < 0 || c == 0 && options["include_upper"] != false)
+		default:
+			continue
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func anyValue(values []interface{}, fn func(x interface{}) bool) bool {
+	for _, x := range values {
+		if fn(x) {
+			return true
+		}
+	}
+	return false
+}
+
+// anyTerm check the string values and their terms
+func anyTerm(values []interface{}, fn func(term string) bool) bool {
+	return anyValue(values, func(x interface{}) bool {
+		s, ok := x.(string)
+		if !ok {
+			return false
+		}
+		if fn(s) {
+			return true
+		}
+		for _, t := range analyze(s) {
+			if fn(t) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// analyze split the text into lowercase terms by the characters other than letters and digits
+func analyze(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// fieldValues return the values of field, the arrays are flattened, and the sub field `keyword` is the field itself
+func fieldValues(doc *document, field string) []interface{} {
+	if field == "_id" {
+		return []interface{}{doc.id}
+	}
+	values := lookup(doc.source, strings.Split(field, "."))
+	if len(values) == 0 && strings.HasSuffix(field, ".keyword") {
+		values = lookup(doc.source, strings.Split(strings.TrimSuffix(field, ".keyword"), "."))
+	}
+	return values
+}
+
+func lookup(v interface{}, path []string) []interface{} {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		values := []interface{}{}
+		for _, item := range v {
+			values = append(values, lookup(item, path)...)
+		}
+		return values
+	case map[string]interface{}:
+		if len(path) == 0 {
+			return []interface{}{v}
+		}
+		//the name of field may contain dots
+		for i := len(path); i > 0; i-- {
+			if child, ok := v[strings.Join(path[:i], ".")]; ok {
+				return lookup(child, path[i:])
+			}
+		}
+		return nil
+	}
+	if len(path) == 0 {
+		return []interface{}{v}
+	}
+	return nil
+}
+
+func allValues(v interface{}) []interface{} {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		values := []interface{}{}
+		for _, item := range v {
+			values = append(values, allValues(item)...)
+		}
+		return values
+	case map[string]interface{}:
+		values := []interface{}{}
+		for _, item := range v {
+			values = append(values, allValues(item)...)
+		}
+		return values
+	}
+	return []interface{}{v}
+}
+
+// compare the values as numbers if both of them are numbers, numeric strings or dates, or as strings
+func compare(a, b interface{}) (int, bool) {
+	if x, ok := toNumber(a); ok {
+		if y, ok := toNumber(b); ok {
+			switch {
+			case x < y:
+				return -1, true
+			case x > y:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	x, ok1 := a.(string)
+	y, ok2 := b.(string)
+	if ok1 && ok2 {
+		return strings.Compare(x, y), true
+	}
+	return 0, false
+}
+
+// toNumber return the number of value, the dates are converted to the milliseconds since epoch
+func toNumber(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+			return f, true
+		}
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return float64(t.UnixNano() / int64(time.Millisecond)), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// wildcardMatch match the text with the pattern, `*` matches any characters and `?` matches a single character
+func wildcardMatch(pattern, text string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(text); i >= 0; i-- {
+				if wildcardMatch(pattern[1:], text[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(text) == 0 {
+				return false
+			}
+			_, size := utf8.DecodeRuneInString(text)
+			pattern, text = pattern[1:], text[size:]
+		default:
+			if len(text) == 0 || pattern[0] != text[0] {
+				return false
+			}
+			pattern, text = pattern[1:], text[1:]
+		}
+	}
+	return len(text) == 0
+}
@@ -0,0 +1,496 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elastictest
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// searchRequest is the body of search, count and scroll
+type searchRequest struct {
+	Query       map[string]interface{} `json:"query"`
+	From        *int                   `json:"from"`
+	Size        *int                   `json:"size"`
+	Sort        interface{}            `json:"sort"`
+	SearchAfter []interface{}          `json:"search_after"`
+	Source      interface{}            `json:"_source"`
+	Slice       *struct {
+		ID  int `json:"id"`
+		Max int `json:"max"`
+	} `json:"slice"`
+	Aggs         json.RawMessage `json:"aggs"`
+	Aggregations json.RawMessage `json:"aggregations"`
+}
+
+type sortField struct {
+	field string
+	desc  bool
+}
+
+type hit struct {
+	doc  *document
+	sort []interface{}
+}
+
+// scroll is a search context, the hits are collected when it is created
+type scroll struct {
+	hits   []*hit
+	size   int
+	next   int
+	source interface{}
+	sorts  []sortField
+}
+
+func (s *Server) search(req *request, names string) (int, interface{}) {
+	if req.method != http.MethodGet && req.method != http.MethodPost {
+		return unsupported(req)
+	}
+
+	body := searchRequest{}
+	if len(req.body) > 0 {
+		if err := json.Unmarshal(req.body, &body); err != nil {
+			return parseError(err)
+		}
+	}
+	from, size := 0, 10
+	if body.From != nil {
+		from = *body.From
+	}
+	if body.Size != nil {
+		size = *body.Size
+	}
+	if v, err := strconv.Atoi(req.param("from")); err == nil {
+		from = v
+	}
+	if v, err := strconv.Atoi(req.param("size")); err == nil {
+		size = v
+	}
+
+	hits, sorts, err := s.query(names, &body)
+	if err != nil {
+		return errorResponse(err)
+	}
+	total := len(hits)
+	aggs, err := s.searchAggregations(names, &body, hits)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if body.SearchAfter != nil {
+		if from > 0 {
+			return errorResponse(newError(400, "search_phase_execution_exception", "`from` parameter must be set to 0 when `search_after` is used."))
+		}
+		if hits, err = searchAfter(hits, sorts, body.SearchAfter); err != nil {
+			return errorResponse(err)
+		}
+	}
+
+	if req.param("scroll") != "" {
+		s.lastID++
+		id := fmt.Sprintf("scroll%012d", s.lastID)
+		context := &scroll{hits: hits, size: size, source: body.Source, sorts: sorts}
+		s.scrolls[id] = context
+		response := s.nextPage(id, context)
+		if aggs != nil {
+			response["aggregations"] = aggs
+		}
+		return 200, response
+	}
+
+	if from > len(hits) {
+		from = len(hits)
+	}
+	end := from + size
+	if end > len(hits) {
+		end = len(hits)
+	}
+	response := s.searchResponse(hits[from:end], total, body.Source, sorts)
+	if aggs != nil {
+		response["aggregations"] = aggs
+	}
+	return 200, response
+}
+
+// searchAggregations compute the aggregations of the request on the matched hits, which is nil if there is no aggregation
+func (s *Server) searchAggregations(names string, body *searchRequest, hits []*hit) (map[string]interface{}, *esError) {
+	raw := body.Aggs
+	if len(raw) == 0 {
+		raw = body.Aggregations
+	}
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	aggs := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &aggs); err != nil {
+		return nil, newError(400, "parsing_exception", "%v", err)
+	}
+
+	all, _, err := s.query(names, &searchRequest{})
+	if err != nil {
+		return nil, err
+	}
+	docs, background := make([]*document, 0, len(hits)), make([]*document, 0, len(all))
+	for _, h := range hits {
+		docs = append(docs, h.doc)
+	}
+	for _, h := range all {
+		background = append(background, h.doc)
+	}
+	return s.aggregate(aggs, docs, background)
+}
+
+func (s *Server) count(req *request, names string) (int, interface{}) {
+	body := searchRequest{}
+	if len(req.body) > 0 {
+		if err := json.Unmarshal(req.body, &body); err != nil {
+			return parseError(err)
+		}
+	}
+	hits, _, err := s.query(names, &searchRequest{Query: body.Query})
+	if err != nil {
+		return errorResponse(err)
+	}
+	return 200, map[string]interface{}{"count": len(hits), "_shards": shards(1)}
+}
+
+func (s *Server) scroll(req *request) (int, interface{}) {
+	ids := []string{}
+	if len(req.path) > 2 {
+		ids = append(ids, strings.Split(req.path[2], ",")...)
+	}
+	if v := req.param("scroll_id"); v != "" {
+		ids = append(ids, strings.Split(v, ",")...)
+	}
+	if len(req.body) > 0 {
+		body := map[string]interface{}{}
+		if err := json.Unmarshal(req.body, &body); err != nil {
+			return parseError(err)
+		}
+		switch v := body["scroll_id"].(type) {
+		case string:
+			ids = append(ids, v)
+		case []interface{}:
+			for _, id := range v {
+				ids = append(ids, fmt.Sprint(id))
+			}
+		}
+	}
+
+	switch req.method {
+	case http.MethodGet, http.MethodPost:
+		if len(ids) != 1 {
+			return errorResponse(newError(400, "action_request_validation_exception", "Validation Failed: 1: scrollId is missing;"))
+		}
+		context, ok := s.scrolls[ids[0]]
+		if !ok {
+			return errorResponse(newError(404, "search_context_missing_exception", "No search context found for id [%s]", ids[0]))
+		}
+		return 200, s.nextPage(ids[0], context)
+	case http.MethodDelete:
+		freed := 0
+		for _, id := range ids {
+			if id == "_all" {
+				freed += len(s.scrolls)
+				s.scrolls = map[string]*scroll{}
+				continue
+			}
+			if _, ok := s.scrolls[id]; ok {
+				delete(s.scrolls, id)
+				freed++
+			}
+		}
+		return 200, map[string]interface{}{"succeeded": true, "num_freed": freed}
+	}
+	return unsupported(req)
+}
+
+func (s *Server) nextPage(id string, context *scroll) map[string]interface{} {
+	end := context.next + context.size
+	if end > len(context.hits) {
+		end = len(context.hits)
+	}
+	page := context.hits[context.next:end]
+	context.next = end
+
+	response := s.searchResponse(page, len(context.hits), context.source, context.sorts)
+	response["_scroll_id"] = id
+	return response
+}
+
+// query return the sorted hits of the indices, and the sort fields of request
+func (s *Server) query(names string, body *searchRequest) ([]*hit, []sortField, *esError) {
+	indices, err := s.resolve(names)
+	if err != nil {
+		return nil, nil, err
+	}
+	sorts, err := parseSort(body.Sort)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, v := range sorts {
+		if v.field == "_id" && s.major >= 8 {
+			return nil, nil, newError(400, "illegal_argument_exception",
+				"Fielddata access on the _id field is disallowed, you can re-enable it by updating the dynamic cluster setting: indices.id_field_data.enabled")
+		}
+	}
+	if body.Slice != nil && (body.Slice.Max <= 1 || body.Slice.ID < 0 || body.Slice.ID >= body.Slice.Max) {
+		return nil, nil, newError(400, "action_request_validation_exception", "invalid slice [%d] of [%d]", body.Slice.ID, body.Slice.Max)
+	}
+
+	hits := []*hit{}
+	for _, idx := range indices {
+		if idx.closed {
+			return nil, nil, newError(400, "index_closed_exception", "closed index [%s]", idx.name)
+		}
+
+		docs := make([]*document, 0, len(idx.docs))
+		for _, doc := range idx.docs {
+			docs = append(docs, doc)
+		}
+		sort.Slice(docs, func(i, j int) bool {
+			return docs[i].order < docs[j].order
+		})
+
+		for _, doc := range docs {
+			if body.Slice != nil && sliceOf(doc.id, body.Slice.Max) != body.Slice.ID {
+				continue
+			}
+			matched, err := matches(doc, body.Query)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !matched {
+				continue
+			}
+			h := &hit{doc: doc}
+			for _, v := range sorts {
+				h.sort = append(h.sort, sortValue(doc, v))
+			}
+			hits = append(hits, h)
+		}
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		return compareSortValues(hits[i].sort, hits[j].sort, sorts) < 0
+	})
+	return hits, sorts, nil
+}
+
+// searchResponse encode the hits, the score of every hit is 1, and the total is a object since 7.0
+func (s *Server) searchResponse(hits []*hit, total int, source interface{}, sorts []sortField) map[string]interface{} {
+	scored := len(sorts) == 0
+	for _, v := range sorts {
+		scored = scored || v.field == "_score"
+	}
+
+	items := []interface{}{}
+	var maxScore interface{}
+	for _, h := range hits {
+		item := s.metadata(h.doc.index, h.doc.typeName, h.doc.id, map[string]interface{}{"_score": nil})
+		if scored {
+			item["_score"] = 1.0
+			maxScore = 1.0
+		}
+		if v := filterSource(h.doc.source, source); v != nil {
+			item["_source"] = v
+		}
+		if len(sorts) > 0 {
+			item["sort"] = h.sort
+		}
+		items = append(items, item)
+	}
+
+	var hitsTotal interface{} = total
+	if s.major >= 7 {
+		hitsTotal = map[string]interface{}{"value": total, "relation": "eq"}
+	}
+	return map[string]interface{}{
+		"took":      1,
+		"timed_out": false,
+		"_shards":   shards(1),
+		"hits":      map[string]interface{}{"total": hitsTotal, "max_score": maxScore, "hits": items},
+	}
+}
+
+func searchAfter(hits []*hit, sorts []sortField, after []interface{}) ([]*hit, *esError) {
+	if len(sorts) == 0 {
+		return nil, newError(400, "search_phase_execution_exception", "Sort must contain at least one field.")
+	}
+	if len(after) != len(sorts) {
+		return nil, newError(400, "search_phase_execution_exception", "search_after has %d value(s) but sort has %d.", len(after), len(sorts))
+	}
+
+	result := []*hit{}
+	for _, h := range hits {
+		if compareSortValues(h.sort, after, sorts) > 0 {
+			result = append(result, h)
+		}
+	}
+	return result, nil
+}
+
+// parseSort parse the sort of request, which is a field, a object of field and order, or a array of them
+func parseSort(v interface{}) ([]sortField, *esError) {
+	var items []interface{}
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		items = v
+	default:
+		items = []interface{}{v}
+	}
+
+	sorts := []sortField{}
+	for _, item := range items {
+		switch item := item.(type) {
+		case string:
+			sorts = append(sorts, sortField{field: item, desc: item == "_score"})
+		case map[string]interface{}:
+			if len(item) != 1 {
+				return nil, newError(400, "parsing_exception", "malformed sort, expected a single field but found %v", item)
+			}
+			for field, order := range item {
+				v := sortField{field: field, desc: field == "_score"}
+				switch order := order.(type) {
+				case string:
+					v.desc = order == "desc"
+				case map[string]interface{}:
+					if o, ok := order["order"].(string); ok {
+						v.desc = o == "desc"
+					}
+				}
+				sorts = append(sorts, v)
+			}
+		default:
+			return nil, newError(400, "parsing_exception", "malformed sort, unexpected %v", item)
+		}
+	}
+	return sorts, nil
+}
+
+// sortValue return the value of document to be sorted, the min value is used for the ascending order if it has multiple values,
+// and the max value for the descending order
+func sortValue(doc *document, v sortField) interface{} {
+	switch v.field {
+	case "_score":
+		return 1.0
+	case "_id":
+		return doc.id
+	case "_doc":
+		return float64(doc.order)
+	}
+
+	var value interface{}
+	for _, x := range fieldValues(doc, v.field) {
+		if _, ok := x.(map[string]interface{}); ok {
+			continue
+		}
+		if value == nil {
+			value = x
+			continue
+		}
+		if c := compareValues(x, value, v.field); (!v.desc && c < 0) || (v.desc && c > 0) {
+			value = x
+		}
+	}
+	return value
+}
+
+// compareSortValues compare the sort values by the order of sort fields, the missing values are always the last
+func compareSortValues(a, b []interface{}, sorts []sortField) int {
+	for i, v := range sorts {
+		switch {
+		case a[i] == nil && b[i] == nil:
+			continue
+		case a[i] == nil:
+			return 1
+		case b[i] == nil:
+			return -1
+		}
+		c := compareValues(a[i], b[i], v.field)
+		if v.desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func compareValues(a, b interface{}, field string) int {
+	if field != "_id" {
+		if c, ok := compare(a, b); ok {
+			return c
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// sliceOf return the slice of the document for the sliced scroll
+func sliceOf(id string, max int) int {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return int(h.Sum32() % uint32(max))
+}
+
+// filterSource return the fields of source included by `_source` of request, which is a bool, a pattern,
+// a list of patterns, or a object of includes and excludes
+func filterSource(source map[string]interface{}, spec interface{}) map[string]interface{} {
+	var includes, excludes []interface{}
+	switch spec := spec.(type) {
+	case nil:
+		return source
+	case bool:
+		if spec {
+			return source
+		}
+		return nil
+	case string:
+		includes = []interface{}{spec}
+	case []interface{}:
+		includes = spec
+	case map[string]interface{}:
+		includes, _ = spec["includes"].([]interface{})
+		excludes, _ = spec["excludes"].([]interface{})
+	}
+
+	filtered := map[string]interface{}{}
+	for k, v := range source {
+		if (len(includes) == 0 || matchAnyPattern(includes, k)) && !matchAnyPattern(excludes, k) {
+			filtered[k] = v
+		}
+	}
+	return filtered
+}
+
+func matchAnyPattern(patterns []interface{}, field string) bool {
+	for _, v := range patterns {
+		pattern := fmt.Sprint(v)
+		if wildcardMatch(pattern, field) || strings.HasPrefix(pattern, field+".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,782 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package elastictest provides a in-process fake elasticsearch, it emulates the subset of the elasticsearch apis used by
+// the adapters and the orm, so that they can be tested offline against every supported major version.
+package elastictest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Versions are the versions could be reported by the server, one for every supported major version
+var Versions = []string{"5.6.16", "6.8.23", "7.10.2", "8.6.2"}
+
+// Server is a fake elasticsearch, the documents are kept in memory and searchable once they are indexed,
+// the templates are stored but not applied to the new indices
+type Server struct {
+	URL     string
+	Version string
+	//TaskPolls is the number of polls the tasks are reported as running before they are completed
+	TaskPolls int
+
+	major          int
+	minor          int
+	server         *httptest.Server
+	lock           sync.Mutex
+	indices        map[string]*index
+	templates      map[string]interface{}
+	indexTemplates map[string]interface{}
+	scrolls        map[string]*scroll
+	tasks          map[string]*task
+	lastID         int64
+}
+
+type index struct {
+	name     string
+	typeName string
+	created  time.Time
+	closed   bool
+	settings map[string]interface{}
+	mappings map[string]interface{}
+	docs     map[string]*document
+	seqNo    int64
+}
+
+type document struct {
+	index       *index
+	id          string
+	typeName    string
+	source      map[string]interface{}
+	version     int64
+	seqNo       int64
+	primaryTerm int64
+
+	//order is the sequence when the document is created, the hits are ordered by it if they are not sorted
+	order int64
+}
+
+type request struct {
+	method string
+	path   []string
+	params map[string][]string
+	body   []byte
+}
+
+func (req *request) param(name string) string {
+	if v := req.params[name]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// NewServer start a fake elasticsearch which reports the version, such as `7.10.2`, the server should be closed after used
+func NewServer(version string) *Server {
+	parts := strings.Split(version, ".")
+	major, err := strconv.Atoi(parts[0])
+	if err != nil || major < 5 || len(parts) < 2 {
+		panic(fmt.Errorf("invalid version of elasticsearch: %s", version))
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		panic(fmt.Errorf("invalid version of elasticsearch: %s", version))
+	}
+
+	s := &Server{
+		Version:        version,
+		TaskPolls:      1,
+		major:          major,
+		minor:          minor,
+		indices:        map[string]*index{},
+		templates:      map[string]interface{}{},
+		indexTemplates: map[string]interface{}{},
+		scrolls:        map[string]*scroll{},
+		tasks:          map[string]*task{},
+	}
+	s.server = httptest.NewServer(s)
+	s.URL = s.server.URL
+	return s
+}
+
+// Close shutdown the server
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	req := &request{method: r.Method, params: r.URL.Query(), body: body}
+	for _, v := range strings.Split(r.URL.Path, "/") {
+		if v != "" {
+			req.path = append(req.path, v)
+		}
+	}
+
+	s.lock.Lock()
+	status, response := s.handle(req)
+	s.lock.Unlock()
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead && response != nil {
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+func (s *Server) handle(req *request) (int, interface{}) {
+	path := req.path
+	if len(path) == 0 {
+		return s.root(req)
+	}
+
+	switch path[0] {
+	case "_cluster":
+		if len(path) == 2 && path[1] == "health" {
+			return s.health(req)
+		}
+	case "_nodes":
+		return s.nodes(req)
+	case "_tasks":
+		if len(path) == 2 {
+			return s.task(req, path[1])
+		}
+		if len(path) == 3 && path[2] == "_cancel" {
+			return s.cancelTask(req, path[1])
+		}
+	case "_template":
+		if len(path) == 2 {
+			return s.template(req, path[1])
+		}
+	case "_index_template":
+		if len(path) == 2 && s.major >= 7 {
+			return s.indexTemplate(req, path[1])
+		}
+	case "_bulk":
+		if len(path) == 1 {
+			return s.bulk(req, "")
+		}
+	case "_search":
+		if len(path) == 1 {
+			return s.search(req, "_all")
+		}
+		if path[1] == "scroll" {
+			return s.scroll(req)
+		}
+	case "_refresh", "_count", "_settings", "_mapping":
+		return s.handle(&request{method: req.method, path: append([]string{"_all"}, path...), params: req.params, body: req.body})
+	}
+	if strings.HasPrefix(path[0], "_") {
+		return unsupported(req)
+	}
+
+	name := path[0]
+	if len(path) == 1 {
+		return s.index(req, name)
+	}
+
+	switch path[1] {
+	case "_search":
+		return s.search(req, name)
+	case "_count":
+		return s.count(req, name)
+	case "_refresh":
+		return s.refresh(req, name)
+	case "_settings":
+		return s.settings(req, name)
+	case "_mapping":
+		if len(path) == 3 {
+			return s.mapping(req, name, path[2])
+		}
+		return s.mapping(req, name, "")
+	case "_bulk":
+		return s.bulk(req, name)
+	case "_open", "_close":
+		return s.openOrClose(req, name, path[1] == "_close")
+	case "_create":
+		if len(path) == 3 {
+			return s.document(req, name, "", path[2], true)
+		}
+	case "_update":
+		if len(path) == 3 && s.major >= 7 {
+			return s.update(req, name, "", path[2])
+		}
+	case "_update_by_query", "_delete_by_query":
+		return s.byQuery(req, name, path[1] == "_delete_by_query")
+	}
+
+	typeName := path[1]
+	switch {
+	case len(path) == 2 && req.method == http.MethodPost:
+		return s.document(req, name, typeName, "", false)
+	case len(path) == 3 && path[2] == "_mapping":
+		return s.mapping(req, name, typeName)
+	case len(path) == 3:
+		return s.document(req, name, typeName, path[2], req.param("op_type") == "create")
+	case len(path) == 4 && path[3] == "_create":
+		return s.document(req, name, typeName, path[2], true)
+	case len(path) == 4 && path[3] == "_update" && s.major < 8:
+		return s.update(req, name, typeName, path[2])
+	}
+	return unsupported(req)
+}
+
+func (s *Server) root(req *request) (int, interface{}) {
+	return 200, map[string]interface{}{
+		"name":         "fake",
+		"cluster_name": "elasticsearch",
+		"version": map[string]interface{}{
+			"number":         s.Version,
+			"build_snapshot": false,
+			"lucene_version": map[int]string{5: "6.6.1", 6: "7.7.3", 7: "8.7.0", 8: "9.4.2"}[s.major],
+		},
+		"tagline": "You Know, for Search",
+	}
+}
+
+func (s *Server) health(req *request) (int, interface{}) {
+	return 200, map[string]interface{}{
+		"cluster_name":          "elasticsearch",
+		"status":                "green",
+		"number_of_nodes":       1,
+		"number_of_data_nodes":  1,
+		"active_primary_shards": len(s.indices),
+		"active_shards":         len(s.indices),
+	}
+}
+
+func (s *Server) nodes(req *request) (int, interface{}) {
+	address := strings.TrimPrefix(s.URL, "http://")
+	return 200, map[string]interface{}{
+		"_nodes":       map[string]interface{}{"total": 1, "successful": 1, "failed": 0},
+		"cluster_name": "elasticsearch",
+		"nodes": map[string]interface{}{
+			"fake": map[string]interface{}{
+				"name":    "fake",
+				"version": s.Version,
+				"http": map[string]interface{}{
+					"bound_address":   []string{address},
+					"publish_address": address,
+				},
+			},
+		},
+	}
+}
+
+func (s *Server) template(req *request, name string) (int, interface{}) {
+	switch req.method {
+	case http.MethodGet, http.MethodHead:
+		if template, ok := s.templates[name]; ok {
+			return 200, map[string]interface{}{name: template}
+		}
+		return 404, map[string]interface{}{}
+	case http.MethodPut, http.MethodPost:
+		template := map[string]interface{}{}
+		if err := json.Unmarshal(req.body, &template); err != nil {
+			return parseError(err)
+		}
+		s.templates[name] = template
+		return acknowledged()
+	case http.MethodDelete:
+		if _, ok := s.templates[name]; !ok {
+			return errorResponse(newError(404, "index_template_missing_exception", "index_template [%s] missing", name))
+		}
+		delete(s.templates, name)
+		return acknowledged()
+	}
+	return unsupported(req)
+}
+
+func (s *Server) indexTemplate(req *request, name string) (int, interface{}) {
+	switch req.method {
+	case http.MethodGet, http.MethodHead:
+		if template, ok := s.indexTemplates[name]; ok {
+			return 200, map[string]interface{}{
+				"index_templates": []interface{}{map[string]interface{}{"name": name, "index_template": template}},
+			}
+		}
+		return errorResponse(newError(404, "resource_not_found_exception", "index template matching [%s] not found", name))
+	case http.MethodPut, http.MethodPost:
+		template := map[string]interface{}{}
+		if err := json.Unmarshal(req.body, &template); err != nil {
+			return parseError(err)
+		}
+		s.indexTemplates[name] = template
+		return acknowledged()
+	case http.MethodDelete:
+		if _, ok := s.indexTemplates[name]; !ok {
+			return errorResponse(newError(404, "resource_not_found_exception", "index_template [%s] missing", name))
+		}
+		delete(s.indexTemplates, name)
+		return acknowledged()
+	}
+	return unsupported(req)
+}
+
+func (s *Server) index(req *request, name string) (int, interface{}) {
+	switch req.method {
+	case http.MethodPut:
+		if _, ok := s.indices[name]; ok {
+			errType := "resource_already_exists_exception"
+			if s.major < 6 {
+				errType = "index_already_exists_exception"
+			}
+			return errorResponse(newError(400, errType, "index [%s] already exists", name))
+		}
+		body := map[string]interface{}{}
+		if len(req.body) > 0 {
+			if err := json.Unmarshal(req.body, &body); err != nil {
+				return parseError(err)
+			}
+		}
+		if _, err := s.createIndex(name, body); err != nil {
+			return errorResponse(err)
+		}
+		return 200, map[string]interface{}{"acknowledged": true, "shards_acknowledged": true, "index": name}
+	case http.MethodGet, http.MethodHead:
+		indices, err := s.resolve(name)
+		if err != nil {
+			return errorResponse(err)
+		}
+		response := map[string]interface{}{}
+		for _, idx := range indices {
+			response[idx.name] = map[string]interface{}{
+				"aliases":  map[string]interface{}{},
+				"mappings": s.getMappings(idx),
+				"settings": map[string]interface{}{"index": s.getSettings(idx)},
+			}
+		}
+		return 200, response
+	case http.MethodDelete:
+		indices, err := s.resolve(name)
+		if err != nil {
+			return errorResponse(err)
+		}
+		for _, idx := range indices {
+			delete(s.indices, idx.name)
+		}
+		return acknowledged()
+	}
+	return unsupported(req)
+}
+
+// privateSettings are maintained by elasticsearch, which are rejected when the index is created
+var privateSettings = []string{"uuid", "version", "provided_name", "routing.allocation.initial_recovery"}
+
+// createIndex create the index with the settings and mappings of body, the mappings are wrapped by the type name before 7.0
+func (s *Server) createIndex(name string, body map[string]interface{}) (*index, *esError) {
+	if strings.ToLower(name) != name {
+		return nil, newError(400, "invalid_index_name_exception", "Invalid index name [%s], must be lowercase", name)
+	}
+	if strings.HasPrefix(name, "_") || strings.HasPrefix(name, "-") || strings.HasPrefix(name, "+") {
+		return nil, newError(400, "invalid_index_name_exception", "Invalid index name [%s], must not start with '_', '-', or '+'", name)
+	}
+	if strings.ContainsAny(name, `\/*?"<>| ,#:`) {
+		return nil, newError(400, "invalid_index_name_exception", "Invalid index name [%s], must not contain the invalid characters", name)
+	}
+
+	idx := &index{
+		name:     name,
+		created:  time.Now(),
+		settings: map[string]interface{}{},
+		mappings: map[string]interface{}{},
+		docs:     map[string]*document{},
+	}
+	if settings, ok := body["settings"].(map[string]interface{}); ok {
+		idx.settings = normalizeSettings(settings)
+		for _, key := range privateSettings {
+			if len(lookup(idx.settings, strings.Split(key, "."))) > 0 {
+				return nil, newError(400, "illegal_argument_exception", "private index setting [index.%s] can not be set explicitly", key)
+			}
+		}
+	}
+	if mappings, ok := body["mappings"].(map[string]interface{}); ok && len(mappings) > 0 {
+		typeName, mapping := s.unwrapMapping(mappings, "")
+		if s.major < 7 && typeName == "" {
+			return nil, newError(400, "mapper_parsing_exception", "Root mapping definition has unsupported parameters: %v", mappings)
+		}
+		idx.typeName = typeName
+		mergeMaps(idx.mappings, mapping)
+	}
+	if s.major >= 7 {
+		idx.typeName = "_doc"
+	}
+	s.indices[name] = idx
+	return idx, nil
+}
+
+// getIndex return the index, which is created on demand as elasticsearch does for the writes
+func (s *Server) getIndex(name string, create bool) (*index, *esError) {
+	idx, ok := s.indices[name]
+	if !ok {
+		if !create {
+			return nil, indexNotFound(name)
+		}
+		return s.createIndex(name, nil)
+	}
+	if idx.closed {
+		return nil, newError(400, "index_closed_exception", "closed index [%s]", name)
+	}
+	return idx, nil
+}
+
+// resolve return the indices of the comma separated names, which can be `_all` or wildcard patterns
+func (s *Server) resolve(names string) ([]*index, *esError) {
+	matched := map[string]*index{}
+	for _, name := range strings.Split(names, ",") {
+		if name == "_all" || name == "*" {
+			for k, v := range s.indices {
+				matched[k] = v
+			}
+			continue
+		}
+		if strings.ContainsAny(name, "*?") {
+			for k, v := range s.indices {
+				if wildcardMatch(name, k) {
+					matched[k] = v
+				}
+			}
+			continue
+		}
+		idx, ok := s.indices[name]
+		if !ok {
+			return nil, indexNotFound(name)
+		}
+		matched[name] = idx
+	}
+
+	indices := make([]*index, 0, len(matched))
+	for _, v := range matched {
+		indices = append(indices, v)
+	}
+	sort.Slice(indices, func(i, j int) bool {
+		return indices[i].name < indices[j].name
+	})
+	return indices, nil
+}
+
+func (s *Server) openOrClose(req *request, name string, closed bool) (int, interface{}) {
+	if req.method != http.MethodPost {
+		return unsupported(req)
+	}
+	indices, err := s.resolve(name)
+	if err != nil {
+		return errorResponse(err)
+	}
+	for _, idx := range indices {
+		idx.closed = closed
+	}
+	return acknowledged()
+}
+
+func (s *Server) refresh(req *request, name string) (int, interface{}) {
+	indices, err := s.resolve(name)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return 200, map[string]interface{}{"_shards": shards(len(indices))}
+}
+
+func (s *Server) settings(req *request, name string) (int, interface{}) {
+	indices, err := s.resolve(name)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	switch req.method {
+	case http.MethodGet:
+		response := map[string]interface{}{}
+		for _, idx := range indices {
+			response[idx.name] = map[string]interface{}{"settings": map[string]interface{}{"index": s.getSettings(idx)}}
+		}
+		return 200, response
+	case http.MethodPut:
+		body := map[string]interface{}{}
+		if err := json.Unmarshal(req.body, &body); err != nil {
+			return parseError(err)
+		}
+		settings := normalizeSettings(body)
+		for _, idx := range indices {
+			if _, ok := settings["analysis"]; ok && !idx.closed {
+				return errorResponse(newError(400, "illegal_argument_exception",
+					"Can't update non dynamic settings [[index.analysis]] for open indices [[%s]]", idx.name))
+			}
+		}
+		for _, idx := range indices {
+			mergeMaps(idx.settings, settings)
+		}
+		return acknowledged()
+	}
+	return unsupported(req)
+}
+
+// getSettings return the index settings with the defaults, all the values are strings as elasticsearch returns
+func (s *Server) getSettings(idx *index) map[string]interface{} {
+	settings := map[string]interface{}{
+		"number_of_shards":   "1",
+		"number_of_replicas": "1",
+		"creation_date":      strconv.FormatInt(idx.created.UnixNano()/int64(time.Millisecond), 10),
+		"uuid":               fmt.Sprintf("%x", idx.created.UnixNano()),
+		"provided_name":      idx.name,
+		"version":            map[string]interface{}{"created": strings.Replace(s.Version, ".", "0", -1) + "99"},
+	}
+	mergeMaps(settings, idx.settings)
+	return settings
+}
+
+func (s *Server) mapping(req *request, name, typeName string) (int, interface{}) {
+	switch req.method {
+	case http.MethodGet:
+		indices, err := s.resolve(name)
+		if err != nil {
+			return errorResponse(err)
+		}
+		response := map[string]interface{}{}
+		for _, idx := range indices {
+			response[idx.name] = map[string]interface{}{"mappings": s.getMappings(idx)}
+		}
+		return 200, response
+	case http.MethodPut, http.MethodPost:
+		indices, err := s.resolve(name)
+		if err != nil {
+			return errorResponse(err)
+		}
+		body := map[string]interface{}{}
+		if err := json.Unmarshal(req.body, &body); err != nil {
+			return parseError(err)
+		}
+		_, mapping := s.unwrapMapping(body, typeName)
+		for _, idx := range indices {
+			if _, err := s.checkType(idx, typeName); err != nil {
+				return errorResponse(err)
+			}
+		}
+		for _, idx := range indices {
+			mergeMaps(idx.mappings, mapping)
+		}
+		return acknowledged()
+	}
+	return unsupported(req)
+}
+
+// getMappings return the mappings of index, which are wrapped by the type name before 7.0
+func (s *Server) getMappings(idx *index) interface{} {
+	if s.major >= 7 {
+		return idx.mappings
+	}
+	if idx.typeName == "" {
+		return map[string]interface{}{}
+	}
+	return map[string]interface{}{idx.typeName: idx.mappings}
+}
+
+// unwrapMapping return the type name and the mapping of body, the body has a single key of the type name if it is wrapped
+func (s *Server) unwrapMapping(body map[string]interface{}, typeName string) (string, map[string]interface{}) {
+	if s.major >= 8 || len(body) != 1 {
+		return typeName, body
+	}
+	for k, v := range body {
+		if mapping, ok := v.(map[string]interface{}); ok && (k == typeName || typeName == "" && !isMappingParameter(k)) {
+			return k, mapping
+		}
+	}
+	return typeName, body
+}
+
+func isMappingParameter(name string) bool {
+	switch name {
+	case "properties", "dynamic", "dynamic_templates", "date_detection", "numeric_detection", "_source", "_all", "_meta", "_routing":
+		return true
+	}
+	return false
+}
+
+// checkType check the type name of the document, which is required before 7.0, and only one type is allowed since 6.0
+func (s *Server) checkType(idx *index, typeName string) (string, *esError) {
+	if typeName == "" {
+		if s.major < 7 {
+			return "", newError(400, "action_request_validation_exception", "Validation Failed: 1: type is missing;")
+		}
+		typeName = "_doc"
+	}
+	if s.major >= 8 && typeName != "_doc" {
+		return "", newError(400, "illegal_argument_exception", "the type must be [_doc] as the types are removed, but found [%s]", typeName)
+	}
+	if s.major >= 6 && idx.typeName != "" && idx.typeName != typeName {
+		return "", newError(400, "illegal_argument_exception",
+			"Rejecting mapping update to [%s] as the final mapping would have more than 1 type: [%s, %s]", idx.name, idx.typeName, typeName)
+	}
+	if idx.typeName == "" {
+		idx.typeName = typeName
+	}
+	return typeName, nil
+}
+
+func acknowledged() (int, interface{}) {
+	return 200, map[string]interface{}{"acknowledged": true}
+}
+
+func shards(n int) map[string]interface{} {
+	return map[string]interface{}{"total": n, "successful": n, "failed": 0}
+}
+
+// normalizeSettings return the index settings of body, which may be nested or flattened, and prefixed by `index` or not,
+// the values are converted to strings as elasticsearch returns
+func normalizeSettings(body map[string]interface{}) map[string]interface{} {
+	if v, ok := body["settings"].(map[string]interface{}); ok {
+		body = v
+	}
+	settings := map[string]interface{}{}
+	for k, v := range body {
+		setSetting(settings, strings.Split(k, "."), v)
+	}
+	if v, ok := settings["index"].(map[string]interface{}); ok {
+		delete(settings, "index")
+		mergeMaps(settings, v)
+	}
+	return settings
+}
+
+func setSetting(settings map[string]interface{}, path []string, value interface{}) {
+	for _, k := range path[:len(path)-1] {
+		child, ok := settings[k].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			settings[k] = child
+		}
+		settings = child
+	}
+
+	key := path[len(path)-1]
+	switch v := value.(type) {
+	case nil:
+		delete(settings, key)
+	case map[string]interface{}:
+		child, ok := settings[key].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			settings[key] = child
+		}
+		for k, v := range v {
+			setSetting(child, strings.Split(k, "."), v)
+		}
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, item := range v {
+			values[i] = settingValue(item)
+		}
+		settings[key] = values
+	default:
+		settings[key] = settingValue(v)
+	}
+}
+
+func settingValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// mergeMaps merge src into dst recursively, the maps of src are copied
+func mergeMaps(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if m, ok := v.(map[string]interface{}); ok {
+			child, ok := dst[k].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				dst[k] = child
+			}
+			mergeMaps(child, m)
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// addDynamicMapping add the mappings of the new fields of source, as the dynamic mapping of elasticsearch does
+func addDynamicMapping(properties map[string]interface{}, source map[string]interface{}) {
+	for k, v := range source {
+		existing, ok := properties[k].(map[string]interface{})
+		if !ok {
+			if mapping := inferMapping(v); mapping != nil {
+				properties[k] = mapping
+			}
+			continue
+		}
+
+		object, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if t, ok := existing["type"]; ok && t != "object" && t != "nested" {
+			continue
+		}
+		children, ok := existing["properties"].(map[string]interface{})
+		if !ok {
+			children = map[string]interface{}{}
+			existing["properties"] = children
+		}
+		addDynamicMapping(children, object)
+	}
+}
+
+func inferMapping(v interface{}) map[string]interface{} {
+	switch v := v.(type) {
+	case string:
+		if _, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return map[string]interface{}{"type": "date"}
+		}
+		return map[string]interface{}{
+			"type":   "text",
+			"fields": map[string]interface{}{"keyword": map[string]interface{}{"type": "keyword", "ignore_above": 256}},
+		}
+	case float64:
+		if v == float64(int64(v)) {
+			return map[string]interface{}{"type": "long"}
+		}
+		return map[string]interface{}{"type": "float"}
+	case bool:
+		return map[string]interface{}{"type": "boolean"}
+	case map[string]interface{}:
+		properties := map[string]interface{}{}
+		addDynamicMapping(properties, v)
+		return map[string]interface{}{"properties": properties}
+	case []interface{}:
+		for _, item := range v {
+			if mapping := inferMapping(item); mapping != nil {
+				return mapping
+			}
+		}
+	}
+	return nil
+}
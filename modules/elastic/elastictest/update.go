@@ -0,0 +1,371 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elastictest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// task is a completed task of update by query or delete by query, it is reported as running at the first polls,
+// so that the polling of clients is tested, and it is completed once it is cancelled
+type task struct {
+	id        int64
+	action    string
+	response  map[string]interface{}
+	polls     int
+	cancelled bool
+}
+
+func (s *Server) update(req *request, name, typeName, id string) (int, interface{}) {
+	if req.method != http.MethodPost {
+		return unsupported(req)
+	}
+	body := map[string]interface{}{}
+	if err := json.Unmarshal(req.body, &body); err != nil {
+		return parseError(err)
+	}
+	status, result, err := s.updateDocument(name, typeName, id, body)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return status, result
+}
+
+// updateDocument merge the partial document into the stored one, or run the script on it, the upsert is indexed if the
+// document is missing, and the result is `noop` if the document is not changed
+func (s *Server) updateDocument(name, typeName, id string, body map[string]interface{}) (int, map[string]interface{}, *esError) {
+	partial, hasDoc := body["doc"].(map[string]interface{})
+	script, hasScript := body["script"]
+	switch {
+	case !hasDoc && !hasScript:
+		return 0, nil, newError(400, "action_request_validation_exception", "Validation Failed: 1: script or doc is missing;")
+	case hasDoc && hasScript:
+		return 0, nil, newError(400, "action_request_validation_exception", "Validation Failed: 1: can't provide both script and doc;")
+	}
+
+	idx, err := s.getIndex(name, true)
+	if err != nil {
+		return 0, nil, err
+	}
+	if typeName, err = s.checkType(idx, typeName); err != nil {
+		return 0, nil, err
+	}
+
+	doc, exists := idx.docs[id]
+	if !exists {
+		if upsert, ok := body["upsert"].(map[string]interface{}); ok {
+			return s.indexDocument(name, typeName, id, upsert, writeOptions{create: true})
+		}
+		if upsert, _ := body["doc_as_upsert"].(bool); upsert && hasDoc {
+			return s.indexDocument(name, typeName, id, partial, writeOptions{create: true})
+		}
+		return 0, nil, newError(404, "document_missing_exception", "[%s][%s]: document missing", typeName, id)
+	}
+
+	source := map[string]interface{}{}
+	mergeMaps(source, doc.source)
+	if hasDoc {
+		mergeMaps(source, partial)
+	} else {
+		statements, params, err := s.compileScript(script)
+		if err != nil {
+			return 0, nil, err
+		}
+		if err := runScript(source, statements, params); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	if reflect.DeepEqual(source, doc.source) {
+		return 200, s.writeResult(doc, "noop"), nil
+	}
+	return s.indexDocument(name, typeName, id, source, writeOptions{})
+}
+
+// byQuery update or delete the matched documents, every matched document is reindexed by update by query,
+// and a task is stored if the request doesn't wait for completion
+func (s *Server) byQuery(req *request, names string, deletion bool) (int, interface{}) {
+	if req.method != http.MethodPost {
+		return unsupported(req)
+	}
+
+	body := struct {
+		Query  map[string]interface{} `json:"query"`
+		Script interface{}            `json:"script"`
+	}{}
+	if len(req.body) > 0 {
+		if err := json.Unmarshal(req.body, &body); err != nil {
+			return parseError(err)
+		}
+	}
+
+	var statements []statement
+	var params map[string]interface{}
+	if body.Script != nil {
+		if deletion {
+			return errorResponse(newError(400, "parsing_exception", "[delete_by_query] unknown field [script]"))
+		}
+		var err *esError
+		if statements, params, err = s.compileScript(body.Script); err != nil {
+			return errorResponse(err)
+		}
+	}
+
+	hits, _, err := s.query(names, &searchRequest{Query: body.Query})
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	updated, deleted := 0, 0
+	failures := []interface{}{}
+	for _, h := range hits {
+		doc := h.doc
+		if deletion {
+			if _, _, err := s.deleteDocument(doc.index.name, doc.typeName, doc.id); err != nil {
+				failures = append(failures, failure(doc, err))
+				continue
+			}
+			deleted++
+			continue
+		}
+
+		source := map[string]interface{}{}
+		mergeMaps(source, doc.source)
+		if err := runScript(source, statements, params); err != nil {
+			failures = append(failures, failure(doc, err))
+			break
+		}
+		if _, _, err := s.indexDocument(doc.index.name, doc.typeName, doc.id, source, writeOptions{}); err != nil {
+			failures = append(failures, failure(doc, err))
+			break
+		}
+		updated++
+	}
+
+	batches := 0
+	if len(hits) > 0 {
+		batches = 1
+	}
+	response := map[string]interface{}{
+		"took":                   1,
+		"timed_out":              false,
+		"total":                  len(hits),
+		"updated":                updated,
+		"deleted":                deleted,
+		"batches":                batches,
+		"version_conflicts":      0,
+		"noops":                  0,
+		"retries":                map[string]interface{}{"bulk": 0, "search": 0},
+		"throttled_millis":       0,
+		"requests_per_second":    -1.0,
+		"throttled_until_millis": 0,
+		"failures":               failures,
+	}
+
+	if req.param("wait_for_completion") != "false" {
+		return 200, response
+	}
+	action := "indices:data/write/update/byquery"
+	if deletion {
+		action = "indices:data/write/delete/byquery"
+	}
+	s.lastID++
+	t := &task{id: s.lastID, action: action, response: response}
+	s.tasks[fmt.Sprintf("fake:%d", t.id)] = t
+	return 200, map[string]interface{}{"task": fmt.Sprintf("fake:%d", t.id)}
+}
+
+func failure(doc *document, err *esError) map[string]interface{} {
+	return map[string]interface{}{"index": doc.index.name, "type": doc.typeName, "id": doc.id, "cause": err.body(), "status": err.status}
+}
+
+func (s *Server) task(req *request, id string) (int, interface{}) {
+	if req.method != http.MethodGet {
+		return unsupported(req)
+	}
+	t, ok := s.tasks[id]
+	if !ok {
+		return errorResponse(newError(404, "resource_not_found_exception", "task [%s] isn't running and hasn't stored its results", id))
+	}
+
+	completed := t.cancelled || t.polls >= s.TaskPolls
+	result := map[string]interface{}{"completed": completed, "task": t.info()}
+	if completed {
+		result["response"] = t.response
+	}
+	t.polls++
+	return 200, result
+}
+
+// cancelTask cancel the running task, the task is completed with the documents processed before
+func (s *Server) cancelTask(req *request, id string) (int, interface{}) {
+	if req.method != http.MethodPost {
+		return unsupported(req)
+	}
+	t, ok := s.tasks[id]
+	if !ok || t.cancelled || t.polls > s.TaskPolls {
+		return errorResponse(newError(404, "resource_not_found_exception", "task [%s] is not found", id))
+	}
+
+	t.cancelled = true
+	t.response["canceled"] = "by user request"
+	return 200, map[string]interface{}{
+		"nodes": map[string]interface{}{
+			"fake": map[string]interface{}{"name": "fake", "tasks": map[string]interface{}{id: t.info()}},
+		},
+	}
+}
+
+func (t *task) info() map[string]interface{} {
+	status := map[string]interface{}{}
+	for _, k := range []string{"total", "updated", "deleted", "batches", "version_conflicts", "noops"} {
+		status[k] = t.response[k]
+	}
+	return map[string]interface{}{
+		"node":        "fake",
+		"id":          t.id,
+		"type":        "transport",
+		"action":      t.action,
+		"status":      status,
+		"cancellable": true,
+	}
+}
+
+// statement is a statement of the supported subset of painless, which assigns `ctx._source.<field>` by `=`, `+=` or `-=`,
+// or removes the field by `ctx._source.remove('<field>')`
+type statement struct {
+	field string
+	op    string
+	value string
+}
+
+var assignment = regexp.MustCompile(`^ctx\._source\.([A-Za-z_][\w.]*)\s*(=|\+=|-=)\s*(.+)$`)
+var removal = regexp.MustCompile(`^ctx\._source\.remove\(\s*['"]([\w.]+)['"]\s*\)$`)
+
+// compileScript parse the script, which is the source or a object of source and params, the source is named as `inline`
+// before 5.6
+func (s *Server) compileScript(script interface{}) ([]statement, map[string]interface{}, *esError) {
+	var source string
+	params := map[string]interface{}{}
+	switch v := script.(type) {
+	case string:
+		source = v
+	case map[string]interface{}:
+		if _, ok := v["source"]; ok && s.major == 5 && s.minor < 6 {
+			return nil, nil, newError(400, "parsing_exception", "[script] unknown field [source], parser not found")
+		}
+		source, _ = v["source"].(string)
+		if source == "" {
+			source, _ = v["inline"].(string)
+		}
+		if p, ok := v["params"].(map[string]interface{}); ok {
+			params = p
+		}
+	}
+
+	statements := []statement{}
+	for _, line := range strings.Split(source, ";") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if m := removal.FindStringSubmatch(line); m != nil {
+			statements = append(statements, statement{field: m[1], op: "remove"})
+			continue
+		}
+		m := assignment.FindStringSubmatch(line)
+		if m == nil {
+			return nil, nil, newError(400, "script_exception", "compile error, [%s] is not supported by the fake elasticsearch", line)
+		}
+		if _, err := evaluate(m[3], params); err != nil {
+			return nil, nil, err
+		}
+		statements = append(statements, statement{field: m[1], op: m[2], value: m[3]})
+	}
+	return statements, params, nil
+}
+
+// evaluate return the value of expression, which is a param, or a string, number, bool or null literal
+func evaluate(expr string, params map[string]interface{}) (interface{}, *esError) {
+	expr = strings.TrimSpace(expr)
+	switch {
+	case strings.HasPrefix(expr, "params."):
+		return params[strings.TrimPrefix(expr, "params.")], nil
+	case len(expr) >= 2 && (expr[0] == '\'' || expr[0] == '"') && expr[len(expr)-1] == expr[0]:
+		return expr[1 : len(expr)-1], nil
+	case expr == "true":
+		return true, nil
+	case expr == "false":
+		return false, nil
+	case expr == "null":
+		return nil, nil
+	}
+	if f, err := strconv.ParseFloat(expr, 64); err == nil {
+		return f, nil
+	}
+	return nil, newError(400, "script_exception", "compile error, [%s] is not supported by the fake elasticsearch", expr)
+}
+
+func runScript(source map[string]interface{}, statements []statement, params map[string]interface{}) *esError {
+	for _, v := range statements {
+		path := strings.Split(v.field, ".")
+		parent := source
+		for _, k := range path[:len(path)-1] {
+			child, ok := parent[k].(map[string]interface{})
+			if !ok {
+				return newError(400, "script_exception", "runtime error, cannot access [%s] of null", k)
+			}
+			parent = child
+		}
+		key := path[len(path)-1]
+
+		if v.op == "remove" {
+			delete(parent, key)
+			continue
+		}
+		value, err := evaluate(v.value, params)
+		if err != nil {
+			return err
+		}
+		if v.op == "=" {
+			parent[key] = value
+			continue
+		}
+
+		if s, ok := parent[key].(string); ok && v.op == "+=" {
+			parent[key] = s + fmt.Sprint(value)
+			continue
+		}
+		x, ok1 := parent[key].(float64)
+		y, ok2 := value.(float64)
+		if !ok1 || !ok2 {
+			return newError(400, "script_exception", "runtime error, cannot apply [%s] to [%v] and [%v]", v.op, parent[key], value)
+		}
+		if v.op == "+=" {
+			parent[key] = x + y
+		} else {
+			parent[key] = x - y
+		}
+	}
+	return nil
+}
@@ -2,86 +2,304 @@ package elastic
 
 import (
 	"encoding/json"
+	"fmt"
 	log "github.com/cihub/seelog"
 	"github.com/huminghe/infini-framework/core/elastic"
-	"github.com/huminghe/infini-framework/core/errors"
-	"github.com/huminghe/infini-framework/core/global"
 	"github.com/huminghe/infini-framework/core/queue"
-	"runtime"
+	"github.com/huminghe/infini-framework/core/stats"
+	"sync"
+	"time"
 )
 
+// IndexerConfig is the config of the indexer of a channel, the documents in the channel are `elastic.IndexDocument` in json
+type IndexerConfig struct {
+	//Channel the queue to read the documents from
+	Channel string `config:"channel"`
+	//Elasticsearch the id of elasticsearch instance, the one of module is used by default
+	Elasticsearch string `config:"elasticsearch"`
+	Workers       int    `config:"workers"`
+	//BulkSizeInDocs, BulkSizeInBytes and FlushIntervalInMs the bulk request is sent when any of them is reached
+	BulkSizeInDocs    int `config:"bulk_size_in_docs"`
+	BulkSizeInBytes   int `config:"bulk_size_in_bytes"`
+	FlushIntervalInMs int `config:"flush_interval_in_ms"`
+	//MaxRetries the times to retry the rejected documents, the backoff is doubled after every retry
+	MaxRetries          int `config:"max_retries"`
+	RetryBackoffInMs    int `config:"retry_backoff_in_ms"`
+	MaxRetryBackoffInMs int `config:"max_retry_backoff_in_ms"`
+	//DeadLetterQueue the queue of the documents failed to index, it is `<channel>_dead_letter` by default
+	DeadLetterQueue string `config:"dead_letter_queue"`
+}
+
+var defaultIndexerConfig = IndexerConfig{
+	Channel:             "index",
+	Workers:             1,
+	BulkSizeInDocs:      1000,
+	BulkSizeInBytes:     10 * 1024 * 1024,
+	FlushIntervalInMs:   1000,
+	MaxRetries:          3,
+	RetryBackoffInMs:    100,
+	MaxRetryBackoffInMs: 5000,
+}
+
+// DeadLetter is the message of dead letter queue, the Message is the original message in the channel,
+// which could be pushed back to retry
+type DeadLetter struct {
+	Channel   string    `json:"channel"`
+	Message   string    `json:"message"`
+	Status    int       `json:"status,omitempty"`
+	Error     string    `json:"error"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ElasticIndexer read the documents from a channel and index them by bulk requests, the documents rejected
+// by elasticsearch are retried with backoff, and the ones failed permanently are pushed to the dead letter queue.
+// The counters are in the stats category `elastic.indexer.<channel>`.
 type ElasticIndexer struct {
-	client       elastic.API
-	indexChannel string
+	client elastic.API
+	config IndexerConfig
+
+	docs chan indexItem
+	quit chan bool
+	wg   sync.WaitGroup
+}
+
+type indexItem struct {
+	action  elastic.BulkAction
+	message []byte
+}
+
+// NewElasticIndexer create the indexer, the unset fields of config are set to the default values
+func NewElasticIndexer(client elastic.API, config IndexerConfig) *ElasticIndexer {
+	if config.Channel == "" {
+		config.Channel = defaultIndexerConfig.Channel
+	}
+	if config.Workers <= 0 {
+		config.Workers = defaultIndexerConfig.Workers
+	}
+	if config.BulkSizeInDocs <= 0 {
+		config.BulkSizeInDocs = defaultIndexerConfig.BulkSizeInDocs
+	}
+	if config.BulkSizeInBytes <= 0 {
+		config.BulkSizeInBytes = defaultIndexerConfig.BulkSizeInBytes
+	}
+	if config.FlushIntervalInMs <= 0 {
+		config.FlushIntervalInMs = defaultIndexerConfig.FlushIntervalInMs
+	}
+	if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	}
+	if config.RetryBackoffInMs <= 0 {
+		config.RetryBackoffInMs = defaultIndexerConfig.RetryBackoffInMs
+	}
+	if config.MaxRetryBackoffInMs < config.RetryBackoffInMs {
+		config.MaxRetryBackoffInMs = config.RetryBackoffInMs
+	}
+	if config.DeadLetterQueue == "" {
+		config.DeadLetterQueue = config.Channel + "_dead_letter"
+	}
+	return &ElasticIndexer{client: client, config: config}
 }
 
-var signalChannel chan bool
+func (this *ElasticIndexer) statsCategory() string {
+	return "elastic.indexer." + this.config.Channel
+}
 
 func (this *ElasticIndexer) Start() error {
 
-	log.Trace("starting ElasticIndexer")
-
-	signalChannel = make(chan bool, 1)
-
-	go func() {
-		defer func() {
-
-			if !global.Env().IsDebug {
-				if r := recover(); r != nil {
-
-					if r == nil {
-						return
-					}
-					var v string
-					switch r.(type) {
-					case error:
-						v = r.(error).Error()
-					case runtime.Error:
-						v = r.(runtime.Error).Error()
-					case string:
-						v = r.(string)
-					}
-					log.Error("error in indexer,", v)
-				}
+	log.Trace("starting ElasticIndexer, channel: ", this.config.Channel)
+
+	//the channel is not buffered more than a bulk for each worker, so the reading is blocked if the workers are busy
+	this.docs = make(chan indexItem, this.config.Workers*this.config.BulkSizeInDocs)
+	this.quit = make(chan bool)
+
+	for i := 0; i < this.config.Workers; i++ {
+		this.wg.Add(1)
+		go this.runWorker()
+	}
+
+	this.wg.Add(1)
+	go this.read()
+
+	log.Trace("started ElasticIndexer, channel: ", this.config.Channel)
+
+	return nil
+}
+
+// Stop stop reading the channel, and wait until the read documents are indexed
+func (this *ElasticIndexer) Stop() error {
+	log.Trace("stopping ElasticIndexer, channel: ", this.config.Channel)
+	close(this.quit)
+	this.wg.Wait()
+	log.Trace("stopped ElasticIndexer, channel: ", this.config.Channel)
+	return nil
+}
+
+func (this *ElasticIndexer) read() {
+	defer this.wg.Done()
+	defer close(this.docs)
+
+	for {
+		select {
+		case <-this.quit:
+			log.Trace("indexer exited, channel: ", this.config.Channel)
+			return
+		case v := <-queue.ReadChan(this.config.Channel):
+			stats.Increment("queue."+this.config.Channel, "pop")
+			stats.Increment(this.statsCategory(), "received")
+
+			item, err := decodeIndexItem(v)
+			if err != nil {
+				this.deadLetter(v, 0, err.Error())
+				continue
 			}
-		}()
 
-		for {
 			select {
-			case <-signalChannel:
-				log.Trace("indexer exited")
+			case this.docs <- item:
+			case <-this.quit:
+				//the read document is still sent to the workers, which are flushing the remaining documents
+				this.docs <- item
 				return
-			default:
-				log.Trace("waiting index signal")
-				v, er := queue.Pop(this.indexChannel)
-				log.Trace("got index signal, ", string(v))
-				if er != nil {
-					log.Error(er)
-					continue
-				}
-				doc := elastic.IndexDocument{}
-				err := json.Unmarshal(v, &doc)
-				if err != nil {
-					panic(err)
-				}
+			}
+		}
+	}
+}
 
-				resp, err := this.client.Index(doc.Index, doc.ID, doc.Source)
-				if err != nil {
-					panic(errors.New(resp.Result))
-				}
+func decodeIndexItem(v []byte) (indexItem, error) {
+	doc := elastic.IndexDocument{}
+	err := json.Unmarshal(v, &doc)
+	if err != nil {
+		return indexItem{}, err
+	}
+	if doc.Index == "" {
+		return indexItem{}, fmt.Errorf("the index of document is not set")
+	}
+
+	//the documents of time-based index are written to its current write index
+	action := elastic.BulkAction{Type: elastic.BulkIndex, Index: GetWriteIndex(doc.Index), Doc: doc.Source}
+	if doc.ID != nil {
+		action.ID = fmt.Sprint(doc.ID)
+	}
+	return indexItem{action: action, message: v}, nil
+}
+
+func (this *ElasticIndexer) runWorker() {
+	defer this.wg.Done()
+
+	ticker := time.NewTicker(time.Duration(this.config.FlushIntervalInMs) * time.Millisecond)
+	defer ticker.Stop()
+
+	items := []indexItem{}
+	size := 0
+	for {
+		select {
+		case item, ok := <-this.docs:
+			if !ok {
+				this.flush(items)
+				return
 			}
+			items = append(items, item)
+			size += len(item.message)
+			if len(items) >= this.config.BulkSizeInDocs || size >= this.config.BulkSizeInBytes {
+				this.flush(items)
+				items = []indexItem{}
+				size = 0
+			}
+		case <-ticker.C:
+			if len(items) > 0 {
+				this.flush(items)
+				items = []indexItem{}
+				size = 0
+			}
+		}
+	}
+}
+
+// isRetryable return true if the document is rejected temporarily, such as the queue of bulk thread pool is full
+func isRetryable(status int) bool {
+	return status == 429 || status == 502 || status == 503 || status == 504
+}
+
+func (this *ElasticIndexer) getBackoff(retry int) time.Duration {
+	backoff := this.config.RetryBackoffInMs
+	for i := 1; i < retry && backoff < this.config.MaxRetryBackoffInMs; i++ {
+		backoff *= 2
+	}
+	if backoff > this.config.MaxRetryBackoffInMs {
+		backoff = this.config.MaxRetryBackoffInMs
+	}
+	return time.Duration(backoff) * time.Millisecond
+}
 
+// flush index the documents by bulk request, the failed request and the rejected documents are retried,
+// and the documents are pushed to the dead letter queue if they are failed permanently or run out of retries
+func (this *ElasticIndexer) flush(items []indexItem) {
+	category := this.statsCategory()
+	for retry := 0; len(items) > 0; retry++ {
+		if retry > 0 {
+			stats.IncrementBy(category, "retried", int64(len(items)))
+			time.Sleep(this.getBackoff(retry))
 		}
-	}()
 
-	log.Trace("started ElasticIndexer")
+		actions := make([]elastic.BulkAction, len(items))
+		for i, item := range items {
+			actions[i] = item.action
+		}
 
-	return nil
+		start := time.Now()
+		resp, err := this.client.BulkRequest(actions)
+		stats.Timing(category, "bulk_time", time.Since(start).Nanoseconds()/int64(time.Millisecond))
+		stats.Increment(category, "bulk_requests")
+		if err != nil {
+			stats.Increment(category, "bulk_errors")
+			log.Errorf("failed to index %v documents of %s, %v", len(items), this.config.Channel, err)
+			if retry >= this.config.MaxRetries {
+				for _, item := range items {
+					this.deadLetter(item.message, 0, err.Error())
+				}
+				return
+			}
+			continue
+		}
+
+		failed := []indexItem{}
+		for i, result := range resp.GetItems() {
+			if result.Error == nil && result.Status < 300 {
+				stats.Increment(category, "indexed")
+				continue
+			}
+
+			reason := fmt.Sprintf("status: %v", result.Status)
+			if result.Error != nil {
+				reason = result.Error.Error()
+			}
+			if isRetryable(result.Status) && retry < this.config.MaxRetries {
+				failed = append(failed, items[i])
+				continue
+			}
+			this.deadLetter(items[i].message, result.Status, reason)
+		}
+		items = failed
+	}
 }
 
-func (this *ElasticIndexer) Stop() error {
-	log.Trace("stopping ElasticIndexer")
-	signalChannel <- true
-	log.Trace("stopped ElasticIndexer")
-	return nil
+func (this *ElasticIndexer) deadLetter(message []byte, status int, reason string) {
+	stats.Increment(this.statsCategory(), "failed")
+	log.Debugf("failed to index document of %s, %s", this.config.Channel, reason)
+
+	letter := DeadLetter{
+		Channel:   this.config.Channel,
+		Message:   string(message),
+		Status:    status,
+		Error:     reason,
+		Timestamp: time.Now(),
+	}
+	v, err := json.Marshal(letter)
+	if err == nil {
+		err = queue.Push(this.config.DeadLetterQueue, v)
+	}
+	if err != nil {
+		log.Errorf("failed to push to dead letter queue %s, %v, message: %s", this.config.DeadLetterQueue, err, string(message))
+		return
+	}
+	stats.Increment(this.statsCategory(), "dead_letter")
 }
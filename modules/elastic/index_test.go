@@ -0,0 +1,202 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elastic
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/huminghe/infini-framework/core/elastic"
+	"github.com/huminghe/infini-framework/core/queue"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testQueue is a queue in memory
+type testQueue struct {
+	lock   sync.Mutex
+	queues map[string]chan []byte
+}
+
+func (q *testQueue) get(k string) chan []byte {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.queues[k] == nil {
+		q.queues[k] = make(chan []byte, 100)
+	}
+	return q.queues[k]
+}
+
+func (q *testQueue) Push(k string, v []byte) error {
+	q.get(k) <- v
+	return nil
+}
+
+func (q *testQueue) Pop(k string, timeout time.Duration) ([]byte, error) {
+	select {
+	case v := <-q.get(k):
+		return v, nil
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("time out")
+	}
+}
+
+func (q *testQueue) ReadChan(k string) chan []byte {
+	return q.get(k)
+}
+
+func (q *testQueue) Close(k string) error {
+	return nil
+}
+
+func (q *testQueue) Depth(k string) int64 {
+	return int64(len(q.get(k)))
+}
+
+func (q *testQueue) GetQueues() []string {
+	return nil
+}
+
+var registerQueue sync.Once
+
+func setupTestQueue() {
+	registerQueue.Do(func() {
+		queue.Register("test", &testQueue{queues: map[string]chan []byte{}})
+	})
+}
+
+// bulkClient reject the documents with the statuses in order, and index them otherwise
+type bulkClient struct {
+	elastic.API
+	lock     sync.Mutex
+	statuses map[string][]int
+	indexed  []string
+	requests int
+}
+
+func (c *bulkClient) BulkRequest(actions []elastic.BulkAction) (*elastic.BulkResponse, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.requests++
+
+	resp := &elastic.BulkResponse{}
+	for _, action := range actions {
+		item := elastic.BulkItemResponse{Index: action.Index, ID: action.ID, Status: 201}
+		if statuses := c.statuses[action.ID]; len(statuses) > 0 {
+			item.Status = statuses[0]
+			item.Error = &elastic.BulkItemError{Type: "error", Reason: fmt.Sprint(statuses[0])}
+			c.statuses[action.ID] = statuses[1:]
+			resp.Errors = true
+		} else {
+			c.indexed = append(c.indexed, action.ID)
+		}
+		resp.Items = append(resp.Items, map[elastic.BulkActionType]elastic.BulkItemResponse{action.Type: item})
+	}
+	return resp, nil
+}
+
+func pushDocument(t *testing.T, channel, id string) {
+	v, err := json.Marshal(elastic.IndexDocument{Index: "test", ID: id, Source: map[string]interface{}{"id": id}})
+	assert.Nil(t, err)
+	assert.Nil(t, queue.Push(channel, v))
+}
+
+func popDeadLetter(t *testing.T, channel string) DeadLetter {
+	v, err := queue.PopTimeout(channel, time.Second)
+	assert.Nil(t, err)
+	letter := DeadLetter{}
+	assert.Nil(t, json.Unmarshal(v, &letter))
+	return letter
+}
+
+func TestIndexerFlush(t *testing.T) {
+	setupTestQueue()
+
+	client := &bulkClient{statuses: map[string][]int{
+		"2": {429},
+		"3": {429, 429, 429},
+		"4": {400},
+	}}
+	indexer := NewElasticIndexer(client, IndexerConfig{Channel: "flush", MaxRetries: 2, RetryBackoffInMs: 1})
+
+	items := []indexItem{}
+	for _, id := range []string{"1", "2", "3", "4"} {
+		item, err := decodeIndexItem([]byte(fmt.Sprintf(`{"_index":"test","_id":"%s","_source":{}}`, id)))
+		assert.Nil(t, err)
+		items = append(items, item)
+	}
+	indexer.flush(items)
+
+	//2 is indexed by the first retry, 3 run out of retries, and 4 is failed permanently
+	assert.Equal(t, []string{"1", "2"}, client.indexed)
+	assert.Equal(t, 3, client.requests)
+
+	letter := popDeadLetter(t, "flush_dead_letter")
+	assert.Equal(t, 400, letter.Status)
+	assert.Equal(t, `{"_index":"test","_id":"4","_source":{}}`, letter.Message)
+	letter = popDeadLetter(t, "flush_dead_letter")
+	assert.Equal(t, 429, letter.Status)
+	assert.Equal(t, "error: 429", letter.Error)
+}
+
+func (c *bulkClient) getIndexed() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.indexed)
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	for i := 0; i < 100 && !cond(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, cond())
+}
+
+func TestIndexer(t *testing.T) {
+	setupTestQueue()
+
+	client := &bulkClient{statuses: map[string][]int{}}
+	indexer := NewElasticIndexer(client, IndexerConfig{Channel: "docs", Workers: 2, BulkSizeInDocs: 3, FlushIntervalInMs: 3600000})
+	indexer.Start()
+
+	for i := 0; i < 10; i++ {
+		pushDocument(t, "docs", fmt.Sprint(i))
+	}
+	assert.Nil(t, queue.Push("docs", []byte("invalid")))
+
+	//the full bulks are flushed, and each worker may hold 2 documents of the last bulk, which are flushed on stop
+	waitFor(t, func() bool { return queue.Depth("docs") == 0 && client.getIndexed() >= 6 })
+	indexer.Stop()
+	assert.Equal(t, 10, client.getIndexed())
+
+	letter := popDeadLetter(t, "docs_dead_letter")
+	assert.Equal(t, "invalid", letter.Message)
+}
+
+func TestIndexerFlushInterval(t *testing.T) {
+	setupTestQueue()
+
+	client := &bulkClient{statuses: map[string][]int{}}
+	indexer := NewElasticIndexer(client, IndexerConfig{Channel: "interval", FlushIntervalInMs: 10})
+	indexer.Start()
+	defer indexer.Stop()
+
+	pushDocument(t, "interval", "1")
+	pushDocument(t, "interval", "2")
+	waitFor(t, func() bool { return client.getIndexed() == 2 })
+}
@@ -0,0 +1,282 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elastic
+
+import (
+	"fmt"
+	log "github.com/cihub/seelog"
+	"github.com/huminghe/infini-framework/core/elastic"
+	"github.com/huminghe/infini-framework/core/util"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LifecycleConfig is the config of time-based indices. The documents are written to the daily index
+// `<index>-2006.01.02` by default, or to the alias `<index>` if any of the rollover conditions is set,
+// which is rolled over from `<index>-000001` to `<index>-000002` and so on.
+type LifecycleConfig struct {
+	Index string `config:"index"`
+	//Elasticsearch the id of elasticsearch instance, the one of module is used by default
+	Elasticsearch string `config:"elasticsearch"`
+	//MaxAge, MaxDocs and MaxSize the write index is rolled over if any of them is reached, eg: 7d, 10000, 5gb
+	MaxAge  string `config:"max_age"`
+	MaxDocs int64  `config:"max_docs"`
+	MaxSize string `config:"max_size"`
+	//RetentionInDays the indices older than it are deleted, the age of daily index is by the date in its name,
+	//and the one of rolled index is by its creation date, the indices are kept forever if it is not set
+	RetentionInDays        int `config:"retention_in_days"`
+	CheckIntervalInSeconds int `config:"check_interval_in_seconds"`
+}
+
+const dailyIndexLayout = "2006.01.02"
+
+const firstRolloverIndex = "000001"
+
+var defaultLifecycleConfig = LifecycleConfig{
+	CheckIntervalInSeconds: 300,
+}
+
+var lifecycles = map[string]*LifecycleManager{}
+
+// GetWriteIndex return the index to write the documents of index now, which is the index itself if it is not managed
+func GetWriteIndex(index string) string {
+	if manager, ok := lifecycles[index]; ok {
+		return manager.WriteIndex(time.Now())
+	}
+	return index
+}
+
+// LifecycleManager roll the time-based indices over and delete the expired ones periodically
+type LifecycleManager struct {
+	client      elastic.API
+	indexPrefix string
+	config      LifecycleConfig
+	//bootstrapped is false until the write alias is found or created, Check retries the bootstrap before that
+	bootstrapped bool
+
+	quit chan bool
+	wg   sync.WaitGroup
+}
+
+// NewLifecycleManager create the manager, the indexPrefix is the one of elasticsearch config,
+// which is prepended to the index names of settings
+func NewLifecycleManager(client elastic.API, indexPrefix string, config LifecycleConfig) *LifecycleManager {
+	if config.CheckIntervalInSeconds <= 0 {
+		config.CheckIntervalInSeconds = defaultLifecycleConfig.CheckIntervalInSeconds
+	}
+	return &LifecycleManager{client: client, indexPrefix: indexPrefix, config: config}
+}
+
+// IsRollover return true if the index is rolled over by the conditions, or it is a daily index
+func (this *LifecycleManager) IsRollover() bool {
+	return this.config.MaxAge != "" || this.config.MaxDocs > 0 || this.config.MaxSize != ""
+}
+
+// WriteIndex return the index to write the documents at the time
+func (this *LifecycleManager) WriteIndex(t time.Time) string {
+	if this.IsRollover() {
+		return this.config.Index
+	}
+	return fmt.Sprintf("%s-%s", this.config.Index, t.UTC().Format(dailyIndexLayout))
+}
+
+// Bootstrap create the first rolled index with the write alias if the alias does not exist,
+// the daily indices are created by elasticsearch when the documents are written
+func (this *LifecycleManager) Bootstrap() (err error) {
+	if !this.IsRollover() {
+		return nil
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to bootstrap index %s, %v", this.config.Index, r)
+		}
+	}()
+
+	//a concrete index of the same name is also matched, with the aliases of its own
+	aliases, err := this.client.GetAliases(this.config.Index)
+	if err != nil {
+		return err
+	}
+	for index, names := range aliases {
+		if hasAlias(names, this.config.Index) {
+			this.bootstrapped = true
+			return nil
+		}
+		if index == this.config.Index {
+			return fmt.Errorf("failed to bootstrap index %s, it is a concrete index rather than an alias", this.config.Index)
+		}
+	}
+
+	index := fmt.Sprintf("%s-%s", this.config.Index, firstRolloverIndex)
+	log.Infof("bootstrap index %s with alias %s", index, this.config.Index)
+	err = this.client.CreateIndex(index, nil)
+	if err != nil {
+		return err
+	}
+	err = this.client.AddAlias(index, this.config.Index)
+	if err != nil {
+		return err
+	}
+	this.bootstrapped = true
+	return nil
+}
+
+// Check roll the write index over if any of the conditions is met, and delete the expired indices
+func (this *LifecycleManager) Check(now time.Time) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to check index %s, %v", this.config.Index, r)
+		}
+	}()
+
+	if this.IsRollover() {
+		//the bootstrap failed at start, eg: elasticsearch was not available
+		if !this.bootstrapped {
+			if err := this.Bootstrap(); err != nil {
+				return err
+			}
+		}
+
+		conditions := &elastic.RolloverConditions{
+			MaxAge:  this.config.MaxAge,
+			MaxDocs: this.config.MaxDocs,
+			MaxSize: this.config.MaxSize,
+		}
+		resp, err := this.client.Rollover(this.config.Index, "", conditions)
+		if err != nil {
+			return err
+		}
+		if resp.RolledOver {
+			log.Infof("index %s is rolled over from %s to %s", this.config.Index, resp.OldIndex, resp.NewIndex)
+		}
+	}
+
+	if this.config.RetentionInDays > 0 {
+		return this.deleteExpired(now)
+	}
+	return nil
+}
+
+// deleteExpired delete the indices created before the retention period, the write index is never deleted
+func (this *LifecycleManager) deleteExpired(now time.Time) error {
+	pattern := this.config.Index + "-*"
+	aliases, err := this.client.GetAliases(pattern)
+	if err != nil {
+		return err
+	}
+
+	var settings *elastic.Indexes
+	if this.IsRollover() {
+		settings, err = this.client.GetIndexSettings(this.indexPrefix + pattern)
+		if err != nil {
+			return err
+		}
+	}
+
+	expiry := now.Add(-time.Duration(this.config.RetentionInDays) * 24 * time.Hour)
+	for index, names := range aliases {
+		if index == this.WriteIndex(now) || hasAlias(names, this.config.Index) {
+			continue
+		}
+
+		t, ok := this.getIndexTime(index, settings)
+		if !ok || !t.Before(expiry) {
+			continue
+		}
+
+		log.Infof("delete expired index %s, %v", index, t)
+		err = this.client.DeleteIndex(index)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getIndexTime return the time of index, which is the end of the date in the name of daily index,
+// or the creation date in the settings of rolled index
+func (this *LifecycleManager) getIndexTime(index string, settings *elastic.Indexes) (time.Time, bool) {
+	suffix := strings.TrimPrefix(index, this.config.Index+"-")
+
+	//the daily index is written until the end of its day
+	if !this.IsRollover() {
+		t, err := time.Parse(dailyIndexLayout, suffix)
+		return t.Add(24 * time.Hour), err == nil
+	}
+
+	if _, err := strconv.Atoi(suffix); err != nil || settings == nil {
+		return time.Time{}, false
+	}
+	//the index name may contain dots, so the settings is not walked by the dotted key
+	indexSettings, ok := (*settings)[this.indexPrefix+index].(map[string]interface{})
+	if !ok {
+		return time.Time{}, false
+	}
+	v, err := util.MapStr(indexSettings).GetValue("settings.index.creation_date")
+	if err != nil {
+		return time.Time{}, false
+	}
+	ms, err := strconv.ParseInt(fmt.Sprint(v), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)), true
+}
+
+func (this *LifecycleManager) Start() {
+	err := this.Bootstrap()
+	if err != nil {
+		log.Error(err)
+	}
+
+	this.quit = make(chan bool)
+	this.wg.Add(1)
+	go func() {
+		defer this.wg.Done()
+		ticker := time.NewTicker(time.Duration(this.config.CheckIntervalInSeconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-this.quit:
+				return
+			case <-ticker.C:
+				if err := this.Check(time.Now()); err != nil {
+					log.Error(err)
+				}
+			}
+		}
+	}()
+}
+
+func (this *LifecycleManager) Stop() {
+	if this.quit == nil {
+		return
+	}
+	close(this.quit)
+	this.wg.Wait()
+}
+
+func hasAlias(aliases []string, alias string) bool {
+	for _, v := range aliases {
+		if v == alias {
+			return true
+		}
+	}
+	return false
+}
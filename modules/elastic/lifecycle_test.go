@@ -0,0 +1,179 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elastic
+
+import (
+	"fmt"
+	"github.com/huminghe/infini-framework/core/elastic"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lifecycleClient keeps the indices and aliases in memory
+type lifecycleClient struct {
+	elastic.API
+	indices    map[string]time.Time
+	aliases    map[string]string
+	conditions *elastic.RolloverConditions
+	rollover   bool
+}
+
+func newLifecycleClient() *lifecycleClient {
+	return &lifecycleClient{indices: map[string]time.Time{}, aliases: map[string]string{}}
+}
+
+func (c *lifecycleClient) GetAliases(indexNames string) (map[string][]string, error) {
+	result := map[string][]string{}
+	for index := range c.indices {
+		matched := index == indexNames || c.aliases[indexNames] == index ||
+			strings.HasSuffix(indexNames, "*") && strings.HasPrefix(index, strings.TrimSuffix(indexNames, "*"))
+		if !matched {
+			continue
+		}
+		result[index] = []string{}
+		for alias, v := range c.aliases {
+			if v == index {
+				result[index] = append(result[index], alias)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (c *lifecycleClient) CreateIndex(name string, settings map[string]interface{}) error {
+	c.indices[name] = time.Now()
+	return nil
+}
+
+func (c *lifecycleClient) AddAlias(indexName, alias string) error {
+	c.aliases[alias] = indexName
+	return nil
+}
+
+func (c *lifecycleClient) DeleteIndex(name string) error {
+	delete(c.indices, name)
+	return nil
+}
+
+func (c *lifecycleClient) GetIndexSettings(indexNames string) (*elastic.Indexes, error) {
+	settings := elastic.Indexes{}
+	for index, created := range c.indices {
+		settings["test_"+index] = map[string]interface{}{
+			"settings": map[string]interface{}{
+				"index": map[string]interface{}{"creation_date": strconv.FormatInt(created.UnixNano()/int64(time.Millisecond), 10)},
+			},
+		}
+	}
+	return &settings, nil
+}
+
+func (c *lifecycleClient) Rollover(alias, newIndex string, conditions *elastic.RolloverConditions) (*elastic.RolloverResponse, error) {
+	c.conditions = conditions
+	if !c.rollover {
+		return &elastic.RolloverResponse{}, nil
+	}
+	old := c.aliases[alias]
+	n, _ := strconv.Atoi(old[len(alias)+1:])
+	newIndex = fmt.Sprintf("%s-%06d", alias, n+1)
+	c.indices[newIndex] = time.Now()
+	c.aliases[alias] = newIndex
+	return &elastic.RolloverResponse{OldIndex: old, NewIndex: newIndex, RolledOver: true}, nil
+}
+
+func TestDailyIndex(t *testing.T) {
+	client := newLifecycleClient()
+	manager := NewLifecycleManager(client, "test_", LifecycleConfig{Index: "logs", RetentionInDays: 7})
+	assert.Equal(t, false, manager.IsRollover())
+
+	now := time.Date(2020, 5, 10, 1, 0, 0, 0, time.UTC)
+	assert.Equal(t, "logs-2020.05.10", manager.WriteIndex(now))
+
+	assert.Nil(t, manager.Bootstrap())
+	assert.Equal(t, 0, len(client.indices))
+
+	for _, v := range []string{"logs-2020.05.10", "logs-2020.05.03", "logs-2020.05.02", "logs-2020.04.01", "logs-other"} {
+		client.indices[v] = now
+	}
+
+	assert.Nil(t, manager.Check(now))
+	assert.Equal(t, 3, len(client.indices))
+	assert.Contains(t, client.indices, "logs-2020.05.10")
+	assert.Contains(t, client.indices, "logs-2020.05.03")
+	assert.Contains(t, client.indices, "logs-other")
+	assert.Nil(t, client.conditions)
+}
+
+func TestRolloverIndex(t *testing.T) {
+	client := newLifecycleClient()
+	manager := NewLifecycleManager(client, "test_", LifecycleConfig{Index: "events", MaxDocs: 100, MaxAge: "1d", RetentionInDays: 30})
+	assert.Equal(t, true, manager.IsRollover())
+	assert.Equal(t, "events", manager.WriteIndex(time.Now()))
+
+	assert.Nil(t, manager.Bootstrap())
+	assert.Contains(t, client.indices, "events-000001")
+	assert.Equal(t, "events-000001", client.aliases["events"])
+
+	//the alias exists already
+	assert.Nil(t, manager.Bootstrap())
+	assert.Equal(t, 1, len(client.indices))
+
+	assert.Nil(t, manager.Check(time.Now()))
+	assert.Equal(t, &elastic.RolloverConditions{MaxAge: "1d", MaxDocs: 100}, client.conditions)
+	assert.Equal(t, 1, len(client.indices))
+
+	client.rollover = true
+	assert.Nil(t, manager.Check(time.Now()))
+	assert.Equal(t, "events-000002", client.aliases["events"])
+	assert.Equal(t, 2, len(client.indices))
+
+	//the old index is deleted after the retention period, but the write index is kept even it is expired
+	client.indices["events-000001"] = time.Now().Add(-31 * 24 * time.Hour)
+	client.indices["events-000002"] = time.Now().Add(-31 * 24 * time.Hour)
+	client.rollover = false
+	assert.Nil(t, manager.Check(time.Now()))
+	assert.Equal(t, 1, len(client.indices))
+	assert.Contains(t, client.indices, "events-000002")
+}
+
+func TestRolloverBootstrapRetry(t *testing.T) {
+	client := newLifecycleClient()
+	manager := NewLifecycleManager(client, "test_", LifecycleConfig{Index: "events", MaxDocs: 100})
+
+	//a concrete index with the name of the alias is not taken as bootstrapped
+	client.indices["events"] = time.Now()
+	assert.NotNil(t, manager.Bootstrap())
+	assert.NotNil(t, manager.Check(time.Now()))
+	assert.Nil(t, client.conditions)
+	assert.Equal(t, 0, len(client.aliases))
+
+	//the bootstrap failed at start is retried by the check
+	delete(client.indices, "events")
+	assert.Nil(t, manager.Check(time.Now()))
+	assert.Equal(t, "events-000001", client.aliases["events"])
+	assert.Equal(t, &elastic.RolloverConditions{MaxDocs: 100}, client.conditions)
+}
+
+func TestGetWriteIndex(t *testing.T) {
+	lifecycles["metrics"] = NewLifecycleManager(newLifecycleClient(), "", LifecycleConfig{Index: "metrics"})
+	defer delete(lifecycles, "metrics")
+
+	assert.Equal(t, "metrics-"+time.Now().UTC().Format(dailyIndexLayout), GetWriteIndex("metrics"))
+	assert.Equal(t, "other", GetWriteIndex("other"))
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elastic
+
+import (
+	"fmt"
+	"github.com/huminghe/infini-framework/core/elastic"
+	api "github.com/huminghe/infini-framework/core/orm"
+	"github.com/huminghe/infini-framework/core/util"
+	"sort"
+	"time"
+)
+
+// migrationIndex is the index of migrations history, the document id is the version
+const migrationIndex = "orm_migrations"
+
+func (handler ElasticORM) GetMigrationRecords() ([]api.MigrationRecord, error) {
+	result := []api.MigrationRecord{}
+
+	exist, err := handler.Client.IndexExists(migrationIndex)
+	if err != nil || !exist {
+		return result, err
+	}
+
+	request := elastic.SearchRequest{Size: 10000}
+	searchResponse, err := handler.Client.Search(migrationIndex, &request)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, doc := range searchResponse.Hits.Hits {
+		r := api.MigrationRecord{}
+		err = util.FromJson(util.ToJson(doc.Source, false), &r)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, r)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+	return result, nil
+}
+
+// ApplyMigration run the step with the client and update the history, the step is not atomic,
+// so a failed step should be safe to retry
+func (handler ElasticORM) ApplyMigration(m *api.Migration, up bool) error {
+	step := m.Up
+	if !up {
+		step = m.Down
+	}
+
+	err := step(handler.Client)
+	if err != nil {
+		return err
+	}
+
+	id := fmt.Sprintf("%v", m.Version)
+	if up {
+		record := api.MigrationRecord{Version: m.Version, Description: m.Description, Applied: time.Now().UTC()}
+		_, err = handler.Client.Index(migrationIndex, id, record)
+	} else {
+		_, err = handler.Client.Delete(migrationIndex, id)
+	}
+	if err != nil {
+		return err
+	}
+
+	return handler.Client.Refresh(migrationIndex)
+}
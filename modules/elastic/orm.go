@@ -1,10 +1,13 @@
 package elastic
 
 import (
+	"bytes"
+	"encoding/json"
 	"github.com/huminghe/infini-framework/core/elastic"
 	"github.com/huminghe/infini-framework/core/errors"
 	api "github.com/huminghe/infini-framework/core/orm"
 	"github.com/huminghe/infini-framework/core/util"
+	"reflect"
 )
 
 type ElasticORM struct {
@@ -31,12 +34,46 @@ func (handler ElasticORM) GetBy(field string, value interface{}, t interface{},
 }
 
 func (handler ElasticORM) Save(o interface{}) error {
+	api.InitVersion(o)
 	_, err := handler.Client.Index(getIndexName(o), getIndexID(o), o)
 	return err
 }
 
+// Update the object, if it has a version field, the stored version is checked,
+// and the document is indexed only if it is not changed since it was read
 func (handler ElasticORM) Update(o interface{}) error {
-	return handler.Save(o)
+	_, version, ok := api.GetVersion(o)
+	if !ok {
+		return handler.Save(o)
+	}
+
+	indexName := getIndexName(o)
+	id := getIndexID(o)
+
+	stored, err := handler.Client.Get(indexName, id)
+	if err != nil {
+		return err
+	}
+
+	storedObject := reflect.New(reflect.Indirect(reflect.ValueOf(o)).Type()).Interface()
+	err = util.FromJson(util.ToJson(stored.Source, false), storedObject)
+	if err != nil {
+		return err
+	}
+
+	if _, storedVersion, _ := api.GetVersion(storedObject); storedVersion != version {
+		return &api.ConflictError{ID: id, Version: version}
+	}
+
+	api.SetVersion(o, version+1)
+	_, err = handler.Client.IndexIfMatch(indexName, id, o, stored)
+	if err != nil {
+		api.SetVersion(o, version)
+		if err == elastic.ErrVersionConflict {
+			return &api.ConflictError{ID: id, Version: version}
+		}
+	}
+	return err
 }
 
 func (handler ElasticORM) Delete(o interface{}) error {
@@ -44,6 +81,11 @@ func (handler ElasticORM) Delete(o interface{}) error {
 	return err
 }
 
+// Transaction is not supported, elasticsearch has no multi-document transaction
+func (handler ElasticORM) Transaction(fn func(tx api.Tx) error) error {
+	return api.ErrTransactionNotSupported
+}
+
 func (handler ElasticORM) Count(o interface{}) (int, error) {
 	countResponse, err := handler.Client.Count(getIndexName(o))
 	if err != nil {
@@ -52,31 +94,99 @@ func (handler ElasticORM) Count(o interface{}) (int, error) {
 	return countResponse.Count, err
 }
 
-func getQuery(c1 *api.Cond) interface{} {
+func getQuery(c1 *api.Cond) (interface{}, error) {
 
 	switch c1.QueryType {
 	case api.Match:
 		q := elastic.MatchQuery{}
 		q.Set(c1.Field, c1.Value)
-		return q
+		return q, nil
 	case api.RangeGt:
 		q := elastic.RangeQuery{}
 		q.Gt(c1.Field, c1.Value)
-		return q
+		return q, nil
 	case api.RangeGte:
 		q := elastic.RangeQuery{}
 		q.Gte(c1.Field, c1.Value)
-		return q
+		return q, nil
 	case api.RangeLt:
 		q := elastic.RangeQuery{}
 		q.Lt(c1.Field, c1.Value)
-		return q
+		return q, nil
 	case api.RangeLte:
 		q := elastic.RangeQuery{}
 		q.Lte(c1.Field, c1.Value)
-		return q
+		return q, nil
+	case api.TermsMatch:
+		values, ok := c1.Value.([]interface{})
+		if !ok {
+			return nil, errors.Errorf("invalid values of terms query: %v", c1.Value)
+		}
+		//null is rejected by the terms query, the empty array matches nothing
+		if values == nil {
+			values = []interface{}{}
+		}
+		q := elastic.TermsQuery{}
+		q.Set(c1.Field, values)
+		return q, nil
+	case api.PrefixMatch:
+		q := elastic.PrefixQuery{}
+		q.Set(c1.Field, c1.Value)
+		return q, nil
+	case api.FieldExists:
+		q := elastic.ExistsQuery{}
+		q.Set(c1.Field)
+		return q, nil
+	case api.FullTextMatch:
+		text, ok := c1.Value.(string)
+		if !ok {
+			return nil, errors.Errorf("invalid text: %v", c1.Value)
+		}
+		//all the fields are searched if no field is specified
+		fields := c1.Fields
+		if len(fields) == 0 {
+			fields = []string{"*"}
+		}
+		q := elastic.MultiMatchQuery{}
+		q.Set(text, fields)
+		return q, nil
+	case api.Group:
+		boolQuery, err := getBoolQuery(c1.Conds)
+		if err != nil {
+			return nil, err
+		}
+		return elastic.Query{BoolQuery: boolQuery}, nil
 	}
-	panic(errors.Errorf("invalid query: %s", c1))
+	return nil, errors.Errorf("invalid query: %v", c1.QueryType)
+}
+
+// getBoolQuery translate a list of conditions to bool query, the should clauses are required when there are any
+func getBoolQuery(conds []*api.Cond) (*elastic.BoolQuery, error) {
+	boolQuery := elastic.BoolQuery{}
+
+	for _, c1 := range conds {
+		q, err := getQuery(c1)
+		if err != nil {
+			return nil, err
+		}
+		switch c1.BoolType {
+		case api.MustNot:
+			boolQuery.MustNot = append(boolQuery.MustNot, q)
+			break
+		case api.Should:
+			boolQuery.Should = append(boolQuery.Should, q)
+			break
+		default:
+			boolQuery.Must = append(boolQuery.Must, q)
+			break
+		}
+	}
+
+	if len(boolQuery.Should) > 0 {
+		boolQuery.MinimumShouldMatch = 1
+	}
+
+	return &boolQuery, nil
 }
 
 func (handler ElasticORM) Search(t interface{}, to interface{}, q *api.Query) (error, api.Result) {
@@ -89,34 +199,44 @@ func (handler ElasticORM) Search(t interface{}, to interface{}, q *api.Query) (e
 	request.Size = q.Size
 
 	if q.Conds != nil && len(q.Conds) > 0 {
-		request.Query = &elastic.Query{}
-
-		boolQuery := elastic.BoolQuery{}
-
-		for _, c1 := range q.Conds {
-			q := getQuery(c1)
-			switch c1.BoolType {
-			case api.Must:
-				boolQuery.Must = append(boolQuery.Must, q)
-				break
-			case api.MustNot:
-				boolQuery.MustNot = append(boolQuery.MustNot, q)
-				break
-			case api.Should:
-				boolQuery.Should = append(boolQuery.Should, q)
-				break
-			}
-
+		boolQuery, err := getBoolQuery(q.Conds)
+		if err != nil {
+			return err, api.Result{}
 		}
-
-		request.Query.BoolQuery = &boolQuery
-
+		request.Query = &elastic.Query{}
+		request.Query.BoolQuery = boolQuery
 	}
 
 	if q.Sort != nil && len(*q.Sort) > 0 {
 		for _, i := range *q.Sort {
 			request.AddSort(i.Field, string(i.SortType))
 		}
+	} else {
+		request.AddSort("_score", string(api.DESC))
+	}
+	//sort by _id at last, so that the sort values of the cursor are unique, or by the source field of _id
+	//if it is not allowed
+	if handler.Client.HasCapability(elastic.CapabilityIDSort) {
+		request.AddSort("_id", string(api.ASC))
+	} else if field := getIndexIDField(t); field != "" {
+		request.AddSort(field, string(api.ASC))
+	}
+
+	if q.Cursor != "" {
+		values, err := api.DecodeCursor(q.Cursor)
+		if err != nil {
+			return err, api.Result{}
+		}
+		for _, v := range values {
+			var v1 interface{}
+			d := json.NewDecoder(bytes.NewReader(v))
+			d.UseNumber()
+			if err := d.Decode(&v1); err != nil {
+				return err, api.Result{}
+			}
+			request.SearchAfter = append(request.SearchAfter, v1)
+		}
+		request.From = 0
 	}
 
 	result := api.Result{}
@@ -134,6 +254,11 @@ func (handler ElasticORM) Search(t interface{}, to interface{}, q *api.Query) (e
 	result.Result = array
 	result.Total = searchResponse.GetTotal()
 
+	hits := searchResponse.Hits.Hits
+	if q.Size > 0 && len(hits) == q.Size {
+		result.Cursor, err = api.EncodeCursor(hits[len(hits)-1].Sort)
+	}
+
 	return err, result
 }
 
@@ -19,7 +19,14 @@ package elastic
 import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"github.com/huminghe/infini-framework/core/elastic"
+	"github.com/huminghe/infini-framework/core/env"
+	"github.com/huminghe/infini-framework/core/global"
+	api "github.com/huminghe/infini-framework/core/orm"
+	"github.com/huminghe/infini-framework/core/orm/ormtest"
 	"github.com/huminghe/infini-framework/core/util"
+	"github.com/huminghe/infini-framework/modules/elastic/elastictest"
+	"os"
 	"testing"
 	"time"
 )
@@ -68,3 +75,104 @@ func TestExtractIndexMappingMetadata(t *testing.T) {
 	mapping := getIndexMapping(host)
 	fmt.Println(util.ToJson(mapping, true))
 }
+
+func TestGetBoolQuery(t *testing.T) {
+	q, err := getBoolQuery(api.And(api.Eq("name", "bob"), api.Or(api.Gt("age", 1), api.Not(api.Exists("tag")))))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"must":[{"match":{"name":"bob"}},{"bool":{"should":[{"range":{"age":{"gt":1}}},{"bool":{"must_not":[{"exists":{"field":"tag"}}]}}],"minimum_should_match":1}}]}`, util.ToJson(q, false))
+
+	q, err = getBoolQuery(api.And(api.FullText("quick fox", "title"), api.FullText("dog")))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"must":[{"multi_match":{"fields":["title"],"lenient":true,"query":"quick fox"}},{"multi_match":{"fields":["*"],"lenient":true,"query":"dog"}}]}`, util.ToJson(q, false))
+}
+
+// TestGetTermsQuery checks the empty terms query, which is sent as a empty array instead of null
+func TestGetTermsQuery(t *testing.T) {
+	q, err := getQuery(api.In("name"))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"terms":{"name":[]}}`, util.ToJson(q, false))
+
+	q, err = getQuery(api.In("name", "alice", "bob"))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"terms":{"name":["alice","bob"]}}`, util.ToJson(q, false))
+}
+
+// TestConformance runs the shared orm suite against the cluster of ES_ENDPOINT
+func TestConformance(t *testing.T) {
+	endpoint := os.Getenv("ES_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("ES_ENDPOINT is not set")
+	}
+	runConformance(t, endpoint)
+}
+
+// TestFakeConformance runs the shared orm suite against the fake elasticsearch of every version
+func TestFakeConformance(t *testing.T) {
+	for _, version := range elastictest.Versions {
+		version := version
+		t.Run(version, func(t *testing.T) {
+			server := elastictest.NewServer(version)
+			defer server.Close()
+			runConformance(t, server.URL)
+		})
+	}
+	for _, pool := range pools {
+		pool.Stop()
+	}
+	pools = nil
+}
+
+func runConformance(t *testing.T, endpoint string) {
+	global.RegisterEnv(env.EmptyEnv())
+
+	client, err := newElasticClient(elastic.ElasticsearchConfig{Endpoint: endpoint, Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index := getIndexName(&ormtest.Doc{})
+	client.DeleteIndex(index)
+	defer client.DeleteIndex(index)
+	client.DeleteIndex(getIndexName(&ormtest.VersionedDoc{}))
+	defer client.DeleteIndex(getIndexName(&ormtest.VersionedDoc{}))
+
+	handler := ElasticORM{Client: client}
+	refresh := func() error {
+		return client.Refresh(index)
+	}
+	ormtest.Suite{Save: handler.Save, Search: handler.Search, Cursor: true,
+		Update: handler.Update, Get: handler.Get, Refresh: refresh, Aggregate: handler.Aggregate,
+		BulkSave: handler.BulkSave, BulkUpdate: handler.BulkUpdate, BulkDelete: handler.BulkDelete}.Run(t)
+}
+
+func TestGetAggregationResults(t *testing.T) {
+	aggs := []*api.Aggregation{
+		api.Terms("by_level", "level", 10).SubAggregation(api.Avg("avg_age", "age"), api.DateHistogram("by_day", "created", 24*time.Hour)),
+		api.Sum("sum_age", "age"),
+	}
+	assert.Equal(t, `{"by_level":{"aggs":{"avg_age":{"avg":{"field":"age"}},"by_day":{"date_histogram":{"field":"created","interval":"86400s","min_doc_count":1}}},"terms":{"field":"level","size":10}},"sum_age":{"sum":{"field":"age"}}}`, util.ToJson(getAggregations(aggs, false), false))
+	assert.Equal(t, `{"by_day":{"date_histogram":{"field":"created","fixed_interval":"86400s","min_doc_count":1}}}`, util.ToJson(getAggregations(aggs[0].Aggs[1:], true), false))
+
+	response := elastic.SearchResponse{}
+	err := util.FromJson(`{"aggregations":{"by_level":{"buckets":[{"key":1,"doc_count":3,"avg_age":{"value":28.5},"by_day":{"buckets":[{"key_as_string":"2020-01-01","key":1577836800000,"doc_count":3}]}}]},"sum_age":{"value":165}}}`, &response)
+	assert.Nil(t, err)
+
+	result, err := getAggregationResults(aggs, response.Aggregations)
+	assert.Nil(t, err)
+	assert.Equal(t, 165.0, *result["sum_age"].Value)
+	bucket := result["by_level"].Buckets[0]
+	assert.Equal(t, 1.0, bucket.Key)
+	assert.Equal(t, 3, bucket.DocCount)
+	assert.Equal(t, 28.5, *bucket.Aggregations["avg_age"].Value)
+	assert.Equal(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), bucket.Aggregations["by_day"].Buckets[0].Key)
+}
+
+func TestTransactionNotSupported(t *testing.T) {
+	called := false
+	err := ElasticORM{}.Transaction(func(tx api.Tx) error {
+		called = true
+		return nil
+	})
+	assert.Equal(t, api.ErrTransactionNotSupported, err)
+	assert.False(t, called)
+}
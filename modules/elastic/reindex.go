@@ -0,0 +1,724 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elastic
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/cihub/seelog"
+	"github.com/huminghe/infini-framework/core/elastic"
+	"github.com/huminghe/infini-framework/core/errors"
+	"github.com/huminghe/infini-framework/core/kv"
+	"github.com/huminghe/infini-framework/core/util"
+	"github.com/huminghe/infini-framework/modules/elastic/adapter"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReindexConfig is the config of copying the documents from an elasticsearch instance to another,
+// the source and target could be the same instance
+type ReindexConfig struct {
+	Name   string `config:"name" json:"name,omitempty"`
+	Source string `config:"source" json:"source"`
+	Target string `config:"target" json:"target"`
+	//SourceIndex the indices to copy from, which could be a pattern
+	SourceIndex string `config:"source_index" json:"source_index"`
+	//TargetIndex the index to copy to, the documents are copied to the index of the same name if it is not set,
+	//the index prefix of target is prepended
+	TargetIndex string `config:"target_index" json:"target_index,omitempty"`
+	//Query the query string to filter the documents
+	Query string `config:"query" json:"query,omitempty"`
+	//Slices the number of sliced scrolls running in parallel, which is not supported before elasticsearch 5.0
+	Slices     int    `config:"slices" json:"slices,omitempty"`
+	BatchSize  int    `config:"batch_size" json:"batch_size,omitempty"`
+	ScrollTime string `config:"scroll_time" json:"scroll_time,omitempty"`
+	//Remove, Rename and Set are applied to the source of documents in order, the fields could be paths separated by `.`
+	Remove []string               `config:"remove" json:"remove,omitempty"`
+	Rename map[string]string      `config:"rename" json:"rename,omitempty"`
+	Set    map[string]interface{} `config:"set" json:"set,omitempty"`
+	//CopySettings and CopyMappings create the target index with the settings and mappings of the source index,
+	//the existing index is not changed
+	CopySettings bool `config:"copy_settings" json:"copy_settings,omitempty"`
+	CopyMappings bool `config:"copy_mappings" json:"copy_mappings,omitempty"`
+}
+
+type ReindexStatus string
+
+const ReindexRunning ReindexStatus = "running"
+const ReindexCompleted ReindexStatus = "completed"
+const ReindexFailed ReindexStatus = "failed"
+const ReindexCancelled ReindexStatus = "cancelled"
+
+// SliceProgress is the progress of a sliced scroll, the slice is copied again if the task is resumed before it is done
+type SliceProgress struct {
+	ID     int   `json:"id"`
+	Done   bool  `json:"done"`
+	Total  int64 `json:"total"`
+	Copied int64 `json:"copied"`
+	Failed int64 `json:"failed"`
+}
+
+// ReindexTask is the state of a reindex, which is saved in the kv store, so it could be resumed after failed or cancelled
+type ReindexTask struct {
+	ID      string          `json:"id"`
+	Config  ReindexConfig   `json:"config"`
+	Status  ReindexStatus   `json:"status"`
+	Error   string          `json:"error,omitempty"`
+	Total   int64           `json:"total"`
+	Copied  int64           `json:"copied"`
+	Failed  int64           `json:"failed"`
+	Slices  []SliceProgress `json:"slices"`
+	Created time.Time       `json:"created"`
+	Updated time.Time       `json:"updated"`
+
+	lock   sync.Mutex
+	cancel chan bool
+	done   chan bool
+	stop   sync.Once
+}
+
+const reindexBucket = "elastic_reindex"
+
+const defaultReindexBatchSize = 1000
+const defaultReindexScrollTime = "5m"
+
+var reindexBulkRetries = 3
+
+var errReindexCancelled = errors.New("reindex was cancelled")
+
+// errReindexConflict is returned if the unfinished task of the same name has a different config
+var errReindexConflict = errors.New("reindex conflict")
+
+var reindexLock sync.Mutex
+var reindexTasks = map[string]*ReindexTask{}
+
+// saveReindexTask and loadReindexTasks persist the tasks, which are replaced in tests
+var saveReindexTask = func(task *ReindexTask) error {
+	v, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+	return kv.AddValue(reindexBucket, []byte(task.ID), v)
+}
+
+var loadReindexTasks = func() (tasks []*ReindexTask, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("%v", r)
+		}
+	}()
+
+	tasks = []*ReindexTask{}
+	scanErr := kv.Scan(reindexBucket, nil, nil, 0, func(key, value []byte) bool {
+		task := &ReindexTask{}
+		if err = json.Unmarshal(value, task); err != nil {
+			return false
+		}
+		tasks = append(tasks, task)
+		return true
+	})
+	if scanErr != nil {
+		return nil, scanErr
+	}
+	return tasks, err
+}
+
+// StartReindex create the target index if required, and copy the documents in background
+func StartReindex(config ReindexConfig) (*ReindexTask, error) {
+	source, target, config, err := prepareReindex(config)
+	if err != nil {
+		return nil, err
+	}
+
+	err = prepareTargetIndex(source, target, config)
+	if err != nil {
+		return nil, err
+	}
+
+	task := &ReindexTask{ID: util.GetUUID(), Config: config, Created: time.Now()}
+	for i := 0; i < config.Slices; i++ {
+		task.Slices = append(task.Slices, SliceProgress{ID: i})
+	}
+	return task, runReindex(task, source, target)
+}
+
+// RunReindex resume the last task of the same name if it is not completed and has the same config, or start a new one,
+// errReindexConflict is returned if the config is changed, the task should be resumed by id or cancelled first
+func RunReindex(config ReindexConfig) (*ReindexTask, error) {
+	if config.Name != "" {
+		_, _, normalized, err := prepareReindex(config)
+		if err != nil {
+			return nil, err
+		}
+
+		tasks, err := ListReindexTasks()
+		if err != nil {
+			log.Warn("failed to load the reindex tasks, ", err)
+		}
+		for _, task := range tasks {
+			if task.Config.Name != config.Name {
+				continue
+			}
+			if task.Status != ReindexCompleted {
+				if !sameReindexConfig(task.Config, normalized) {
+					return nil, errors.Wrapf(errReindexConflict, "reindex %s of %s is not completed and its config is different", task.ID, config.Name)
+				}
+				log.Infof("resume reindex %s of %s", task.ID, config.Name)
+				return ResumeReindex(task.ID)
+			}
+			break
+		}
+	}
+	return StartReindex(config)
+}
+
+// prepareReindex validate the config and fill the default values
+func prepareReindex(config ReindexConfig) (source, target elastic.API, result ReindexConfig, err error) {
+	if config.Source == "" || config.Target == "" || config.SourceIndex == "" {
+		return nil, nil, config, errors.New("source, target and source_index are required")
+	}
+	//the index prefix of the same instance is the same, the documents would be indexed to where they are scrolled from
+	if config.Source == config.Target && (config.TargetIndex == "" || config.TargetIndex == config.SourceIndex) {
+		return nil, nil, config, errors.Errorf("source_index %s is the same as the target index", config.SourceIndex)
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultReindexBatchSize
+	}
+	if config.ScrollTime == "" {
+		config.ScrollTime = defaultReindexScrollTime
+	}
+	if config.Slices <= 0 {
+		config.Slices = 1
+	}
+
+	source, target, err = getReindexClients(config)
+	if err != nil {
+		return nil, nil, config, err
+	}
+	if config.Slices > 1 && !source.HasCapability(elastic.CapabilitySlicedScroll) {
+		log.Warnf("sliced scroll is not supported by elasticsearch %s", source.ClusterVersion().Version.Number)
+		config.Slices = 1
+	}
+	return source, target, config, nil
+}
+
+// sameReindexConfig compare the configs by json, so the values decoded from the saved task are comparable
+func sameReindexConfig(a, b ReindexConfig) bool {
+	v1, err1 := json.Marshal(a)
+	v2, err2 := json.Marshal(b)
+	return err1 == nil && err2 == nil && string(v1) == string(v2)
+}
+
+// ResumeReindex continue the task which is not completed, the slices not done are copied from the beginning,
+// it is safe as the documents are indexed with the same ids
+func ResumeReindex(id string) (*ReindexTask, error) {
+	task, err := GetReindexTask(id)
+	if err != nil {
+		return nil, err
+	}
+	if task.Status == ReindexCompleted {
+		return nil, errors.Errorf("reindex %s is completed", id)
+	}
+
+	source, target, err := getReindexClients(task.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	task = &ReindexTask{ID: task.ID, Config: task.Config, Slices: task.Slices, Created: task.Created}
+	for i := range task.Slices {
+		if !task.Slices[i].Done {
+			task.Slices[i] = SliceProgress{ID: task.Slices[i].ID}
+		}
+	}
+	return task, runReindex(task, source, target)
+}
+
+// CancelReindex stop the running task, it could be resumed later
+func CancelReindex(id string) error {
+	reindexLock.Lock()
+	task, ok := reindexTasks[id]
+	reindexLock.Unlock()
+	if !ok {
+		return errors.Errorf("reindex %s is not running", id)
+	}
+	task.stop.Do(func() { close(task.cancel) })
+	return nil
+}
+
+// GetReindexTask return the progress of task
+func GetReindexTask(id string) (*ReindexTask, error) {
+	reindexLock.Lock()
+	task, ok := reindexTasks[id]
+	reindexLock.Unlock()
+	if ok {
+		return task.snapshot(), nil
+	}
+
+	tasks, err := loadReindexTasks()
+	if err != nil {
+		return nil, err
+	}
+	for _, task := range tasks {
+		if task.ID == id {
+			return task, nil
+		}
+	}
+	return nil, errors.Errorf("reindex %s was not found", id)
+}
+
+// ListReindexTasks return all the tasks, the latest one is the first
+func ListReindexTasks() ([]*ReindexTask, error) {
+	tasks, err := loadReindexTasks()
+	if err != nil {
+		return nil, err
+	}
+
+	reindexLock.Lock()
+	for i, task := range tasks {
+		if running, ok := reindexTasks[task.ID]; ok {
+			tasks[i] = running.snapshot()
+		}
+	}
+	reindexLock.Unlock()
+
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].Created.After(tasks[j].Created)
+	})
+	return tasks, nil
+}
+
+// Wait block until the task is finished
+func (task *ReindexTask) Wait() {
+	<-task.done
+}
+
+// getSourcePrefix return the index prefix of source, the prefix is not applied by the scroll, settings and mapping apis,
+// and the index names returned by them are prefixed
+func getSourcePrefix(config ReindexConfig) string {
+	return elastic.GetConfig(config.Source).IndexPrefix
+}
+
+func getReindexClients(config ReindexConfig) (source, target elastic.API, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("%v", r)
+		}
+	}()
+	return elastic.GetClient(config.Source), elastic.GetClient(config.Target), nil
+}
+
+func runReindex(task *ReindexTask, source, target elastic.API) error {
+	reindexLock.Lock()
+	if _, ok := reindexTasks[task.ID]; ok {
+		reindexLock.Unlock()
+		return errors.Errorf("reindex %s is running", task.ID)
+	}
+	reindexTasks[task.ID] = task
+	reindexLock.Unlock()
+
+	task.Status = ReindexRunning
+	task.cancel = make(chan bool)
+	task.done = make(chan bool)
+	task.save()
+
+	log.Infof("reindex %s started, %s/%s to %s/%s", task.ID, task.Config.Source, task.Config.SourceIndex,
+		task.Config.Target, task.Config.TargetIndex)
+
+	go func() {
+		defer close(task.done)
+
+		wg := sync.WaitGroup{}
+		errs := make([]error, len(task.Slices))
+		for i := range task.Slices {
+			if task.Slices[i].Done {
+				continue
+			}
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = task.copySlice(i, source, target)
+				if errs[i] != nil {
+					//stop the other slices, the task could be resumed later
+					task.stop.Do(func() { close(task.cancel) })
+				}
+			}(i)
+		}
+
+		finished := make(chan bool)
+		go func() {
+			ticker := time.NewTicker(10 * time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-finished:
+					return
+				case <-ticker.C:
+					progress := task.save()
+					log.Infof("reindex %s: %v of %v copied, %v failed", task.ID, progress.Copied, progress.Total, progress.Failed)
+				}
+			}
+		}()
+		wg.Wait()
+		close(finished)
+
+		task.lock.Lock()
+		task.Status = ReindexCompleted
+		for _, err := range errs {
+			if err == errReindexCancelled && task.Status == ReindexCompleted {
+				task.Status = ReindexCancelled
+			} else if err != nil && err != errReindexCancelled {
+				task.Status = ReindexFailed
+				task.Error = err.Error()
+			}
+		}
+		task.lock.Unlock()
+
+		progress := task.save()
+		reindexLock.Lock()
+		delete(reindexTasks, task.ID)
+		reindexLock.Unlock()
+
+		log.Infof("reindex %s %s, %v of %v copied, %v failed", task.ID, progress.Status, progress.Copied, progress.Total, progress.Failed)
+	}()
+	return nil
+}
+
+// snapshot return a copy of the task with the summary of slices
+func (task *ReindexTask) snapshot() *ReindexTask {
+	task.lock.Lock()
+	defer task.lock.Unlock()
+
+	result := &ReindexTask{ID: task.ID, Config: task.Config, Status: task.Status, Error: task.Error,
+		Created: task.Created, Updated: time.Now()}
+	result.Slices = append([]SliceProgress{}, task.Slices...)
+	for _, slice := range result.Slices {
+		result.Total += slice.Total
+		result.Copied += slice.Copied
+		result.Failed += slice.Failed
+	}
+	return result
+}
+
+// save persist the progress, the task is not resumable if there is no kv store
+func (task *ReindexTask) save() *ReindexTask {
+	progress := task.snapshot()
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("failed to save reindex %s, %v", task.ID, r)
+		}
+	}()
+	err := saveReindexTask(progress)
+	if err != nil {
+		log.Errorf("failed to save reindex %s, %v", task.ID, err)
+	}
+	return progress
+}
+
+func (task *ReindexTask) updateSlice(i int, fn func(slice *SliceProgress)) {
+	task.lock.Lock()
+	fn(&task.Slices[i])
+	task.lock.Unlock()
+}
+
+// copySlice scroll the documents of the slice, and index them to the target in bulks
+func (task *ReindexTask) copySlice(i int, source, target elastic.API) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("%v", r)
+		}
+	}()
+
+	config := task.Config
+	id := task.Slices[i].ID
+	prefix := getSourcePrefix(config)
+	resp, err := source.NewScroll(prefix+config.SourceIndex, config.ScrollTime, config.BatchSize, config.Query, id, config.Slices, "")
+	if err != nil {
+		return err
+	}
+
+	for first := true; ; first = false {
+		scroll, ok := resp.(elastic.ScrollResponseAPI)
+		if !ok {
+			return errors.Errorf("invalid scroll response: %v", resp)
+		}
+		if first {
+			task.updateSlice(i, func(slice *SliceProgress) { slice.Total = int64(scroll.GetHitsTotal()) })
+		}
+
+		docs := scroll.GetDocs()
+		//there is no hits in the first response of scan before elasticsearch 5.0
+		if len(docs) == 0 && !(first && scroll.GetHitsTotal() > 0) {
+			break
+		}
+		if len(docs) > 0 {
+			copied, failed, err := task.bulk(target, docs, prefix)
+			if err != nil {
+				return err
+			}
+			task.updateSlice(i, func(slice *SliceProgress) {
+				slice.Copied += copied
+				slice.Failed += failed
+			})
+		}
+
+		select {
+		case <-task.cancel:
+			return errReindexCancelled
+		default:
+		}
+
+		resp, err = source.NextScroll(config.ScrollTime, scroll.GetScrollId())
+		if err != nil {
+			return err
+		}
+	}
+
+	task.updateSlice(i, func(slice *SliceProgress) { slice.Done = true })
+	task.save()
+	return nil
+}
+
+// bulk index the documents, the request is retried if it is failed, and the failed documents are counted and logged,
+// the source prefix is removed from the index of documents, as the target prefix is prepended by the target
+func (task *ReindexTask) bulk(target elastic.API, docs []interface{}, sourcePrefix string) (copied, failed int64, err error) {
+	actions := make([]elastic.BulkAction, 0, len(docs))
+	for _, doc := range docs {
+		hit, ok := doc.(map[string]interface{})
+		if !ok {
+			return 0, 0, errors.Errorf("invalid document: %v", doc)
+		}
+		index := task.Config.TargetIndex
+		if index == "" {
+			index, _ = hit["_index"].(string)
+			index = strings.TrimPrefix(index, sourcePrefix)
+		}
+		source, _ := hit["_source"].(map[string]interface{})
+		if source == nil {
+			source = map[string]interface{}{}
+		}
+		transformSource(source, task.Config)
+		actions = append(actions, elastic.BulkAction{Type: elastic.BulkIndex, Index: index, ID: fmt.Sprint(hit["_id"]), Doc: source})
+	}
+
+	var resp *elastic.BulkResponse
+	for retry := 0; ; retry++ {
+		resp, err = target.BulkRequest(actions)
+		if err == nil || retry >= reindexBulkRetries {
+			break
+		}
+		log.Warnf("reindex %s, failed to index %v documents, %v", task.ID, len(actions), err)
+		select {
+		case <-task.cancel:
+			return 0, 0, errReindexCancelled
+		case <-time.After(time.Duration(retry+1) * time.Second):
+		}
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, item := range resp.GetItems() {
+		if item.Error != nil || item.Status >= 300 {
+			failed++
+			log.Warnf("reindex %s, failed to index document %s/%s, %v", task.ID, item.Index, item.ID, item.Error)
+			continue
+		}
+		copied++
+	}
+	return copied, failed, nil
+}
+
+// transformSource remove, rename and set the fields of source, the fields of renames are removed before set,
+// so that the renames could be swapped
+func transformSource(source map[string]interface{}, config ReindexConfig) {
+	m := util.MapStr(source)
+	for _, field := range config.Remove {
+		m.Delete(field)
+	}
+
+	values := map[string]interface{}{}
+	for from, to := range config.Rename {
+		if v, err := m.GetValue(from); err == nil {
+			m.Delete(from)
+			values[to] = v
+		}
+	}
+	for to, v := range values {
+		m.Put(to, v)
+	}
+
+	for field, v := range config.Set {
+		m.Put(field, v)
+	}
+}
+
+// prepareTargetIndex create the target indices with the settings and mappings of the source indices
+func prepareTargetIndex(source, target elastic.API, config ReindexConfig) (err error) {
+	if !config.CopySettings && !config.CopyMappings {
+		return nil
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("%v", r)
+		}
+	}()
+
+	prefix := getSourcePrefix(config)
+	settings, err := source.GetIndexSettings(prefix + config.SourceIndex)
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	for name := range *settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return errors.Errorf("index %s was not found", config.SourceIndex)
+	}
+	//all the source indices are copied to the same index if the target index is set, the first one is used
+	if config.TargetIndex != "" {
+		names = names[:1]
+	}
+
+	for _, name := range names {
+		targetIndex := config.TargetIndex
+		if targetIndex == "" {
+			targetIndex = strings.TrimPrefix(name, prefix)
+		}
+		exists, err := target.IndexExists(targetIndex)
+		if err != nil {
+			return err
+		}
+		if exists {
+			log.Infof("index %s exists in %s, settings and mappings are not copied", targetIndex, config.Target)
+			continue
+		}
+
+		body, err := getIndexBody(source, target, settings, name, config)
+		if err != nil {
+			return err
+		}
+		err = target.CreateIndex(targetIndex, body)
+		if err != nil {
+			return err
+		}
+		log.Debugf("index %s is created in %s", targetIndex, config.Target)
+	}
+	return nil
+}
+
+func getIndexBody(source, target elastic.API, settings *elastic.Indexes, name string, config ReindexConfig) (map[string]interface{}, error) {
+	indexSettings := map[string]interface{}{}
+	if config.CopySettings {
+		v, _ := getIndexValue(*settings, name, "settings.index")
+		if m, ok := v.(map[string]interface{}); ok {
+			indexSettings = removePrivateSettings(m, "")
+		}
+	}
+	body := map[string]interface{}{
+		"settings": map[string]interface{}{"index": indexSettings},
+	}
+
+	if config.CopyMappings {
+		_, _, mappings, err := source.GetMapping(false, name)
+		if err != nil {
+			return nil, err
+		}
+		v, _ := getIndexValue(*mappings, name, "mappings")
+		mapping, _ := v.(map[string]interface{})
+		mapping, err = convertMapping(mapping, source.ClusterVersion().GetMajorVersion(), target.ClusterVersion().GetMajorVersion())
+		if err != nil {
+			return nil, err
+		}
+		if len(mapping) > 0 {
+			body["mappings"] = mapping
+		}
+	}
+	return body, nil
+}
+
+// privateSettings are the index settings maintained by elasticsearch, which are rejected or meaningless
+// when the index is created
+var privateSettings = []string{"uuid", "creation_date", "version", "provided_name", "routing.allocation.initial_recovery"}
+
+// removePrivateSettings return a copy of the index settings without the private settings, the keys could be
+// nested or dotted, and the objects left empty by the removal are dropped
+func removePrivateSettings(settings map[string]interface{}, prefix string) map[string]interface{} {
+	result := map[string]interface{}{}
+	for k, v := range settings {
+		path := prefix + k
+		if isPrivateSetting(path) {
+			continue
+		}
+		if m, ok := v.(map[string]interface{}); ok && len(m) > 0 {
+			m = removePrivateSettings(m, path+".")
+			if len(m) == 0 {
+				continue
+			}
+			v = m
+		}
+		result[k] = v
+	}
+	return result
+}
+
+func isPrivateSetting(path string) bool {
+	for _, setting := range privateSettings {
+		if path == setting || strings.HasPrefix(path, setting+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// getIndexValue return the value of the index by path, the index name may contain `.`
+func getIndexValue(indexes elastic.Indexes, name, path string) (interface{}, error) {
+	m, ok := indexes[name].(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("index %s was not found", name)
+	}
+	return util.MapStr(m).GetValue(path)
+}
+
+// convertMapping convert the mapping between versions, the mappings are grouped by type before 7.0,
+// and the type of documents indexed by adapters is `doc`
+func convertMapping(mapping map[string]interface{}, sourceVersion, targetVersion int) (map[string]interface{}, error) {
+	if len(mapping) == 0 {
+		return mapping, nil
+	}
+	if sourceVersion < 7 {
+		delete(mapping, "_default_")
+		if len(mapping) != 1 {
+			return nil, errors.Errorf("unable to copy the mapping of %v types", len(mapping))
+		}
+		for _, v := range mapping {
+			typeMapping, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, errors.Errorf("invalid mapping: %v", v)
+			}
+			mapping = typeMapping
+		}
+	}
+	if targetVersion < 7 {
+		return map[string]interface{}{adapter.TypeName6: mapping}, nil
+	}
+	return mapping, nil
+}
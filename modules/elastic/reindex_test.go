@@ -0,0 +1,387 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elastic
+
+import (
+	"fmt"
+	"github.com/huminghe/infini-framework/core/elastic"
+	"github.com/huminghe/infini-framework/core/errors"
+	"github.com/huminghe/infini-framework/core/util"
+	"github.com/huminghe/infini-framework/modules/elastic/elastictest"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// scrollClient scroll the documents, which are assigned to slices by the order
+type scrollClient struct {
+	elastic.API
+	docs []map[string]interface{}
+}
+
+func (c *scrollClient) ClusterVersion() *elastic.ClusterVersion {
+	v := &elastic.ClusterVersion{}
+	v.Version.Number = "7.10.0"
+	return v
+}
+
+func (c *scrollClient) HasCapability(capability elastic.Capability) bool {
+	return capability == elastic.CapabilitySlicedScroll
+}
+
+func (c *scrollClient) getPage(slice, slices, from, size int) *elastic.ScrollResponse {
+	docs := []interface{}{}
+	for i, doc := range c.docs {
+		if i%slices == slice {
+			docs = append(docs, doc)
+		}
+	}
+	resp := &elastic.ScrollResponse{ScrollId: fmt.Sprintf("%d,%d,%d,%d", slice, slices, from+size, size)}
+	resp.Hits.Total = len(docs)
+	if from < len(docs) {
+		end := from + size
+		if end > len(docs) {
+			end = len(docs)
+		}
+		resp.Hits.Docs = docs[from:end]
+	}
+	return resp
+}
+
+func (c *scrollClient) NewScroll(indexNames string, scrollTime string, docBufferCount int, query string, slicedId, maxSlicedCount int, fields string) (interface{}, error) {
+	return c.getPage(slicedId, maxSlicedCount, 0, docBufferCount), nil
+}
+
+func (c *scrollClient) NextScroll(scrollTime string, scrollId string) (interface{}, error) {
+	var slice, slices, from, size int
+	fmt.Sscanf(scrollId, "%d,%d,%d,%d", &slice, &slices, &from, &size)
+	return c.getPage(slice, slices, from, size), nil
+}
+
+// targetClient record the indexed documents, and fail the bulk request if it contains the document of failID
+type targetClient struct {
+	elastic.API
+	lock   sync.Mutex
+	failID string
+	docs   map[string]elastic.BulkAction
+}
+
+func (c *targetClient) ClusterVersion() *elastic.ClusterVersion {
+	v := &elastic.ClusterVersion{}
+	v.Version.Number = "6.8.0"
+	return v
+}
+
+func (c *targetClient) BulkRequest(actions []elastic.BulkAction) (*elastic.BulkResponse, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	resp := &elastic.BulkResponse{}
+	for _, action := range actions {
+		if action.ID == c.failID {
+			return nil, fmt.Errorf("bulk request failed")
+		}
+	}
+	for _, action := range actions {
+		c.docs[action.ID] = action
+		resp.Items = append(resp.Items, map[elastic.BulkActionType]elastic.BulkItemResponse{
+			action.Type: {Index: action.Index, ID: action.ID, Status: 201},
+		})
+	}
+	return resp, nil
+}
+
+func setupReindex(count int) (*scrollClient, *targetClient, func()) {
+	source := &scrollClient{}
+	for i := 0; i < count; i++ {
+		source.docs = append(source.docs, map[string]interface{}{
+			"_index": "src", "_id": fmt.Sprint(i),
+			"_source": map[string]interface{}{"name": fmt.Sprint("doc", i), "tmp": 1, "user": map[string]interface{}{"id": i}},
+		})
+	}
+	target := &targetClient{docs: map[string]elastic.BulkAction{}}
+	elastic.RegisterInstance("reindex_source", elastic.ElasticsearchConfig{}, source)
+	elastic.RegisterInstance("reindex_target", elastic.ElasticsearchConfig{}, target)
+
+	saved := map[string]*ReindexTask{}
+	lock := sync.Mutex{}
+	save, load, retries := saveReindexTask, loadReindexTasks, reindexBulkRetries
+	saveReindexTask = func(task *ReindexTask) error {
+		lock.Lock()
+		defer lock.Unlock()
+		saved[task.ID] = task
+		return nil
+	}
+	loadReindexTasks = func() ([]*ReindexTask, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		tasks := []*ReindexTask{}
+		for _, task := range saved {
+			tasks = append(tasks, task)
+		}
+		return tasks, nil
+	}
+	reindexBulkRetries = 0
+
+	return source, target, func() {
+		saveReindexTask, loadReindexTasks, reindexBulkRetries = save, load, retries
+	}
+}
+
+func TestReindex(t *testing.T) {
+	_, target, cleanup := setupReindex(10)
+	defer cleanup()
+
+	task, err := StartReindex(ReindexConfig{Source: "reindex_source", Target: "reindex_target", SourceIndex: "src", TargetIndex: "dest",
+		Slices: 2, BatchSize: 3, Remove: []string{"tmp"}, Rename: map[string]string{"name": "title", "user.id": "uid"},
+		Set: map[string]interface{}{"meta.source": "src"}})
+	assert.Nil(t, err)
+	task.Wait()
+
+	task, err = GetReindexTask(task.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, ReindexCompleted, task.Status)
+	assert.Equal(t, int64(10), task.Total)
+	assert.Equal(t, int64(10), task.Copied)
+	assert.Equal(t, 2, len(task.Slices))
+
+	assert.Equal(t, 10, len(target.docs))
+	doc := target.docs["3"]
+	assert.Equal(t, "dest", doc.Index)
+	assert.Equal(t, map[string]interface{}{"title": "doc3", "uid": 3, "user": map[string]interface{}{}, "meta": map[string]interface{}{"source": "src"}},
+		toPlainMap(doc.Doc.(map[string]interface{})))
+}
+
+func TestResumeReindex(t *testing.T) {
+	_, target, cleanup := setupReindex(10)
+	defer cleanup()
+
+	//the slice of odd documents is failed, and the other one is cancelled or done
+	target.failID = "7"
+	config := ReindexConfig{Name: "resume", Source: "reindex_source", Target: "reindex_target", SourceIndex: "src", Slices: 2, BatchSize: 5}
+	task, err := RunReindex(config)
+	assert.Nil(t, err)
+	task.Wait()
+
+	task, err = GetReindexTask(task.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, ReindexFailed, task.Status)
+	assert.True(t, strings.Contains(task.Error, "bulk request failed"))
+	assert.False(t, task.Slices[1].Done)
+
+	//the unfinished task of the same name is not resumed with a different config
+	target.failID = ""
+	changed := config
+	changed.BatchSize = 2
+	_, err = RunReindex(changed)
+	assert.Equal(t, errReindexConflict, errors.Cause(err))
+
+	//the task of the same name is resumed
+	resumed, err := RunReindex(config)
+	assert.Nil(t, err)
+	assert.Equal(t, task.ID, resumed.ID)
+	resumed.Wait()
+
+	task, err = GetReindexTask(task.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, ReindexCompleted, task.Status)
+	assert.Equal(t, int64(10), task.Copied)
+	assert.Equal(t, 10, len(target.docs))
+	assert.Equal(t, "src", target.docs["7"].Index)
+
+	_, err = ResumeReindex(task.ID)
+	assert.NotNil(t, err)
+
+	//only the slices not done are copied
+	target.docs = map[string]elastic.BulkAction{}
+	saveReindexTask(&ReindexTask{ID: "cancelled", Config: config, Status: ReindexCancelled,
+		Slices: []SliceProgress{{ID: 0, Done: true, Total: 5, Copied: 5}, {ID: 1, Total: 5, Copied: 2}}})
+	resumed, err = ResumeReindex("cancelled")
+	assert.Nil(t, err)
+	resumed.Wait()
+
+	task, err = GetReindexTask("cancelled")
+	assert.Nil(t, err)
+	assert.Equal(t, ReindexCompleted, task.Status)
+	assert.Equal(t, int64(10), task.Copied)
+	assert.Equal(t, 5, len(target.docs))
+	assert.Equal(t, "src", target.docs["1"].Index)
+}
+
+func TestGetIndexBody(t *testing.T) {
+	settings := elastic.Indexes{"src": map[string]interface{}{"settings": map[string]interface{}{"index": map[string]interface{}{
+		"number_of_shards": "3", "uuid": "K9RS6X5HTZSgKtZnhZ5ZZA", "creation_date": "1577836800000",
+		"provided_name": "src", "version": map[string]interface{}{"created": "6082399", "upgraded": "6082399"},
+		"routing": map[string]interface{}{"allocation": map[string]interface{}{"initial_recovery": map[string]interface{}{"_id": "node"}}},
+		"routing.allocation.initial_recovery._name": "node", "version.created": "6082399",
+		"analysis": map[string]interface{}{"analyzer": map[string]interface{}{"default": map[string]interface{}{"type": "simple"}}},
+	}}}}
+
+	body, err := getIndexBody(nil, nil, &settings, "src", ReindexConfig{CopySettings: true})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"settings": map[string]interface{}{"index": map[string]interface{}{
+		"number_of_shards": "3",
+		"analysis":         map[string]interface{}{"analyzer": map[string]interface{}{"default": map[string]interface{}{"type": "simple"}}},
+	}}}, body)
+
+	//the settings of source are not changed
+	index, _ := getIndexValue(settings, "src", "settings.index")
+	assert.Equal(t, "src", index.(map[string]interface{})["provided_name"])
+}
+
+// TestReindexCopySettings copy the settings returned by the fake elasticsearch, which rejects the private settings
+func TestReindexCopySettings(t *testing.T) {
+	_, _, cleanup := setupReindex(0)
+	defer cleanup()
+
+	for _, version := range elastictest.Versions {
+		version := version
+		t.Run(version, func(t *testing.T) {
+			server := elastictest.NewServer(version)
+			defer server.Close()
+			client, err := newElasticClient(elastic.ElasticsearchConfig{Endpoint: server.URL, Enabled: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			elastic.RegisterInstance("reindex_fake", elastic.ElasticsearchConfig{}, client)
+
+			assert.Nil(t, client.CreateIndex("src", map[string]interface{}{"settings": map[string]interface{}{"index": map[string]interface{}{"number_of_replicas": "0"}}}))
+			_, err = client.Index("src", "1", map[string]interface{}{"name": "doc1"})
+			assert.Nil(t, err)
+			assert.Nil(t, client.Refresh("src"))
+
+			task, err := StartReindex(ReindexConfig{Source: "reindex_fake", Target: "reindex_fake", SourceIndex: "src", TargetIndex: "dest", CopySettings: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			task.Wait()
+			task, err = GetReindexTask(task.ID)
+			assert.Nil(t, err)
+			assert.Equal(t, ReindexCompleted, task.Status)
+			assert.Equal(t, int64(1), task.Copied)
+
+			settings, err := client.GetIndexSettings("dest")
+			assert.Nil(t, err)
+			replicas, _ := getIndexValue(*settings, "dest", "settings.index.number_of_replicas")
+			assert.Equal(t, "0", replicas)
+			name, _ := getIndexValue(*settings, "dest", "settings.index.provided_name")
+			assert.Equal(t, "dest", name)
+		})
+	}
+	for _, pool := range pools {
+		pool.Stop()
+	}
+	pools = nil
+}
+
+func TestReindexSameIndex(t *testing.T) {
+	_, _, cleanup := setupReindex(0)
+	defer cleanup()
+
+	_, err := StartReindex(ReindexConfig{Source: "reindex_source", Target: "reindex_source", SourceIndex: "src"})
+	assert.NotNil(t, err)
+	_, err = StartReindex(ReindexConfig{Source: "reindex_source", Target: "reindex_source", SourceIndex: "src", TargetIndex: "src"})
+	assert.NotNil(t, err)
+}
+
+// TestReindexIndexPrefix copy the index between the instances of different prefixes, the documents scrolled
+// from the prefixed source index are indexed to the index of the same name with the target prefix
+func TestReindexIndexPrefix(t *testing.T) {
+	_, _, cleanup := setupReindex(0)
+	defer cleanup()
+
+	server := elastictest.NewServer("7.10.2")
+	defer server.Close()
+	sourceConfig := elastic.ElasticsearchConfig{Endpoint: server.URL, Enabled: true, IndexPrefix: "a_"}
+	targetConfig := elastic.ElasticsearchConfig{Endpoint: server.URL, Enabled: true, IndexPrefix: "b_"}
+	source, err := newElasticClient(sourceConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := newElasticClient(targetConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, pool := range pools {
+			pool.Stop()
+		}
+		pools = nil
+	}()
+	elastic.RegisterInstance("reindex_prefix_source", sourceConfig, source)
+	elastic.RegisterInstance("reindex_prefix_target", targetConfig, target)
+
+	assert.Nil(t, source.CreateIndex("src", map[string]interface{}{"settings": map[string]interface{}{"index": map[string]interface{}{"number_of_replicas": "0"}}}))
+	_, err = source.Index("src", "1", map[string]interface{}{"name": "doc1"})
+	assert.Nil(t, err)
+	assert.Nil(t, source.Refresh("src"))
+
+	task, err := StartReindex(ReindexConfig{Source: "reindex_prefix_source", Target: "reindex_prefix_target", SourceIndex: "src", CopySettings: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task.Wait()
+	task, err = GetReindexTask(task.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, ReindexCompleted, task.Status)
+	assert.Equal(t, int64(1), task.Copied)
+
+	settings, err := target.GetIndexSettings("b_src")
+	assert.Nil(t, err)
+	replicas, _ := getIndexValue(*settings, "b_src", "settings.index.number_of_replicas")
+	assert.Equal(t, "0", replicas)
+	exists, err := target.IndexExists("a_src")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+
+	assert.Nil(t, target.Refresh("src"))
+	count, err := target.Count("src")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count.Count)
+}
+
+func TestConvertMapping(t *testing.T) {
+	properties := map[string]interface{}{"properties": map[string]interface{}{"name": map[string]interface{}{"type": "keyword"}}}
+
+	m, err := convertMapping(map[string]interface{}{"_default_": map[string]interface{}{}, "item": properties}, 6, 7)
+	assert.Nil(t, err)
+	assert.Equal(t, properties, m)
+
+	m, err = convertMapping(properties, 7, 6)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"doc": properties}, m)
+
+	_, err = convertMapping(map[string]interface{}{"a": properties, "b": properties}, 5, 7)
+	assert.NotNil(t, err)
+}
+
+// toPlainMap convert the nested maps to map[string]interface{}
+func toPlainMap(m map[string]interface{}) map[string]interface{} {
+	result := map[string]interface{}{}
+	for k, v := range m {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			result[k] = toPlainMap(v)
+		case util.MapStr:
+			result[k] = toPlainMap(v)
+		default:
+			result[k] = v
+		}
+	}
+	return result
+}
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	log "github.com/cihub/seelog"
 	"github.com/huminghe/infini-framework/core/util"
+	"reflect"
 	"strings"
 	"unicode"
 )
@@ -35,6 +36,26 @@ func getIndexID(any interface{}) string {
 	return util.GetFieldValueByTagName(any, "elastic_meta", "_id")
 }
 
+// getIndexIDField return the json name of the field which is used as document ID, or empty if it is not in the source
+func getIndexIDField(any interface{}) string {
+	t := reflect.Indirect(reflect.ValueOf(any)).Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Tag.Get("elastic_meta") != "_id" {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			name = field.Name
+		}
+		return name
+	}
+	return ""
+}
+
 func getIndexMapping(any interface{}) []util.Annotation {
 	return util.GetTagsByTagName(any, "elastic_mapping")
 }
@@ -103,3 +103,11 @@ func (store ElasticStore) DeleteKey(bucket string, key []byte) error {
 func (store ElasticStore) DeleteBucket(bucket string) error {
 	panic(errors.New("not implemented yet"))
 }
+
+func (store ElasticStore) ListBuckets() ([]string, error) {
+	return nil, errors.New("not implemented yet")
+}
+
+func (store ElasticStore) Scan(bucket string, prefix []byte, from []byte, size int, walkFunc func(key, value []byte) bool) error {
+	return errors.New("not implemented yet")
+}
@@ -0,0 +1,254 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"github.com/huminghe/infini-framework/core/api"
+	"github.com/huminghe/infini-framework/core/api/router"
+	"github.com/huminghe/infini-framework/core/errors"
+	"github.com/huminghe/infini-framework/core/filter"
+	"github.com/huminghe/infini-framework/core/ui"
+	"net/http"
+)
+
+// API namespace
+type API struct {
+	api.Handler
+}
+
+// KeyRequest is the request body of the batch operations
+type KeyRequest struct {
+	Keys []string `json:"keys"`
+}
+
+// KeyResult is the status of a key in the bucket
+type KeyResult struct {
+	Key    string `json:"key"`
+	Exists bool   `json:"exists"`
+}
+
+// FilterInfo is the summary of a registered filter
+type FilterInfo struct {
+	Name      string `json:"name"`
+	Active    bool   `json:"active"`
+	Clearable bool   `json:"clearable"`
+	Stats     bool   `json:"stats"`
+}
+
+func (handler API) Init() {
+
+	//Filter API, also registered to the ui server, so the admin ui is able to access,
+	//all the operations are applied to the active filter, or the one specified by the parameter `filter`
+	handle(api.GET, "/filter/", api.PERMISSION_FILTER_READ, handler.listFiltersAction)
+	handle(api.GET, "/filter/:bucket/_check", api.PERMISSION_FILTER_READ, handler.checkKeyAction)
+	handle(api.POST, "/filter/:bucket/_check", api.PERMISSION_FILTER_READ, handler.checkKeysAction)
+	handle(api.POST, "/filter/:bucket/_add", api.PERMISSION_FILTER_WRITE, handler.addKeysAction)
+	handle(api.POST, "/filter/:bucket/_remove", api.PERMISSION_FILTER_WRITE, handler.removeKeysAction)
+	handle(api.POST, "/filter/:bucket/_clear", api.PERMISSION_FILTER_WRITE, handler.clearBucketAction)
+	handle(api.POST, "/filter/:bucket/_reset", api.PERMISSION_FILTER_WRITE, handler.clearBucketAction)
+	//the stats of some filters are collected by scanning the whole bucket, so it requires the write permission
+	handle(api.GET, "/filter/:bucket/_stats", api.PERMISSION_FILTER_WRITE, handler.getStatsAction)
+}
+
+func handle(method api.Method, pattern string, permission string, h httprouter.Handle) {
+	h = api.NeedPermission(permission, h)
+	api.HandleAPIMethod(method, pattern, h)
+	ui.HandleUIMethod(method, pattern, h)
+}
+
+// getFilter return the filter specified by the parameter `filter`, default to the active one
+func (handler API) getFilter(req *http.Request) (string, filter.Filter, error) {
+	name := handler.GetParameterOrDefault(req, "filter", filter.GetActiveName())
+	f, err := filter.GetFilter(name)
+	return name, f, err
+}
+
+// getKeys return the keys from the parameter `key`, or the keys of the request body
+func (handler API) getKeys(req *http.Request) ([]string, error) {
+	if key := handler.GetParameter(req, "key"); key != "" {
+		return []string{key}, nil
+	}
+
+	request := KeyRequest{}
+	err := handler.DecodeJSON(req, &request)
+	if err != nil {
+		return nil, err
+	}
+	if len(request.Keys) == 0 {
+		return nil, errors.New("keys should not be empty")
+	}
+	return request.Keys, nil
+}
+
+func (handler API) listFiltersAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	result := []FilterInfo{}
+	for _, name := range filter.ListFilters() {
+		f, err := filter.GetFilter(name)
+		if err != nil {
+			continue
+		}
+		_, clearable := f.(filter.Clearable)
+		_, stats := f.(filter.StatsProvider)
+		result = append(result, FilterInfo{
+			Name:      name,
+			Active:    name == filter.GetActiveName(),
+			Clearable: clearable,
+			Stats:     stats,
+		})
+	}
+	handler.WriteJSONListResult(w, len(result), result, http.StatusOK)
+}
+
+func (handler API) checkKeyAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	_, f, err := handler.getFilter(req)
+	if err != nil {
+		handler.Error(w, err)
+		return
+	}
+
+	key := handler.GetParameter(req, "key")
+	if key == "" {
+		handler.WriteJSON(w, map[string]interface{}{"error": "key should not be empty"}, http.StatusBadRequest)
+		return
+	}
+
+	handler.WriteJSON(w, KeyResult{Key: key, Exists: f.Exists(ps.ByName("bucket"), []byte(key))}, http.StatusOK)
+}
+
+// checkKeysAction check a batch of keys, the keys are passed by the request body: {"keys":["key1","key2"]}
+func (handler API) checkKeysAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	_, f, err := handler.getFilter(req)
+	if err != nil {
+		handler.Error(w, err)
+		return
+	}
+
+	keys, err := handler.getKeys(req)
+	if err != nil {
+		handler.WriteJSON(w, map[string]interface{}{"error": err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	bucket := ps.ByName("bucket")
+	result := []KeyResult{}
+	for _, key := range keys {
+		result = append(result, KeyResult{Key: key, Exists: f.Exists(bucket, []byte(key))})
+	}
+	handler.WriteJSONListResult(w, len(result), result, http.StatusOK)
+}
+
+// addKeysAction add the keys to the bucket, and return the previous status of the keys
+func (handler API) addKeysAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	_, f, err := handler.getFilter(req)
+	if err != nil {
+		handler.Error(w, err)
+		return
+	}
+
+	keys, err := handler.getKeys(req)
+	if err != nil {
+		handler.WriteJSON(w, map[string]interface{}{"error": err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	bucket := ps.ByName("bucket")
+	result := []KeyResult{}
+	for _, key := range keys {
+		b, err := f.CheckThenAdd(bucket, []byte(key))
+		if err != nil {
+			handler.Error(w, err)
+			return
+		}
+		result = append(result, KeyResult{Key: key, Exists: b})
+	}
+	handler.WriteJSONListResult(w, len(result), result, http.StatusOK)
+}
+
+// removeKeysAction remove the keys from the bucket, the filters which are not able to remove a key, such as the bloom filter, will be rejected
+func (handler API) removeKeysAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	_, f, err := handler.getFilter(req)
+	if err != nil {
+		handler.Error(w, err)
+		return
+	}
+
+	keys, err := handler.getKeys(req)
+	if err != nil {
+		handler.WriteJSON(w, map[string]interface{}{"error": err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	bucket := ps.ByName("bucket")
+	for _, key := range keys {
+		err = f.Delete(bucket, []byte(key))
+		if errors.Cause(err) == filter.ErrDeleteNotSupported {
+			handler.WriteJSON(w, map[string]interface{}{"error": err.Error()}, http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			handler.Error(w, err)
+			return
+		}
+	}
+	handler.WriteAckJSON(w, true)
+}
+
+// clearBucketAction remove all the keys of the bucket, the bucket will be re-created with the current settings on next access,
+// so it is also used to reset a bucket after the capacity changed
+func (handler API) clearBucketAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	name, f, err := handler.getFilter(req)
+	if err != nil {
+		handler.Error(w, err)
+		return
+	}
+
+	c, ok := f.(filter.Clearable)
+	if !ok {
+		handler.WriteJSON(w, map[string]interface{}{"error": errors.Errorf("filter: %v is not clearable", name).Error()}, http.StatusBadRequest)
+		return
+	}
+
+	err = c.Clear(ps.ByName("bucket"))
+	if err != nil {
+		handler.Error(w, err)
+		return
+	}
+	handler.WriteAckJSON(w, true)
+}
+
+// getStatsAction return the item count, the memory used and the estimated false positive rate of the bucket
+func (handler API) getStatsAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	name, f, err := handler.getFilter(req)
+	if err != nil {
+		handler.Error(w, err)
+		return
+	}
+
+	p, ok := f.(filter.StatsProvider)
+	if !ok {
+		handler.WriteJSON(w, map[string]interface{}{"error": errors.Errorf("filter: %v is not able to report stats", name).Error()}, http.StatusBadRequest)
+		return
+	}
+
+	stats, err := p.GetStats(ps.ByName("bucket"))
+	if err != nil {
+		handler.Error(w, err)
+		return
+	}
+	stats.Filter = name
+	handler.WriteJSON(w, stats, http.StatusOK)
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	log "github.com/cihub/seelog"
+	"github.com/huminghe/infini-framework/core/errors"
+	"github.com/huminghe/infini-framework/core/filter"
+	pb "github.com/huminghe/infini-framework/core/filter/pb"
+	"google.golang.org/grpc"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config defines how the keys are partitioned and forwarded to the cluster members
+type Config struct {
+	Enabled bool `config:"enabled"`
+
+	//Backend is the local filter, which stores the keys owned by the current node,
+	//and the keys of the unreachable nodes
+	Backend string `config:"backend"`
+
+	VirtualNodes             int `config:"virtual_nodes"`
+	BatchSize                int `config:"batch_size"`
+	BatchWaitInMs            int `config:"batch_wait_in_ms"`
+	TimeoutInMs              int `config:"timeout_in_ms"`
+	RetryIntervalInSeconds   int `config:"retry_interval_in_seconds"`
+	RefreshIntervalInSeconds int `config:"refresh_interval_in_seconds"`
+}
+
+// Membership provides the rpc addresses of the cluster members
+type Membership interface {
+	LocalAddress() string
+	Peers() []string
+}
+
+// ClusterFilter partitions the keys across the cluster members by consistent hashing,
+// the keys owned by other nodes are forwarded to them in batches over gRPC,
+// the local filter is used when the owner is the current node or it is unreachable
+type ClusterFilter struct {
+	Local      filter.Filter
+	Config     Config
+	Membership Membership
+
+	//Dial is used to connect to the peers, default to rpc.ObtainConnection
+	Dial func(address string) (*grpc.ClientConn, error)
+
+	lock    sync.RWMutex
+	ring    *ring
+	local   string
+	members string
+	peers   map[string]*peer
+	quit    chan bool
+}
+
+func (f *ClusterFilter) Open() error {
+	if f.Local == nil || f.Membership == nil || f.Dial == nil {
+		return errors.New("cluster filter: local filter, membership and dial are required")
+	}
+
+	if f.Config.BatchSize <= 0 {
+		f.Config.BatchSize = 1
+	}
+	if f.Config.TimeoutInMs <= 0 {
+		f.Config.TimeoutInMs = 1000
+	}
+	if f.Config.RefreshIntervalInSeconds <= 0 {
+		f.Config.RefreshIntervalInSeconds = 5
+	}
+
+	f.peers = map[string]*peer{}
+	f.refresh()
+
+	f.quit = make(chan bool)
+	go func(quit chan bool) {
+		ticker := time.NewTicker(time.Duration(f.Config.RefreshIntervalInSeconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+				f.refresh()
+			}
+		}
+	}(f.quit)
+	return nil
+}
+
+// Close stop the refresh schedule and close the connections to the peers, the local filter is closed by its owner
+func (f *ClusterFilter) Close() error {
+	if f.quit != nil {
+		close(f.quit)
+		f.quit = nil
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, p := range f.peers {
+		p.close()
+	}
+	f.peers = map[string]*peer{}
+	return nil
+}
+
+func (f *ClusterFilter) Exists(bucket string, key []byte) bool {
+	b, err := f.call(methodExists, bucket, key)
+	if err != nil {
+		log.Error(err)
+	}
+	return b
+}
+
+func (f *ClusterFilter) Add(bucket string, key []byte) error {
+	_, err := f.call(methodAdd, bucket, key)
+	return err
+}
+
+func (f *ClusterFilter) Delete(bucket string, key []byte) error {
+	_, err := f.call(methodDelete, bucket, key)
+	return err
+}
+
+func (f *ClusterFilter) CheckThenAdd(bucket string, key []byte) (bool, error) {
+	return f.call(methodCheckThenAdd, bucket, key)
+}
+
+func (f *ClusterFilter) call(method string, bucket string, key []byte) (bool, error) {
+	//the local address is only known after the rpc server is listening, rebuild the ring at once instead of waiting for the next refresh
+	if f.localChanged() {
+		f.refresh()
+	}
+
+	p := f.getPeer(bucket, key)
+	if p == nil || !p.available() {
+		return callLocal(f.Local, method, bucket, key)
+	}
+	return p.call(method, &pb.FilterItem{Bucket: bucket, Key: key})
+}
+
+// getPeer return the peer which owns the key, nil if the key is owned by the current node
+func (f *ClusterFilter) getPeer(bucket string, key []byte) *peer {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	partitionKey := make([]byte, 0, len(bucket)+1+len(key))
+	partitionKey = append(partitionKey, bucket...)
+	partitionKey = append(partitionKey, '/')
+	partitionKey = append(partitionKey, key...)
+
+	address := f.ring.get(partitionKey)
+	if address == "" || address == f.local {
+		return nil
+	}
+	return f.peers[address]
+}
+
+func (f *ClusterFilter) localChanged() bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.local != f.Membership.LocalAddress()
+}
+
+// refresh rebuild the ring when the members of the cluster changed
+func (f *ClusterFilter) refresh() {
+	local := f.Membership.LocalAddress()
+	members := []string{}
+	if local != "" {
+		members = append(members, local)
+	}
+	for _, v := range f.Membership.Peers() {
+		if v != "" && v != local {
+			members = append(members, v)
+		}
+	}
+	sort.Strings(members)
+	key := strings.Join(members, ",")
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.ring != nil && key == f.members && local == f.local {
+		return
+	}
+
+	log.Debug("cluster filter, members changed: ", key)
+	active := map[string]bool{}
+	for _, v := range members {
+		if v == local {
+			continue
+		}
+		active[v] = true
+		if _, ok := f.peers[v]; !ok {
+			f.peers[v] = newPeer(v, f)
+		}
+	}
+
+	//close the connections to the nodes left the cluster, the queued requests are handled locally
+	for address, p := range f.peers {
+		if !active[address] {
+			log.Debug("cluster filter, close peer: ", address)
+			p.close()
+			delete(f.peers, address)
+		}
+	}
+
+	f.ring = newRing(members, f.Config.VirtualNodes)
+	f.local = local
+	f.members = key
+}
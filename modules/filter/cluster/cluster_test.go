@@ -0,0 +1,257 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"fmt"
+	pb "github.com/huminghe/infini-framework/core/filter/pb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"net"
+	"sync"
+	"testing"
+)
+
+type memoryFilter struct {
+	lock  sync.Mutex
+	keys  map[string]bool
+	calls int
+}
+
+func (f *memoryFilter) Open() error  { return nil }
+func (f *memoryFilter) Close() error { return nil }
+
+func (f *memoryFilter) Exists(bucket string, key []byte) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.keys[bucket+"/"+string(key)]
+}
+
+func (f *memoryFilter) Add(bucket string, key []byte) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.keys[bucket+"/"+string(key)] = true
+	return nil
+}
+
+func (f *memoryFilter) Delete(bucket string, key []byte) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.keys, bucket+"/"+string(key))
+	return nil
+}
+
+func (f *memoryFilter) CheckThenAdd(bucket string, key []byte) (bool, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.calls++
+	b := f.keys[bucket+"/"+string(key)]
+	f.keys[bucket+"/"+string(key)] = true
+	return b, nil
+}
+
+type staticMembership struct {
+	local string
+	peers []string
+}
+
+func (m staticMembership) LocalAddress() string { return m.local }
+func (m staticMembership) Peers() []string      { return m.peers }
+
+// dynamicMembership changes the members while the filter is running
+type dynamicMembership struct {
+	lock  sync.Mutex
+	local string
+	peers []string
+}
+
+func (m *dynamicMembership) LocalAddress() string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.local
+}
+
+func (m *dynamicMembership) Peers() []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.peers
+}
+
+func (m *dynamicMembership) set(local string, peers []string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.local = local
+	m.peers = peers
+}
+
+func dial(address string) (*grpc.ClientConn, error) {
+	return grpc.Dial(address, grpc.WithInsecure())
+}
+
+func startNode(t *testing.T) (string, *memoryFilter, *grpc.Server) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	local := &memoryFilter{keys: map[string]bool{}}
+	s := grpc.NewServer()
+	pb.RegisterFilterServer(s, &Server{Local: local})
+	go s.Serve(listener)
+	return listener.Addr().String(), local, s
+}
+
+func TestRing(t *testing.T) {
+	r := newRing([]string{"a", "b", "c"}, 100)
+	counts := map[string]int{}
+	for i := 0; i < 3000; i++ {
+		counts[r.get([]byte(fmt.Sprint(i)))]++
+	}
+	assert.Equal(t, 3, len(counts))
+	for _, v := range counts {
+		assert.True(t, v > 500)
+	}
+
+	//only the keys of the left node are moved
+	r2 := newRing([]string{"a", "b"}, 100)
+	for i := 0; i < 3000; i++ {
+		key := []byte(fmt.Sprint(i))
+		if owner := r.get(key); owner != "c" {
+			assert.Equal(t, owner, r2.get(key))
+		}
+	}
+	assert.Equal(t, "", newRing(nil, 100).get([]byte("key")))
+}
+
+func TestClusterFilter(t *testing.T) {
+	addr1, local1, s1 := startNode(t)
+	addr2, local2, s2 := startNode(t)
+	defer s1.Stop()
+
+	config := Config{VirtualNodes: 100, BatchSize: 10, BatchWaitInMs: 5, TimeoutInMs: 1000, RetryIntervalInSeconds: 10}
+	node1 := &ClusterFilter{Local: local1, Config: config, Membership: staticMembership{addr1, []string{addr2}}, Dial: dial}
+	node2 := &ClusterFilter{Local: local2, Config: config, Membership: staticMembership{addr2, []string{addr1}}, Dial: dial}
+	assert.Nil(t, node1.Open())
+	assert.Nil(t, node2.Open())
+	defer node1.Close()
+	defer node2.Close()
+
+	//the same key is only added once across the cluster
+	wg := sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := []byte(fmt.Sprint("url-", i))
+			b, err := node1.CheckThenAdd("urls", key)
+			assert.Nil(t, err)
+			assert.False(t, b)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprint("url-", i))
+		b, err := node2.CheckThenAdd("urls", key)
+		assert.Nil(t, err)
+		assert.True(t, b)
+		assert.True(t, node2.Exists("urls", key))
+	}
+	assert.Equal(t, 100, len(local1.keys)+len(local2.keys))
+	assert.True(t, len(local1.keys) > 0)
+	assert.True(t, len(local2.keys) > 0)
+
+	assert.Nil(t, node1.Delete("urls", []byte("url-1")))
+	assert.False(t, node2.Exists("urls", []byte("url-1")))
+
+	//the peer is down, the keys are checked locally
+	s2.Stop()
+	for i := 100; i < 200; i++ {
+		b, err := node1.CheckThenAdd("urls", []byte(fmt.Sprint("url-", i)))
+		assert.Nil(t, err)
+		assert.False(t, b)
+	}
+	for i := 100; i < 200; i++ {
+		assert.True(t, node1.Exists("urls", []byte(fmt.Sprint("url-", i))))
+	}
+}
+
+func TestClusterFilterMembershipChanged(t *testing.T) {
+	addr1, local1, s1 := startNode(t)
+	addr2, local2, s2 := startNode(t)
+	defer s1.Stop()
+	defer s2.Stop()
+
+	//the rpc server of node1 is not listening yet
+	membership := &dynamicMembership{peers: []string{addr2}}
+	config := Config{VirtualNodes: 100, BatchSize: 10, BatchWaitInMs: 5, TimeoutInMs: 1000, RetryIntervalInSeconds: 10, RefreshIntervalInSeconds: 3600}
+	node1 := &ClusterFilter{Local: local1, Config: config, Membership: membership, Dial: dial}
+	assert.Nil(t, node1.Open())
+	defer node1.Close()
+	assert.Equal(t, 1, len(node1.peers))
+
+	//node1 joins the ring at the next call, without waiting for the refresh schedule
+	membership.set(addr1, []string{addr2})
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, node1.Add("urls", []byte(fmt.Sprint("url-", i))))
+	}
+	assert.True(t, len(local1.keys) > 0)
+	assert.True(t, len(local2.keys) > 0)
+
+	//node2 left the cluster, the peer is closed and removed
+	p := node1.peers[addr2]
+	membership.set(addr1, nil)
+	node1.refresh()
+	assert.Equal(t, 0, len(node1.peers))
+	select {
+	case <-p.quit:
+	default:
+		t.Fatal("the peer of the left node is not closed")
+	}
+	b, err := node1.CheckThenAdd("urls", []byte("url-new"))
+	assert.Nil(t, err)
+	assert.False(t, b)
+	assert.True(t, local1.keys["urls/url-new"])
+}
+
+func TestPeerClosedWhileQueued(t *testing.T) {
+	//nothing listens on the peer address, the requests are handled locally
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	local := &memoryFilter{keys: map[string]bool{}}
+	config := Config{VirtualNodes: 100, BatchSize: 10, BatchWaitInMs: 5, TimeoutInMs: 100, RetryIntervalInSeconds: 10}
+	owner := &ClusterFilter{Local: local, Config: config, Dial: dial}
+	p := newPeer(addr, owner)
+
+	//every request is executed exactly once, whether it was queued before or after the peer closed
+	wg := sync.WaitGroup{}
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			b, err := p.call(methodCheckThenAdd, &pb.FilterItem{Bucket: "urls", Key: []byte(fmt.Sprint("url-", i))})
+			assert.Nil(t, err)
+			assert.False(t, b)
+		}(i)
+		if i == 100 {
+			p.close()
+		}
+	}
+	wg.Wait()
+	assert.Equal(t, 200, local.calls)
+	assert.Equal(t, 200, len(local.keys))
+}
@@ -0,0 +1,38 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"github.com/huminghe/infini-framework/core/cluster"
+	"github.com/huminghe/infini-framework/core/rpc"
+)
+
+// NodeMembership return the active nodes known by the cluster module
+type NodeMembership struct{}
+
+func (NodeMembership) LocalAddress() string {
+	return rpc.GetRPCAddress()
+}
+
+func (NodeMembership) Peers() []string {
+	_, nodes := cluster.GetActivePeers()
+	out := []string{}
+	for _, v := range nodes {
+		out = append(out, v.RPCEndpoint)
+	}
+	return out
+}
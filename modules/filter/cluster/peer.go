@@ -0,0 +1,249 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+	log "github.com/cihub/seelog"
+	"github.com/huminghe/infini-framework/core/errors"
+	"github.com/huminghe/infini-framework/core/filter"
+	pb "github.com/huminghe/infini-framework/core/filter/pb"
+	"google.golang.org/grpc"
+	"sync"
+	"time"
+)
+
+const (
+	methodExists       = "exists"
+	methodAdd          = "add"
+	methodDelete       = "delete"
+	methodCheckThenAdd = "check_then_add"
+)
+
+type result struct {
+	b   bool
+	err error
+}
+
+type request struct {
+	method string
+	item   *pb.FilterItem
+	result chan result
+}
+
+// peer is a remote cluster member, the requests to it are queued and sent in batches
+type peer struct {
+	address string
+	owner   *ClusterFilter
+	pending chan *request
+	quit    chan bool
+
+	closeLock sync.RWMutex
+	closed    bool
+
+	lock      sync.Mutex
+	conn      *grpc.ClientConn
+	client    pb.FilterClient
+	downUntil time.Time
+}
+
+func newPeer(address string, owner *ClusterFilter) *peer {
+	p := &peer{
+		address: address,
+		owner:   owner,
+		pending: make(chan *request, owner.Config.BatchSize*2),
+		quit:    make(chan bool),
+	}
+	go p.run()
+	return p
+}
+
+// available return false if the peer was failed recently, the requests will be handled locally before the retry interval passed
+func (p *peer) available() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return time.Now().After(p.downUntil)
+}
+
+func (p *peer) markDown(err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	log.Warnf("cluster filter, peer %s is unreachable, fallback to local filter, %v", p.address, err)
+	p.downUntil = time.Now().Add(time.Duration(p.owner.Config.RetryIntervalInSeconds) * time.Second)
+}
+
+func (p *peer) getClient() (pb.FilterClient, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.client == nil {
+		conn, err := p.owner.Dial(p.address)
+		if err != nil {
+			return nil, err
+		}
+		p.conn = conn
+		p.client = pb.NewFilterClient(conn)
+	}
+	return p.client, nil
+}
+
+// call queue the request and wait for its result, a queued request is always answered by run,
+// either sent to the peer or handled locally while draining, so it is never executed twice
+func (p *peer) call(method string, item *pb.FilterItem) (bool, error) {
+	req := &request{method: method, item: item, result: make(chan result, 1)}
+
+	p.closeLock.RLock()
+	if p.closed {
+		p.closeLock.RUnlock()
+		return callLocal(p.owner.Local, method, item.Bucket, item.Key)
+	}
+	p.pending <- req
+	p.closeLock.RUnlock()
+
+	r := <-req.result
+	return r.b, r.err
+}
+
+func (p *peer) close() {
+	//no more requests will be queued after closed, run drains the queued ones before exit
+	p.closeLock.Lock()
+	p.closed = true
+	close(p.quit)
+	p.closeLock.Unlock()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.conn != nil {
+		p.conn.Close()
+	}
+}
+
+// run collect the queued requests until the batch is full or the wait time is reached, and then send them out
+func (p *peer) run() {
+	wait := time.Duration(p.owner.Config.BatchWaitInMs) * time.Millisecond
+	for {
+		var batch []*request
+		select {
+		case <-p.quit:
+			p.drain()
+			return
+		case req := <-p.pending:
+			batch = append(batch, req)
+		}
+
+		timer := time.NewTimer(wait)
+	COLLECT:
+		for len(batch) < p.owner.Config.BatchSize {
+			select {
+			case req := <-p.pending:
+				batch = append(batch, req)
+			case <-timer.C:
+				break COLLECT
+			}
+		}
+		timer.Stop()
+
+		p.flush(batch)
+	}
+}
+
+// drain handle the remaining requests locally after the peer closed
+func (p *peer) drain() {
+	for {
+		select {
+		case req := <-p.pending:
+			b, err := callLocal(p.owner.Local, req.method, req.item.Bucket, req.item.Key)
+			req.result <- result{b, err}
+		default:
+			return
+		}
+	}
+}
+
+func (p *peer) flush(batch []*request) {
+	groups := map[string][]*request{}
+	for _, req := range batch {
+		groups[req.method] = append(groups[req.method], req)
+	}
+	for method, reqs := range groups {
+		p.send(method, reqs)
+	}
+}
+
+func (p *peer) send(method string, reqs []*request) {
+	in := &pb.FilterRequest{}
+	for _, req := range reqs {
+		in.Items = append(in.Items, req.item)
+	}
+
+	out, err := p.invoke(method, in)
+	if err == nil && len(out.Results) != len(reqs) {
+		err = errors.Errorf("cluster filter, expected %v results from %s, got %v", len(reqs), p.address, len(out.Results))
+	}
+
+	if err != nil {
+		p.markDown(err)
+		for _, req := range reqs {
+			b, err := callLocal(p.owner.Local, method, req.item.Bucket, req.item.Key)
+			req.result <- result{b, err}
+		}
+		return
+	}
+
+	for i, req := range reqs {
+		r := result{b: out.Results[i]}
+		if i < len(out.Errors) && out.Errors[i] != "" {
+			r.err = errors.New(out.Errors[i])
+		}
+		req.result <- r
+	}
+}
+
+func (p *peer) invoke(method string, in *pb.FilterRequest) (*pb.FilterResponse, error) {
+	client, err := p.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.owner.Config.TimeoutInMs)*time.Millisecond)
+	defer cancel()
+
+	switch method {
+	case methodExists:
+		return client.Exists(ctx, in)
+	case methodAdd:
+		return client.Add(ctx, in)
+	case methodDelete:
+		return client.Delete(ctx, in)
+	case methodCheckThenAdd:
+		return client.CheckThenAdd(ctx, in)
+	}
+	return nil, errors.Errorf("cluster filter, unknown method: %s", method)
+}
+
+func callLocal(f filter.Filter, method string, bucket string, key []byte) (bool, error) {
+	switch method {
+	case methodExists:
+		return f.Exists(bucket, key), nil
+	case methodAdd:
+		return false, f.Add(bucket, key)
+	case methodDelete:
+		return false, f.Delete(bucket, key)
+	case methodCheckThenAdd:
+		return f.CheckThenAdd(bucket, key)
+	}
+	return false, errors.Errorf("cluster filter, unknown method: %s", method)
+}
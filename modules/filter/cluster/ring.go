@@ -0,0 +1,59 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"fmt"
+	"hash/crc32"
+	"sort"
+)
+
+// ring is a consistent hashing ring, each node is placed on the ring multiple times to spread the keys evenly,
+// so only the keys of the joined or left node are moved when the membership changed
+type ring struct {
+	hashes []uint32
+	nodes  map[uint32]string
+}
+
+func newRing(nodes []string, replicas int) *ring {
+	if replicas <= 0 {
+		replicas = 1
+	}
+	r := &ring{nodes: map[uint32]string{}}
+	for _, node := range nodes {
+		for i := 0; i < replicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(fmt.Sprintf("%s#%d", node, i)))
+			r.hashes = append(r.hashes, h)
+			r.nodes[h] = node
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+	return r
+}
+
+// get return the node which owns the key, empty if the ring has no node
+func (r *ring) get(key []byte) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := crc32.ChecksumIEEE(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.nodes[r.hashes[i]]
+}
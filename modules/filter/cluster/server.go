@@ -0,0 +1,67 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+	"github.com/huminghe/infini-framework/core/filter"
+	pb "github.com/huminghe/infini-framework/core/filter/pb"
+)
+
+// Server serves the filter requests forwarded by other nodes, the keys are checked against the local filter
+type Server struct {
+	Local filter.Filter
+}
+
+func (s *Server) Exists(ctx context.Context, in *pb.FilterRequest) (*pb.FilterResponse, error) {
+	return s.handle(in, func(item *pb.FilterItem) (bool, error) {
+		return s.Local.Exists(item.Bucket, item.Key), nil
+	}), nil
+}
+
+func (s *Server) Add(ctx context.Context, in *pb.FilterRequest) (*pb.FilterResponse, error) {
+	return s.handle(in, func(item *pb.FilterItem) (bool, error) {
+		return false, s.Local.Add(item.Bucket, item.Key)
+	}), nil
+}
+
+func (s *Server) Delete(ctx context.Context, in *pb.FilterRequest) (*pb.FilterResponse, error) {
+	return s.handle(in, func(item *pb.FilterItem) (bool, error) {
+		return false, s.Local.Delete(item.Bucket, item.Key)
+	}), nil
+}
+
+func (s *Server) CheckThenAdd(ctx context.Context, in *pb.FilterRequest) (*pb.FilterResponse, error) {
+	return s.handle(in, func(item *pb.FilterItem) (bool, error) {
+		return s.Local.CheckThenAdd(item.Bucket, item.Key)
+	}), nil
+}
+
+func (s *Server) handle(in *pb.FilterRequest, f func(item *pb.FilterItem) (bool, error)) *pb.FilterResponse {
+	out := &pb.FilterResponse{
+		Results: make([]bool, len(in.Items)),
+		Errors:  make([]string, len(in.Items)),
+	}
+	for i, item := range in.Items {
+		b, err := f(item)
+		out.Results[i] = b
+		if err != nil {
+			out.Errors[i] = err.Error()
+		}
+	}
+	return out
+}
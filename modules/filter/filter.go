@@ -3,22 +3,51 @@ package filter
 import (
 	log "github.com/cihub/seelog"
 	. "github.com/huminghe/infini-framework/core/config"
+	"github.com/huminghe/infini-framework/core/errors"
 	"github.com/huminghe/infini-framework/core/filter"
+	pb "github.com/huminghe/infini-framework/core/filter/pb"
+	"github.com/huminghe/infini-framework/core/global"
+	"github.com/huminghe/infini-framework/core/rpc"
+	"github.com/huminghe/infini-framework/modules/filter/cluster"
 	"github.com/huminghe/infini-framework/modules/filter/kv"
+	"github.com/huminghe/infini-framework/modules/filter/window"
+	"os"
+	"path"
 )
 
 type FilterModule struct {
 }
 
 type FilterConfig struct {
-	KV *KVFilterConfig
+	APIEnabled bool `config:"api_enabled"`
+
+	KV      *KVFilterConfig
+	Window  window.Config  `config:"window"`
+	Cluster cluster.Config `config:"cluster"`
 }
 
 type KVFilterConfig struct{}
 
 var (
 	defaultConfig = FilterConfig{
-		KV: &KVFilterConfig{},
+		APIEnabled: true,
+		KV:         &KVFilterConfig{},
+		Window: window.Config{
+			Enabled:         false,
+			Backend:         "kv",
+			WindowInSeconds: 86400,
+			Generations:     24,
+		},
+		Cluster: cluster.Config{
+			Enabled:                  false,
+			Backend:                  "kv",
+			VirtualNodes:             100,
+			BatchSize:                100,
+			BatchWaitInMs:            5,
+			TimeoutInMs:              1000,
+			RetryIntervalInSeconds:   10,
+			RefreshIntervalInSeconds: 5,
+		},
 	}
 )
 
@@ -27,6 +56,8 @@ func (module FilterModule) Name() string {
 }
 
 var handler filter.Filter
+var windowFilter *window.WindowFilter
+var clusterFilter *cluster.ClusterFilter
 
 func (module FilterModule) Setup(cfg *Config) {
 
@@ -35,13 +66,78 @@ func (module FilterModule) Setup(cfg *Config) {
 
 	handler = kv.KVFilter{}
 	filter.Register("kv", handler)
+
+	if defaultConfig.APIEnabled {
+		api := API{}
+		api.Init()
+	}
 }
 
 func (module FilterModule) Start() error {
+
+	//the rpc server is started by the cluster module, the filter service need to be registered before that
+	if defaultConfig.Cluster.Enabled {
+		if !global.Env().SystemConfig.ClusterConfig.Enabled {
+			panic(errors.New("cluster filter requires the cluster to be enabled"))
+		}
+
+		backend, err := filter.GetFilter(defaultConfig.Cluster.Backend)
+		if err != nil {
+			panic(err)
+		}
+
+		pb.RegisterFilterServer(rpc.GetRPCServer(), &cluster.Server{Local: backend})
+
+		clusterFilter = &cluster.ClusterFilter{
+			Local:      backend,
+			Config:     defaultConfig.Cluster,
+			Membership: cluster.NodeMembership{},
+			Dial:       rpc.ObtainConnection,
+		}
+		if err := clusterFilter.Open(); err != nil {
+			panic(err)
+		}
+		filter.Register("cluster", clusterFilter)
+	}
+
+	//the backend filter may be registered by plugins, so the window filter is initialized after all the modules are setup
+	if defaultConfig.Window.Enabled {
+		backend, err := filter.GetFilter(defaultConfig.Window.Backend)
+		if err != nil {
+			panic(err)
+		}
+
+		folder := path.Join(global.Env().GetWorkingDir(), "filters")
+		if err := os.MkdirAll(folder, 0777); err != nil {
+			panic(err)
+		}
+
+		windowFilter = &window.WindowFilter{
+			Backend:   backend,
+			Config:    defaultConfig.Window,
+			StateFile: path.Join(folder, "window.json"),
+		}
+		if err := windowFilter.Open(); err != nil {
+			panic(err)
+		}
+		filter.Register("window", windowFilter)
+	}
 	return nil
 }
 
 func (module FilterModule) Stop() error {
+	if windowFilter != nil {
+		err := windowFilter.Close()
+		if err != nil {
+			log.Error(err)
+		}
+	}
+	if clusterFilter != nil {
+		err := clusterFilter.Close()
+		if err != nil {
+			log.Error(err)
+		}
+	}
 	if handler != nil {
 		err := handler.Close()
 		if err != nil {
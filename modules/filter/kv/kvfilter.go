@@ -1,6 +1,8 @@
 package kv
 
 import (
+	"github.com/huminghe/infini-framework/core/errors"
+	api "github.com/huminghe/infini-framework/core/filter"
 	"github.com/huminghe/infini-framework/core/kv"
 	"sync"
 )
@@ -19,6 +21,7 @@ func (filter KVFilter) Close() error {
 	return nil
 }
 
+// Exists check the key without creating the bucket, a missing bucket has no keys
 func (filter KVFilter) Exists(bucket string, key []byte) bool {
 	b, _ := kv.GetValue(bucket, key)
 	return b != nil
@@ -28,8 +31,13 @@ func (filter KVFilter) Add(bucket string, key []byte) error {
 	return kv.AddValue(bucket, key, v)
 }
 
+// Delete remove the key, nothing to do if the bucket doesn't exist
 func (filter KVFilter) Delete(bucket string, key []byte) error {
-	return kv.DeleteKey(bucket, key)
+	err := kv.DeleteKey(bucket, key)
+	if errors.Cause(err) == kv.ErrBucketNotFound {
+		return nil
+	}
+	return err
 }
 
 func (filter KVFilter) CheckThenAdd(bucket string, key []byte) (b bool, err error) {
@@ -41,3 +49,24 @@ func (filter KVFilter) CheckThenAdd(bucket string, key []byte) (b bool, err erro
 	}
 	return b, err
 }
+
+// Clear remove all the keys of the bucket, nothing to do if the bucket doesn't exist
+func (filter KVFilter) Clear(bucket string) error {
+	err := kv.DeleteBucket(bucket)
+	if errors.Cause(err) == kv.ErrBucketNotFound {
+		return nil
+	}
+	return err
+}
+
+// GetStats count the keys of the bucket, the size is the total size of the stored keys and values,
+// the whole bucket is scanned on each call, so the cost grows with the number of keys
+func (filter KVFilter) GetStats(bucket string) (api.Stats, error) {
+	stats := api.Stats{Bucket: bucket}
+	err := kv.Scan(bucket, nil, nil, 0, func(key, value []byte) bool {
+		stats.Items++
+		stats.SizeInBytes += uint64(len(key) + len(value))
+		return true
+	})
+	return stats, err
+}
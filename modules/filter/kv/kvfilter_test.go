@@ -0,0 +1,62 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kv
+
+import (
+	"github.com/huminghe/infini-framework/core/env"
+	"github.com/huminghe/infini-framework/core/global"
+	"github.com/huminghe/infini-framework/core/kv"
+	"github.com/huminghe/infini-framework/modules/boltdb/boltdb"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func TestKVFilter(t *testing.T) {
+	global.RegisterEnv(env.EmptyEnv())
+
+	store := boltdb.BoltdbStore{FileName: filepath.Join(t.TempDir(), "kv.db")}
+	assert.Nil(t, store.Open())
+	defer store.Close()
+	kv.Register("kv_filter_test", store)
+
+	filter := KVFilter{}
+
+	//lookups and deletes of missing buckets don't create them
+	assert.False(t, filter.Exists("missing", []byte("key")))
+	assert.Nil(t, filter.Delete("missing", []byte("key")))
+	assert.Nil(t, filter.Clear("missing"))
+	buckets, err := kv.ListBuckets()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(buckets))
+
+	b, err := filter.CheckThenAdd("urls", []byte("key"))
+	assert.Nil(t, err)
+	assert.False(t, b)
+	b, err = filter.CheckThenAdd("urls", []byte("key"))
+	assert.Nil(t, err)
+	assert.True(t, b)
+
+	stats, err := filter.GetStats("urls")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), stats.Items)
+
+	assert.Nil(t, filter.Delete("urls", []byte("key")))
+	assert.False(t, filter.Exists("urls", []byte("key")))
+	assert.Nil(t, filter.Clear("urls"))
+	assert.Nil(t, filter.Clear("urls"))
+}
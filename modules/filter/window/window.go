@@ -0,0 +1,324 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package window
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/cihub/seelog"
+	"github.com/huminghe/infini-framework/core/errors"
+	"github.com/huminghe/infini-framework/core/filter"
+	"github.com/huminghe/infini-framework/core/util"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Config defines the length of the window and how many generations the window is split into,
+// a key added to the filter will be forgotten after the window passed, with the precision of one generation
+type Config struct {
+	Enabled         bool   `config:"enabled"`
+	Backend         string `config:"backend"`
+	WindowInSeconds int64  `config:"window_in_seconds"`
+	Generations     int64  `config:"generations"`
+}
+
+// WindowFilter is a time-windowed filter, each generation of a bucket is stored as a separate bucket of the backend filter,
+// keys are added to the current generation, lookups check all the live generations,
+// generations fell out of the window will be cleared from the backend
+type WindowFilter struct {
+	Backend   filter.Filter
+	Config    Config
+	StateFile string
+
+	lock sync.Mutex
+	//generations of each bucket which are stored in the backend filter
+	generations map[string][]int64
+	quit        chan bool
+	wg          sync.WaitGroup
+	now         func() time.Time
+}
+
+// Open load the state of the generations, expire the generations out of window and start the expire schedule
+func (w *WindowFilter) Open() error {
+	if w.Backend == nil {
+		return errors.New("window filter: backend filter is not set")
+	}
+	if _, ok := w.Backend.(filter.Clearable); !ok {
+		return errors.Errorf("window filter: backend filter %v is not clearable", w.Config.Backend)
+	}
+	if w.Config.WindowInSeconds <= 0 || w.Config.Generations <= 0 {
+		return errors.Errorf("window filter: invalid window: %v seconds, generations: %v", w.Config.WindowInSeconds, w.Config.Generations)
+	}
+	if w.now == nil {
+		w.now = time.Now
+	}
+
+	w.lock.Lock()
+	w.generations = map[string][]int64{}
+	if w.StateFile != "" && util.FileExists(w.StateFile) {
+		data, err := ioutil.ReadFile(w.StateFile)
+		if err == nil {
+			err = json.Unmarshal(data, &w.generations)
+		}
+		if err != nil {
+			w.lock.Unlock()
+			return err
+		}
+		log.Debug("window filter state reloaded: ", w.StateFile)
+	}
+	w.lock.Unlock()
+
+	w.Expire()
+
+	w.quit = make(chan bool)
+	w.wg.Add(1)
+	go func(quit chan bool) {
+		defer w.wg.Done()
+		ticker := time.NewTicker(time.Duration(w.interval()) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+				w.Expire()
+			}
+		}
+	}(w.quit)
+
+	return nil
+}
+
+// Close stop the expire schedule and persist the state of the generations, the backend filter is closed by its owner
+func (w *WindowFilter) Close() error {
+	if w.quit != nil {
+		close(w.quit)
+		w.quit = nil
+	}
+	//wait for the running expire, so it won't touch the backend after it was closed
+	w.wg.Wait()
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.saveState()
+}
+
+func (w *WindowFilter) Exists(bucket string, key []byte) bool {
+	w.lock.Lock()
+	gens := w.liveGenerations(bucket, w.currentGeneration())
+	w.lock.Unlock()
+
+	for _, gen := range gens {
+		if w.Backend.Exists(generationBucket(bucket, gen), key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *WindowFilter) Add(bucket string, key []byte) error {
+	gen := w.currentGeneration()
+	if err := w.track(bucket, gen); err != nil {
+		return err
+	}
+	return w.Backend.Add(generationBucket(bucket, gen), key)
+}
+
+// Delete remove the key from all the live generations
+func (w *WindowFilter) Delete(bucket string, key []byte) error {
+	w.lock.Lock()
+	gens := w.liveGenerations(bucket, w.currentGeneration())
+	w.lock.Unlock()
+
+	for _, gen := range gens {
+		if err := w.Backend.Delete(generationBucket(bucket, gen), key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *WindowFilter) CheckThenAdd(bucket string, key []byte) (b bool, err error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	current := w.currentGeneration()
+	for _, gen := range w.liveGenerations(bucket, current) {
+		if gen == current {
+			continue
+		}
+		if w.Backend.Exists(generationBucket(bucket, gen), key) {
+			return true, nil
+		}
+	}
+
+	if err = w.trackWithoutLock(bucket, current); err != nil {
+		return false, err
+	}
+	return w.Backend.CheckThenAdd(generationBucket(bucket, current), key)
+}
+
+// Clear remove all the generations of the bucket
+func (w *WindowFilter) Clear(bucket string) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	for _, gen := range w.generations[bucket] {
+		if err := w.Backend.(filter.Clearable).Clear(generationBucket(bucket, gen)); err != nil {
+			return err
+		}
+	}
+	delete(w.generations, bucket)
+	return w.saveState()
+}
+
+// GetStats sum the stats of the live generations of the bucket, the backend filter need to be able to report stats
+func (w *WindowFilter) GetStats(bucket string) (filter.Stats, error) {
+	stats := filter.Stats{Bucket: bucket}
+	provider, ok := w.Backend.(filter.StatsProvider)
+	if !ok {
+		return stats, errors.Errorf("window filter: backend filter %v is not able to report stats", w.Config.Backend)
+	}
+
+	w.lock.Lock()
+	gens := append([]int64{}, w.generations[bucket]...)
+	w.lock.Unlock()
+
+	//a key is reported as exists if any of the generations reported
+	notFalsePositive := 1.0
+	oldest := w.currentGeneration() - w.Config.Generations + 1
+	for _, gen := range gens {
+		if gen < oldest {
+			continue
+		}
+		v, err := provider.GetStats(generationBucket(bucket, gen))
+		if err != nil {
+			return stats, err
+		}
+		stats.Items += v.Items
+		stats.SizeInBytes += v.SizeInBytes
+		notFalsePositive *= 1 - v.EstimatedFalsePositiveRate
+	}
+	stats.EstimatedFalsePositiveRate = 1 - notFalsePositive
+	return stats, nil
+}
+
+// Expire clear the generations which fell out of the window
+func (w *WindowFilter) Expire() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	oldest := w.currentGeneration() - w.Config.Generations + 1
+	changed := false
+	for bucket, gens := range w.generations {
+		live := gens[:0]
+		for _, gen := range gens {
+			if gen >= oldest {
+				live = append(live, gen)
+				continue
+			}
+			log.Debugf("window filter, expire bucket: %s, generation: %v", bucket, gen)
+			if err := w.Backend.(filter.Clearable).Clear(generationBucket(bucket, gen)); err != nil {
+				log.Error(err)
+				live = append(live, gen)
+				continue
+			}
+			changed = true
+		}
+		if len(live) == 0 {
+			delete(w.generations, bucket)
+		} else {
+			w.generations[bucket] = live
+		}
+	}
+
+	if changed {
+		if err := w.saveState(); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// NextExpiry return the time when the oldest live generation will be expired
+func (w *WindowFilter) NextExpiry() time.Time {
+	interval := w.interval()
+	return time.Unix((w.currentGeneration()+1)*interval, 0)
+}
+
+// liveGenerations return a copy of the tracked generations of the bucket which are still in the window,
+// the generations never written are skipped, so the backend won't create empty sub-filters for the lookups
+func (w *WindowFilter) liveGenerations(bucket string, current int64) []int64 {
+	oldest := current - w.Config.Generations + 1
+	gens := []int64{}
+	for _, gen := range w.generations[bucket] {
+		if gen >= oldest && gen <= current {
+			gens = append(gens, gen)
+		}
+	}
+	return gens
+}
+
+func (w *WindowFilter) track(bucket string, gen int64) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.trackWithoutLock(bucket, gen)
+}
+
+func (w *WindowFilter) trackWithoutLock(bucket string, gen int64) error {
+	gens := w.generations[bucket]
+	for _, v := range gens {
+		if v == gen {
+			return nil
+		}
+	}
+	gens = append(gens, gen)
+	sort.Slice(gens, func(i, j int) bool { return gens[i] < gens[j] })
+	w.generations[bucket] = gens
+	return w.saveState()
+}
+
+func (w *WindowFilter) saveState() error {
+	if w.StateFile == "" {
+		return nil
+	}
+	data, err := json.Marshal(w.generations)
+	if err != nil {
+		return err
+	}
+	_, err = util.FilePutContentAtomic(w.StateFile, data)
+	return err
+}
+
+// interval return the length of one generation in seconds
+func (w *WindowFilter) interval() int64 {
+	interval := w.Config.WindowInSeconds / w.Config.Generations
+	if interval <= 0 {
+		interval = 1
+	}
+	return interval
+}
+
+// currentGeneration return the id of the current generation, generations are aligned to the unix epoch, so they survive restarts
+func (w *WindowFilter) currentGeneration() int64 {
+	return w.now().Unix() / w.interval()
+}
+
+func generationBucket(bucket string, gen int64) string {
+	return fmt.Sprintf("%s#%d", bucket, gen)
+}
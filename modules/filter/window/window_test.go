@@ -0,0 +1,146 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package window
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memoryFilter struct {
+	lock    sync.Mutex
+	buckets map[string]map[string]bool
+}
+
+func (filter *memoryFilter) Open() error {
+	filter.buckets = map[string]map[string]bool{}
+	return nil
+}
+
+func (filter *memoryFilter) Close() error {
+	return nil
+}
+
+// getBucket create the missing bucket on access, same as the bloom and cuckoo filters
+func (filter *memoryFilter) getBucket(bucket string) map[string]bool {
+	if filter.buckets[bucket] == nil {
+		filter.buckets[bucket] = map[string]bool{}
+	}
+	return filter.buckets[bucket]
+}
+
+func (filter *memoryFilter) Exists(bucket string, key []byte) bool {
+	filter.lock.Lock()
+	defer filter.lock.Unlock()
+	return filter.getBucket(bucket)[string(key)]
+}
+
+func (filter *memoryFilter) Add(bucket string, key []byte) error {
+	filter.lock.Lock()
+	defer filter.lock.Unlock()
+	filter.getBucket(bucket)[string(key)] = true
+	return nil
+}
+
+func (filter *memoryFilter) Delete(bucket string, key []byte) error {
+	filter.lock.Lock()
+	defer filter.lock.Unlock()
+	delete(filter.getBucket(bucket), string(key))
+	return nil
+}
+
+func (filter *memoryFilter) CheckThenAdd(bucket string, key []byte) (bool, error) {
+	b := filter.Exists(bucket, key)
+	if !b {
+		return b, filter.Add(bucket, key)
+	}
+	return b, nil
+}
+
+func (filter *memoryFilter) Clear(bucket string) error {
+	filter.lock.Lock()
+	defer filter.lock.Unlock()
+	delete(filter.buckets, bucket)
+	return nil
+}
+
+func TestWindowFilter(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "window_filter.json")
+
+	backend := &memoryFilter{}
+	backend.Open()
+
+	now := time.Unix(3600*1000, 0)
+	w := &WindowFilter{
+		Backend:   backend,
+		Config:    Config{WindowInSeconds: 3600, Generations: 4},
+		StateFile: stateFile,
+		now:       func() time.Time { return now },
+	}
+	assert.Nil(t, w.Open())
+
+	b, err := w.CheckThenAdd("bucket", []byte("key1"))
+	assert.Nil(t, err)
+	assert.Equal(t, false, b)
+	b, _ = w.CheckThenAdd("bucket", []byte("key1"))
+	assert.Equal(t, true, b)
+
+	//next generation, the key is still in the window
+	now = now.Add(900 * time.Second)
+	w.Add("bucket", []byte("key2"))
+	assert.Equal(t, true, w.Exists("bucket", []byte("key1")))
+	b, _ = w.CheckThenAdd("bucket", []byte("key1"))
+	assert.Equal(t, true, b)
+	assert.Equal(t, 2, len(backend.buckets))
+
+	//lookups and deletes only touch the generations written
+	assert.Nil(t, w.Delete("bucket", []byte("key3")))
+	assert.Equal(t, false, w.Exists("other", []byte("key1")))
+	assert.Equal(t, 2, len(backend.buckets))
+
+	//the first generation fell out of the window
+	now = now.Add(3 * 900 * time.Second)
+	w.Expire()
+	assert.Equal(t, false, w.Exists("bucket", []byte("key1")))
+	assert.Equal(t, true, w.Exists("bucket", []byte("key2")))
+	assert.Equal(t, 1, len(backend.buckets))
+	assert.Nil(t, w.Close())
+
+	//the state is replaced atomically, no temp file left behind
+	tmpFiles, _ := filepath.Glob(filepath.Join(filepath.Dir(stateFile), "*.tmp"))
+	assert.Equal(t, 0, len(tmpFiles))
+
+	//reopen, the tracked generations are reloaded and expired
+	now = now.Add(900 * time.Second)
+	w = &WindowFilter{
+		Backend:   backend,
+		Config:    Config{WindowInSeconds: 3600, Generations: 4},
+		StateFile: stateFile,
+		now:       func() time.Time { return now },
+	}
+	assert.Nil(t, w.Open())
+	assert.Equal(t, false, w.Exists("bucket", []byte("key2")))
+	assert.Equal(t, 0, len(backend.buckets))
+
+	w.Add("bucket", []byte("key3"))
+	assert.Nil(t, w.Clear("bucket"))
+	assert.Equal(t, false, w.Exists("bucket", []byte("key3")))
+	assert.Nil(t, w.Close())
+}
@@ -0,0 +1,256 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kv
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/cihub/seelog"
+	"github.com/huminghe/infini-framework/core/api"
+	"github.com/huminghe/infini-framework/core/api/router"
+	"github.com/huminghe/infini-framework/core/errors"
+	"github.com/huminghe/infini-framework/core/kv"
+	"github.com/huminghe/infini-framework/core/ui"
+	"io"
+	"net/http"
+)
+
+// API namespace
+type API struct {
+	api.Handler
+}
+
+// Item is the json representation of a key and value pair,
+// value is used when the stored bytes is a valid json, otherwise raw bytes will be base64 encoded
+type Item struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value,omitempty"`
+	Raw   []byte          `json:"raw,omitempty"`
+}
+
+// KeyList is the result of key listing, next is the key to continue with
+type KeyList struct {
+	Bucket string `json:"bucket"`
+	Keys   []Item `json:"keys"`
+	Next   string `json:"next,omitempty"`
+}
+
+func newItem(key, value []byte, withValue bool) Item {
+	item := Item{Key: string(key)}
+	if !withValue {
+		return item
+	}
+
+	//the value is only valid inside the scan, make a copy
+	v := make([]byte, len(value))
+	copy(v, value)
+
+	if json.Valid(v) {
+		item.Value = v
+	} else {
+		item.Raw = v
+	}
+	return item
+}
+
+func (item Item) bytes() []byte {
+	if item.Raw != nil {
+		return item.Raw
+	}
+	return item.Value
+}
+
+func (handler API) Init() {
+
+	//KV API, also registered to the ui server, so the admin ui is able to access
+	handle(api.GET, "/kv/", api.PERMISSION_KV_READ, handler.listBucketsAction)
+	handle(api.GET, "/kv/:bucket/", api.PERMISSION_KV_READ, handler.listKeysAction)
+	handle(api.DELETE, "/kv/:bucket/", api.PERMISSION_KV_WRITE, handler.deleteBucketAction)
+	handle(api.GET, "/kv/:bucket/:key", api.PERMISSION_KV_READ, handler.getValueAction)
+	handle(api.PUT, "/kv/:bucket/:key", api.PERMISSION_KV_WRITE, handler.putValueAction)
+	handle(api.DELETE, "/kv/:bucket/:key", api.PERMISSION_KV_WRITE, handler.deleteKeyAction)
+	handle(api.POST, "/kv/:bucket/_import", api.PERMISSION_KV_WRITE, handler.importAction)
+	handle(api.POST, "/kv/:bucket/_export", api.PERMISSION_KV_READ, handler.exportAction)
+}
+
+func handle(method api.Method, pattern string, permission string, h httprouter.Handle) {
+	h = api.NeedPermission(permission, h)
+	api.HandleAPIMethod(method, pattern, h)
+	ui.HandleUIMethod(method, pattern, h)
+}
+
+func (handler API) listBucketsAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	buckets, err := kv.ListBuckets()
+	if err != nil {
+		handler.Error(w, err)
+		return
+	}
+	handler.WriteJSONListResult(w, len(buckets), buckets, http.StatusOK)
+}
+
+// listKeysAction list keys of the bucket, parameters:
+// prefix: only return keys with the prefix
+// from: the key to start with, use the `next` of last response to fetch next page
+// size: the max number of keys to return
+// values: return values as well if it is true
+func (handler API) listKeysAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	bucket := ps.ByName("bucket")
+	prefix := handler.GetParameter(req, "prefix")
+	from := handler.GetParameter(req, "from")
+	size := handler.GetIntOrDefault(req, "size", 20)
+	withValue := handler.GetParameter(req, "values") == "true"
+
+	if size <= 0 {
+		size = 20
+	}
+
+	result := KeyList{Bucket: bucket, Keys: []Item{}}
+
+	//fetch one more key to get the next start key
+	err := kv.Scan(bucket, []byte(prefix), []byte(from), size+1, func(key, value []byte) bool {
+		if len(result.Keys) >= size {
+			result.Next = string(key)
+			return false
+		}
+		result.Keys = append(result.Keys, newItem(key, value, withValue))
+		return true
+	})
+
+	if err != nil {
+		handler.Error(w, err)
+		return
+	}
+	handler.WriteJSON(w, result, http.StatusOK)
+}
+
+func (handler API) deleteBucketAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	err := kv.DeleteBucket(ps.ByName("bucket"))
+	if errors.Cause(err) == kv.ErrBucketNotFound {
+		handler.Error404(w)
+		return
+	}
+	if err != nil {
+		handler.Error(w, err)
+		return
+	}
+	handler.WriteAckJSON(w, true)
+}
+
+func (handler API) getValueAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	v, err := kv.GetValue(ps.ByName("bucket"), []byte(ps.ByName("key")))
+	if err != nil {
+		handler.Error(w, err)
+		return
+	}
+
+	if v == nil {
+		handler.Error404(w)
+		return
+	}
+
+	if json.Valid(v) {
+		handler.WriteJSONHeader(w)
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	w.WriteHeader(http.StatusOK)
+	handler.Write(w, v)
+}
+
+func (handler API) putValueAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	data, err := handler.GetRawBody(req)
+	if err != nil {
+		handler.Error(w, err)
+		return
+	}
+
+	err = kv.AddValue(ps.ByName("bucket"), []byte(ps.ByName("key")), data)
+	if err != nil {
+		handler.Error(w, err)
+		return
+	}
+	handler.WriteAckJSON(w, true)
+}
+
+func (handler API) deleteKeyAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	err := kv.DeleteKey(ps.ByName("bucket"), []byte(ps.ByName("key")))
+	if errors.Cause(err) == kv.ErrBucketNotFound {
+		handler.Error404(w)
+		return
+	}
+	if err != nil {
+		handler.Error(w, err)
+		return
+	}
+	handler.WriteAckJSON(w, true)
+}
+
+// importAction read a stream of json items from the request body, the same format of export
+func (handler API) importAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	bucket := ps.ByName("bucket")
+	defer req.Body.Close()
+
+	count := 0
+	decoder := json.NewDecoder(req.Body)
+	for {
+		item := Item{}
+		err := decoder.Decode(&item)
+		if err == io.EOF {
+			break
+		}
+		if err == nil && item.Key == "" {
+			err = errors.New("empty key")
+		}
+		if err != nil {
+			handler.Error(w, errors.Errorf("invalid item #%v, %v", count, err))
+			return
+		}
+
+		err = kv.AddValue(bucket, []byte(item.Key), item.bytes())
+		if err != nil {
+			handler.Error(w, err)
+			return
+		}
+		count++
+	}
+
+	handler.WriteJSON(w, map[string]interface{}{"ok": true, "total": count}, http.StatusOK)
+}
+
+// exportAction write all the key and value pairs of the bucket as a stream of json items, one item per line
+func (handler API) exportAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	bucket := ps.ByName("bucket")
+	prefix := handler.GetParameter(req, "prefix")
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", bucket+".json"))
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	var err error
+	scanErr := kv.Scan(bucket, []byte(prefix), nil, 0, func(key, value []byte) bool {
+		err = encoder.Encode(newItem(key, value, true))
+		return err == nil
+	})
+
+	if scanErr != nil {
+		err = scanErr
+	}
+	if err != nil {
+		log.Error(err)
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kv
+
+import (
+	"github.com/huminghe/infini-framework/core/api/router"
+	"github.com/huminghe/infini-framework/core/env"
+	"github.com/huminghe/infini-framework/core/global"
+	"github.com/huminghe/infini-framework/core/kv"
+	"github.com/huminghe/infini-framework/modules/boltdb/boltdb"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKVAPI(t *testing.T) {
+	global.RegisterEnv(env.EmptyEnv())
+
+	store := boltdb.BoltdbStore{FileName: filepath.Join(t.TempDir(), "kv.db")}
+	assert.Nil(t, store.Open())
+	defer store.Close()
+	kv.Register("kv_api_test", store)
+
+	handler := API{}
+	call := func(action httprouter.Handle, method, bucket, key, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, "/kv/"+bucket+"/"+key, strings.NewReader(body))
+		action(w, req, httprouter.Params{{Key: "bucket", Value: bucket}, {Key: "key", Value: key}})
+		return w
+	}
+
+	w := call(handler.putValueAction, http.MethodPut, "users", "alice", `{"age":20}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = call(handler.getValueAction, http.MethodGet, "users", "alice", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"age":20}`, w.Body.String())
+
+	//the reads of missing buckets don't create them
+	w = call(handler.getValueAction, http.MethodGet, "missing", "alice", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	buckets, err := kv.ListBuckets()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"users"}, buckets)
+
+	w = call(handler.deleteKeyAction, http.MethodDelete, "missing", "alice", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = call(handler.deleteBucketAction, http.MethodDelete, "missing", "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = call(handler.deleteKeyAction, http.MethodDelete, "users", "alice", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = call(handler.getValueAction, http.MethodGet, "users", "alice", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = call(handler.importAction, http.MethodPost, "users", "_import", "{\"key\":\"bob\",\"value\":1}\n{\"key\":\"carol\",\"raw\":\"AQI=\"}\n")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"total":2`)
+	w = call(handler.listKeysAction, http.MethodGet, "users", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"key":"bob"`)
+	w = call(handler.exportAction, http.MethodPost, "users", "_export", "")
+	assert.Equal(t, "{\"key\":\"bob\",\"value\":1}\n{\"key\":\"carol\",\"raw\":\"AQI=\"}\n", w.Body.String())
+
+	//the bucket is created again by the writes after deleted
+	w = call(handler.deleteBucketAction, http.MethodDelete, "users", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = call(handler.putValueAction, http.MethodPut, "users", "dave", `1`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = call(handler.getValueAction, http.MethodGet, "users", "dave", "")
+	assert.Equal(t, `1`, w.Body.String())
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kv
+
+import (
+	. "github.com/huminghe/infini-framework/core/config"
+)
+
+type KVModule struct {
+}
+
+func (module KVModule) Name() string {
+	return "KV"
+}
+
+var config = struct {
+	APIEnabled bool `config:"api_enabled"`
+}{
+	APIEnabled: true,
+}
+
+func (module KVModule) Setup(cfg *Config) {
+
+	cfg.Unpack(&config)
+
+	if config.APIEnabled {
+
+		handler := API{}
+
+		handler.Init()
+	}
+}
+
+func (module KVModule) Start() error {
+	return nil
+}
+
+func (module KVModule) Stop() error {
+	return nil
+}
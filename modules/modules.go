@@ -23,6 +23,7 @@ import (
 	"github.com/huminghe/infini-framework/modules/cluster"
 	"github.com/huminghe/infini-framework/modules/elastic"
 	"github.com/huminghe/infini-framework/modules/filter"
+	"github.com/huminghe/infini-framework/modules/kv"
 	"github.com/huminghe/infini-framework/modules/pipeline"
 	"github.com/huminghe/infini-framework/modules/queue"
 	"github.com/huminghe/infini-framework/modules/stats"
@@ -34,6 +35,7 @@ func Register() {
 	//module.RegisterSystemModule(nsq.NSQModule{})
 	module.RegisterSystemModule(elastic.ElasticModule{})
 	module.RegisterSystemModule(boltdb.StorageModule{})
+	module.RegisterSystemModule(kv.KVModule{})
 	module.RegisterSystemModule(filter.FilterModule{})
 	module.RegisterSystemModule(stats.SimpleStatsModule{})
 	module.RegisterSystemModule(queue.DiskQueue{})
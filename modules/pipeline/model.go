@@ -61,30 +61,31 @@ func CreatePipelineConfig(cfg *pipeline.PipelineConfig) error {
 	cfg.ID = util.GetUUID()
 	cfg.Created = t
 	cfg.Updated = t
-	b, err := json.Marshal(cfg)
+	err := orm.Save(cfg)
 	if err != nil {
 		return err
 	}
-	err = kv.AddValue(configBucket, []byte(cfg.ID), b)
+	b, err := json.Marshal(cfg)
 	if err != nil {
 		return err
 	}
-	return orm.Save(cfg)
+	return kv.AddValue(configBucket, []byte(cfg.ID), b)
 }
 
+// UpdatePipelineConfig update the config if its version matches the stored one, or return orm.ConflictError
 func UpdatePipelineConfig(id string, cfg *pipeline.PipelineConfig) error {
 	t := time.Now().UTC()
 	cfg.ID = id
 	cfg.Updated = t
-	b, err := json.Marshal(cfg)
+	err := orm.Update(cfg)
 	if err != nil {
 		return err
 	}
-	err = kv.AddValue(configBucket, []byte(cfg.ID), b)
+	b, err := json.Marshal(cfg)
 	if err != nil {
 		return err
 	}
-	return orm.Update(cfg)
+	return kv.AddValue(configBucket, []byte(cfg.ID), b)
 }
 
 func DeletePipelineConfig(id string) error {
@@ -0,0 +1,27 @@
+// Generated by ego.
+// DO NOT EDIT
+
+package filter
+
+import (
+	"fmt"
+	"github.com/huminghe/infini-framework/modules/ui/common"
+	"io"
+	"net/http"
+)
+
+var _ = fmt.Sprint("") // just so that we can keep the fmt import for now
+func Index(w http.ResponseWriter, r *http.Request) error {
+	_, _ = io.WriteString(w, "\n\n")
+	_, _ = io.WriteString(w, "\n")
+	_, _ = io.WriteString(w, "\n\n")
+	common.Head(w, "Filter", "")
+	_, _ = io.WriteString(w, "\n<link rel=\"stylesheet\" href=\"/static/assets/uikit-2.27.1/css/components/notify.min.css\" />\n<script src=\"/static/assets/uikit-2.27.1/js/components/notify.min.js\"></script>\n")
+	common.Body(w)
+	_, _ = io.WriteString(w, "\n")
+	common.Nav(w, r, "Filter")
+	_, _ = io.WriteString(w, "\n\n\n<div class=\"tm-middle\">\n    <div class=\"uk-container uk-container-center\">\n\n        <form class=\"uk-form\" onsubmit=\"return false;\">\n            <fieldset data-uk-margin>\n                <legend class=\"uk-text-primary\">Bucket</legend>\n                <select id=\"filter\"></select>\n                <input id=\"bucket\" type=\"text\" class=\"uk-form-width-medium\" placeholder=\"Bucket\">\n                <button class=\"uk-button uk-button-primary\" onclick=\"loadStats()\">Stats</button>\n                <button class=\"uk-button uk-button-danger\" onclick=\"clearBucket()\">Clear Bucket</button>\n            </fieldset>\n        </form>\n\n        <div class=\"uk-grid\" data-uk-grid-margin>\n            <div class=\"uk-width-medium-1-3\">\n                <div class=\"uk-panel uk-panel-box\">\n                    <h3 class=\"uk-panel-title\">Stats</h3>\n                    <dl id=\"stats\" class=\"uk-description-list-horizontal\"></dl>\n                </div>\n            </div>\n\n            <div class=\"uk-width-medium-2-3\">\n                <form class=\"uk-form\" onsubmit=\"return false;\">\n                    <textarea id=\"keys\" class=\"uk-width-1-1\" rows=\"10\" placeholder=\"Keys, one per line\"></textarea>\n                    <button class=\"uk-button uk-button-primary\" onclick=\"operate('_check')\">Check</button>\n                    <button class=\"uk-button uk-button-success\" onclick=\"operate('_add')\">Add</button>\n                    <button class=\"uk-button uk-button-danger\" onclick=\"operate('_remove')\">Remove</button>\n                </form>\n                <table class=\"uk-table uk-table-striped uk-table-condensed\">\n                    <thead><tr><th>Key</th><th>Exists</th></tr></thead>\n                    <tbody id=\"results\"></tbody>\n                </table>\n            </div>\n        </div>\n\n    </div>\n</div>\n\n<script type=\"text/javascript\">\n\n    function url(action) {\n        return \"/filter/\" + encodeURIComponent($(\"#bucket\").val()) + \"/\" + action + \"?filter=\" + encodeURIComponent($(\"#filter\").val());\n    }\n\n    function showError(xhr) {\n        UIkit.notify(xhr.responseText, {status: \"danger\"});\n    }\n\n    function loadFilters() {\n        $.getJSON(\"/filter/\", function (data) {\n            $(\"#filter\").empty();\n            $.each(data.result, function (i, item) {\n                var option = $(\"<option>\").val(item.name).text(item.name + (item.active ? \" (active)\" : \"\"));\n                if (item.active) {\n                    option.attr(\"selected\", \"selected\");\n                }\n                $(\"#filter\").append(option);\n            });\n        }).fail(showError);\n    }\n\n    function loadStats() {\n        if (!$(\"#bucket\").val()) {\n            return;\n        }\n        $.getJSON(url(\"_stats\"), function (data) {\n            $(\"#stats\").empty();\n            $.each([\"items\", \"size_in_bytes\", \"estimated_false_positive_rate\"], function (i, name) {\n                $(\"#stats\").append($(\"<dt>\").text(name)).append($(\"<dd>\").text(data[name]));\n            });\n        }).fail(showError);\n    }\n\n    function operate(action) {\n        var keys = $.grep($(\"#keys\").val().split(\"\\n\"), function (key) {\n            return key != \"\";\n        });\n        if (!$(\"#bucket\").val() || keys.length == 0) {\n            return;\n        }\n        $.ajax({url: url(action), type: \"POST\", data: JSON.stringify({keys: keys}), contentType: \"application/json\"}).done(function (data) {\n            $(\"#results\").empty();\n            if (data.result) {\n                $.each(data.result, function (i, item) {\n                    $(\"#results\").append($(\"<tr>\").append($(\"<td>\").text(item.key)).append($(\"<td>\").text(item.exists)));\n                });\n            } else {\n                UIkit.notify(\"done\", {status: \"success\"});\n            }\n            loadStats();\n        }).fail(showError);\n    }\n\n    function clearBucket() {\n        var bucket = $(\"#bucket\").val();\n        if (!bucket || !confirm(\"Clear bucket: \" + bucket + \"?\")) {\n            return;\n        }\n        $.ajax({url: url(\"_clear\"), type: \"POST\"}).done(function () {\n            UIkit.notify(\"cleared\", {status: \"success\"});\n            loadStats();\n        }).fail(showError);\n    }\n\n    $(function () {\n        loadFilters();\n    });\n</script>\n\n")
+	common.Footer(w)
+	_, _ = io.WriteString(w, "\n")
+	return nil
+}
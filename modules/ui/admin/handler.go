@@ -5,6 +5,8 @@ import (
 	"github.com/huminghe/infini-framework/core/api/router"
 	"github.com/huminghe/infini-framework/modules/ui/admin/console"
 	"github.com/huminghe/infini-framework/modules/ui/admin/dashboard"
+	"github.com/huminghe/infini-framework/modules/ui/admin/filter"
+	"github.com/huminghe/infini-framework/modules/ui/admin/kv"
 	"github.com/huminghe/infini-framework/modules/ui/common"
 	"net/http"
 )
@@ -23,6 +25,16 @@ func (h AdminUI) ConsolePageAction(w http.ResponseWriter, r *http.Request, p htt
 	console.Index(w, r)
 }
 
+func (h AdminUI) KVPageAction(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	kv.Index(w, r)
+}
+
+func (h AdminUI) FilterPageAction(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	filter.Index(w, r)
+}
+
 func (h AdminUI) ExplorePageAction(w http.ResponseWriter, r *http.Request) {
 	common.Message(w, r, "hello", "world")
 	//explore.Index(w, r)
@@ -27,6 +27,8 @@ import (
 func InitUI() {
 	//Nav init
 	common.RegisterNav("Console", "Console", "/admin/console/")
+	common.RegisterNav("KV", "KV", "/admin/kv/")
+	common.RegisterNav("Filter", "Filter", "/admin/filter/")
 	//common.RegisterNav("Dashboard", "Dashboard", "/admin/")
 	//common.RegisterNav("Explore","Explore","/ui/explore/")
 	//common.RegisterNav("Setting", "Setting", "/admin/setting/")
@@ -37,6 +39,8 @@ func InitUI() {
 	ui.HandleUIMethod(api.GET, "/admin/", api.NeedPermission(api.PERMISSION_ADMIN_MINIMAL, adminUI.DashboardAction))
 	ui.HandleUIMethod(api.GET, "/admin/dashboard/", api.NeedPermission(api.PERMISSION_ADMIN_MINIMAL, adminUI.DashboardAction))
 	ui.HandleUIMethod(api.GET, "/admin/console/", api.NeedPermission(api.PERMISSION_ADMIN_MINIMAL, adminUI.ConsolePageAction))
+	ui.HandleUIMethod(api.GET, "/admin/kv/", api.NeedPermission(api.PERMISSION_KV_READ, adminUI.KVPageAction))
+	ui.HandleUIMethod(api.GET, "/admin/filter/", api.NeedPermission(api.PERMISSION_FILTER_READ, adminUI.FilterPageAction))
 
 	ui.HandleUIFunc("/admin/explore/", adminUI.ExplorePageAction)
 
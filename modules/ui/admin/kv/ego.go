@@ -0,0 +1,27 @@
+// Generated by ego.
+// DO NOT EDIT
+
+package kv
+
+import (
+	"fmt"
+	"github.com/huminghe/infini-framework/modules/ui/common"
+	"io"
+	"net/http"
+)
+
+var _ = fmt.Sprint("") // just so that we can keep the fmt import for now
+func Index(w http.ResponseWriter, r *http.Request) error {
+	_, _ = io.WriteString(w, "\n\n")
+	_, _ = io.WriteString(w, "\n")
+	_, _ = io.WriteString(w, "\n\n")
+	common.Head(w, "KV", "")
+	_, _ = io.WriteString(w, "\n<link rel=\"stylesheet\" href=\"/static/assets/uikit-2.27.1/css/components/notify.min.css\" />\n<script src=\"/static/assets/uikit-2.27.1/js/components/notify.min.js\"></script>\n<style type=\"text/css\">\n    #buckets li.uk-active a{\n        font-weight: bold;\n    }\n    #value{\n        min-height: 300px;\n        max-height: 600px;\n        white-space: pre-wrap;\n        word-break: break-all;\n    }\n</style>\n")
+	common.Body(w)
+	_, _ = io.WriteString(w, "\n")
+	common.Nav(w, r, "KV")
+	_, _ = io.WriteString(w, "\n\n\n<div class=\"tm-middle\">\n    <div class=\"uk-container uk-container-center\">\n\n        <div class=\"uk-grid\" data-uk-grid-margin>\n            <div class=\"uk-width-medium-1-4\">\n                <div class=\"uk-panel uk-panel-box\">\n                    <h3 class=\"uk-panel-title\">Buckets</h3>\n                    <ul id=\"buckets\" class=\"uk-nav uk-nav-side\"></ul>\n                </div>\n            </div>\n\n            <div class=\"uk-width-medium-3-4\">\n                <form class=\"uk-form\" onsubmit=\"return false;\">\n                    <fieldset data-uk-margin>\n                        <legend class=\"uk-text-primary\">Keys of <span id=\"current_bucket\">N/A</span></legend>\n                        <input id=\"prefix\" type=\"text\" class=\"uk-form-width-medium\" placeholder=\"Key prefix\">\n                        <button class=\"uk-button uk-button-primary\" onclick=\"loadKeys(true)\">Search</button>\n                        <button class=\"uk-button\" onclick=\"exportBucket()\">Export</button>\n                        <input id=\"import_file\" type=\"file\" style=\"display: none\" onchange=\"importBucket(this.files)\">\n                        <button class=\"uk-button\" onclick=\"$('#import_file').click()\">Import</button>\n                        <button class=\"uk-button uk-button-danger\" onclick=\"deleteBucket()\">Delete Bucket</button>\n                    </fieldset>\n                </form>\n\n                <div class=\"uk-grid\">\n                    <div class=\"uk-width-medium-1-3\">\n                        <ul id=\"keys\" class=\"uk-list uk-list-line\"></ul>\n                        <button id=\"more\" class=\"uk-button uk-button-mini\" style=\"display: none\" onclick=\"loadKeys(false)\">More</button>\n                    </div>\n                    <div class=\"uk-width-medium-2-3\">\n                        <form class=\"uk-form\" onsubmit=\"return false;\">\n                            <input id=\"key\" type=\"text\" class=\"uk-width-1-1\" placeholder=\"Key\">\n                            <textarea id=\"value\" class=\"uk-width-1-1\" rows=\"15\" placeholder=\"Value\"></textarea>\n                            <button class=\"uk-button uk-button-success\" onclick=\"putValue()\">Save</button>\n                            <button class=\"uk-button uk-button-danger\" onclick=\"deleteKey()\">Delete</button>\n                        </form>\n                    </div>\n                </div>\n            </div>\n        </div>\n\n    </div>\n</div>\n\n<script type=\"text/javascript\">\n    var bucket = \"\";\n    var next = \"\";\n\n    function url(key) {\n        var u = \"/kv/\" + encodeURIComponent(bucket) + \"/\";\n        if (key) {\n            u += encodeURIComponent(key);\n        }\n        return u;\n    }\n\n    function showError(xhr) {\n        UIkit.notify(xhr.responseText, {status: \"danger\"});\n    }\n\n    function loadBuckets() {\n        $.getJSON(\"/kv/\", function (data) {\n            $(\"#buckets\").empty();\n            $.each(data.result, function (i, name) {\n                var li = $(\"<li>\").append($(\"<a href='#'>\").text(name).click(function () {\n                    selectBucket(name);\n                    return false;\n                }));\n                if (name == bucket) {\n                    li.addClass(\"uk-active\");\n                }\n                $(\"#buckets\").append(li);\n            });\n        }).fail(showError);\n    }\n\n    function selectBucket(name) {\n        bucket = name;\n        $(\"#current_bucket\").text(name);\n        $(\"#key\").val(\"\");\n        $(\"#value\").val(\"\");\n        loadBuckets();\n        loadKeys(true);\n    }\n\n    function loadKeys(reset) {\n        if (!bucket) {\n            return;\n        }\n        if (reset) {\n            next = \"\";\n            $(\"#keys\").empty();\n        }\n        $.getJSON(url(), {prefix: $(\"#prefix\").val(), from: next, size: 50}, function (data) {\n            $.each(data.keys, function (i, item) {\n                $(\"#keys\").append($(\"<li>\").append($(\"<a href='#'>\").text(item.key).click(function () {\n                    getValue(item.key);\n                    return false;\n                })));\n            });\n            next = data.next || \"\";\n            $(\"#more\").toggle(next != \"\");\n        }).fail(showError);\n    }\n\n    function getValue(key) {\n        $.ajax({url: url(key), dataType: \"text\"}).done(function (data, status, xhr) {\n            $(\"#key\").val(key);\n            if ((xhr.getResponseHeader(\"Content-Type\") || \"\").indexOf(\"json\") >= 0) {\n                data = JSON.stringify(JSON.parse(data), null, 2);\n            }\n            $(\"#value\").val(data);\n        }).fail(showError);\n    }\n\n    function putValue() {\n        var key = $(\"#key\").val();\n        if (!bucket || !key) {\n            return;\n        }\n        $.ajax({url: url(key), type: \"PUT\", data: $(\"#value\").val(), processData: false}).done(function () {\n            UIkit.notify(\"saved\", {status: \"success\"});\n            loadKeys(true);\n        }).fail(showError);\n    }\n\n    function deleteKey() {\n        var key = $(\"#key\").val();\n        if (!bucket || !key || !confirm(\"Delete key: \" + key + \"?\")) {\n            return;\n        }\n        $.ajax({url: url(key), type: \"DELETE\"}).done(function () {\n            $(\"#key\").val(\"\");\n            $(\"#value\").val(\"\");\n            loadKeys(true);\n        }).fail(showError);\n    }\n\n    function deleteBucket() {\n        if (!bucket || !confirm(\"Delete bucket: \" + bucket + \"?\")) {\n            return;\n        }\n        $.ajax({url: url(), type: \"DELETE\"}).done(function () {\n            bucket = \"\";\n            $(\"#current_bucket\").text(\"N/A\");\n            $(\"#keys\").empty();\n            loadBuckets();\n        }).fail(showError);\n    }\n\n    function exportBucket() {\n        if (!bucket) {\n            return;\n        }\n        $(\"<form method='POST'>\").attr(\"action\", url() + \"_export?prefix=\" + encodeURIComponent($(\"#prefix\").val()))\n                .appendTo(\"body\").submit().remove();\n    }\n\n    function importBucket(files) {\n        if (!bucket || files.length == 0) {\n            return;\n        }\n        $.ajax({url: url() + \"_import\", type: \"POST\", data: files[0], processData: false, contentType: false}).done(function (data) {\n            UIkit.notify(data.total + \" items imported\", {status: \"success\"});\n            loadKeys(true);\n        }).fail(showError);\n        $(\"#import_file\").val(\"\");\n    }\n\n    $(function () {\n        loadBuckets();\n    });\n</script>\n\n")
+	common.Footer(w)
+	_, _ = io.WriteString(w, "\n")
+	return nil
+}
@@ -0,0 +1,45 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package impl
+
+import (
+	"github.com/huminghe/infini-framework/core/api"
+	"github.com/huminghe/infini-framework/core/api/router"
+	"net/http"
+)
+
+// API namespace
+type API struct {
+	api.Handler
+}
+
+func (handler API) Init() {
+	api.HandleAPIMethod(api.GET, "/bloom/_stats", api.NeedPermission(api.PERMISSION_FILTER_READ, handler.getStatsAction))
+}
+
+// getStatsAction return the fill ratio and the estimated false positive rate of the buckets,
+// only the specify bucket will be returned if the parameter `bucket` is set
+func (handler API) getStatsAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	bucket := handler.GetParameter(req, "bucket")
+	if bucket != "" {
+		handler.WriteJSON(w, bloomFilter.BucketStats(bucket), http.StatusOK)
+		return
+	}
+
+	stats := bloomFilter.Stats()
+	handler.WriteJSONListResult(w, len(stats), stats, http.StatusOK)
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package impl
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/bits"
+
+	"github.com/huminghe/infini-framework/core/errors"
+)
+
+var errInvalidSnapshot = errors.New("invalid bloom filter snapshot")
+
+// bloom is a classic bloom filter, the size and the number of hash functions
+// are derived from the expected number of items and the target false positive rate
+type bloom struct {
+	bits  []uint64
+	m     uint64 //number of bits
+	k     uint64 //number of hash functions
+	count uint64 //number of items added
+	ones  uint64 //number of bits set
+}
+
+func newBloom(expectedItems int, falsePositiveRate float64) *bloom {
+	if expectedItems <= 0 {
+		expectedItems = 1
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = 0.0001
+	}
+
+	n := float64(expectedItems)
+	m := uint64(math.Ceil(-n * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Ceil(float64(m) / n * math.Ln2))
+	if m < 64 {
+		m = 64
+	}
+	if k < 1 {
+		k = 1
+	}
+
+	return &bloom{m: m, k: k, bits: make([]uint64, (m+63)/64)}
+}
+
+// locations use double hashing to simulate k hash functions
+func (b *bloom) locations(key []byte) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write(key)
+	h1 := h.Sum64()
+
+	h = fnv.New64()
+	h.Write(key)
+	h2 := h.Sum64() | 1
+
+	return h1, h2
+}
+
+func (b *bloom) Add(key []byte) {
+	h1, h2 := b.locations(key)
+	for i := uint64(0); i < b.k; i++ {
+		loc := (h1 + i*h2) % b.m
+		mask := uint64(1) << (loc % 64)
+		if b.bits[loc/64]&mask == 0 {
+			b.bits[loc/64] |= mask
+			b.ones++
+		}
+	}
+	b.count++
+}
+
+func (b *bloom) Lookup(key []byte) bool {
+	h1, h2 := b.locations(key)
+	for i := uint64(0); i < b.k; i++ {
+		loc := (h1 + i*h2) % b.m
+		if b.bits[loc/64]&(uint64(1)<<(loc%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// FillRatio return the ratio of bits set
+func (b *bloom) FillRatio() float64 {
+	return float64(b.ones) / float64(b.m)
+}
+
+// EstimatedFalsePositiveRate return the current false positive rate based on the fill ratio
+func (b *bloom) EstimatedFalsePositiveRate() float64 {
+	return math.Pow(b.FillRatio(), float64(b.k))
+}
+
+// SizeInBytes return the memory used by the bits
+func (b *bloom) SizeInBytes() uint64 {
+	return uint64(len(b.bits) * 8)
+}
+
+func (b *bloom) fillStats(stats *Stats) {
+	stats.Items = b.count
+	stats.Bits = b.m
+	stats.HashFunctions = b.k
+	stats.SizeInBytes = b.SizeInBytes()
+	stats.FillRatio = b.FillRatio()
+	stats.EstimatedFalsePositiveRate = b.EstimatedFalsePositiveRate()
+	stats.Slices = 1
+}
+
+func (b *bloom) WriteTo(w io.Writer) (int64, error) {
+	header := []uint64{b.m, b.k, b.count}
+	err := binary.Write(w, binary.LittleEndian, header)
+	if err != nil {
+		return 0, err
+	}
+	err = binary.Write(w, binary.LittleEndian, b.bits)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(header)*8 + len(b.bits)*8), nil
+}
+
+func (b *bloom) ReadFrom(r io.Reader) (int64, error) {
+	header := make([]uint64, 3)
+	err := binary.Read(r, binary.LittleEndian, header)
+	if err != nil {
+		return 0, err
+	}
+	m, k := header[0], header[1]
+	if m == 0 || m > math.MaxUint64-63 || k == 0 || k > m {
+		return 0, errors.Wrapf(errInvalidSnapshot, "bits: %v, hash functions: %v", m, k)
+	}
+	//the bits must fit in what is left of the snapshot, don't allocate for a broken header
+	words := (m + 63) / 64
+	if lr, ok := r.(*io.LimitedReader); ok && int64(words) > lr.N/8 {
+		return 0, errors.Wrapf(errInvalidSnapshot, "bits: %v, remaining bytes: %v", m, lr.N)
+	}
+
+	b.m, b.k, b.count = m, k, header[2]
+	b.bits = make([]uint64, words)
+	err = binary.Read(r, binary.LittleEndian, b.bits)
+	if err != nil {
+		return 0, err
+	}
+
+	b.ones = 0
+	for _, v := range b.bits {
+		b.ones += uint64(bits.OnesCount64(v))
+	}
+	return int64(len(header)*8 + len(b.bits)*8), nil
+}
@@ -0,0 +1,250 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package impl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/huminghe/infini-framework/core/errors"
+	api "github.com/huminghe/infini-framework/core/filter"
+	"github.com/huminghe/infini-framework/core/util"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBloomFalsePositiveRate(t *testing.T) {
+	b := newBloom(10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		b.Add([]byte(fmt.Sprintf("key-%v", i)))
+	}
+
+	for i := 0; i < 10000; i++ {
+		assert.True(t, b.Lookup([]byte(fmt.Sprintf("key-%v", i))))
+	}
+
+	falsePositive := 0
+	for i := 0; i < 10000; i++ {
+		if b.Lookup([]byte(fmt.Sprintf("other-%v", i))) {
+			falsePositive++
+		}
+	}
+	assert.True(t, falsePositive < 200, "false positive: %v", falsePositive)
+	assert.True(t, b.EstimatedFalsePositiveRate() < 0.02)
+	assert.True(t, b.FillRatio() > 0.4 && b.FillRatio() < 0.6)
+}
+
+func TestBloomPersist(t *testing.T) {
+	b := newBloom(1000, 0.001)
+	b.Add([]byte("hello"))
+
+	buffer := bytes.Buffer{}
+	_, err := b.WriteTo(&buffer)
+	assert.Nil(t, err)
+
+	b1 := &bloom{}
+	_, err = b1.ReadFrom(&buffer)
+	assert.Nil(t, err)
+	assert.True(t, b1.Lookup([]byte("hello")))
+	assert.False(t, b1.Lookup([]byte("world")))
+	assert.Equal(t, b.m, b1.m)
+	assert.Equal(t, b.k, b1.k)
+	assert.Equal(t, b.ones, b1.ones)
+	assert.Equal(t, uint64(1), b1.count)
+}
+
+func TestBloomInvalidSnapshot(t *testing.T) {
+	//a zero filled header would divide by zero on lookups
+	b := &bloom{}
+	_, err := b.ReadFrom(bytes.NewReader(make([]byte, 64)))
+	assert.Equal(t, errInvalidSnapshot, errors.Cause(err))
+
+	//a header claiming more bits than the snapshot has is rejected before allocating them
+	buffer := bytes.Buffer{}
+	binary.Write(&buffer, binary.LittleEndian, []uint64{math.MaxUint64 / 2, 7, 0, 0})
+	_, err = b.ReadFrom(io.LimitReader(&buffer, int64(buffer.Len())))
+	assert.Equal(t, errInvalidSnapshot, errors.Cause(err))
+
+	s := &scalableBloom{}
+	buffer.Reset()
+	binary.Write(&buffer, binary.LittleEndian, []uint64{1000, 2, math.Float64bits(0.01), math.Float64bits(0.85), math.MaxUint64})
+	_, err = s.ReadFrom(io.LimitReader(&buffer, int64(buffer.Len())))
+	assert.Equal(t, errInvalidSnapshot, errors.Cause(err))
+
+	//an invalid snapshot is treated as missing
+	folder := t.TempDir()
+	assert.Nil(t, ioutil.WriteFile(path.Join(folder, "bucket.bloom"), make([]byte, 64), 0644))
+	filter := &BloomFilter{Folder: folder, Config: BloomFilterConfig{ExpectedItems: 1000, FalsePositiveRate: 0.01}}
+	assert.Nil(t, filter.Open())
+	assert.False(t, filter.Exists("bucket", []byte("key")))
+	filter.Add("bucket", []byte("key"))
+	assert.True(t, filter.Exists("bucket", []byte("key")))
+	assert.Equal(t, uint64(1), filter.BucketStats("bucket").Items)
+	assert.Nil(t, filter.Close())
+}
+
+func TestScalableBloom(t *testing.T) {
+	b := newScalableBloom(1000, 0.01, 2, 0.85)
+	for i := 0; i < 20000; i++ {
+		b.Add([]byte(fmt.Sprintf("key-%v", i)))
+	}
+
+	stats := Stats{}
+	b.fillStats(&stats)
+	assert.Equal(t, 5, stats.Slices)
+	assert.Equal(t, uint64(20000), stats.Items)
+	assert.True(t, stats.EstimatedFalsePositiveRate < 0.01)
+
+	falsePositive := 0
+	for i := 0; i < 20000; i++ {
+		assert.True(t, b.Lookup([]byte(fmt.Sprintf("key-%v", i))))
+		if b.Lookup([]byte(fmt.Sprintf("other-%v", i))) {
+			falsePositive++
+		}
+	}
+	assert.True(t, falsePositive < 200, "false positive: %v", falsePositive)
+
+	buffer := bytes.Buffer{}
+	_, err := b.WriteTo(&buffer)
+	assert.Nil(t, err)
+
+	b1 := &scalableBloom{}
+	_, err = b1.ReadFrom(&buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(b1.slices))
+	assert.Equal(t, b.falsePositiveRate, b1.falsePositiveRate)
+	assert.Equal(t, b.tighteningRatio, b1.tighteningRatio)
+	assert.True(t, b1.Lookup([]byte("key-0")))
+	assert.True(t, b1.Lookup([]byte("key-19999")))
+}
+
+func TestBloomFilterBuckets(t *testing.T) {
+	folder := path.Join(os.TempDir(), "bloom_"+util.PickRandomName())
+	defer os.RemoveAll(folder)
+
+	config := BloomFilterConfig{
+		ExpectedItems:     1000,
+		FalsePositiveRate: 0.01,
+		Buckets:           []BucketConfig{{Name: "large", ExpectedItems: 100000}, {Name: "scalable", Mode: ModeScalable}},
+	}
+
+	filter := &BloomFilter{Folder: folder, Config: config}
+	assert.Nil(t, filter.Open())
+
+	b, _ := filter.CheckThenAdd("small", []byte("key"))
+	assert.False(t, b)
+	b, _ = filter.CheckThenAdd("small", []byte("key"))
+	assert.True(t, b)
+	assert.False(t, filter.Exists("large", []byte("key")))
+
+	assert.Equal(t, 1000, filter.BucketStats("small").ExpectedItems)
+	assert.Equal(t, 100000, filter.BucketStats("large").ExpectedItems)
+	assert.Equal(t, 0.01, filter.BucketStats("large").FalsePositiveRate)
+	filter.Add("scalable", []byte("key"))
+	assert.Equal(t, ModeScalable, filter.BucketStats("scalable").Mode)
+	assert.Nil(t, filter.Close())
+
+	//lookups and stats never create the bucket
+	assert.True(t, util.FileExists(path.Join(folder, "small.bloom")))
+	assert.False(t, util.FileExists(path.Join(folder, "large.bloom")))
+	assert.True(t, util.FileExists(path.Join(folder, "scalable.sbloom")))
+
+	filter = &BloomFilter{Folder: folder, Config: config}
+	assert.Nil(t, filter.Open())
+	assert.True(t, filter.Exists("small", []byte("key")))
+	assert.Equal(t, uint64(1), filter.BucketStats("small").Items)
+	assert.True(t, filter.Exists("scalable", []byte("key")))
+
+	//keys can't be removed from a bloom filter
+	err := filter.Delete("small", []byte("key"))
+	assert.Equal(t, api.ErrDeleteNotSupported, errors.Cause(err))
+	assert.True(t, filter.Exists("small", []byte("key")))
+}
+
+func TestBloomFilterSnapshot(t *testing.T) {
+	folder := path.Join(os.TempDir(), "bloom_"+util.PickRandomName())
+	defer os.RemoveAll(folder)
+
+	config := BloomFilterConfig{ExpectedItems: 1000, FalsePositiveRate: 0.01, SnapshotIntervalInSeconds: 1}
+	filter := &BloomFilter{Folder: folder, Config: config}
+	assert.Nil(t, filter.Open())
+	filter.Add("bucket", []byte("key"))
+	assert.Nil(t, filter.BucketStats("bucket").LastSnapshotTime)
+
+	time.Sleep(1500 * time.Millisecond)
+	stats := filter.BucketStats("bucket")
+	assert.NotNil(t, stats.LastSnapshotTime)
+	assert.True(t, stats.LastSnapshotSize > 0)
+	assert.True(t, util.FileExists(path.Join(folder, "bucket.bloom")))
+	tmpFiles, _ := filepath.Glob(path.Join(folder, "*.tmp"))
+	assert.Equal(t, 0, len(tmpFiles))
+
+	//reload the snapshot without closing the filter, as if the process was killed
+	reloaded := &BloomFilter{Folder: folder, Config: BloomFilterConfig{ExpectedItems: 1000, FalsePositiveRate: 0.01}}
+	assert.Nil(t, reloaded.Open())
+	assert.True(t, reloaded.Exists("bucket", []byte("key")))
+	assert.Equal(t, stats.LastSnapshotSize, reloaded.BucketStats("bucket").LastSnapshotSize)
+
+	//write errors are reported instead of panic
+	os.RemoveAll(folder)
+	filter.Add("bucket", []byte("key2"))
+	assert.NotNil(t, filter.Close())
+}
+
+func TestBloomFilterConcurrentSnapshot(t *testing.T) {
+	folder := t.TempDir()
+
+	config := BloomFilterConfig{ExpectedItems: 10000, FalsePositiveRate: 0.01, SnapshotIntervalInSeconds: 1}
+	filter := &BloomFilter{Folder: folder, Config: config}
+	assert.Nil(t, filter.Open())
+
+	//snapshots run concurrently with the writers, the last keys added must survive the close
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				filter.Add("bucket", []byte(fmt.Sprintf("key-%v-%v", i, j)))
+				if j%50 == 0 {
+					assert.Nil(t, filter.Snapshot())
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Nil(t, filter.Close())
+
+	tmpFiles, _ := filepath.Glob(path.Join(folder, "*.tmp"))
+	assert.Equal(t, 0, len(tmpFiles))
+
+	reloaded := &BloomFilter{Folder: folder, Config: BloomFilterConfig{ExpectedItems: 10000, FalsePositiveRate: 0.01}}
+	assert.Nil(t, reloaded.Open())
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 500; j++ {
+			assert.True(t, reloaded.Exists("bucket", []byte(fmt.Sprintf("key-%v-%v", i, j))))
+		}
+	}
+}
@@ -17,101 +17,435 @@ limitations under the License.
 package impl
 
 import (
+	"bufio"
+	"bytes"
 	log "github.com/cihub/seelog"
-	. "github.com/zeebo/sbloom"
-	"hash/fnv"
-	"github.com/huminghe/infini-framework/core/global"
+	"github.com/huminghe/infini-framework/core/errors"
+	api "github.com/huminghe/infini-framework/core/filter"
 	"github.com/huminghe/infini-framework/core/util"
-	"io/ioutil"
+	"io"
+	"net/url"
 	"os"
 	"path"
 	"sync"
+	"time"
 )
 
+const (
+	// ModeFixed use a bloom filter with fixed capacity
+	ModeFixed = "fixed"
+
+	// ModeScalable use a scalable bloom filter, the expected items is the capacity of the first slice
+	ModeScalable = "scalable"
+)
+
+// BucketConfig defines the capacity and the accuracy of the filter of a bucket
+type BucketConfig struct {
+	Name              string  `config:"name"`
+	Mode              string  `config:"mode"`
+	ExpectedItems     int     `config:"expected_items"`
+	FalsePositiveRate float64 `config:"false_positive_rate"`
+
+	//GrowthFactor and TighteningRatio are only used by the scalable mode
+	GrowthFactor    int     `config:"growth_factor"`
+	TighteningRatio float64 `config:"tightening_ratio"`
+}
+
+// BloomFilterConfig defines the default settings and the settings per bucket
+type BloomFilterConfig struct {
+	Mode              string         `config:"mode"`
+	ExpectedItems     int            `config:"expected_items"`
+	FalsePositiveRate float64        `config:"false_positive_rate"`
+	GrowthFactor      int            `config:"growth_factor"`
+	TighteningRatio   float64        `config:"tightening_ratio"`
+	Buckets           []BucketConfig `config:"buckets"`
+
+	//SnapshotIntervalInSeconds defines how often the changed filters are persisted, 0 means only persist on close
+	SnapshotIntervalInSeconds int `config:"snapshot_interval_in_seconds"`
+}
+
+// Stats of the bloom filter of a bucket
+type Stats struct {
+	Bucket                     string  `json:"bucket"`
+	Mode                       string  `json:"mode"`
+	ExpectedItems              int     `json:"expected_items"`
+	FalsePositiveRate          float64 `json:"false_positive_rate"`
+	Items                      uint64  `json:"items"`
+	Bits                       uint64  `json:"bits"`
+	HashFunctions              uint64  `json:"hash_functions"`
+	SizeInBytes                uint64  `json:"size_in_bytes"`
+	FillRatio                  float64 `json:"fill_ratio"`
+	EstimatedFalsePositiveRate float64 `json:"estimated_false_positive_rate"`
+	Slices                     int     `json:"slices"`
+
+	LastSnapshotTime *time.Time `json:"last_snapshot_time,omitempty"`
+	LastSnapshotSize int64      `json:"last_snapshot_size"`
+}
+
+// bloomImpl is implemented by both the fixed and the scalable bloom filter
+type bloomImpl interface {
+	Add(key []byte)
+	Lookup(key []byte) bool
+	WriteTo(w io.Writer) (int64, error)
+	ReadFrom(r io.Reader) (int64, error)
+	fillStats(stats *Stats)
+}
+
+// BloomFilter keeps one bloom filter per bucket, each bucket is persisted to a separate file under the folder
 type BloomFilter struct {
-	PersistFileName string
-	filter          *Filter
-	ProbItems       int
+	Folder string
+	Config BloomFilterConfig
+
+	lock    sync.RWMutex
+	buckets map[string]*bucketFilter
+	quit    chan bool
+	wg      sync.WaitGroup
 }
 
-var filters map[string]BloomFilter
+type bucketFilter struct {
+	lock sync.RWMutex
+	//persistLock serializes the snapshots of the bucket, so an older snapshot never replaces a newer one
+	persistLock sync.Mutex
 
-func (filter *BloomFilter) Open() error {
+	config          BucketConfig
+	persistFileName string
+	filter          bloomImpl
 
-	//loading or initializing bloom filter
-	if util.FileExists(filter.PersistFileName) {
-		log.Debug("found bloomFilter,start reload,", filter.PersistFileName)
-		n, err := ioutil.ReadFile(filter.PersistFileName)
-		if err != nil {
-			log.Error("bloomFilter:", filter.PersistFileName, err)
-		}
-		filter.filter = new(Filter)
-		if err := filter.filter.GobDecode(n); err != nil {
-			log.Error("bloomFilter:", filter.PersistFileName, err)
-		}
-		log.Info("bloomFilter successfully reloaded:", filter.PersistFileName)
-	} else {
+	//dirty is set when keys are added after the last snapshot
+	dirty            bool
+	lastSnapshotTime time.Time
+	lastSnapshotSize int64
+}
 
-		probItems := 1000000 //config.GetIntConfig("BloomFilter", "ItemSize", 100000)
-		log.Debug("initializing bloom-filter", filter.PersistFileName, ",virual size is,", probItems)
-		filter.filter = NewFilter(fnv.New64(), probItems)
-		log.Info("bloomFilter successfully initialized:", filter.PersistFileName)
+func (filter *BloomFilter) Open() error {
+	filter.lock.Lock()
+	defer filter.lock.Unlock()
+
+	filter.buckets = map[string]*bucketFilter{}
+	err := os.MkdirAll(filter.Folder, 0777)
+	if err != nil {
+		return err
 	}
 
+	if filter.Config.SnapshotIntervalInSeconds > 0 {
+		filter.quit = make(chan bool)
+		filter.wg.Add(1)
+		go filter.runSnapshot(time.Duration(filter.Config.SnapshotIntervalInSeconds)*time.Second, filter.quit)
+	}
 	return nil
 }
 
 func (filter *BloomFilter) Close() error {
-
-	log.Debug("bloomFilter start persist,file:", filter.PersistFileName)
-
-	//save bloom-filter
-	m, err := filter.filter.GobEncode()
-	if err != nil {
-		panic(err)
+	//wait for the running snapshot, so the final snapshot is the last one written
+	if filter.quit != nil {
+		close(filter.quit)
+		filter.wg.Wait()
+		filter.quit = nil
 	}
-	err = ioutil.WriteFile(filter.PersistFileName, m, 0600)
+
+	err := filter.Snapshot()
 	if err != nil {
-		panic(err)
+		return err
 	}
 	log.Info("bloomFilter safety persisted.")
-
 	return nil
 }
 
+// Snapshot persist all the changed filters, a failed bucket will not stop the others from being persisted
+func (filter *BloomFilter) Snapshot() error {
+	filter.lock.RLock()
+	defer filter.lock.RUnlock()
+
+	var lastErr error
+	for _, f := range filter.buckets {
+		err := f.persist()
+		if err != nil {
+			log.Error("bloomFilter failed to persist,file:", f.persistFileName, ", ", err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (filter *BloomFilter) runSnapshot(interval time.Duration, quit chan bool) {
+	defer filter.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+			filter.Snapshot()
+		}
+	}
+}
+
 func (filter *BloomFilter) Exists(bucket string, key []byte) bool {
-	return filter.filter.Lookup(key)
+	f := filter.lookupBucket(bucket)
+	if f == nil {
+		return false
+	}
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.filter.Lookup(key)
 }
 
 func (filter *BloomFilter) Add(bucket string, key []byte) error {
-	filter.filter.Add(key)
+	f := filter.getBucket(bucket)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.filter.Add(key)
+	f.dirty = true
 	return nil
 }
 
+// Delete is not supported, the bits of a key are shared with other keys
 func (filter *BloomFilter) Delete(bucket string, key []byte) error {
+	return errors.Wrap(api.ErrDeleteNotSupported, "bloom filter")
+}
+
+func (filter *BloomFilter) CheckThenAdd(bucket string, key []byte) (b bool, err error) {
+	f := filter.getBucket(bucket)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	b = f.filter.Lookup(key)
+	if !b {
+		f.filter.Add(key)
+		f.dirty = true
+	}
+	return b, nil
+}
+
+// Stats return the stats of all the opened buckets
+func (filter *BloomFilter) Stats() []Stats {
+	filter.lock.RLock()
+	defer filter.lock.RUnlock()
+
+	result := []Stats{}
+	for _, f := range filter.buckets {
+		result = append(result, f.stats())
+	}
+	return result
+}
+
+// BucketStats return the stats of the bucket, only the settings are reported if the bucket doesn't exist
+func (filter *BloomFilter) BucketStats(bucket string) Stats {
+	f := filter.lookupBucket(bucket)
+	if f == nil {
+		cfg := filter.getBucketConfig(bucket)
+		return Stats{
+			Bucket:            bucket,
+			Mode:              cfg.Mode,
+			ExpectedItems:     cfg.ExpectedItems,
+			FalsePositiveRate: cfg.FalsePositiveRate,
+		}
+	}
+	return f.stats()
+}
+
+// GetStats return the common stats of the bucket
+func (filter *BloomFilter) GetStats(bucket string) (api.Stats, error) {
+	stats := filter.BucketStats(bucket)
+	return api.Stats{
+		Bucket:                     bucket,
+		Items:                      stats.Items,
+		SizeInBytes:                stats.SizeInBytes,
+		EstimatedFalsePositiveRate: stats.EstimatedFalsePositiveRate,
+	}, nil
+}
+
+func (filter *BloomFilter) getBucketConfig(bucket string) BucketConfig {
+	cfg := BucketConfig{
+		Name:              bucket,
+		Mode:              filter.Config.Mode,
+		ExpectedItems:     filter.Config.ExpectedItems,
+		FalsePositiveRate: filter.Config.FalsePositiveRate,
+		GrowthFactor:      filter.Config.GrowthFactor,
+		TighteningRatio:   filter.Config.TighteningRatio,
+	}
+	for _, v := range filter.Config.Buckets {
+		if v.Name != bucket {
+			continue
+		}
+		if v.Mode != "" {
+			cfg.Mode = v.Mode
+		}
+		if v.GrowthFactor > 0 {
+			cfg.GrowthFactor = v.GrowthFactor
+		}
+		if v.TighteningRatio > 0 {
+			cfg.TighteningRatio = v.TighteningRatio
+		}
+		if v.ExpectedItems > 0 {
+			cfg.ExpectedItems = v.ExpectedItems
+		}
+		if v.FalsePositiveRate > 0 {
+			cfg.FalsePositiveRate = v.FalsePositiveRate
+		}
+	}
+	return cfg
+}
 
+// Clear drop the filter of the bucket and remove the persisted file
+func (filter *BloomFilter) Clear(bucket string) error {
+	filter.lock.Lock()
+	defer filter.lock.Unlock()
+
+	delete(filter.buckets, bucket)
+
+	for _, ext := range []string{".bloom", ".sbloom"} {
+		file := path.Join(filter.Folder, url.QueryEscape(bucket)+ext)
+		if util.FileExists(file) {
+			if err := os.Remove(file); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
-var l sync.RWMutex
+// getBucket return the filter of bucket, the filter will be loaded or initialized if it is not opened
+func (filter *BloomFilter) getBucket(bucket string) *bucketFilter {
+	return filter.openBucket(bucket, true)
+}
 
-func (filter *BloomFilter) CheckThenAdd(bucket string, key []byte) (b bool, err error) {
-	f := filters[bucket]
-	l.Lock()
-	defer l.Unlock()
-	b = f.Exists(bucket, key)
-	if !b {
-		err = f.Add(bucket, key)
+// lookupBucket return the filter of bucket, the filter will be loaded if it is persisted, nil if the bucket doesn't exist,
+// so the lookups never create empty buckets
+func (filter *BloomFilter) lookupBucket(bucket string) *bucketFilter {
+	return filter.openBucket(bucket, false)
+}
+
+func (filter *BloomFilter) openBucket(bucket string, create bool) *bucketFilter {
+	filter.lock.RLock()
+	f, ok := filter.buckets[bucket]
+	filter.lock.RUnlock()
+	if ok {
+		return f
+	}
+
+	filter.lock.Lock()
+	defer filter.lock.Unlock()
+
+	//double check after lock in
+	f, ok = filter.buckets[bucket]
+	if ok {
+		return f
+	}
+
+	cfg := filter.getBucketConfig(bucket)
+	ext := ".bloom"
+	if cfg.Mode == ModeScalable {
+		ext = ".sbloom"
+	}
+
+	f = &bucketFilter{
+		config:          cfg,
+		persistFileName: path.Join(filter.Folder, url.QueryEscape(bucket)+ext),
+	}
+	if !create && !util.FileExists(f.persistFileName) {
+		return nil
 	}
-	return b, err
+	f.open()
+	filter.buckets[bucket] = f
+	return f
 }
 
-func initBloomFilter(key string) {
-	//f := impl.PersistFilter{FilterBucket: string(key)}
-	dir := path.Join(global.Env().GetWorkingDir(), "filters")
-	os.MkdirAll(dir, 0777)
-	file := path.Join(dir, string(key))
-	f := BloomFilter{PersistFileName: file}
-	f.Open()
-	filters[key] = f
+func (f *bucketFilter) open() {
+	f.filter = f.newFilter()
+
+	//loading or initializing bloom filter
+	if util.FileExists(f.persistFileName) {
+		log.Debug("found bloomFilter,start reload,", f.persistFileName)
+		file, err := os.Open(f.persistFileName)
+		if err == nil {
+			var stat os.FileInfo
+			stat, err = file.Stat()
+			if err == nil {
+				//limit the reader to the file size, so a broken header can't claim more bits than the file has
+				_, err = f.filter.ReadFrom(io.LimitReader(bufio.NewReader(file), stat.Size()))
+			}
+			file.Close()
+		}
+		if err == nil {
+			f.lastSnapshotSize, _ = util.FileSize(f.persistFileName)
+			if mtime, err := util.FileMTime(f.persistFileName); err == nil {
+				f.lastSnapshotTime = time.Unix(mtime, 0)
+			}
+			log.Info("bloomFilter successfully reloaded:", f.persistFileName)
+			return
+		}
+		if errors.Cause(err) == errInvalidSnapshot {
+			log.Warn("bloomFilter: ignore invalid snapshot,", f.persistFileName, ",", err)
+		} else {
+			log.Error("bloomFilter:", f.persistFileName, err)
+		}
+	}
+
+	log.Debug("initializing bloom-filter", f.persistFileName, ",mode: ", f.config.Mode, ",expected items: ", f.config.ExpectedItems, ",false positive rate: ", f.config.FalsePositiveRate)
+	f.filter = f.newFilter()
+	f.dirty = true
+	log.Info("bloomFilter successfully initialized:", f.persistFileName)
+}
+
+func (f *bucketFilter) newFilter() bloomImpl {
+	if f.config.Mode == ModeScalable {
+		return newScalableBloom(f.config.ExpectedItems, f.config.FalsePositiveRate, f.config.GrowthFactor, f.config.TighteningRatio)
+	}
+	return newBloom(f.config.ExpectedItems, f.config.FalsePositiveRate)
+}
+
+// persist write the filter to a temp file and rename it to the snapshot file, skipped if nothing changed since the last snapshot
+func (f *bucketFilter) persist() error {
+	f.persistLock.Lock()
+	defer f.persistLock.Unlock()
+
+	f.lock.Lock()
+	if !f.dirty {
+		f.lock.Unlock()
+		return nil
+	}
+
+	log.Debug("bloomFilter start persist,file:", f.persistFileName)
+
+	//serialize inside the lock, and write to disk without blocking the writers
+	buffer := bytes.Buffer{}
+	_, err := f.filter.WriteTo(&buffer)
+	if err != nil {
+		f.lock.Unlock()
+		return err
+	}
+	f.dirty = false
+	f.lock.Unlock()
+
+	n, err := util.FilePutContentAtomic(f.persistFileName, buffer.Bytes())
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err != nil {
+		f.dirty = true
+		return err
+	}
+	f.lastSnapshotTime = time.Now()
+	f.lastSnapshotSize = int64(n)
+	return nil
+}
+
+func (f *bucketFilter) stats() Stats {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	stats := Stats{
+		Bucket:            f.config.Name,
+		Mode:              f.config.Mode,
+		ExpectedItems:     f.config.ExpectedItems,
+		FalsePositiveRate: f.config.FalsePositiveRate,
+	}
+	f.filter.fillStats(&stats)
+	if !f.lastSnapshotTime.IsZero() {
+		t := f.lastSnapshotTime
+		stats.LastSnapshotTime = &t
+	}
+	stats.LastSnapshotSize = f.lastSnapshotSize
+	return stats
 }
@@ -0,0 +1,86 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package impl
+
+import (
+	log "github.com/cihub/seelog"
+	. "github.com/huminghe/infini-framework/core/config"
+	"github.com/huminghe/infini-framework/core/filter"
+	"github.com/huminghe/infini-framework/core/global"
+	"path"
+)
+
+type BloomFilterPlugin struct {
+}
+
+func (plugin BloomFilterPlugin) Name() string {
+	return "BloomFilter"
+}
+
+var pluginConfig = struct {
+	APIEnabled        bool `config:"api_enabled"`
+	BloomFilterConfig `config:",inline"`
+}{
+	APIEnabled: true,
+	BloomFilterConfig: BloomFilterConfig{
+		Mode:              ModeFixed,
+		ExpectedItems:     1000000,
+		FalsePositiveRate: 0.0001,
+		GrowthFactor:      2,
+		TighteningRatio:   0.85,
+
+		SnapshotIntervalInSeconds: 60,
+	},
+}
+
+var bloomFilter *BloomFilter
+
+func (plugin BloomFilterPlugin) Setup(cfg *Config) {
+
+	cfg.Unpack(&pluginConfig)
+
+	bloomFilter = &BloomFilter{
+		Folder: path.Join(global.Env().GetWorkingDir(), "filters", "bloom"),
+		Config: pluginConfig.BloomFilterConfig,
+	}
+
+	err := bloomFilter.Open()
+	if err != nil {
+		panic(err)
+	}
+
+	filter.Register("bloom", bloomFilter)
+
+	if pluginConfig.APIEnabled {
+		handler := API{}
+		handler.Init()
+	}
+}
+
+func (plugin BloomFilterPlugin) Start() error {
+	return nil
+}
+
+func (plugin BloomFilterPlugin) Stop() error {
+	if bloomFilter != nil {
+		err := bloomFilter.Close()
+		if err != nil {
+			log.Error(err)
+		}
+	}
+	return nil
+}
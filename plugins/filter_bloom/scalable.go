@@ -0,0 +1,171 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package impl
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/huminghe/infini-framework/core/errors"
+)
+
+// scalableBloom is a scalable bloom filter, a new and larger bloom filter slice will be added when
+// the current slice reached its capacity, each new slice has a tighter error ratio,
+// so the overall false positive rate will be bounded by the target rate
+type scalableBloom struct {
+	slices            []*bloom
+	initialItems      uint64
+	growthFactor      uint64
+	falsePositiveRate float64
+	tighteningRatio   float64
+}
+
+func newScalableBloom(initialItems int, falsePositiveRate float64, growthFactor int, tighteningRatio float64) *scalableBloom {
+	if initialItems <= 0 {
+		initialItems = 1
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = 0.0001
+	}
+	if growthFactor < 1 {
+		growthFactor = 2
+	}
+	if tighteningRatio <= 0 || tighteningRatio >= 1 {
+		tighteningRatio = 0.85
+	}
+
+	b := &scalableBloom{
+		initialItems:      uint64(initialItems),
+		growthFactor:      uint64(growthFactor),
+		falsePositiveRate: falsePositiveRate,
+		tighteningRatio:   tighteningRatio,
+	}
+	b.grow()
+	return b
+}
+
+// capacity return the expected items of the slice i
+func (b *scalableBloom) capacity(i int) uint64 {
+	c := b.initialItems
+	for j := 0; j < i; j++ {
+		c *= b.growthFactor
+	}
+	return c
+}
+
+// grow add a new slice, the error ratio of slice i is P*(1-r)*r^i,
+// so the sum of all slices will never exceed the target rate P
+func (b *scalableBloom) grow() {
+	i := len(b.slices)
+	rate := b.falsePositiveRate * (1 - b.tighteningRatio) * math.Pow(b.tighteningRatio, float64(i))
+	b.slices = append(b.slices, newBloom(int(b.capacity(i)), rate))
+}
+
+func (b *scalableBloom) Add(key []byte) {
+	i := len(b.slices) - 1
+	if b.slices[i].count >= b.capacity(i) {
+		b.grow()
+		i++
+	}
+	b.slices[i].Add(key)
+}
+
+func (b *scalableBloom) Lookup(key []byte) bool {
+	for i := len(b.slices) - 1; i >= 0; i-- {
+		if b.slices[i].Lookup(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// EstimatedFalsePositiveRate return the probability that any of the slices gives a false positive
+func (b *scalableBloom) EstimatedFalsePositiveRate() float64 {
+	rate := 1.0
+	for _, v := range b.slices {
+		rate *= 1 - v.EstimatedFalsePositiveRate()
+	}
+	return 1 - rate
+}
+
+func (b *scalableBloom) fillStats(stats *Stats) {
+	current := b.slices[len(b.slices)-1]
+	stats.Items = 0
+	stats.Bits = 0
+	stats.SizeInBytes = 0
+	for _, v := range b.slices {
+		stats.Items += v.count
+		stats.Bits += v.m
+		stats.SizeInBytes += v.SizeInBytes()
+	}
+	stats.HashFunctions = current.k
+	stats.FillRatio = current.FillRatio()
+	stats.EstimatedFalsePositiveRate = b.EstimatedFalsePositiveRate()
+	stats.Slices = len(b.slices)
+}
+
+func (b *scalableBloom) WriteTo(w io.Writer) (int64, error) {
+	header := []uint64{b.initialItems, b.growthFactor, math.Float64bits(b.falsePositiveRate), math.Float64bits(b.tighteningRatio), uint64(len(b.slices))}
+	err := binary.Write(w, binary.LittleEndian, header)
+	if err != nil {
+		return 0, err
+	}
+
+	total := int64(len(header) * 8)
+	for _, v := range b.slices {
+		n, err := v.WriteTo(w)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+func (b *scalableBloom) ReadFrom(r io.Reader) (int64, error) {
+	header := make([]uint64, 5)
+	err := binary.Read(r, binary.LittleEndian, header)
+	if err != nil {
+		return 0, err
+	}
+	b.initialItems, b.growthFactor = header[0], header[1]
+	b.falsePositiveRate, b.tighteningRatio = math.Float64frombits(header[2]), math.Float64frombits(header[3])
+	if b.initialItems == 0 || b.growthFactor == 0 || !(b.falsePositiveRate > 0 && b.falsePositiveRate < 1) || !(b.tighteningRatio > 0 && b.tighteningRatio < 1) {
+		return 0, errors.Wrapf(errInvalidSnapshot, "initial items: %v, growth factor: %v, false positive rate: %v, tightening ratio: %v",
+			b.initialItems, b.growthFactor, b.falsePositiveRate, b.tighteningRatio)
+	}
+	//each slice takes at least its header and one word of bits
+	if lr, ok := r.(*io.LimitedReader); ok && header[4] > uint64(lr.N)/32 {
+		return 0, errors.Wrapf(errInvalidSnapshot, "slices: %v, remaining bytes: %v", header[4], lr.N)
+	}
+
+	total := int64(len(header) * 8)
+	b.slices = make([]*bloom, header[4])
+	for i := range b.slices {
+		b.slices[i] = &bloom{}
+		n, err := b.slices[i].ReadFrom(r)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	if len(b.slices) == 0 {
+		b.grow()
+	}
+	return total, nil
+}
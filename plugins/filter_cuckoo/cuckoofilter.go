@@ -1,27 +1,349 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
 package impl
 
 import (
+	log "github.com/cihub/seelog"
+	api "github.com/huminghe/infini-framework/core/filter"
+	"github.com/huminghe/infini-framework/core/util"
 	f "github.com/seiflotfy/cuckoofilter"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"os"
+	"path"
 	"sync"
+	"time"
 )
 
+// BucketConfig defines the capacity of the filter of a bucket
+type BucketConfig struct {
+	Name     string `config:"name"`
+	Capacity uint   `config:"capacity"`
+}
+
+// CuckooFilterConfig defines the default capacity and the capacity per bucket
+type CuckooFilterConfig struct {
+	Capacity uint           `config:"capacity"`
+	Buckets  []BucketConfig `config:"buckets"`
+
+	//SnapshotIntervalInSeconds defines how often the changed filters are persisted, 0 means only persist on close
+	SnapshotIntervalInSeconds int `config:"snapshot_interval_in_seconds"`
+}
+
+// Stats of the cuckoo filter of a bucket
+type Stats struct {
+	Bucket           string     `json:"bucket"`
+	Capacity         uint       `json:"capacity"`
+	Items            uint       `json:"items"`
+	LastSnapshotTime *time.Time `json:"last_snapshot_time,omitempty"`
+	LastSnapshotSize int64      `json:"last_snapshot_size"`
+}
+
+// CuckooFilter keeps one cuckoo filter per bucket, each bucket is persisted to a separate file under the folder
+type CuckooFilter struct {
+	Folder string
+	Config CuckooFilterConfig
+
+	lock    sync.RWMutex
+	buckets map[string]*CuckooFilterImpl
+	quit    chan bool
+	wg      sync.WaitGroup
+}
+
+// CuckooFilterImpl is the cuckoo filter of a bucket
 type CuckooFilterImpl struct {
-	l  sync.Mutex
-	cf *f.CuckooFilter
+	l sync.Mutex
+	//persistLock serializes the snapshots of the bucket, so an older snapshot never replaces a newer one
+	persistLock sync.Mutex
+
+	cf              *f.CuckooFilter
+	name            string
+	capacity        uint
+	persistFileName string
+
+	//dirty is set when keys are changed after the last snapshot
+	dirty            bool
+	lastSnapshotTime time.Time
+	lastSnapshotSize int64
+}
+
+func (filter *CuckooFilter) Open() error {
+	filter.lock.Lock()
+	defer filter.lock.Unlock()
+
+	filter.buckets = map[string]*CuckooFilterImpl{}
+	err := os.MkdirAll(filter.Folder, 0777)
+	if err != nil {
+		return err
+	}
+
+	if filter.Config.SnapshotIntervalInSeconds > 0 {
+		filter.quit = make(chan bool)
+		filter.wg.Add(1)
+		go filter.runSnapshot(time.Duration(filter.Config.SnapshotIntervalInSeconds)*time.Second, filter.quit)
+	}
+	return nil
+}
+
+func (filter *CuckooFilter) Close() error {
+	//wait for the running snapshot, so the final snapshot is the last one written
+	if filter.quit != nil {
+		close(filter.quit)
+		filter.wg.Wait()
+		filter.quit = nil
+	}
+
+	err := filter.Snapshot()
+	if err != nil {
+		return err
+	}
+	log.Info("cuckooFilter safety persisted.")
+	return nil
+}
+
+// Snapshot persist all the changed filters, a failed bucket will not stop the others from being persisted
+func (filter *CuckooFilter) Snapshot() error {
+	filter.lock.RLock()
+	defer filter.lock.RUnlock()
+
+	var lastErr error
+	for _, v := range filter.buckets {
+		err := v.persist()
+		if err != nil {
+			log.Error("cuckooFilter failed to persist,file:", v.persistFileName, ", ", err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (filter *CuckooFilter) runSnapshot(interval time.Duration, quit chan bool) {
+	defer filter.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+			filter.Snapshot()
+		}
+	}
+}
+
+// Stats return the stats of all the opened buckets
+func (filter *CuckooFilter) Stats() []Stats {
+	filter.lock.RLock()
+	defer filter.lock.RUnlock()
+
+	result := []Stats{}
+	for _, v := range filter.buckets {
+		result = append(result, v.stats())
+	}
+	return result
+}
+
+// BucketStats return the stats of the bucket, only the capacity is reported if the bucket doesn't exist
+func (filter *CuckooFilter) BucketStats(bucket string) Stats {
+	v := filter.lookupBucket(bucket)
+	if v == nil {
+		return Stats{Bucket: bucket, Capacity: filter.newBucket(bucket).capacity}
+	}
+	return v.stats()
+}
+
+func (filter *CuckooFilter) Exists(bucket string, key []byte) bool {
+	v := filter.lookupBucket(bucket)
+	if v == nil {
+		return false
+	}
+	return v.Exists(key)
+}
+
+func (filter *CuckooFilter) Add(bucket string, key []byte) error {
+	return filter.getBucket(bucket).Add(key)
+}
+
+func (filter *CuckooFilter) Delete(bucket string, key []byte) error {
+	v := filter.lookupBucket(bucket)
+	if v == nil {
+		return nil
+	}
+	return v.Delete(key)
+}
+
+func (filter *CuckooFilter) CheckThenAdd(bucket string, key []byte) (bool, error) {
+	return filter.getBucket(bucket).CheckThenAdd(key)
+}
+
+// Clear drop the filter of the bucket and remove the persisted file
+func (filter *CuckooFilter) Clear(bucket string) error {
+	filter.lock.Lock()
+	defer filter.lock.Unlock()
+
+	v, ok := filter.buckets[bucket]
+	if !ok {
+		v = filter.newBucket(bucket)
+	}
+	delete(filter.buckets, bucket)
+
+	if util.FileExists(v.persistFileName) {
+		return os.Remove(v.persistFileName)
+	}
+	return nil
+}
+
+// GetStats return the common stats of the bucket
+func (filter *CuckooFilter) GetStats(bucket string) (api.Stats, error) {
+	v := filter.lookupBucket(bucket)
+	if v == nil {
+		return api.Stats{Bucket: bucket}, nil
+	}
+	return v.commonStats(), nil
+}
+
+func (filter *CuckooFilter) newBucket(bucket string) *CuckooFilterImpl {
+	capacity := filter.Config.Capacity
+	for _, v := range filter.Config.Buckets {
+		if v.Name == bucket && v.Capacity > 0 {
+			capacity = v.Capacity
+		}
+	}
+	return &CuckooFilterImpl{
+		name:            bucket,
+		capacity:        capacity,
+		persistFileName: path.Join(filter.Folder, url.QueryEscape(bucket)+".cuckoo"),
+	}
+}
+
+// getBucket return the filter of bucket, the filter will be loaded or initialized if it is not opened
+func (filter *CuckooFilter) getBucket(bucket string) *CuckooFilterImpl {
+	return filter.openBucket(bucket, true)
+}
+
+// lookupBucket return the filter of bucket, the filter will be loaded if it is persisted, nil if the bucket doesn't exist,
+// so the lookups never create empty buckets
+func (filter *CuckooFilter) lookupBucket(bucket string) *CuckooFilterImpl {
+	return filter.openBucket(bucket, false)
+}
+
+func (filter *CuckooFilter) openBucket(bucket string, create bool) *CuckooFilterImpl {
+	filter.lock.RLock()
+	v, ok := filter.buckets[bucket]
+	filter.lock.RUnlock()
+	if ok {
+		return v
+	}
+
+	filter.lock.Lock()
+	defer filter.lock.Unlock()
+
+	//double check after lock in
+	v, ok = filter.buckets[bucket]
+	if ok {
+		return v
+	}
+
+	v = filter.newBucket(bucket)
+	if !create && !util.FileExists(v.persistFileName) {
+		return nil
+	}
+	v.Open()
+	filter.buckets[bucket] = v
+	return v
 }
 
 func (filter *CuckooFilterImpl) Open() error {
+	filter.l.Lock()
+	defer filter.l.Unlock()
 
-	filter.cf = f.NewCuckooFilter(10000000)
+	//loading or initializing cuckoo filter
+	if util.FileExists(filter.persistFileName) {
+		log.Debug("found cuckooFilter,start reload,", filter.persistFileName)
+		data, err := ioutil.ReadFile(filter.persistFileName)
+		if err == nil {
+			filter.cf, err = f.Decode(data)
+		}
+		if err == nil {
+			filter.lastSnapshotSize = int64(len(data))
+			if mtime, err := util.FileMTime(filter.persistFileName); err == nil {
+				filter.lastSnapshotTime = time.Unix(mtime, 0)
+			}
+			log.Info("cuckooFilter successfully reloaded:", filter.persistFileName)
+			return nil
+		}
+		log.Error("cuckooFilter:", filter.persistFileName, err)
+	}
+
+	log.Debug("initializing cuckoo-filter", filter.persistFileName, ",capacity: ", filter.capacity)
+	filter.cf = f.NewCuckooFilter(filter.capacity)
+	filter.dirty = true
 	return nil
 }
 
-func (filter *CuckooFilterImpl) Close() error {
+// persist write the filter to a temp file and rename it to the snapshot file, skipped if nothing changed since the last snapshot
+func (filter *CuckooFilterImpl) persist() error {
+	filter.persistLock.Lock()
+	defer filter.persistLock.Unlock()
+
+	filter.l.Lock()
+	if !filter.dirty {
+		filter.l.Unlock()
+		return nil
+	}
+
+	log.Debug("cuckooFilter start persist,file:", filter.persistFileName)
+
+	//encode inside the lock, and write to disk without blocking the writers
+	data := filter.cf.Encode()
+	filter.dirty = false
+	filter.l.Unlock()
+
+	n, err := util.FilePutContentAtomic(filter.persistFileName, data)
+
 	filter.l.Lock()
 	defer filter.l.Unlock()
+	if err != nil {
+		filter.dirty = true
+		return err
+	}
+	filter.lastSnapshotTime = time.Now()
+	filter.lastSnapshotSize = int64(n)
 	return nil
 }
 
+func (filter *CuckooFilterImpl) stats() Stats {
+	filter.l.Lock()
+	defer filter.l.Unlock()
+
+	stats := Stats{
+		Bucket:           filter.name,
+		Capacity:         filter.capacity,
+		Items:            filter.cf.Count(),
+		LastSnapshotSize: filter.lastSnapshotSize,
+	}
+	if !filter.lastSnapshotTime.IsZero() {
+		t := filter.lastSnapshotTime
+		stats.LastSnapshotTime = &t
+	}
+	return stats
+}
+
 func (filter *CuckooFilterImpl) Exists(key []byte) bool {
 	filter.l.Lock()
 	defer filter.l.Unlock()
@@ -32,12 +354,49 @@ func (filter *CuckooFilterImpl) Add(key []byte) error {
 	filter.l.Lock()
 	defer filter.l.Unlock()
 	filter.cf.Insert(key)
+	filter.dirty = true
 	return nil
 }
 
 func (filter *CuckooFilterImpl) Delete(key []byte) error {
 	filter.l.Lock()
 	defer filter.l.Unlock()
-	filter.cf.Delete(key)
+	if filter.cf.Delete(key) {
+		filter.dirty = true
+	}
 	return nil
 }
+
+func (filter *CuckooFilterImpl) CheckThenAdd(key []byte) (b bool, err error) {
+	filter.l.Lock()
+	defer filter.l.Unlock()
+	b = filter.cf.Lookup(key)
+	if !b {
+		filter.cf.Insert(key)
+		filter.dirty = true
+	}
+	return b, nil
+}
+
+// commonStats estimate the memory and the false positive rate, the filter keeps 4 fingerprints of one byte in each bucket,
+// and the number of buckets is rounded up to power of two
+func (filter *CuckooFilterImpl) commonStats() api.Stats {
+	filter.l.Lock()
+	defer filter.l.Unlock()
+
+	slots := uint64(4)
+	for slots < uint64(filter.capacity) {
+		slots <<= 1
+	}
+
+	items := uint64(filter.cf.Count())
+	load := float64(items) / float64(slots)
+
+	//a lookup compares with 8 fingerprints of two buckets, each has 1/256 chance to collide
+	return api.Stats{
+		Bucket:                     filter.name,
+		Items:                      items,
+		SizeInBytes:                slots,
+		EstimatedFalsePositiveRate: 1 - math.Pow(1-1.0/256, 8*load),
+	}
+}
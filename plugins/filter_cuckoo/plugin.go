@@ -0,0 +1,70 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package impl
+
+import (
+	log "github.com/cihub/seelog"
+	. "github.com/huminghe/infini-framework/core/config"
+	"github.com/huminghe/infini-framework/core/filter"
+	"github.com/huminghe/infini-framework/core/global"
+	"path"
+)
+
+type CuckooFilterPlugin struct {
+}
+
+func (plugin CuckooFilterPlugin) Name() string {
+	return "CuckooFilter"
+}
+
+var pluginConfig = CuckooFilterConfig{
+	Capacity:                  10000000,
+	SnapshotIntervalInSeconds: 60,
+}
+
+var cuckooFilter *CuckooFilter
+
+func (plugin CuckooFilterPlugin) Setup(cfg *Config) {
+
+	cfg.Unpack(&pluginConfig)
+
+	cuckooFilter = &CuckooFilter{
+		Folder: path.Join(global.Env().GetWorkingDir(), "filters", "cuckoo"),
+		Config: pluginConfig,
+	}
+
+	err := cuckooFilter.Open()
+	if err != nil {
+		panic(err)
+	}
+
+	filter.Register("cuckoo", cuckooFilter)
+}
+
+func (plugin CuckooFilterPlugin) Start() error {
+	return nil
+}
+
+func (plugin CuckooFilterPlugin) Stop() error {
+	if cuckooFilter != nil {
+		err := cuckooFilter.Close()
+		if err != nil {
+			log.Error(err)
+		}
+	}
+	return nil
+}
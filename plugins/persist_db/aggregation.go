@@ -0,0 +1,267 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package persist_db
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/huminghe/infini-framework/core/errors"
+	api "github.com/huminghe/infini-framework/core/orm"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Aggregate compute the aggregations with GROUP BY, every bucket aggregation is a grouped query,
+// and the bucket sub aggregations are computed by the queries filtered by the key of the parent bucket
+func (handler SQLORM) Aggregate(t interface{}, q *api.Query, aggs ...*api.Aggregation) (map[string]*api.AggregationResult, error) {
+	if handler.useLock {
+		dbLock.Lock()
+		defer dbLock.Unlock()
+	}
+
+	err := api.ValidateAggregations(aggs)
+	if err != nil {
+		return nil, err
+	}
+
+	where := "1 = 1"
+	args := []interface{}{}
+	if q != nil && len(q.Conds) > 0 {
+		where, args, err = getWhere(handler.conn.NewScope(t), q.Conds)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return handler.aggregate(t, where, args, aggs)
+}
+
+func (handler SQLORM) aggregate(t interface{}, where string, args []interface{}, aggs []*api.Aggregation) (map[string]*api.AggregationResult, error) {
+	result := map[string]*api.AggregationResult{}
+	metrics := []*api.Aggregation{}
+
+	for _, agg := range aggs {
+		if !agg.IsBucket() {
+			metrics = append(metrics, agg)
+			continue
+		}
+		r, err := handler.bucketAggregate(t, where, args, agg)
+		if err != nil {
+			return nil, err
+		}
+		result[agg.Name] = r
+	}
+
+	if len(metrics) == 0 {
+		return result, nil
+	}
+
+	selects := []string{}
+	for _, agg := range metrics {
+		selects = append(selects, getMetricExpr(agg))
+	}
+
+	values := make([]sql.NullFloat64, len(metrics))
+	dest := make([]interface{}, len(metrics))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	err := handler.conn.Model(t).Select(strings.Join(selects, ", ")).Where(where, args...).Row().Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, agg := range metrics {
+		result[agg.Name] = getMetricResult(agg, values[i])
+	}
+
+	return result, nil
+}
+
+func (handler SQLORM) bucketAggregate(t interface{}, where string, args []interface{}, agg *api.Aggregation) (*api.AggregationResult, error) {
+	key := handler.getKeyExpr(agg)
+
+	metrics := []*api.Aggregation{}
+	subBuckets := []*api.Aggregation{}
+	selects := []string{key + " AS agg_key", "COUNT(*) AS agg_count"}
+	for _, sub := range agg.Aggs {
+		if sub.IsBucket() {
+			subBuckets = append(subBuckets, sub)
+		} else {
+			metrics = append(metrics, sub)
+			selects = append(selects, getMetricExpr(sub))
+		}
+	}
+
+	db1 := handler.conn.Model(t).Select(strings.Join(selects, ", ")).
+		Where(where, args...).Where(agg.Field + " IS NOT NULL").Group(key)
+	if agg.Type == api.TermsAggregation {
+		db1 = db1.Order("agg_count DESC").Order("agg_key").Limit(agg.GetSize())
+	} else {
+		db1 = db1.Order("agg_key")
+	}
+
+	rows, err := db1.Rows()
+	if err != nil {
+		return nil, err
+	}
+
+	type row struct {
+		key     interface{}
+		count   int
+		metrics []sql.NullFloat64
+	}
+	list := []row{}
+	for rows.Next() {
+		r := row{metrics: make([]sql.NullFloat64, len(metrics))}
+		dest := []interface{}{&r.key, &r.count}
+		for i := range r.metrics {
+			dest = append(dest, &r.metrics[i])
+		}
+		if err = rows.Scan(dest...); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		list = append(list, r)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &api.AggregationResult{Buckets: []api.Bucket{}}
+	for _, r := range list {
+		bucket := api.Bucket{DocCount: r.count}
+		bucket.Key, err = getBucketKey(agg, r.key)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(agg.Aggs) > 0 {
+			bucket.Aggregations = map[string]*api.AggregationResult{}
+			for i, sub := range metrics {
+				bucket.Aggregations[sub.Name] = getMetricResult(sub, r.metrics[i])
+			}
+		}
+
+		if len(subBuckets) > 0 {
+			subWhere := "(" + where + ") AND " + key + " = ?"
+			subArgs := append(append([]interface{}{}, args...), r.key)
+			subResult, err := handler.aggregate(t, subWhere, subArgs, subBuckets)
+			if err != nil {
+				return nil, err
+			}
+			for k, v := range subResult {
+				bucket.Aggregations[k] = v
+			}
+		}
+
+		result.Buckets = append(result.Buckets, bucket)
+	}
+
+	return result, nil
+}
+
+// getKeyExpr return the expression of the bucket key, the histogram key is the floor of the value to the interval
+func (handler SQLORM) getKeyExpr(agg *api.Aggregation) string {
+	mysql := handler.conn.Dialect().GetName() == "mysql"
+
+	switch agg.Type {
+	case api.HistogramAggregation:
+		interval := strconv.FormatFloat(agg.Interval, 'f', -1, 64)
+		if !strings.Contains(interval, ".") {
+			interval = interval + ".0"
+		}
+		if mysql {
+			return fmt.Sprintf("FLOOR(%s / %s) * %s", agg.Field, interval, interval)
+		}
+		//there is no FLOOR in sqlite, the truncated quotient of negative values need to minus one
+		quotient := fmt.Sprintf("%s / %s", agg.Field, interval)
+		return fmt.Sprintf("(CAST(%s AS INTEGER) - (%s < 0 AND CAST(%s AS INTEGER) <> %s)) * %s", quotient, agg.Field, quotient, quotient, interval)
+	case api.DateHistogramAggregation:
+		seconds := int64(agg.DateInterval / time.Second)
+		if mysql {
+			return fmt.Sprintf("FLOOR(UNIX_TIMESTAMP(%s) / %d) * %d", agg.Field, seconds, seconds)
+		}
+		return fmt.Sprintf("(CAST(strftime('%%s', %s) AS INTEGER) / %d) * %d", agg.Field, seconds, seconds)
+	}
+	return agg.Field
+}
+
+func getMetricExpr(agg *api.Aggregation) string {
+	switch agg.Type {
+	case api.MinAggregation:
+		return "MIN(" + agg.Field + ")"
+	case api.MaxAggregation:
+		return "MAX(" + agg.Field + ")"
+	case api.AvgAggregation:
+		return "AVG(" + agg.Field + ")"
+	case api.SumAggregation:
+		return "SUM(" + agg.Field + ")"
+	}
+	return "COUNT(DISTINCT " + agg.Field + ")"
+}
+
+// getMetricResult return the value of metric, the sum of no document is zero as in elasticsearch
+func getMetricResult(agg *api.Aggregation, v sql.NullFloat64) *api.AggregationResult {
+	if !v.Valid {
+		if agg.Type != api.SumAggregation {
+			return &api.AggregationResult{}
+		}
+		v.Float64 = 0
+	}
+	value := v.Float64
+	return &api.AggregationResult{Value: &value}
+}
+
+func getBucketKey(agg *api.Aggregation, v interface{}) (interface{}, error) {
+	if b, ok := v.([]byte); ok {
+		v = string(b)
+	}
+
+	if agg.Type == api.TermsAggregation {
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	}
+
+	var f float64
+	switch x := v.(type) {
+	case int64:
+		f = float64(x)
+	case float64:
+		f = x
+	case string:
+		var err error
+		f, err = strconv.ParseFloat(x, 64)
+		if err != nil {
+			return nil, errors.Errorf("invalid key of aggregation %s: %v", agg.Name, v)
+		}
+	default:
+		if agg.Type == api.TermsAggregation {
+			return fmt.Sprint(v), nil
+		}
+		return nil, errors.Errorf("invalid key of aggregation %s: %v", agg.Name, v)
+	}
+
+	if agg.Type == api.DateHistogramAggregation {
+		return time.Unix(int64(f), 0).UTC(), nil
+	}
+	return f, nil
+}
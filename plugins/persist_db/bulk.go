@@ -0,0 +1,234 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package persist_db
+
+import (
+	"fmt"
+	log "github.com/cihub/seelog"
+	"github.com/huminghe/infini-framework/core/errors"
+	api "github.com/huminghe/infini-framework/core/orm"
+	"github.com/jinzhu/gorm"
+	"reflect"
+	"strings"
+)
+
+// maxBulkArgs is the max number of arguments of a statement, the older sqlite doesn't take more than 999
+const maxBulkArgs = 999
+
+// BulkSave insert the objects of every chunk by multi-row INSERT statements,
+// the chunk falls back to save the objects one by one if the insert failed, such as some of them are already stored
+func (handler SQLORM) BulkSave(objects []interface{}, chunkSize int) ([]api.BulkResult, error) {
+	return handler.bulk(objects, chunkSize, func(db *gorm.DB, chunk []interface{}) []api.BulkResult {
+		for _, o := range chunk {
+			api.InitVersion(o)
+		}
+
+		results := make([]api.BulkResult, len(chunk))
+		for _, group := range groupByType(chunk) {
+			objects := pick(chunk, group)
+			err := insertRows(db, objects)
+			if err != nil {
+				log.Debugf("bulk insert failed, save the objects one by one: %v", err)
+				for i, o := range objects {
+					results[group[i]].Error = db.Save(o).Error
+				}
+			}
+			for _, i := range group {
+				results[i].ID = api.GetID(chunk[i])
+			}
+		}
+		return results
+	})
+}
+
+func (handler SQLORM) BulkUpdate(objects []interface{}, chunkSize int) ([]api.BulkResult, error) {
+	return handler.bulk(objects, chunkSize, func(db *gorm.DB, chunk []interface{}) []api.BulkResult {
+		return api.RunEach(chunk, func(o interface{}) error {
+			return update(db, o)
+		})
+	})
+}
+
+// BulkDelete delete the objects of every chunk by `DELETE ... WHERE id IN (...)`, the objects without primary key are failed
+func (handler SQLORM) BulkDelete(objects []interface{}, chunkSize int) ([]api.BulkResult, error) {
+	return handler.bulk(objects, chunkSize, func(db *gorm.DB, chunk []interface{}) []api.BulkResult {
+		results := make([]api.BulkResult, len(chunk))
+		for _, group := range groupByType(chunk) {
+			errs := deleteRows(db, pick(chunk, group))
+			for i, j := range group {
+				results[j] = api.BulkResult{ID: api.GetID(chunk[j]), Error: errs[i]}
+			}
+		}
+		return results
+	})
+}
+
+// bulk run the statements of every chunk in a transaction, the failed objects don't affect the others in the chunk
+func (handler SQLORM) bulk(objects []interface{}, chunkSize int, fn func(db *gorm.DB, chunk []interface{}) []api.BulkResult) ([]api.BulkResult, error) {
+	if handler.useLock {
+		dbLock.Lock()
+		defer dbLock.Unlock()
+	}
+
+	return api.RunBulk(objects, chunkSize, func(chunk []interface{}) ([]api.BulkResult, error) {
+		if handler.inTx {
+			return fn(handler.conn, chunk), nil
+		}
+
+		tx := handler.conn.Begin()
+		if tx.Error != nil {
+			return nil, tx.Error
+		}
+		results := fn(tx, chunk)
+		if err := tx.Commit().Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		return results, nil
+	})
+}
+
+// groupByType return the indexes of the objects grouped by their types, in the order of the first object of each type
+func groupByType(objects []interface{}) [][]int {
+	groups := [][]int{}
+	types := map[reflect.Type]int{}
+	for i, o := range objects {
+		t := reflect.TypeOf(o)
+		g, ok := types[t]
+		if !ok {
+			g = len(groups)
+			types[t] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+	return groups
+}
+
+func pick(objects []interface{}, index []int) []interface{} {
+	out := make([]interface{}, len(index))
+	for i, j := range index {
+		out[i] = objects[j]
+	}
+	return out
+}
+
+// insertRows insert the objects of the same type by multi-row INSERT statements,
+// the primary keys need to be set, as the generated keys of a multi-row insert can't be read back
+func insertRows(db *gorm.DB, objects []interface{}) error {
+	scope := db.NewScope(objects[0])
+	if len(scope.PrimaryFields()) != 1 {
+		return errors.Errorf("bulk insert requires a single primary key of table: %s", scope.TableName())
+	}
+
+	columns := []string{}
+	for _, field := range scope.Fields() {
+		if field.IsNormal && !field.IsIgnored {
+			columns = append(columns, field.DBName)
+		}
+	}
+
+	rows := []string{}
+	args := []interface{}{}
+	placeholder := "(" + strings.TrimSuffix(strings.Repeat("?,", len(columns)), ",") + ")"
+	now := gorm.NowFunc()
+	for _, o := range objects {
+		s := db.NewScope(o)
+		if s.PrimaryKeyZero() {
+			return errors.Errorf("bulk insert requires the primary key of table: %s", scope.TableName())
+		}
+
+		//same as gorm's create callback
+		for _, name := range []string{"CreatedAt", "UpdatedAt"} {
+			if field, ok := s.FieldByName(name); ok && field.IsBlank {
+				field.Set(now)
+			}
+		}
+
+		for _, column := range columns {
+			field, _ := s.FieldByName(column)
+			if field.IsBlank && field.HasDefaultValue {
+				return errors.Errorf("bulk insert can't apply the default value of column: %s", column)
+			}
+			args = append(args, field.Field.Interface())
+		}
+		rows = append(rows, placeholder)
+	}
+
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = scope.Quote(column)
+	}
+
+	//split the rows by the max arguments of a statement
+	size := maxBulkArgs / len(columns)
+	if size < 1 {
+		size = 1
+	}
+	for i := 0; i < len(rows); i += size {
+		end := i + size
+		if end > len(rows) {
+			end = len(rows)
+		}
+		sql := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", scope.QuotedTableName(), strings.Join(quoted, ","), strings.Join(rows[i:end], ","))
+		if err := db.Exec(sql, args[i*len(columns):end*len(columns)]...).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteRows delete the objects of the same type by their primary keys, the soft delete of gorm is kept,
+// the objects without primary key are failed instead of deleting the whole table
+func deleteRows(db *gorm.DB, objects []interface{}) []error {
+	errs := make([]error, len(objects))
+	scope := db.NewScope(objects[0])
+	if len(scope.PrimaryFields()) != 1 {
+		for i, o := range objects {
+			errs[i] = db.Delete(o).Error
+		}
+		return errs
+	}
+
+	ids := []interface{}{}
+	index := []int{}
+	for i, o := range objects {
+		s := db.NewScope(o)
+		if s.PrimaryKeyZero() {
+			errs[i] = errors.Errorf("primary key is not set: %v", s.TableName())
+			continue
+		}
+		ids = append(ids, s.PrimaryKeyValue())
+		index = append(index, i)
+	}
+
+	//split the ids by the max arguments of a statement
+	model := reflect.New(reflect.Indirect(reflect.ValueOf(objects[0])).Type()).Interface()
+	where := fmt.Sprintf("%s IN (?)", scope.Quote(scope.PrimaryKey()))
+	for i := 0; i < len(ids); i += maxBulkArgs {
+		end := i + maxBulkArgs
+		if end > len(ids) {
+			end = len(ids)
+		}
+		if err := db.Where(where, ids[i:end]).Delete(model).Error; err != nil {
+			for _, j := range index[i:end] {
+				errs[j] = err
+			}
+		}
+	}
+	return errs
+}
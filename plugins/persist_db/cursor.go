@@ -0,0 +1,134 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package persist_db
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/huminghe/infini-framework/core/errors"
+	api "github.com/huminghe/infini-framework/core/orm"
+	"github.com/jinzhu/gorm"
+	"reflect"
+	"strings"
+)
+
+type sortKey struct {
+	field    *gorm.StructField
+	sortType api.SortType
+}
+
+// getSortKeys return the fields to order by, the primary key is always the last one to make the order unique
+func getSortKeys(scope *gorm.Scope, sort *[]api.Sort) ([]sortKey, error) {
+	keys := []sortKey{}
+	fields := scope.GetModelStruct().StructFields
+
+	if sort != nil {
+		for _, s := range *sort {
+			field := getStructField(fields, s.Field)
+			if field == nil {
+				return nil, errors.Errorf("invalid sort field: %s", s.Field)
+			}
+			sortType := api.ASC
+			if strings.ToLower(string(s.SortType)) == string(api.DESC) {
+				sortType = api.DESC
+			}
+			keys = append(keys, sortKey{field: field, sortType: sortType})
+		}
+	}
+
+	for _, field := range scope.GetModelStruct().PrimaryFields {
+		keys = append(keys, sortKey{field: field, sortType: api.ASC})
+	}
+	if len(keys) == 0 || !keys[len(keys)-1].field.IsPrimaryKey {
+		return nil, errors.Errorf("no primary key of table: %s", scope.TableName())
+	}
+
+	return keys, nil
+}
+
+func getStructField(fields []*gorm.StructField, name string) *gorm.StructField {
+	for _, field := range fields {
+		if field.IsNormal && (field.DBName == name || field.Name == name) {
+			return field
+		}
+	}
+	return nil
+}
+
+// getCursorWhere return the condition of records after the cursor, which is
+// `k1 > v1 OR (k1 = v1 AND k2 > v2) OR ...`, and `<` is used for the descending keys
+func getCursorWhere(keys []sortKey, cursor string) (string, []interface{}, error) {
+	values, err := api.DecodeCursor(cursor)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(values) != len(keys) {
+		return "", nil, errors.Errorf("cursor doesn't match the sort fields: %s", cursor)
+	}
+
+	args := []interface{}{}
+	for i, k := range keys {
+		v := reflect.New(k.field.Struct.Type)
+		if err := json.Unmarshal(values[i], v.Interface()); err != nil {
+			return "", nil, errors.Errorf("invalid cursor value of %s: %s", k.field.DBName, values[i])
+		}
+		args = append(args, v.Elem().Interface())
+	}
+
+	ors := []string{}
+	whereArgs := []interface{}{}
+	for i, k := range keys {
+		ands := []string{}
+		for j := 0; j < i; j++ {
+			ands = append(ands, fmt.Sprintf("%s = ?", keys[j].field.DBName))
+			whereArgs = append(whereArgs, args[j])
+		}
+		op := ">"
+		if k.sortType == api.DESC {
+			op = "<"
+		}
+		ands = append(ands, fmt.Sprintf("%s %s ?", k.field.DBName, op))
+		whereArgs = append(whereArgs, args[i])
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+
+	return strings.Join(ors, " OR "), whereArgs, nil
+}
+
+// getNextCursor return the cursor of the last record, it is empty if the page is not full
+func (handler SQLORM) getNextCursor(keys []sortKey, o interface{}, size int) (string, error) {
+	v := reflect.Indirect(reflect.ValueOf(o))
+	if v.Kind() != reflect.Slice || size <= 0 || v.Len() < size {
+		return "", nil
+	}
+
+	last := v.Index(v.Len() - 1)
+	if last.Kind() != reflect.Ptr {
+		last = last.Addr()
+	}
+	scope := handler.conn.NewScope(last.Interface())
+
+	values := []interface{}{}
+	for _, k := range keys {
+		field, ok := scope.FieldByName(k.field.Name)
+		if !ok {
+			return "", errors.Errorf("invalid sort field: %s", k.field.Name)
+		}
+		values = append(values, field.Field.Interface())
+	}
+	return api.EncodeCursor(values)
+}
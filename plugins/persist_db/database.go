@@ -67,6 +67,11 @@ func (module DatabaseModule) Start() error {
 
 	orm.Register("db", handler)
 
+	err := orm.MigrateOnStartup("db")
+	if err != nil {
+		panic(err)
+	}
+
 	return nil
 }
 
@@ -0,0 +1,241 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package persist_db
+
+import (
+	"fmt"
+	log "github.com/cihub/seelog"
+	"github.com/huminghe/infini-framework/core/errors"
+	api "github.com/huminghe/infini-framework/core/orm"
+	"github.com/jinzhu/gorm"
+	"strings"
+)
+
+// The full text search is backed by a FTS5 virtual table for sqlite, which is named as `<table>_fts` and kept in sync by triggers,
+// the sqlite driver must be built with the tag `sqlite_fts5`.
+// The FTS5 table refers to the rows by rowid, which may be renumbered by VACUUM if the table has no INTEGER PRIMARY KEY,
+// such as the tables with string ids, so the index is rebuilt every time the schema is registered, that is on startup,
+// and the database should only be vacuumed while the application is stopped.
+// For mysql, there is a FULLTEXT index of all the searchable columns, and a FULLTEXT index for each of them if there are more than one.
+
+// getSearchableColumns return the columns of the fields tagged by `orm:"searchable"`
+func getSearchableColumns(scope *gorm.Scope) []string {
+	columns := []string{}
+	for _, f := range api.GetSearchableFields(scope.Value) {
+		if field, ok := scope.FieldByName(f.Name); ok {
+			columns = append(columns, field.DBName)
+		}
+	}
+	return columns
+}
+
+// getFullTextColumns return the columns of the full text condition, the fields must be searchable
+func getFullTextColumns(scope *gorm.Scope, c *api.Cond) ([]string, error) {
+	searchable := getSearchableColumns(scope)
+	if len(searchable) == 0 {
+		return nil, errors.Errorf("no searchable field in %s", scope.TableName())
+	}
+	if len(c.Fields) == 0 {
+		return searchable, nil
+	}
+
+	columns := []string{}
+	for _, name := range c.Fields {
+		field, ok := scope.FieldByName(name)
+		if !ok || !contains(searchable, field.DBName) {
+			return nil, errors.Errorf("field %s is not searchable", name)
+		}
+		columns = append(columns, field.DBName)
+	}
+	return columns, nil
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+func getFullTextWhere(scope *gorm.Scope, c *api.Cond) (string, []interface{}, error) {
+	text, ok := c.Value.(string)
+	if !ok {
+		return "", nil, errors.Errorf("invalid text: %v", c.Value)
+	}
+	columns, err := getFullTextColumns(scope, c)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(strings.Fields(text)) == 0 {
+		return "1 = 0", nil, nil
+	}
+
+	switch scope.Dialect().GetName() {
+	case "sqlite3":
+		table := scope.TableName()
+		return fmt.Sprintf("%s.rowid IN (SELECT rowid FROM %s_fts WHERE %s_fts MATCH ?)", table, table, table),
+			[]interface{}{getFTS5Query(columns, text, len(c.Fields) == 0)}, nil
+	case "mysql":
+		where, args := getMatchAgainst(columns, text, len(c.Fields) == 0, " OR ")
+		return "(" + where + ")", args, nil
+	}
+	return "", nil, errors.Errorf("full text search is not supported by %s", scope.Dialect().GetName())
+}
+
+// getFullTextRank return the expression to order by relevance
+func getFullTextRank(scope *gorm.Scope, c *api.Cond) (string, []interface{}, error) {
+	text, ok := c.Value.(string)
+	if !ok {
+		return "", nil, errors.Errorf("invalid text: %v", c.Value)
+	}
+	columns, err := getFullTextColumns(scope, c)
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch scope.Dialect().GetName() {
+	case "sqlite3":
+		//the smaller bm25 is more relevant, it is negated and ordered descending, so that the unmatched rows with null come last
+		table := scope.TableName()
+		return fmt.Sprintf("-(SELECT bm25(%s_fts) FROM %s_fts WHERE %s_fts MATCH ? AND rowid = %s.rowid) DESC", table, table, table, table),
+			[]interface{}{getFTS5Query(columns, text, len(c.Fields) == 0)}, nil
+	case "mysql":
+		order, args := getMatchAgainst(columns, text, len(c.Fields) == 0, " + ")
+		return "(" + order + ") DESC", args, nil
+	}
+	return "", nil, errors.Errorf("full text search is not supported by %s", scope.Dialect().GetName())
+}
+
+var fts5Escaper = strings.NewReplacer(`"`, `""`)
+
+// getFTS5Query quote the words as strings of FTS5 query, so that the words are not parsed as operators,
+// and the columns are filtered unless all the searchable columns are used
+func getFTS5Query(columns []string, text string, all bool) string {
+	words := []string{}
+	for _, w := range strings.Fields(text) {
+		words = append(words, `"`+fts5Escaper.Replace(w)+`"`)
+	}
+	query := strings.Join(words, " OR ")
+	if all {
+		return query
+	}
+	return "{" + strings.Join(columns, " ") + "} : (" + query + ")"
+}
+
+// getMatchAgainst return the MATCH expressions, the columns of a MATCH must be the same as a FULLTEXT index,
+// so the combined index is used for all the searchable columns, and the index of each column is used otherwise
+func getMatchAgainst(columns []string, text string, all bool, sep string) (string, []interface{}) {
+	if all {
+		return fmt.Sprintf("MATCH (%s) AGAINST (? IN NATURAL LANGUAGE MODE)", strings.Join(columns, ", ")), []interface{}{text}
+	}
+	exprs := []string{}
+	args := []interface{}{}
+	for _, column := range columns {
+		exprs = append(exprs, fmt.Sprintf("MATCH (%s) AGAINST (? IN NATURAL LANGUAGE MODE)", column))
+		args = append(args, text)
+	}
+	return strings.Join(exprs, sep), args
+}
+
+// createFullTextIndex create the full text index of the searchable columns
+func createFullTextIndex(db *gorm.DB, t interface{}) error {
+	scope := db.NewScope(t)
+	columns := getSearchableColumns(scope)
+	if len(columns) == 0 {
+		return nil
+	}
+
+	switch scope.Dialect().GetName() {
+	case "sqlite3":
+		return createFTS5Table(db, scope.TableName(), columns)
+	case "mysql":
+		table := scope.TableName()
+		err := createMySQLFullTextIndex(db, table, "ft_"+table, columns)
+		if err != nil || len(columns) == 1 {
+			return err
+		}
+		for _, column := range columns {
+			err = createMySQLFullTextIndex(db, table, "ft_"+table+"_"+column, []string{column})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	log.Warnf("full text search is not supported by %s", scope.Dialect().GetName())
+	return nil
+}
+
+// createFTS5Table create the FTS5 table with the triggers, the table is recreated if the searchable columns are changed,
+// and the existing rows are indexed by rebuilding it, the existing FTS5 table is also rebuilt to catch up the changed rowids
+func createFTS5Table(db *gorm.DB, table string, columns []string) error {
+	fts := table + "_fts"
+	create := fmt.Sprintf("CREATE VIRTUAL TABLE %s USING fts5(%s, content='%s', content_rowid='rowid')", fts, strings.Join(columns, ", "), table)
+
+	var existing []string
+	err := db.Table("sqlite_master").Where("type = ? AND name = ?", "table", fts).Pluck("sql", &existing).Error
+	if err != nil {
+		return err
+	}
+	rebuild := fmt.Sprintf("INSERT INTO %s(%s) VALUES ('rebuild')", fts, fts)
+	if len(existing) > 0 && existing[0] == create {
+		return db.Exec(rebuild).Error
+	}
+
+	newValues := "new." + strings.Join(columns, ", new.")
+	oldValues := "old." + strings.Join(columns, ", old.")
+	cols := strings.Join(columns, ", ")
+	statements := []string{
+		fmt.Sprintf("DROP TRIGGER IF EXISTS %s_ai", fts),
+		fmt.Sprintf("DROP TRIGGER IF EXISTS %s_ad", fts),
+		fmt.Sprintf("DROP TRIGGER IF EXISTS %s_au", fts),
+		fmt.Sprintf("DROP TABLE IF EXISTS %s", fts),
+		create,
+		fmt.Sprintf("CREATE TRIGGER %s_ai AFTER INSERT ON %s BEGIN INSERT INTO %s(rowid, %s) VALUES (new.rowid, %s); END",
+			fts, table, fts, cols, newValues),
+		fmt.Sprintf("CREATE TRIGGER %s_ad AFTER DELETE ON %s BEGIN INSERT INTO %s(%s, rowid, %s) VALUES ('delete', old.rowid, %s); END",
+			fts, table, fts, fts, cols, oldValues),
+		fmt.Sprintf("CREATE TRIGGER %s_au AFTER UPDATE ON %s BEGIN INSERT INTO %s(%s, rowid, %s) VALUES ('delete', old.rowid, %s); "+
+			"INSERT INTO %s(rowid, %s) VALUES (new.rowid, %s); END",
+			fts, table, fts, fts, cols, oldValues, fts, cols, newValues),
+		rebuild,
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	for _, statement := range statements {
+		if err := tx.Exec(statement).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+func createMySQLFullTextIndex(db *gorm.DB, table, index string, columns []string) error {
+	var count int
+	err := db.Table("information_schema.statistics").
+		Where("table_schema = DATABASE() AND table_name = ? AND index_name = ?", table, index).Count(&count).Error
+	if err != nil || count > 0 {
+		return err
+	}
+	return db.Exec(fmt.Sprintf("CREATE FULLTEXT INDEX %s ON %s (%s)", index, table, strings.Join(columns, ", "))).Error
+}
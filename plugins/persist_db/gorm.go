@@ -3,6 +3,7 @@ package persist_db
 import (
 	"fmt"
 	log "github.com/cihub/seelog"
+	"github.com/huminghe/infini-framework/core/errors"
 	"github.com/jinzhu/gorm"
 	api "github.com/huminghe/infini-framework/core/orm"
 	"sync"
@@ -12,6 +13,9 @@ import (
 type SQLORM struct {
 	conn    *gorm.DB
 	useLock bool
+
+	//inTx is true if the conn is a transaction
+	inTx bool
 }
 
 var dbLock sync.RWMutex
@@ -19,8 +23,11 @@ var db *gorm.DB
 
 func (handler SQLORM) RegisterSchema(t interface{}) error {
 	// Migrate the schema
-	db.AutoMigrate(t)
-	return nil
+	err := handler.conn.AutoMigrate(t).Error
+	if err != nil {
+		return err
+	}
+	return createFullTextIndex(handler.conn, t)
 }
 
 func (handler SQLORM) Get(o interface{}) error {
@@ -47,6 +54,7 @@ func (handler SQLORM) Save(o interface{}) error {
 		defer dbLock.Unlock()
 	}
 
+	api.InitVersion(o)
 	return handler.conn.Save(o).Error
 }
 
@@ -56,16 +64,57 @@ func (handler SQLORM) Create(o interface{}) error {
 		defer dbLock.Unlock()
 	}
 
+	api.InitVersion(o)
 	return handler.conn.Create(o).Error
 }
 
+// Update save the object, if it has a version field, the row is only updated when the stored version matches
 func (handler SQLORM) Update(o interface{}) error {
 	if handler.useLock {
 		dbLock.Lock()
 		defer dbLock.Unlock()
 	}
 
-	return handler.conn.Save(o).Error
+	if _, _, ok := api.GetVersion(o); !ok || handler.inTx {
+		return update(handler.conn, o)
+	}
+
+	tx := handler.conn.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := update(tx, o); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// update save the object, the version is increased by a `UPDATE ... WHERE version = ?` first,
+// which also locks the row until the save is committed, so db must be a transaction if the object has a version field
+func update(db *gorm.DB, o interface{}) error {
+	field, version, ok := api.GetVersion(o)
+	if !ok {
+		return db.Save(o).Error
+	}
+
+	scope := db.NewScope(o)
+	column, _ := scope.FieldByName(field)
+
+	db1 := db.Model(o).Where(column.DBName+" = ?", version).UpdateColumn(column.DBName, version+1)
+	err := db1.Error
+	if err == nil && db1.RowsAffected == 0 {
+		err = &api.ConflictError{ID: fmt.Sprint(scope.PrimaryKeyValue()), Version: version}
+	}
+	if err == nil {
+		api.SetVersion(o, version+1)
+		err = db.Save(o).Error
+	}
+	if err != nil {
+		//gorm also assigns the updated column to o, even if no row was affected
+		api.SetVersion(o, version)
+	}
+	return err
 }
 
 func (handler SQLORM) Delete(o interface{}) error {
@@ -122,6 +171,9 @@ func (handler SQLORM) GroupBy(o interface{}, selectField, groupField string, hav
 	return err, result
 }
 
+// Search return a page of the matched records, the records are ordered by the sort fields and then the primary key,
+// if the query has a cursor, the page starts after the sort values of the cursor instead of the offset,
+// the records are ranked by the full text conditions if there is no sort, and there is no cursor for the ranked results
 func (handler SQLORM) Search(t interface{}, o interface{}, q *api.Query) (error, api.Result) {
 	if handler.useLock {
 		dbLock.Lock()
@@ -139,34 +191,80 @@ func (handler SQLORM) Search(t interface{}, o interface{}, q *api.Query) (error,
 	var err error
 	start := time.Now()
 	db1 := handler.conn.Model(o)
-	if q.Sort != nil && len(*q.Sort) > 0 {
-		for _, i := range *q.Sort {
-			db1 = db1.Order(fmt.Sprintf("%s %s", i.Field, i.SortType))
+	scope := handler.conn.NewScope(t)
+
+	ranking := api.GetRankingConds(q)
+	if len(ranking) > 0 && q.Cursor != "" {
+		return errors.New("cursor is not supported by the ranked full text search"), api.Result{}
+	}
+	for _, c := range ranking {
+		order, args, err := getFullTextRank(scope, c)
+		if err != nil {
+			return err, api.Result{}
 		}
+		db1 = db1.Order(gorm.Expr(order, args...))
 	}
 
-	if q.Conds != nil {
-		where := ""
-		args := []interface{}{}
-		for _, c1 := range q.Conds {
-			if where != "" {
-				where = where + " AND "
-			}
-			where = where + c1.Field + c1.SQLOperator + " ?"
-			args = append(args, c1.Value)
+	keys, err := getSortKeys(scope, q.Sort)
+	if err != nil {
+		return err, api.Result{}
+	}
+	for _, k := range keys {
+		db1 = db1.Order(fmt.Sprintf("%s %s", k.field.DBName, k.sortType))
+	}
+
+	if len(q.Conds) > 0 {
+		var where string
+		var args []interface{}
+		where, args, err = getWhere(scope, q.Conds)
+		if err != nil {
+			return err, api.Result{}
 		}
 		log.Tracef("search where: %s - %v", where, args)
-		err = db1.Limit(q.Size).Offset(q.From).Where(where, args...).Find(o).Error
-		db1.Where(where, args...).Count(&c)
-	} else {
-		err = db1.Limit(q.Size).Offset(q.From).Find(o).Error
-		db1.Count(&c)
+		db1 = db1.Where(where, args...)
 	}
+	db1.Count(&c)
+
+	from := q.From
+	if q.Cursor != "" {
+		var where string
+		var args []interface{}
+		where, args, err = getCursorWhere(keys, q.Cursor)
+		if err != nil {
+			return err, api.Result{}
+		}
+		db1 = db1.Where(where, args...)
+		from = 0
+	}
+	err = db1.Limit(q.Size).Offset(from).Find(o).Error
 
 	result := api.Result{}
 	result.Result = o
 	result.Total = c
 
+	if err == nil && len(ranking) == 0 {
+		result.Cursor, err = handler.getNextCursor(keys, o, q.Size)
+	}
+
 	log.Tracef("search,t:%v, q: %v, elapsed:%vs", t, q, time.Now().Sub(start).Seconds())
 	return err, result
 }
+
+// Transaction run fn in a SQL transaction, the operations of tx share the connection of the transaction
+func (handler SQLORM) Transaction(fn func(tx api.Tx) error) error {
+	if handler.useLock {
+		dbLock.Lock()
+		defer dbLock.Unlock()
+	}
+
+	tx := handler.conn.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	return api.RunInTransaction(SQLORM{conn: tx, inTx: true}, fn, func() error {
+		return tx.Commit().Error
+	}, func() error {
+		return tx.Rollback().Error
+	})
+}
@@ -0,0 +1,230 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package persist_db
+
+import (
+	"fmt"
+	"github.com/jinzhu/gorm"
+	api "github.com/huminghe/infini-framework/core/orm"
+	"github.com/huminghe/infini-framework/core/orm/ormtest"
+	"github.com/huminghe/infini-framework/core/util"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestSQLiteConformance(t *testing.T) {
+	file := path.Join(os.TempDir(), "orm_"+util.PickRandomName()+".db")
+	defer os.RemoveAll(file)
+
+	conn, err := gorm.Open("sqlite3", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.AutoMigrate(&ormtest.Doc{}, &ormtest.VersionedDoc{})
+
+	handler := SQLORM{conn: conn, useLock: true}
+	ormtest.Suite{Save: handler.Create, Search: handler.Search, Aggregate: handler.Aggregate, Cursor: true,
+		Transaction: handler.Transaction, Update: handler.Update, Get: handler.Get,
+		BulkSave: handler.BulkSave, BulkUpdate: handler.BulkUpdate, BulkDelete: handler.BulkDelete}.Run(t)
+}
+
+func TestSQLiteMigration(t *testing.T) {
+	file := path.Join(os.TempDir(), "orm_"+util.PickRandomName()+".db")
+	defer os.RemoveAll(file)
+
+	conn, err := gorm.Open("sqlite3", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	type User struct {
+		ID   int
+		Name string
+	}
+
+	handler := SQLORM{conn: conn, useLock: true}
+	m := &api.Migration{
+		Version:     1,
+		Description: "create users",
+		Up: func(conn interface{}) error {
+			return conn.(*gorm.DB).CreateTable(&User{}).Error
+		},
+		Down: func(conn interface{}) error {
+			return conn.(*gorm.DB).DropTable(&User{}).Error
+		},
+	}
+
+	records, err := handler.GetMigrationRecords()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(records))
+
+	assert.Nil(t, handler.ApplyMigration(m, true))
+	assert.True(t, conn.HasTable(&User{}))
+	records, err = handler.GetMigrationRecords()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, int64(1), records[0].Version)
+	assert.Equal(t, "create users", records[0].Description)
+
+	//the history is not changed if the step failed
+	assert.NotNil(t, handler.ApplyMigration(m, true))
+	records, _ = handler.GetMigrationRecords()
+	assert.Equal(t, 1, len(records))
+
+	assert.Nil(t, handler.ApplyMigration(m, false))
+	assert.False(t, conn.HasTable(&User{}))
+	records, err = handler.GetMigrationRecords()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(records))
+}
+
+func TestSQLiteFullText(t *testing.T) {
+	file := path.Join(os.TempDir(), "orm_"+util.PickRandomName()+".db")
+	defer os.RemoveAll(file)
+
+	conn, err := gorm.Open("sqlite3", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	type Article struct {
+		ID    string `gorm:"primary_key"`
+		Title string `orm:"searchable"`
+		Body  string `orm:"searchable"`
+		Level int
+	}
+
+	handler := SQLORM{conn: conn, useLock: true}
+	if err := handler.RegisterSchema(&Article{}); err != nil {
+		if strings.Contains(err.Error(), "no such module: fts5") {
+			t.Skip("fts5 is not available, build with the tag `sqlite_fts5`: ", err)
+		}
+		t.Fatal(err)
+	}
+	for _, a := range []Article{
+		{"a", "Quick brown fox", "jumps over the lazy dog", 1},
+		{"b", "Lazy afternoon", "the dog sleeps, the dog dreams", 2},
+		{"c", "Fox news", "nothing here", 3},
+		{"d", "Cats", "no match", 4},
+	} {
+		a := a
+		assert.Nil(t, handler.Save(&a))
+	}
+
+	search := func(q *api.Query) []string {
+		articles := []Article{}
+		err, _ := handler.Search(&Article{}, &articles, q)
+		assert.Nil(t, err)
+		ids := []string{}
+		for _, a := range articles {
+			ids = append(ids, a.ID)
+		}
+		return ids
+	}
+
+	assert.Equal(t, []string{"b", "a"}, search(&api.Query{Size: 10, Conds: api.And(api.FullText("DOG"))}))
+	assert.Equal(t, []string{"a", "c"}, search(&api.Query{Size: 10, Conds: api.And(api.FullText("fox dog", "Title"), api.Lt("level", 4)),
+		Sort: &[]api.Sort{{Field: "level", SortType: api.ASC}}}))
+	assert.Equal(t, []string{"b"}, search(&api.Query{Size: 10, Conds: api.And(api.FullText("fox lazy"), api.Not(api.FullText("fox")))}))
+
+	//the index is kept in sync with the table
+	assert.Nil(t, handler.Update(&Article{"d", "Cats and dogs", "no match", 4}))
+	assert.Nil(t, handler.Delete(&Article{ID: "b"}))
+	assert.Equal(t, []string{"a", "c", "d"}, search(&api.Query{Size: 10, Conds: api.And(api.FullText("fox dogs")),
+		Sort: &[]api.Sort{{Field: "level", SortType: api.ASC}}}))
+
+	//the index is rebuilt when the schema is registered again on startup, in case it is out of sync, such as the rowids are renumbered by vacuum
+	assert.Nil(t, conn.Exec("INSERT INTO articles_fts(articles_fts) VALUES ('delete-all')").Error)
+	assert.Equal(t, []string{}, search(&api.Query{Size: 10, Conds: api.And(api.FullText("fox dogs"))}))
+	assert.Nil(t, handler.RegisterSchema(&Article{}))
+	assert.Equal(t, []string{"a", "c", "d"}, search(&api.Query{Size: 10, Conds: api.And(api.FullText("fox dogs")),
+		Sort: &[]api.Sort{{Field: "level", SortType: api.ASC}}}))
+
+	err, _ = handler.Search(&Article{}, &[]Article{}, &api.Query{Size: 10, Cursor: "x", Conds: api.And(api.FullText("dog"))})
+	assert.NotNil(t, err)
+	err, _ = handler.Search(&Article{}, &[]Article{}, &api.Query{Size: 10, Conds: api.And(api.FullText("dog", "Level"))})
+	assert.NotNil(t, err)
+}
+
+// statementLogger counts the sql statements by their first word
+type statementLogger struct {
+	counts map[string]int
+}
+
+func (l *statementLogger) Print(values ...interface{}) {
+	if len(values) > 3 && values[0] == "sql" {
+		l.counts[strings.Fields(fmt.Sprint(values[3]))[0]]++
+	}
+}
+
+func TestSQLiteBulk(t *testing.T) {
+	file := path.Join(os.TempDir(), "orm_"+util.PickRandomName()+".db")
+	defer os.RemoveAll(file)
+
+	conn, err := gorm.Open("sqlite3", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.AutoMigrate(&ormtest.Doc{})
+
+	logger := &statementLogger{counts: map[string]int{}}
+	conn.SetLogger(logger)
+	conn.LogMode(true)
+
+	//the rows are inserted by multi-row statements, split by the max arguments
+	handler := SQLORM{conn: conn, useLock: true}
+	docs := []interface{}{}
+	for i := 0; i < 1500; i++ {
+		docs = append(docs, &ormtest.Doc{ID: fmt.Sprint("d", i), Name: "bulk", Age: i})
+	}
+	results, err := handler.BulkSave(docs, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1500, len(results))
+	assert.True(t, logger.counts["INSERT"] < 20, logger.counts["INSERT"])
+	assert.Equal(t, 0, logger.counts["UPDATE"])
+	count := 0
+	conn.Model(&ormtest.Doc{}).Count(&count)
+	assert.Equal(t, 1500, count)
+
+	//fall back to save the objects one by one if some of them are already stored
+	results, err = handler.BulkSave([]interface{}{&ormtest.Doc{ID: "d1", Name: "renamed"}, &ormtest.Doc{ID: "new", Name: "new"}}, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, results[0].Error)
+	assert.Nil(t, results[1].Error)
+	doc := ormtest.Doc{ID: "d1"}
+	assert.Nil(t, handler.Get(&doc))
+	assert.Equal(t, "renamed", doc.Name)
+
+	//the rows are deleted by the primary keys, the object without primary key fails instead of deleting all the rows
+	logger.counts = map[string]int{}
+	docs = append(docs, &ormtest.Doc{})
+	results, err = handler.BulkDelete(docs, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1501, len(results))
+	assert.Nil(t, results[0].Error)
+	assert.NotNil(t, results[1500].Error)
+	assert.Equal(t, 3, logger.counts["DELETE"])
+	conn.Model(&ormtest.Doc{}).Count(&count)
+	assert.Equal(t, 1, count)
+}
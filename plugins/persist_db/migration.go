@@ -0,0 +1,96 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package persist_db
+
+import (
+	api "github.com/huminghe/infini-framework/core/orm"
+	"time"
+)
+
+// migrationRecord is the row of migrations history table
+type migrationRecord struct {
+	Version     int64 `gorm:"primary_key;auto_increment:false"`
+	Description string
+	Applied     time.Time
+}
+
+func (migrationRecord) TableName() string {
+	return "orm_migrations"
+}
+
+func (handler SQLORM) GetMigrationRecords() ([]api.MigrationRecord, error) {
+	if handler.useLock {
+		dbLock.Lock()
+		defer dbLock.Unlock()
+	}
+
+	err := handler.conn.AutoMigrate(&migrationRecord{}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	rows := []migrationRecord{}
+	err = handler.conn.Order("version").Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result := []api.MigrationRecord{}
+	for _, r := range rows {
+		result = append(result, api.MigrationRecord{Version: r.Version, Description: r.Description, Applied: r.Applied})
+	}
+	return result, nil
+}
+
+// ApplyMigration run the step and update the history in one transaction, the step should only use the given *gorm.DB,
+// note that the DDL statements of MySQL are committed implicitly and can't be rolled back
+func (handler SQLORM) ApplyMigration(m *api.Migration, up bool) error {
+	if handler.useLock {
+		dbLock.Lock()
+		defer dbLock.Unlock()
+	}
+
+	err := handler.conn.AutoMigrate(&migrationRecord{}).Error
+	if err != nil {
+		return err
+	}
+
+	step := m.Up
+	if !up {
+		step = m.Down
+	}
+
+	tx := handler.conn.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	err = step(tx)
+	if err == nil {
+		if up {
+			err = tx.Create(&migrationRecord{Version: m.Version, Description: m.Description, Applied: time.Now().UTC()}).Error
+		} else {
+			err = tx.Where("version = ?", m.Version).Delete(&migrationRecord{}).Error
+		}
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2016 Medcl (m AT medcl.net)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package persist_db
+
+import (
+	"github.com/huminghe/infini-framework/core/errors"
+	api "github.com/huminghe/infini-framework/core/orm"
+	"github.com/jinzhu/gorm"
+	"strings"
+)
+
+// getWhere translate the query tree to the where clause and its arguments, scope is the model of query
+func getWhere(scope *gorm.Scope, conds []*api.Cond) (string, []interface{}, error) {
+	must := []string{}
+	should := []string{}
+	args := []interface{}{}
+
+	for _, c := range conds {
+		where, a, err := getCondWhere(scope, c)
+		if err != nil {
+			return "", nil, err
+		}
+		args = append(args, a...)
+
+		switch c.BoolType {
+		case api.MustNot:
+			must = append(must, "NOT ("+where+")")
+			break
+		case api.Should:
+			should = append(should, where)
+			break
+		default:
+			must = append(must, where)
+			break
+		}
+	}
+
+	if len(should) > 0 {
+		must = append(must, "("+strings.Join(should, " OR ")+")")
+	}
+
+	if len(must) == 0 {
+		return "1 = 1", args, nil
+	}
+
+	if len(must) == 1 {
+		return must[0], args, nil
+	}
+
+	return "(" + strings.Join(must, " AND ") + ")", args, nil
+}
+
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+func getCondWhere(scope *gorm.Scope, c *api.Cond) (string, []interface{}, error) {
+	switch c.QueryType {
+	case api.Match:
+		return c.Field + " = ?", []interface{}{c.Value}, nil
+	case api.RangeGt:
+		return c.Field + " > ?", []interface{}{c.Value}, nil
+	case api.RangeGte:
+		return c.Field + " >= ?", []interface{}{c.Value}, nil
+	case api.RangeLt:
+		return c.Field + " < ?", []interface{}{c.Value}, nil
+	case api.RangeLte:
+		return c.Field + " <= ?", []interface{}{c.Value}, nil
+	case api.TermsMatch:
+		values, ok := c.Value.([]interface{})
+		if !ok {
+			return "", nil, errors.Errorf("invalid values of terms query: %v", c.Value)
+		}
+		if len(values) == 0 {
+			return "1 = 0", nil, nil
+		}
+		return c.Field + " IN (?)", []interface{}{values}, nil
+	case api.PrefixMatch:
+		prefix, ok := c.Value.(string)
+		if !ok {
+			return "", nil, errors.Errorf("invalid prefix: %v", c.Value)
+		}
+		return c.Field + " LIKE ? ESCAPE '!'", []interface{}{likeEscaper.Replace(prefix) + "%"}, nil
+	case api.FieldExists:
+		return c.Field + " IS NOT NULL", nil, nil
+	case api.Group:
+		return getWhere(scope, c.Conds)
+	case api.FullTextMatch:
+		return getFullTextWhere(scope, c)
+	}
+	return "", nil, errors.Errorf("invalid query: %v", c.QueryType)
+}